@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/database"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runReindexCommand 从 chat_messages 重建记忆集合
+//
+//	go-chat-backend reindex -collection chat_memory_v2 -switch
+//
+// 中断(Ctrl+C)后任务保留游标，使用相同参数再次运行即可继续。
+func runReindexCommand(args []string) int {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	collection := fs.String("collection", "", "目标集合名称（默认为当前记忆集合，即原地重建）")
+	switchOnComplete := fs.Bool("switch", false, "完成后切换到目标集合")
+	batchSize := fs.Int("batch", 64, "每批处理的消息数量")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	config.LoadConfig()
+	setupLogger()
+
+	db, err := database.InitDB()
+	if err != nil {
+		logrus.Error("数据库连接失败:", err)
+		return 1
+	}
+	defer database.CloseDB()
+
+	memoryStore, _, err := setupMemoryStore(db, services.NewEmbeddingService(db))
+	if err != nil {
+		logrus.Errorf("初始化记忆库失败: %v", err)
+		return 1
+	}
+
+	reindexService := services.NewReindexService(db, memoryStore)
+	if err := reindexService.LoadActiveCollection(); err != nil {
+		logrus.Errorf("加载记忆集合设置失败: %v", err)
+		return 1
+	}
+
+	target := *collection
+	if target == "" {
+		target = reindexService.ActiveCollection()
+	}
+
+	job, err := reindexService.PrepareReindex(target, *switchOnComplete, *batchSize)
+	if err != nil {
+		logrus.Errorf("创建重建任务失败: %v", err)
+		return 1
+	}
+	fmt.Printf("重建任务 %s: %s -> %s, 已处理 %d/%d\n", job.ID, job.SourceCollection, job.TargetCollection, job.Processed, job.Total)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = reindexService.RunReindex(ctx, job.ID, func(j *models.ReindexJob) {
+		fmt.Printf("\r已处理 %d/%d (%.1f%%)", j.Processed, j.Total, services.ReindexProgress(j))
+	})
+	fmt.Println()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Println("已中断，再次运行相同命令即可从断点继续")
+			return 130
+		}
+		logrus.Errorf("重建失败: %v", err)
+		return 1
+	}
+
+	fmt.Printf("重建完成，当前记忆集合: %s\n", reindexService.ActiveCollection())
+	return 0
+}
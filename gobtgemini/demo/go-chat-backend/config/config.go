@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -26,8 +27,73 @@ type Config struct {
 	LLMAPIURL        string
 	LLMAPIKey        string
 	LLMModel         string
+	LLMModels        []string // 用户和对话可以选择的模型，默认只有 LLMModel
 	LogLevel         string
 	LogFile          string
+
+	// 知识库(RAG)配置
+	ChromaKnowledgeCollection string
+	KnowledgeChunkSize        int
+	KnowledgeChunkOverlap     int
+	KnowledgeTopK             int
+	KnowledgeMaxUploadMB      int
+
+	// 记忆写入队列配置
+	MemoryQueueWorkers     int
+	MemoryQueueBatchSize   int
+	MemoryQueueMaxAttempts int
+	MemoryQueuePollSeconds int
+	MemoryQueueLockMinutes int
+	MemoryQueueRetainDays  int
+
+	// 各实例检查记忆集合切换的间隔（秒）
+	MemoryCollectionPollSeconds int
+
+	// 管理员用户名列表（逗号分隔）
+	AdminUsernames []string
+
+	// Chroma 连接配置：API版本(auto/v1/v2)、租户、数据库、认证和距离函数
+	ChromaAPIVersion  string
+	ChromaTenant      string
+	ChromaDatabase    string
+	ChromaAuthToken   string
+	ChromaTokenHeader string
+	ChromaUsername    string
+	ChromaPassword    string
+	ChromaDistance    string
+
+	// 记忆库后端(chroma/pgvector)及 pgvector 索引配置
+	MemoryBackend        string
+	EmbeddingServiceURL  string
+	PGVectorDimensions   int
+	PGVectorIndex        string
+	PGVectorDistance     string
+	PGVectorIVFFlatLists int
+
+	// 全文检索配置：文本搜索配置名（中文需 zhparser 等扩展，如 chinese）及混合检索参数
+	TextSearchConfig   string
+	MemoryHybridSearch bool
+	MemoryRRFK         int
+
+	// 向量缓存：模型名（为空时从 TEI /info 获取）、进程内 LRU 容量、是否使用 Postgres 二级缓存
+	EmbeddingModel     string
+	EmbeddingCacheSize int
+	EmbeddingCacheDB   bool
+
+	// 已删除对话在回收站中保留的天数，过期后彻底删除
+	ConversationTrashDays int
+
+	// 对话导入文件大小上限
+	ImportMaxUploadMB int
+
+	// 定时提示词：调度轮询间隔和每个用户的数量上限
+	SchedulePollSeconds int
+	ScheduleMaxPerUser  int
+
+	// WebSocket 跨节点分发：memory（单实例）或 postgres（LISTEN/NOTIFY），通知频道和在线情况发布间隔
+	WSBroker          string
+	WSNotifyChannel   string
+	WSPresenceSeconds int
 }
 
 var cfg *Config
@@ -59,7 +125,60 @@ func LoadConfig() {
 		LLMModel:         GetString("LLM_MODEL", "gemini-2.0-flash"),
 		LogLevel:         GetString("LOG_LEVEL", "info"),
 		LogFile:          GetString("LOG_FILE", "logs/app.log"),
+
+		ChromaKnowledgeCollection: GetString("CHROMA_KNOWLEDGE_COLLECTION_NAME", "knowledge_base"),
+		KnowledgeChunkSize:        GetInt("KNOWLEDGE_CHUNK_SIZE", 800),
+		KnowledgeChunkOverlap:     GetInt("KNOWLEDGE_CHUNK_OVERLAP", 150),
+		KnowledgeTopK:             GetInt("KNOWLEDGE_TOP_K", 4),
+		KnowledgeMaxUploadMB:      GetInt("KNOWLEDGE_MAX_UPLOAD_MB", 20),
+
+		MemoryQueueWorkers:     GetInt("MEMORY_QUEUE_WORKERS", 2),
+		MemoryQueueBatchSize:   GetInt("MEMORY_QUEUE_BATCH_SIZE", 16),
+		MemoryQueueMaxAttempts: GetInt("MEMORY_QUEUE_MAX_ATTEMPTS", 5),
+		MemoryQueuePollSeconds: GetInt("MEMORY_QUEUE_POLL_SECONDS", 2),
+		MemoryQueueLockMinutes: GetInt("MEMORY_QUEUE_LOCK_MINUTES", 5),
+		MemoryQueueRetainDays:  GetInt("MEMORY_QUEUE_RETAIN_DAYS", 7),
+
+		MemoryCollectionPollSeconds: GetInt("MEMORY_COLLECTION_POLL_SECONDS", 10),
+
+		AdminUsernames: GetStringSlice("ADMIN_USERNAMES", nil),
+
+		ChromaAPIVersion:  GetString("CHROMA_API_VERSION", "auto"),
+		ChromaTenant:      GetString("CHROMA_TENANT", "default_tenant"),
+		ChromaDatabase:    GetString("CHROMA_DATABASE", "default_database"),
+		ChromaAuthToken:   GetString("CHROMA_AUTH_TOKEN", ""),
+		ChromaTokenHeader: GetString("CHROMA_AUTH_TOKEN_HEADER", "Authorization"),
+		ChromaUsername:    GetString("CHROMA_USERNAME", ""),
+		ChromaPassword:    GetString("CHROMA_PASSWORD", ""),
+		ChromaDistance:    GetString("CHROMA_DISTANCE", "cosine"),
+
+		MemoryBackend:        GetString("MEMORY_BACKEND", "chroma"),
+		EmbeddingServiceURL:  GetString("EMBEDDING_SERVICE_URL", "http://embedding-service/embed"),
+		PGVectorDimensions:   GetInt("PGVECTOR_DIMENSIONS", 1024),
+		PGVectorIndex:        GetString("PGVECTOR_INDEX", "hnsw"),
+		PGVectorDistance:     GetString("PGVECTOR_DISTANCE", "cosine"),
+		PGVectorIVFFlatLists: GetInt("PGVECTOR_IVFFLAT_LISTS", 100),
+
+		TextSearchConfig:   GetString("TEXT_SEARCH_CONFIG", "simple"),
+		MemoryHybridSearch: GetBool("MEMORY_HYBRID_SEARCH", false),
+		MemoryRRFK:         GetInt("MEMORY_RRF_K", 60),
+
+		EmbeddingModel:     GetString("EMBEDDING_MODEL", ""),
+		EmbeddingCacheSize: GetInt("EMBEDDING_CACHE_SIZE", 10000),
+		EmbeddingCacheDB:   GetBool("EMBEDDING_CACHE_DB", false),
+
+		ConversationTrashDays: GetInt("CONVERSATION_TRASH_DAYS", 30),
+
+		ImportMaxUploadMB: GetInt("IMPORT_MAX_UPLOAD_MB", 50),
+
+		SchedulePollSeconds: GetInt("SCHEDULE_POLL_SECONDS", 30),
+		ScheduleMaxPerUser:  GetInt("SCHEDULE_MAX_PER_USER", 20),
+
+		WSBroker:          GetString("WS_BROKER", "memory"),
+		WSNotifyChannel:   GetString("WS_NOTIFY_CHANNEL", "chat_ws_events"),
+		WSPresenceSeconds: GetInt("WS_PRESENCE_SECONDS", 10),
 	}
+	cfg.LLMModels = GetStringSlice("LLM_MODELS", []string{cfg.LLMModel})
 }
 
 // GetString 获取字符串配置
@@ -70,6 +189,22 @@ func GetString(key, defaultValue string) string {
 	return defaultValue
 }
 
+// GetStringSlice 获取逗号分隔的字符串列表配置
+func GetStringSlice(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 // GetInt 获取整数配置
 func GetInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"go-chat-backend/config"
 	"go-chat-backend/models"
+	"go-chat-backend/utils"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
@@ -63,16 +65,289 @@ func runMigrations(db *gorm.DB) error {
 		&models.ChatMessage{},
 		&models.UserPreference{},
 		&models.RefreshToken{},
+		&models.KnowledgeBase{},
+		&models.KnowledgeDocument{},
+		&models.KnowledgeChunk{},
+		&models.ConversationKnowledgeBase{},
+		&models.MemoryJob{},
+		&models.SystemSetting{},
+		&models.ReindexJob{},
+		&models.EmbeddingCacheEntry{},
+		&models.ImportJob{},
+		&models.ConversationShare{},
+		&models.AuditLog{},
+		&models.Folder{},
+		&models.Tag{},
+		&models.ConversationTag{},
+		&models.MessageFeedback{},
+		&models.ConversationParticipant{},
+		&models.RetentionPolicy{},
+		&models.ScheduledPrompt{},
+		&models.ScheduledPromptRun{},
+		&models.Persona{},
 	)
 
 	if err != nil {
 		return fmt.Errorf("模型迁移失败: %w", err)
 	}
 
+	if err := migrateTextSearch(db); err != nil {
+		return fmt.Errorf("全文检索迁移失败: %w", err)
+	}
+
+	if err := migrateMessageTree(db); err != nil {
+		return fmt.Errorf("消息树迁移失败: %w", err)
+	}
+
+	if err := migrateSessionActivity(db); err != nil {
+		return fmt.Errorf("对话活动字段迁移失败: %w", err)
+	}
+
+	if config.Get().MemoryBackend == "pgvector" {
+		if err := migrateVectorStore(db); err != nil {
+			return fmt.Errorf("pgvector迁移失败: %w", err)
+		}
+	}
+
 	logrus.Info("数据库迁移完成")
 	return nil
 }
 
+// migrateTextSearch 为 chat_messages.content 创建全文检索 GIN 表达式索引。
+// TEXT_SEARCH_CONFIG=chinese 时自动基于 zhparser 扩展创建中文分词配置。
+func migrateTextSearch(db *gorm.DB) error {
+	name := config.Get().TextSearchConfig
+	if !utils.IsSQLIdentifier(name) {
+		return fmt.Errorf("无效的文本搜索配置名: %q", name)
+	}
+
+	var exists bool
+	if err := db.Raw("SELECT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = ?)", name).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if !exists {
+		if name != "chinese" {
+			return fmt.Errorf("文本搜索配置 %s 不存在", name)
+		}
+		statements := []string{
+			"CREATE EXTENSION IF NOT EXISTS zhparser",
+			"CREATE TEXT SEARCH CONFIGURATION chinese (PARSER = zhparser)",
+			"ALTER TEXT SEARCH CONFIGURATION chinese ADD MAPPING FOR n,v,a,i,e,l,j WITH simple",
+		}
+		for _, stmt := range statements {
+			if err := db.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("创建中文分词配置失败（需要安装 zhparser 扩展）: %w", err)
+			}
+		}
+		logrus.Info("已创建中文文本搜索配置: chinese")
+	}
+
+	indexName := "idx_chat_messages_content_fts_" + name
+	var existing []string
+	err := db.Raw(`SELECT indexname FROM pg_indexes
+WHERE tablename = 'chat_messages' AND indexname LIKE 'idx_chat_messages_content_fts_%'`).Scan(&existing).Error
+	if err != nil {
+		return err
+	}
+	for _, old := range existing {
+		if old != indexName {
+			if err := db.Exec("DROP INDEX IF EXISTS " + old).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON chat_messages USING gin (to_tsvector('%s', content))", indexName, name)).Error; err != nil {
+		return err
+	}
+
+	return migrateTrigramIndex(db)
+}
+
+// migrateTrigramIndex 创建 pg_trgm 索引，供分词配置无法切分的中日韩文字做子串搜索。
+// 没有权限安装扩展时只记录警告，搜索仍可用但需要全表扫描。
+func migrateTrigramIndex(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		logrus.WithError(err).Warn("安装 pg_trgm 扩展失败，中日韩文字搜索将不使用索引")
+		return nil
+	}
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_chat_messages_content_trgm ON chat_messages USING gin (content gin_trgm_ops)").Error
+}
+
+// migrateMessageTree 为引入消息分支之前的数据补齐 parent_id 和 active_leaf_id：
+// 同一对话中的消息按时间顺序串成一条链，最新消息作为当前分支末尾。只执行一次。
+func migrateMessageTree(db *gorm.DB) error {
+	const settingKey = "migration.message_tree_backfill"
+
+	var done int64
+	if err := db.Model(&models.SystemSetting{}).Where("key = ?", settingKey).Count(&done).Error; err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE chat_messages m SET parent_id = p.prev_id
+FROM (
+	SELECT id, LAG(id) OVER (PARTITION BY conversation_id ORDER BY created_at, id) AS prev_id
+	FROM chat_messages
+) p
+WHERE m.id = p.id AND m.parent_id IS NULL AND p.prev_id IS NOT NULL`).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec(`UPDATE chat_sessions s SET active_leaf_id = (
+	SELECT m.id FROM chat_messages m
+	WHERE m.conversation_id = s.id AND m.deleted_at IS NULL
+	ORDER BY m.created_at DESC, m.id DESC LIMIT 1
+)
+WHERE s.active_leaf_id IS NULL`).Error
+		if err != nil {
+			return err
+		}
+
+		logrus.Info("已为历史消息补齐消息树字段")
+		return tx.Create(&models.SystemSetting{Key: settingKey, Value: time.Now().Format(time.RFC3339)}).Error
+	})
+}
+
+// migrateSessionActivity 为已有对话补齐最后消息时间、消息数和最后一条消息的摘要。只执行一次。
+func migrateSessionActivity(db *gorm.DB) error {
+	const settingKey = "migration.session_activity_backfill"
+
+	var done int64
+	if err := db.Model(&models.SystemSetting{}).Where("key = ?", settingKey).Count(&done).Error; err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE chat_sessions s SET
+	message_count = (
+		SELECT COUNT(*) FROM chat_messages m
+		WHERE m.conversation_id = s.id AND m.deleted_at IS NULL
+	),
+	last_message_at = COALESCE((
+		SELECT MAX(m.created_at) FROM chat_messages m
+		WHERE m.conversation_id = s.id AND m.deleted_at IS NULL
+	), s.created_at),
+	last_message_preview = COALESCE((
+		SELECT LEFT(regexp_replace(m.content, '\s+', ' ', 'g'), 100) FROM chat_messages m
+		WHERE m.conversation_id = s.id AND m.deleted_at IS NULL
+		ORDER BY m.created_at DESC, m.id DESC LIMIT 1
+	), '')`).Error
+		if err != nil {
+			return err
+		}
+
+		logrus.Info("已为历史对话补齐最后消息时间和消息数")
+		return tx.Create(&models.SystemSetting{Key: settingKey, Value: time.Now().Format(time.RFC3339)}).Error
+	})
+}
+
+// pgvector 距离函数对应的索引运算符类
+var vectorOpsClasses = map[string]string{
+	"cosine": "vector_cosine_ops",
+	"l2":     "vector_l2_ops",
+	"ip":     "vector_ip_ops",
+}
+
+// migrateVectorStore 创建 pgvector 扩展、记忆向量表和向量索引。
+// 向量维度取决于 embedding 模型，因此表结构不走 AutoMigrate。
+func migrateVectorStore(db *gorm.DB) error {
+	cfg := config.Get()
+
+	opsClass, ok := vectorOpsClasses[cfg.PGVectorDistance]
+	if !ok {
+		return fmt.Errorf("不支持的距离函数: %s", cfg.PGVectorDistance)
+	}
+	if cfg.PGVectorDimensions <= 0 {
+		return fmt.Errorf("无效的向量维度: %d", cfg.PGVectorDimensions)
+	}
+
+	statements := []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS memory_vectors (
+	id text NOT NULL,
+	collection varchar(100) NOT NULL,
+	user_id uuid NOT NULL,
+	conversation_id uuid,
+	role varchar(20),
+	content text NOT NULL,
+	embedding vector(%d) NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (collection, id)
+)`, cfg.PGVectorDimensions),
+		"CREATE INDEX IF NOT EXISTS idx_memory_vectors_user ON memory_vectors (collection, user_id)",
+	}
+	for _, stmt := range statements {
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+
+	// 已有表的维度与配置不一致时，写入会失败，直接报错提示
+	var dimensions int
+	err := db.Raw(`SELECT atttypmod FROM pg_attribute
+WHERE attrelid = 'memory_vectors'::regclass AND attname = 'embedding'`).Scan(&dimensions).Error
+	if err != nil {
+		return err
+	}
+	if dimensions != cfg.PGVectorDimensions {
+		return fmt.Errorf("memory_vectors.embedding 维度为 %d，与 PGVECTOR_DIMENSIONS=%d 不一致", dimensions, cfg.PGVectorDimensions)
+	}
+
+	return migrateVectorIndex(db, cfg.PGVectorIndex, cfg.PGVectorDistance, opsClass, cfg.PGVectorIVFFlatLists)
+}
+
+// migrateVectorIndex 按配置创建 HNSW 或 IVFFlat 索引，并删除类型或距离函数不同的旧索引
+func migrateVectorIndex(db *gorm.DB, indexType, distance, opsClass string, lists int) error {
+	var indexName, createSQL string
+	switch indexType {
+	case "hnsw":
+		indexName = "idx_memory_vectors_embedding_hnsw_" + distance
+		createSQL = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON memory_vectors USING hnsw (embedding %s) WITH (m = 16, ef_construction = 64)", indexName, opsClass)
+	case "ivfflat":
+		if lists <= 0 {
+			lists = 100
+		}
+		indexName = "idx_memory_vectors_embedding_ivfflat_" + distance
+		createSQL = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON memory_vectors USING ivfflat (embedding %s) WITH (lists = %d)", indexName, opsClass, lists)
+	case "none", "":
+	default:
+		return fmt.Errorf("不支持的向量索引类型: %s", indexType)
+	}
+
+	var existing []string
+	err := db.Raw(`SELECT indexname FROM pg_indexes
+WHERE tablename = 'memory_vectors' AND indexname LIKE 'idx_memory_vectors_embedding_%'`).Scan(&existing).Error
+	if err != nil {
+		return err
+	}
+	for _, name := range existing {
+		if name == indexName {
+			continue
+		}
+		if err := db.Exec("DROP INDEX IF EXISTS " + name).Error; err != nil {
+			return err
+		}
+		logrus.Info("已删除旧的向量索引: ", name)
+	}
+
+	if createSQL == "" {
+		return nil
+	}
+	if err := db.Exec(createSQL).Error; err != nil {
+		return err
+	}
+	logrus.Info("向量索引已就绪: ", indexName)
+	return nil
+}
+
 // GetDB 获取数据库实例
 func GetDB() *gorm.DB {
 	return DB
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"go-chat-backend/websocket"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminHandler 管理接口处理器
+type AdminHandler struct {
+	memoryQueue      *services.MemoryQueue
+	reindexService   *services.ReindexService
+	embeddingService *services.EmbeddingService
+	hub              *websocket.Hub
+}
+
+// NewAdminHandler 创建管理接口处理器
+func NewAdminHandler(memoryQueue *services.MemoryQueue, reindexService *services.ReindexService, embeddingService *services.EmbeddingService) *AdminHandler {
+	return &AdminHandler{
+		memoryQueue:      memoryQueue,
+		reindexService:   reindexService,
+		embeddingService: embeddingService,
+	}
+}
+
+// SetWebSocketHub 设置WebSocket Hub，用于统计各节点的在线情况
+func (h *AdminHandler) SetWebSocketHub(hub *websocket.Hub) {
+	h.hub = hub
+}
+
+// StartReindexRequest 重建记忆集合请求结构
+type StartReindexRequest struct {
+	TargetCollection string `json:"target_collection" binding:"required"`
+	SwitchOnComplete bool   `json:"switch_on_complete"`
+	BatchSize        int    `json:"batch_size"`
+}
+
+// SwitchCollectionRequest 切换记忆集合请求结构
+type SwitchCollectionRequest struct {
+	Collection string `json:"collection" binding:"required"`
+}
+
+// reindexJobResponse 重建任务及进度
+type reindexJobResponse struct {
+	*models.ReindexJob
+	Progress float64 `json:"progress"`
+}
+
+// GetMetrics 获取运行指标，如向量缓存命中率和各节点的WebSocket在线情况
+func (h *AdminHandler) GetMetrics(c *gin.Context) {
+	metrics := gin.H{
+		"embedding_cache": h.embeddingService.Stats(),
+	}
+	if h.hub != nil {
+		metrics["websocket"] = h.hub.Presence()
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: metrics,
+	})
+}
+
+// GetMemoryQueueStatus 获取记忆写入队列深度和失败情况
+func (h *AdminHandler) GetMemoryQueueStatus(c *gin.Context) {
+	stats, err := h.memoryQueue.Stats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "QUEUE_STATUS_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: stats,
+	})
+}
+
+// RetryDeadMemoryJobs 重新排队所有死信任务
+func (h *AdminHandler) RetryDeadMemoryJobs(c *gin.Context) {
+	count, err := h.memoryQueue.RetryDead()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "QUEUE_RETRY_FAILED",
+		})
+		return
+	}
+
+	logrus.WithField("count", count).Info("死信记忆任务已重新排队")
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    gin.H{"requeued": count},
+		Message: "死信任务已重新排队",
+	})
+}
+
+// StartReindex 从 chat_messages 重建记忆集合，后台执行；同一目标集合的未完成任务会从断点继续
+func (h *AdminHandler) StartReindex(c *gin.Context) {
+	var req StartReindexRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 target_collection",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	job, err := h.reindexService.PrepareReindex(req.TargetCollection, req.SwitchOnComplete, req.BatchSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "REINDEX_START_FAILED",
+		})
+		return
+	}
+
+	// 先在数据库中领取任务，已有任务在运行时直接返回 409
+	job, err = h.reindexService.ClaimReindex(job.ID)
+	if err != nil {
+		respondReindexError(c, err, "REINDEX_START_FAILED")
+		return
+	}
+
+	go func() {
+		if err := h.reindexService.RunClaimedReindex(context.Background(), job, nil); err != nil {
+			logrus.WithError(err).WithField("job_id", job.ID).Warn("重建任务未完成")
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, utils.SuccessResponse{
+		Data:    reindexJobResponse{ReindexJob: job, Progress: services.ReindexProgress(job)},
+		Message: "重建任务已开始",
+	})
+}
+
+// ListReindexJobs 获取最近的重建任务
+func (h *AdminHandler) ListReindexJobs(c *gin.Context) {
+	jobs, err := h.reindexService.ListReindexJobs(20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "REINDEX_FETCH_FAILED",
+		})
+		return
+	}
+
+	result := make([]reindexJobResponse, 0, len(jobs))
+	for i := range jobs {
+		result = append(result, reindexJobResponse{ReindexJob: &jobs[i], Progress: services.ReindexProgress(&jobs[i])})
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: result,
+	})
+}
+
+// GetReindexJob 获取重建任务进度
+func (h *AdminHandler) GetReindexJob(c *gin.Context) {
+	jobID, ok := parseUUIDParam(c, "id", "无效的任务ID")
+	if !ok {
+		return
+	}
+
+	job, err := h.reindexService.GetReindexJob(jobID)
+	if err != nil {
+		respondReindexError(c, err, "REINDEX_FETCH_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: reindexJobResponse{ReindexJob: job, Progress: services.ReindexProgress(job)},
+	})
+}
+
+// CancelReindex 暂停正在运行的重建任务
+func (h *AdminHandler) CancelReindex(c *gin.Context) {
+	jobID, ok := parseUUIDParam(c, "id", "无效的任务ID")
+	if !ok {
+		return
+	}
+
+	if err := h.reindexService.CancelReindex(jobID); err != nil {
+		respondReindexError(c, err, "REINDEX_CANCEL_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "重建任务已暂停，可再次提交相同目标集合继续",
+	})
+}
+
+// GetMemoryCollection 获取当前使用的记忆集合
+func (h *AdminHandler) GetMemoryCollection(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{"collection": h.reindexService.ActiveCollection()},
+	})
+}
+
+// SwitchMemoryCollection 切换记忆集合
+func (h *AdminHandler) SwitchMemoryCollection(c *gin.Context) {
+	var req SwitchCollectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 collection",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := h.reindexService.SwitchActiveCollection(req.Collection); err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "COLLECTION_SWITCH_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    gin.H{"collection": req.Collection},
+		Message: "记忆集合已切换",
+	})
+}
+
+// respondReindexError 将重建服务错误映射为HTTP响应
+func respondReindexError(c *gin.Context, err error, code string) {
+	if errors.Is(err, services.ErrReindexJobNotFound) {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrReindexAlreadyRunning) || errors.Is(err, services.ErrReindexAnotherRunning) ||
+		errors.Is(err, services.ErrReindexCompleted) {
+		c.JSON(http.StatusConflict, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "REINDEX_CONFLICT",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+		Error: err.Error(),
+		Code:  code,
+	})
+}
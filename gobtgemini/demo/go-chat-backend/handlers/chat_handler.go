@@ -1,13 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"go-chat-backend/middleware"
 	"go-chat-backend/models"
 	"go-chat-backend/services"
 	"go-chat-backend/utils"
 	"go-chat-backend/websocket"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
@@ -18,19 +19,22 @@ import (
 
 // ChatHandler 聊天处理器
 type ChatHandler struct {
-	chatService   *services.ChatService
-	llmService    *services.LLMService
-	chromaService *services.ChromaService
-	userService   *services.UserService
-	hub           *websocket.Hub
+	chatService *services.ChatService
+	llmService  *services.LLMService
+	memoryStore services.MemoryStore
+	userService *services.UserService
+	hub         *websocket.Hub
+
+	knowledgeService *services.KnowledgeService
+	memoryQueue      *services.MemoryQueue
 }
 
 // NewChatHandler 创建聊天处理器
-func NewChatHandler(chatService *services.ChatService, llmService *services.LLMService, chromaService *services.ChromaService) *ChatHandler {
+func NewChatHandler(chatService *services.ChatService, llmService *services.LLMService, memoryStore services.MemoryStore) *ChatHandler {
 	return &ChatHandler{
-		chatService:   chatService,
-		llmService:    llmService,
-		chromaService: chromaService,
+		chatService: chatService,
+		llmService:  llmService,
+		memoryStore: memoryStore,
 	}
 }
 
@@ -39,6 +43,16 @@ func (h *ChatHandler) SetUserService(userService *services.UserService) {
 	h.userService = userService
 }
 
+// SetKnowledgeService 设置知识库服务
+func (h *ChatHandler) SetKnowledgeService(knowledgeService *services.KnowledgeService) {
+	h.knowledgeService = knowledgeService
+}
+
+// SetMemoryQueue 设置记忆写入队列
+func (h *ChatHandler) SetMemoryQueue(memoryQueue *services.MemoryQueue) {
+	h.memoryQueue = memoryQueue
+}
+
 // SetWebSocketHub 设置WebSocket Hub
 func (h *ChatHandler) SetWebSocketHub(hub *websocket.Hub) {
 	h.hub = hub
@@ -62,6 +76,23 @@ type CreateConversationRequest struct {
 	Title string `json:"title"`
 }
 
+// UpdateConversationRequest 修改对话请求结构，未提供的字段保持不变
+type UpdateConversationRequest struct {
+	Title       *string `json:"title" binding:"omitempty,max=200"`
+	Description *string `json:"description"`
+	Pinned      *bool   `json:"pinned"`
+	Archived    *bool   `json:"archived"`
+	FolderID    *string `json:"folder_id"` // 空字符串表示移出文件夹
+}
+
+// BulkConversationRequest 批量操作对话请求结构
+type BulkConversationRequest struct {
+	ConversationIDs []uuid.UUID `json:"conversation_ids" binding:"required,min=1,max=500"`
+	Action          string      `json:"action" binding:"required"`
+	FolderID        *uuid.UUID  `json:"folder_id"` // move 时使用，为空表示移出文件夹
+	TagIDs          []uuid.UUID `json:"tag_ids"`   // tag、untag 时使用
+}
+
 // SendMessage 发送聊天消息
 func (h *ChatHandler) SendMessage(c *gin.Context) {
 	startTime := time.Now()
@@ -97,117 +128,20 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
-	// 保存用户消息 (现在传入 ConversationID)
-	userMessage, err := h.chatService.SendMessage(user.ID, req.ConversationID, req.Content, "user")
-	if err != nil {
-		logrus.WithError(err).Error("保存用户消息失败")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
-			Error: "消息保存失败",
-			Code:  "MESSAGE_SAVE_FAILED",
-		})
-		return
-	}
-
-	// 获取用户偏好设置
-	var userPreference *models.UserPreference
-	if h.userService != nil {
-		userPreference, err = h.userService.GetUserPreference(user.ID)
-		if err != nil {
-			logrus.WithError(err).Warn("获取用户偏好失败，使用默认设置")
-			// 使用默认偏好
-			userPreference = &models.UserPreference{
-				LLMModel:      "gpt-3.5-turbo",
-				Temperature:   0.7,
-				MaxTokens:     2000,
-				ContextWindow: 10,
-				MemoryEnabled: true,
-			}
-		}
-	} else {
-		// 默认偏好
-		userPreference = &models.UserPreference{
-			LLMModel:      "gpt-3.5-turbo",
-			Temperature:   0.7,
-			MaxTokens:     2000,
-			ContextWindow: 10,
-			MemoryEnabled: true,
-		}
-	}
-
-	// 获取上下文消息 (现在也需要 ConversationID)
-	contextMessages, err := h.chatService.GetRecentMessages(req.ConversationID, userPreference.ContextWindow)
+	// 保存用户消息、生成回复并推送
+	userMessage, reply, err := h.sendAndReply(user, req.ConversationID, req.Content, nil)
 	if err != nil {
-		logrus.WithError(err).Warn("获取上下文消息失败")
-		contextMessages = []models.ChatMessage{*userMessage}
-	}
-
-	// 如果启用了记忆功能，搜索相关记忆
-	var memoryContext []string
-	if userPreference.MemoryEnabled && h.chromaService != nil {
-		memoryContext, err = h.chromaService.SearchMemory(user.ID, req.Content, 3)
-		if err != nil {
-			logrus.WithError(err).Warn("搜索记忆失败")
-		}
-	}
-
-	// 如果有记忆上下文，将其添加到系统提示中
-	if len(memoryContext) > 0 {
-		memoryPrompt := "相关记忆：" + strings.Join(memoryContext, "\n")
-		if userPreference.SystemPrompt != "" {
-			userPreference.SystemPrompt += "\n\n" + memoryPrompt
-		} else {
-			userPreference.SystemPrompt = memoryPrompt
+		if userMessage == nil {
+			respondChatError(c, err, "MESSAGE_SAVE_FAILED", "消息保存失败")
+			return
 		}
-	}
-
-	// 生成AI回复
-	response, err := h.llmService.GenerateResponse(contextMessages, userPreference)
-	if err != nil {
-		logrus.WithError(err).Error("AI回复生成失败")
 		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
 			Error: "AI服务暂时不可用，请稍后再试",
 			Code:  "AI_SERVICE_ERROR",
 		})
 		return
 	}
-
-	// 保存AI回复 (现在也传入 ConversationID)
-	assistantMessage, err := h.chatService.SendMessage(user.ID, req.ConversationID, response, "assistant")
-	if err != nil {
-		logrus.WithError(err).Error("保存AI回复失败")
-		// 不阻止请求，但记录错误
-	}
-
-	// 如果启用了记忆功能，保存对话到向量数据库
-	if userPreference.MemoryEnabled && h.chromaService != nil {
-		// 保存用户消息
-		if err := h.chromaService.AddMemory(user.ID, req.Content, "user"); err != nil {
-			logrus.WithError(err).Warn("保存用户消息到记忆失败")
-		}
-
-		// 保存AI回复
-		if err := h.chromaService.AddMemory(user.ID, response, "assistant"); err != nil {
-			logrus.WithError(err).Warn("保存AI回复到记忆失败")
-		}
-	}
-
-	// 通过WebSocket发送实时消息
-	if h.hub != nil {
-		wsMessage := websocket.Message{
-			Type:      "chat_response",
-			Content:   response,
-			UserID:    user.ID,
-			Username:  user.Username,
-			Timestamp: time.Now(),
-			Data: gin.H{
-				"user_message":      userMessage,
-				"assistant_message": assistantMessage,
-			},
-		}
-		if err := h.hub.SendToUser(user.ID, wsMessage); err != nil {
-			logrus.WithError(err).Warn("发送WebSocket消息失败")
-		}
-	}
+	assistantMessage, response := reply.message, reply.content
 
 	processingTime := time.Since(startTime)
 
@@ -229,7 +163,8 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 	}).Info("聊天消息处理完成")
 }
 
-// GetChatHistory 获取聊天历史
+// GetChatHistory 获取用户所有对话中的消息
+// 查询参数：cursor、direction（before 默认 / after）、limit；带 since 时改为返回同步令牌之后变更的消息
 func (h *ChatHandler) GetChatHistory(c *gin.Context) {
 	// 获取用户信息
 	user, err := middleware.GetUserFromContext(c)
@@ -241,40 +176,34 @@ func (h *ChatHandler) GetChatHistory(c *gin.Context) {
 		return
 	}
 
-	// 获取查询参数
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
-	
-
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 || limit > 100 {
-		limit = 50
-	}
-
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	if sync, ok := syncRequest(c); ok {
+		changes, err := h.chatService.SyncMessages(user.ID, nil, sync)
+		if err != nil {
+			respondPageError(c, err, "HISTORY_FETCH_FAILED", "获取聊天历史失败")
+			return
+		}
+		c.JSON(http.StatusOK, utils.SuccessResponse{
+			Data:    changes,
+			Message: "获取成功",
+		})
+		return
 	}
 
-
-	// 获取聊天历史
-	messages, err := h.chatService.GetChatHistory(user.ID,limit, offset)
+	// 同步令牌在查询前生成，避免漏掉查询期间的变更
+	syncToken := h.chatService.SyncToken()
+	messages, page, err := h.chatService.GetChatHistory(user.ID, pageRequest(c))
 	if err != nil {
-		logrus.WithError(err).Error("获取聊天历史失败")
-		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
-			Error: "获取聊天历史失败",
-			Code:  "HISTORY_FETCH_FAILED",
-		})
+		respondPageError(c, err, "HISTORY_FETCH_FAILED", "获取聊天历史失败")
 		return
 	}
 
 	c.JSON(http.StatusOK, utils.SuccessResponse{
 		Data: gin.H{
-			"messages": messages,
-			"limit":    limit,
-			"offset":   offset,
-			"count":    len(messages),
+			"messages":   messages,
+			"page":       page,
+			"sync_token": syncToken,
 		},
+		Message: "获取成功",
 	})
 }
 
@@ -305,10 +234,7 @@ func (h *ChatHandler) DeleteMessage(c *gin.Context) {
 	err = h.chatService.DeleteMessage(user.ID, messageID)
 	if err != nil {
 		logrus.WithError(err).Error("删除消息失败")
-		c.JSON(http.StatusNotFound, utils.ErrorResponse{
-			Error: err.Error(),
-			Code:  "DELETE_FAILED",
-		})
+		respondChatError(c, err, "DELETE_FAILED", "删除消息失败")
 		return
 	}
 
@@ -346,8 +272,8 @@ func (h *ChatHandler) ClearHistory(c *gin.Context) {
 	}
 
 	// 如果启用了Chroma，也清空记忆
-	if h.chromaService != nil {
-		if err := h.chromaService.ClearUserMemory(user.ID); err != nil {
+	if h.memoryStore != nil {
+		if err := h.memoryStore.ClearUserMemory(user.ID); err != nil {
 			logrus.WithError(err).Warn("清空用户记忆失败")
 		}
 	}
@@ -359,39 +285,78 @@ func (h *ChatHandler) ClearHistory(c *gin.Context) {
 	logrus.WithField("user_id", user.ID).Info("聊天历史清空成功")
 }
 
-//获取上下会话列表
+// GetConversations 获取对话列表，按最后一条消息的时间从新到旧
+// 查询参数：archived、pinned、folder_id（none 表示未归入文件夹）、tag_id、cursor、direction、limit；带 since 时改为返回同步令牌之后变更的对话（包括已删除的）
 func (h *ChatHandler) GetConversations(c *gin.Context) {
-	// 1. 从JWT中间件设置的上下文中获取用户ID
-	userIDClaim, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
 		return
 	}
-	userID, ok := userIDClaim.(uuid.UUID)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "无效的用户ID格式"})
+
+	if sync, ok := syncRequest(c); ok {
+		changes, err := h.chatService.SyncChatSessions(user.ID, sync)
+		if err != nil {
+			respondPageError(c, err, "CONVERSATIONS_FETCH_FAILED", "获取对话列表失败")
+			return
+		}
+		c.JSON(http.StatusOK, utils.SuccessResponse{
+			Data:    changes,
+			Message: "获取成功",
+		})
 		return
 	}
 
-	// 2. 从URL查询参数中获取分页信息 (可选，但推荐)
-	// 如果前端没有提供，则使用默认值
-	limitStr := c.DefaultQuery("limit", "20")
-	offsetStr := c.DefaultQuery("offset", "0")
-	limit, _ := strconv.Atoi(limitStr)
-	offset, _ := strconv.Atoi(offsetStr)
+	filter := services.ConversationFilter{
+		Archived: c.Query("archived") == "true", // 默认只返回未归档的对话
+	}
+	if raw := c.Query("pinned"); raw != "" {
+		pinned := raw == "true"
+		filter.Pinned = &pinned
+	}
+	// folder_id=none 表示不在任何文件夹中的对话
+	if raw := c.Query("folder_id"); raw == "none" {
+		filter.Unfiled = true
+	} else if raw != "" {
+		folderID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+				Error: "无效的文件夹ID",
+				Code:  "INVALID_ID",
+			})
+			return
+		}
+		filter.FolderID = &folderID
+	}
+	if raw := c.Query("tag_id"); raw != "" {
+		tagID, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+				Error: "无效的标签ID",
+				Code:  "INVALID_ID",
+			})
+			return
+		}
+		filter.TagID = &tagID
+	}
 
-	// 3. 调用您已经存在的 Service 函数来获取数据
-	// 假设您的 ChatHandler 结构体中有一个名为 chatService 的字段
-	sessions, err := h.chatService.GetChatSessions(userID, limit, offset)
+	syncToken := h.chatService.SyncToken()
+	sessions, page, err := h.chatService.GetChatSessions(user.ID, filter, pageRequest(c))
 	if err != nil {
-		// Service 层已经记录了详细日志，这里直接返回通用错误
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取对话列表失败"})
+		respondPageError(c, err, "CONVERSATIONS_FETCH_FAILED", "获取对话列表失败")
 		return
 	}
 
-	// 4. 成功后，返回对话列表
-	c.JSON(http.StatusOK, gin.H{
-		"data": sessions,
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"conversations": sessions,
+			"page":          page,
+			"sync_token":    syncToken,
+		},
+		Message: "获取成功",
 	})
 }
 
@@ -424,10 +389,13 @@ func (h *ChatHandler) CreateConversation(c *gin.Context) {
 // GetConversationHistory 获取指定对话的聊天历史
 func (h *ChatHandler) GetOneConversationHistory(c *gin.Context) {
     // --- 第1步：从 JWT 上下文中获取当前用户信息 ---
-	// 这是为了安全，确保用户只能访问自己的聊天记录，尽管当前逻辑暂未校验。
-	_, err := middleware.GetUserFromContext(c)
+	// 这是为了安全，确保用户只能访问自己有权查看的对话。
+	user, err := middleware.GetUserFromContext(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "无效的认证信息"})
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
 		return
 	}
 
@@ -437,40 +405,744 @@ func (h *ChatHandler) GetOneConversationHistory(c *gin.Context) {
 	conversationID, err := uuid.Parse(conversationIDStr)
 	if err != nil {
 		// 如果解析失败，说明URL中的ID不是一个有效的UUID格式
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的对话ID格式"})
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "无效的对话ID格式",
+			Code:  "INVALID_ID",
+		})
 		return
 	}
 
-	// --- 第3步：从 URL 查询参数中解析分页信息 ---
-	limitStr := c.DefaultQuery("limit", "50")
-	offsetStr := c.DefaultQuery("offset", "0")
-
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 || limit > 200 { // 增加一个最大值限制，防止滥用
-		limit = 50
+	// view=tree 时返回包含所有分支的完整消息树
+	if c.Query("view") == "tree" {
+		tree, err := h.chatService.GetConversationTree(user.ID, conversationID)
+		if err != nil {
+			respondChatError(c, err, "HISTORY_FETCH_FAILED", "获取对话历史失败")
+			return
+		}
+		c.JSON(http.StatusOK, utils.SuccessResponse{
+			Data: gin.H{
+				"tree": tree,
+			},
+			Message: "获取成功",
+		})
+		return
 	}
 
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	// --- 第3步：带 since 时返回该对话中同步令牌之后变更的消息（包括其他分支和已删除的消息） ---
+	if sync, ok := syncRequest(c); ok {
+		changes, err := h.chatService.SyncMessages(user.ID, &conversationID, sync)
+		if err != nil {
+			respondPageError(c, err, "HISTORY_FETCH_FAILED", "获取对话历史失败")
+			return
+		}
+		c.JSON(http.StatusOK, utils.SuccessResponse{
+			Data:    changes,
+			Message: "获取成功",
+		})
+		return
 	}
 
 	// --- 第4步：调用 Service 层函数，执行业务逻辑 ---
-	// h.chatService 是您在 ChatHandler 结构体中定义的 ChatService 实例
-	messages, err := h.chatService.GetOneConversationHistory(conversationID, limit, offset)
+	// 默认返回当前分支上最新的一页消息，sibling_count 大于1的消息为分支切换点
+	syncToken := h.chatService.SyncToken()
+	messages, page, err := h.chatService.GetOneConversationHistory(user.ID, conversationID, pageRequest(c))
 	if err != nil {
-		// 如果 Service 层返回错误（例如数据库查询失败），则向前端返回服务器内部错误
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取对话历史失败"})
+		// 对话不存在或属于他人时返回404，其他错误（例如数据库查询失败）返回服务器内部错误
+		respondPageError(c, err, "HISTORY_FETCH_FAILED", "获取对话历史失败")
 		return
 	}
 
 	// --- 第5步：成功后，将查询结果以 JSON 格式返回给前端 ---
-	c.JSON(http.StatusOK, gin.H{
-		"message": "获取成功",
-		"data": gin.H{
-			"messages": messages,
-			"limit":    limit,
-			"offset":   offset,
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"messages":   messages,
+			"page":       page,
+			"sync_token": syncToken,
+		},
+		Message: "获取成功",
+	})
+}
+
+// UpdateConversation 修改对话标题、描述、置顶和归档状态
+func (h *ChatHandler) UpdateConversation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if req.Title != nil {
+		updates["title"] = strings.TrimSpace(*req.Title)
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Pinned != nil {
+		updates["pinned"] = *req.Pinned
+	}
+	if req.Archived != nil {
+		updates["archived"] = *req.Archived
+	}
+	if req.FolderID != nil {
+		var folderID *uuid.UUID
+		if *req.FolderID != "" {
+			parsed, err := uuid.Parse(*req.FolderID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+					Error: "无效的文件夹ID",
+					Code:  "INVALID_ID",
+				})
+				return
+			}
+			folderID = &parsed
+		}
+		updates["folder_id"] = folderID
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "没有有效的更新字段",
+			Code:  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	if err := h.chatService.UpdateChatSession(user.ID, conversationID, updates); err != nil {
+		respondOrganizeError(c, err, "UPDATE_FAILED", "更新对话失败")
+		return
+	}
+
+	session, err := h.chatService.GetChatSession(user.ID, conversationID)
+	if err != nil {
+		respondChatError(c, err, "UPDATE_FAILED", "更新对话失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    session,
+		Message: "对话已更新",
+	})
+}
+
+// ConversationSettingsRequest 替换对话设置请求结构，persona_id 为空时不使用人设
+type ConversationSettingsRequest struct {
+	models.GenerationSettings
+	PersonaID *uuid.UUID `json:"persona_id"`
+}
+
+// conversationSettingsResponse 对话设置、选用的人设、合并后实际使用的参数和可选模型
+type conversationSettingsResponse struct {
+	Settings        models.GenerationSettings  `json:"settings"`
+	PersonaID       *uuid.UUID                 `json:"persona_id"`
+	Effective       services.EffectiveSettings `json:"effective"`
+	AvailableModels []string                   `json:"available_models"`
+}
+
+// GetConversationSettings 获取对话级生成参数及合并后实际使用的参数
+func (h *ChatHandler) GetConversationSettings(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	h.respondConversationSettings(c, user, conversationID, "")
+}
+
+// UpdateConversationSettings 替换对话级生成参数和选用的人设，未提供或为 null 的字段沿用上一级
+func (h *ChatHandler) UpdateConversationSettings(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req ConversationSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if _, err := h.chatService.UpdateConversationSettings(user.ID, conversationID, req.GenerationSettings, req.PersonaID); err != nil {
+		respondPersonaError(c, err, "UPDATE_SETTINGS_FAILED", "更新对话设置失败")
+		return
+	}
+
+	h.respondConversationSettings(c, user, conversationID, "对话设置已更新")
+}
+
+// respondConversationSettings 返回对话设置及合并后实际使用的参数
+func (h *ChatHandler) respondConversationSettings(c *gin.Context, user *models.User, conversationID uuid.UUID, message string) {
+	session, err := h.chatService.GetChatSession(user.ID, conversationID)
+	if err != nil {
+		respondChatError(c, err, "GET_SETTINGS_FAILED", "获取对话设置失败")
+		return
+	}
+	effective, err := h.effectiveSettings(user, conversationID)
+	if err != nil {
+		respondChatError(c, err, "GET_SETTINGS_FAILED", "获取对话设置失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: conversationSettingsResponse{
+			Settings:        session.Settings,
+			PersonaID:       session.PersonaID,
+			Effective:       effective,
+			AvailableModels: services.AvailableModels(),
 		},
+		Message: message,
+	})
+}
+
+// BulkUpdateConversations 批量移动、打标签、置顶、归档或删除对话
+func (h *ChatHandler) BulkUpdateConversations(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req BulkConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	result, err := h.chatService.BulkUpdateChatSessions(user.ID, services.BulkOperation{
+		ConversationIDs: req.ConversationIDs,
+		Action:          req.Action,
+		FolderID:        req.FolderID,
+		TagIDs:          req.TagIDs,
 	})
-}
\ No newline at end of file
+	if err != nil {
+		respondOrganizeError(c, err, "BULK_UPDATE_FAILED", "批量操作失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    result,
+		Message: "批量操作完成",
+	})
+}
+
+// DeleteConversation 将对话移入回收站，保留期内可恢复
+func (h *ChatHandler) DeleteConversation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	if err := h.chatService.DeleteChatSession(user.ID, conversationID); err != nil {
+		respondChatError(c, err, "DELETE_FAILED", "删除对话失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"restore_before": time.Now().Add(h.chatService.TrashRetention()),
+		},
+		Message: "对话已移入回收站",
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":         user.ID,
+		"conversation_id": conversationID,
+	}).Info("对话已移入回收站")
+}
+
+// GetDeletedConversations 获取回收站中的对话
+func (h *ChatHandler) GetDeletedConversations(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	sessions, page, err := h.chatService.GetDeletedChatSessions(user.ID, pageRequest(c))
+	if err != nil {
+		respondPageError(c, err, "TRASH_FETCH_FAILED", "获取回收站失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"conversations":  sessions,
+			"page":           page,
+			"retention_days": int(h.chatService.TrashRetention().Hours() / 24),
+		},
+	})
+}
+
+// RestoreConversation 从回收站恢复对话
+func (h *ChatHandler) RestoreConversation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	session, err := h.chatService.RestoreChatSession(user.ID, conversationID)
+	if err != nil {
+		respondChatError(c, err, "RESTORE_FAILED", "恢复对话失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    session,
+		Message: "对话已恢复",
+	})
+}
+
+// sendAndReply 发送消息的完整流程：保存用户消息、生成AI回复，再加入记忆写入队列并通过WebSocket推送。
+// 保存用户消息失败时 userMessage 为 nil；生成回复失败时用户消息已保存
+func (h *ChatHandler) sendAndReply(user *models.User, conversationID uuid.UUID, content string, metadata map[string]interface{}) (*models.ChatMessage, *chatReply, error) {
+	userMessage, err := h.chatService.SendMessage(user.ID, conversationID, content, "user", metadata)
+	if err != nil {
+		logrus.WithError(err).Error("保存用户消息失败")
+		return nil, nil, err
+	}
+
+	reply, err := h.generateReply(user, conversationID, userMessage)
+	if err != nil {
+		logrus.WithError(err).Error("AI回复生成失败")
+		return userMessage, nil, err
+	}
+
+	// 如果启用了记忆功能，将对话加入记忆写入队列，由后台批量向量化；通过WebSocket发送实时消息
+	h.deliverReply(user, userMessage, reply, userMessage, reply.message)
+	return userMessage, reply, nil
+}
+
+// chatReply 一次AI回复的结果
+type chatReply struct {
+	message       *models.ChatMessage // 保存失败时为 nil
+	content       string
+	memoryEnabled bool
+}
+
+// generateReply 以 userMessage 所在分支为上下文生成AI回复，回复保存为 userMessage 的子消息
+func (h *ChatHandler) generateReply(user *models.User, conversationID uuid.UUID, userMessage *models.ChatMessage) (*chatReply, error) {
+	return h.streamReply(context.Background(), user, conversationID, userMessage, nil)
+}
+
+// streamReply 同 generateReply；onDelta 不为空时以流式接口生成，每收到一段文本调用一次
+func (h *ChatHandler) streamReply(ctx context.Context, user *models.User, conversationID uuid.UUID, userMessage *models.ChatMessage, onDelta func(string) error) (*chatReply, error) {
+	// 按 对话 > 用户 > 服务端默认值 确定生成参数
+	settings, err := h.effectiveSettings(user, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	userPreference := settings.Preference()
+
+	// 获取上下文消息：沿当前分支从根到用户消息
+	contextMessages, err := h.chatService.GetPathMessages(user.ID, conversationID, userMessage.ID, userPreference.ContextWindow)
+	if err != nil || len(contextMessages) == 0 {
+		logrus.WithError(err).Warn("获取上下文消息失败")
+		contextMessages = []models.ChatMessage{*userMessage}
+	}
+
+	// 如果启用了记忆功能，搜索相关记忆
+	var memoryContext []string
+	if userPreference.MemoryEnabled && h.memoryStore != nil {
+		memoryContext, err = h.memoryStore.SearchMemory(user.ID, userMessage.Content, 3)
+		if err != nil {
+			logrus.WithError(err).Warn("搜索记忆失败")
+		}
+	}
+
+	// 如果有记忆上下文，将其添加到系统提示中
+	if len(memoryContext) > 0 {
+		memoryPrompt := "相关记忆：" + strings.Join(memoryContext, "\n")
+		if userPreference.SystemPrompt != "" {
+			userPreference.SystemPrompt += "\n\n" + memoryPrompt
+		} else {
+			userPreference.SystemPrompt = memoryPrompt
+		}
+	}
+
+	// 检索对话挂载的知识库
+	var knowledgeHits []services.KnowledgeHit
+	if h.knowledgeService != nil {
+		knowledgeHits, err = h.knowledgeService.Retrieve(conversationID, userMessage.Content, 0)
+		if err != nil {
+			logrus.WithError(err).Warn("检索知识库失败")
+		}
+	}
+
+	if len(knowledgeHits) > 0 {
+		knowledgePrompt := services.FormatKnowledgeContext(knowledgeHits)
+		if userPreference.SystemPrompt != "" {
+			userPreference.SystemPrompt += "\n\n" + knowledgePrompt
+		} else {
+			userPreference.SystemPrompt = knowledgePrompt
+		}
+	}
+
+	// 生成AI回复
+	var response string
+	if onDelta != nil {
+		response, err = h.llmService.StreamResponse(ctx, contextMessages, userPreference, onDelta)
+	} else {
+		response, err = h.llmService.GenerateResponse(contextMessages, userPreference)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 回复元数据中记录生成参数，以及引用的文档及分块位置
+	generation := services.NewGenerationInfo(settings)
+	generation.ContextMessages = len(contextMessages)
+	generation.MemoryHits = len(memoryContext)
+	generation.KnowledgeHits = len(knowledgeHits)
+	assistantMetadata := map[string]interface{}{"generation": generation}
+	if len(knowledgeHits) > 0 {
+		assistantMetadata["citations"] = knowledgeHits
+	}
+
+	// 保存AI回复，重新生成时与原回复互为同级分支
+	assistantMessage, err := h.chatService.AddMessage(user.ID, conversationID, &userMessage.ID, response, "assistant", assistantMetadata)
+	if err != nil {
+		logrus.WithError(err).Error("保存AI回复失败")
+		// 不阻止请求，但记录错误
+		assistantMessage = nil
+	}
+
+	return &chatReply{
+		message:       assistantMessage,
+		content:       response,
+		memoryEnabled: userPreference.MemoryEnabled,
+	}, nil
+}
+
+// effectiveSettings 合并服务端默认值、用户偏好、对话选用的人设和对话设置，得到生成回复实际使用的参数；
+// 获取用户偏好或人设失败时跳过这一级
+func (h *ChatHandler) effectiveSettings(user *models.User, conversationID uuid.UUID) (services.EffectiveSettings, error) {
+	session, err := h.chatService.GetChatSession(user.ID, conversationID)
+	if err != nil {
+		return services.EffectiveSettings{}, err
+	}
+
+	var userPreference *models.UserPreference
+	if h.userService != nil {
+		userPreference, err = h.userService.GetUserPreference(user.ID)
+		if err != nil {
+			logrus.WithError(err).Warn("获取用户偏好失败，使用默认设置")
+			userPreference = nil
+		}
+	}
+
+	persona, err := h.chatService.ConversationPersona(session)
+	if err != nil {
+		logrus.WithError(err).Warn("获取对话人设失败，跳过人设设置")
+		persona = nil
+	}
+
+	return services.ResolveSettings(
+		services.UserSettingsLayer(userPreference),
+		services.PersonaSettingsLayer(persona),
+		services.ConversationSettingsLayer(session),
+	), nil
+}
+
+// deliverReply 将新消息加入记忆写入队列，并通过WebSocket推送回复给对话的所有参与者
+func (h *ChatHandler) deliverReply(user *models.User, userMessage *models.ChatMessage, reply *chatReply, newMessages ...*models.ChatMessage) {
+	if reply.memoryEnabled && h.memoryQueue != nil {
+		if err := h.memoryQueue.Enqueue(newMessages...); err != nil {
+			logrus.WithError(err).Warn("对话加入记忆队列失败")
+		}
+	}
+
+	if h.hub != nil {
+		wsMessage := websocket.Message{
+			Type:      "chat_response",
+			Content:   reply.content,
+			UserID:    user.ID,
+			Username:  user.Username,
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"conversation_id":   userMessage.ConversationID,
+				"user_message":      userMessage,
+				"assistant_message": reply.message,
+			},
+		}
+		h.notifyConversation(userMessage.ConversationID, wsMessage)
+	}
+}
+
+// notifyConversation 通过WebSocket推送给对话的创建者和全部参与者
+func (h *ChatHandler) notifyConversation(conversationID uuid.UUID, message websocket.Message) {
+	if h.hub == nil {
+		return
+	}
+	memberIDs, err := h.chatService.ConversationMemberIDs(conversationID)
+	if err != nil {
+		logrus.WithError(err).Warn("获取对话参与者失败")
+		return
+	}
+	for _, memberID := range memberIDs {
+		if err := h.hub.SendToUser(memberID, message); err != nil {
+			logrus.WithError(err).Warn("发送WebSocket消息失败")
+		}
+	}
+}
+
+// EditMessageRequest 编辑消息请求结构
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required,min=1,max=4000"`
+}
+
+// SwitchBranchRequest 切换分支请求结构
+type SwitchBranchRequest struct {
+	MessageID uuid.UUID `json:"message_id" binding:"required"`
+}
+
+// EditMessage 编辑用户消息并重新发送：在原消息处创建新分支并生成回复，原分支保留
+func (h *ChatHandler) EditMessage(c *gin.Context) {
+	startTime := time.Now()
+
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	messageID, ok := parseUUIDParam(c, "message_id", "无效的消息ID")
+	if !ok {
+		return
+	}
+
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "消息内容不能为空",
+			Code:  "EMPTY_MESSAGE",
+		})
+		return
+	}
+
+	userMessage, err := h.chatService.EditMessage(user.ID, conversationID, messageID, req.Content)
+	if err != nil {
+		respondChatError(c, err, "MESSAGE_SAVE_FAILED", "消息保存失败")
+		return
+	}
+
+	reply, err := h.generateReply(user, conversationID, userMessage)
+	if err != nil {
+		logrus.WithError(err).Error("AI回复生成失败")
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: "AI服务暂时不可用，请稍后再试",
+			Code:  "AI_SERVICE_ERROR",
+		})
+		return
+	}
+	h.deliverReply(user, userMessage, reply, userMessage, reply.message)
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: SendMessageResponse{
+			UserMessage:      userMessage,
+			AssistantMessage: reply.message,
+			ProcessingTime:   time.Since(startTime).String(),
+		},
+		Message: "消息已编辑并重新发送",
+	})
+}
+
+// RegenerateMessage 重新生成AI回复，新回复与原回复互为同级分支
+func (h *ChatHandler) RegenerateMessage(c *gin.Context) {
+	startTime := time.Now()
+
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	messageID, ok := parseUUIDParam(c, "message_id", "无效的消息ID")
+	if !ok {
+		return
+	}
+
+	userMessage, err := h.chatService.RegenerateSource(user.ID, conversationID, messageID)
+	if err != nil {
+		respondChatError(c, err, "REGENERATE_FAILED", "重新生成失败")
+		return
+	}
+
+	reply, err := h.generateReply(user, conversationID, userMessage)
+	if err != nil {
+		logrus.WithError(err).Error("AI回复生成失败")
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: "AI服务暂时不可用，请稍后再试",
+			Code:  "AI_SERVICE_ERROR",
+		})
+		return
+	}
+	h.deliverReply(user, userMessage, reply, reply.message)
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: SendMessageResponse{
+			UserMessage:      userMessage,
+			AssistantMessage: reply.message,
+			ProcessingTime:   time.Since(startTime).String(),
+		},
+		Message: "回复已重新生成",
+	})
+}
+
+// SwitchBranch 切换对话的当前分支，后续消息和AI上下文都沿该分支
+func (h *ChatHandler) SwitchBranch(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req SwitchBranchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 message_id",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	messages, err := h.chatService.SwitchBranch(user.ID, conversationID, req.MessageID)
+	if err != nil {
+		respondChatError(c, err, "SWITCH_BRANCH_FAILED", "切换分支失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"messages": messages,
+		},
+		Message: "已切换分支",
+	})
+}
+
+// respondChatError 对话或消息不存在（含无权访问）时返回404，参与者角色不足返回403，分支目标不合法返回400，其他错误返回500
+func respondChatError(c *gin.Context, err error, code, message string) {
+	if errors.Is(err, services.ErrInvalidBranchTarget) {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_BRANCH_TARGET",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrConversationForbidden) {
+		c.JSON(http.StatusForbidden, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "FORBIDDEN",
+		})
+		return
+	}
+	if errors.Is(err, services.ErrConversationNotFound) || errors.Is(err, services.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+		Error: message,
+		Code:  code,
+	})
+}
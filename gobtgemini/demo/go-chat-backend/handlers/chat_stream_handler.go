@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/websocket"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var _ websocket.ChatProcessor = (*ChatHandler)(nil)
+
+// ProcessChat 实现 websocket.ChatProcessor：按发送消息接口的流程处理WebSocket聊天请求，
+// 保存用户消息后确认，回复以增量帧推送给发起请求的连接，完成后同样加入记忆队列并推送 chat_response
+func (h *ChatHandler) ProcessChat(ctx context.Context, request websocket.ChatRequest, stream websocket.ChatStream) {
+	startTime := time.Now()
+	user := &models.User{ID: request.UserID, Username: request.Username}
+
+	userMessage, err := h.chatService.SendMessage(user.ID, request.ConversationID, request.Content, "user", nil)
+	if err != nil {
+		logrus.WithError(err).Error("保存用户消息失败")
+		code, message := chatErrorCode(err, "MESSAGE_SAVE_FAILED", "消息保存失败")
+		stream.Error(code, message)
+		return
+	}
+	stream.Ack(gin.H{
+		"user_message": userMessage,
+	})
+
+	reply, err := h.streamReply(ctx, user, request.ConversationID, userMessage, func(delta string) error {
+		stream.Delta(delta)
+		return ctx.Err()
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			logrus.WithField("request_id", request.RequestID).Info("WebSocket连接已断开，停止生成回复")
+			return
+		}
+		logrus.WithError(err).Error("AI回复生成失败")
+		stream.Error("AI_SERVICE_ERROR", "AI服务暂时不可用，请稍后再试")
+		return
+	}
+
+	h.deliverReply(user, userMessage, reply, userMessage, reply.message)
+
+	processingTime := time.Since(startTime)
+	stream.Done(reply.content, gin.H{
+		"user_message":      userMessage,
+		"assistant_message": reply.message,
+		"processing_time":   processingTime.String(),
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":         user.ID,
+		"request_id":      request.RequestID,
+		"message_length":  len(request.Content),
+		"response_length": len(reply.content),
+		"processing_time": processingTime.String(),
+	}).Info("WebSocket聊天消息处理完成")
+}
+
+// chatErrorCode 与 respondChatError 相同的错误分类，用于WebSocket错误帧
+func chatErrorCode(err error, code, message string) (string, string) {
+	switch {
+	case errors.Is(err, services.ErrConversationForbidden):
+		return "FORBIDDEN", err.Error()
+	case errors.Is(err, services.ErrConversationNotFound), errors.Is(err, services.ErrMessageNotFound):
+		return "NOT_FOUND", err.Error()
+	default:
+		return code, message
+	}
+}
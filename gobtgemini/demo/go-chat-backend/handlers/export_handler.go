@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// exportContentTypes 导出格式对应的 Content-Type
+var exportContentTypes = map[string]string{
+	services.ExportFormatJSON:     "application/json; charset=utf-8",
+	services.ExportFormatMarkdown: "text/markdown; charset=utf-8",
+	services.ExportFormatHTML:     "text/html; charset=utf-8",
+}
+
+// ExportHandler 对话导出处理器
+type ExportHandler struct {
+	exportService *services.ExportService
+}
+
+// NewExportHandler 创建对话导出处理器
+func NewExportHandler(exportService *services.ExportService) *ExportHandler {
+	return &ExportHandler{
+		exportService: exportService,
+	}
+}
+
+// ExportConversation 以附件形式下载单个对话，format 为 json（默认）、markdown 或 html
+func (h *ExportHandler) ExportConversation(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", services.ExportFormatJSON)
+	content, err := h.exportService.ExportConversation(user.ID, conversationID, format)
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedExportFormat) {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+				Error: err.Error(),
+				Code:  "INVALID_FORMAT",
+			})
+			return
+		}
+		respondChatError(c, err, "EXPORT_FAILED", "导出对话失败")
+		return
+	}
+
+	filename := fmt.Sprintf("conversation-%s.%s", conversationID, services.ExportFileExtension(format))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, exportContentTypes[format], content)
+}
+
+// ExportAllConversations 将当前用户的全部对话打包为 zip 下载
+func (h *ExportHandler) ExportAllConversations(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	format := c.DefaultQuery("format", services.ExportFormatJSON)
+	if _, ok := exportContentTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: services.ErrUnsupportedExportFormat.Error(),
+			Code:  "INVALID_FORMAT",
+		})
+		return
+	}
+
+	// 边生成边写出，开始写入后无法再返回错误响应，失败时只记录日志
+	filename := fmt.Sprintf("conversations-%s.zip", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+	if err := h.exportService.WriteArchive(user.ID, format, c.Writer); err != nil {
+		logrus.WithError(err).WithField("user_id", user.ID).Error("批量导出对话失败")
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-backend/middleware"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// FeedbackRequest 评价AI回复请求结构
+type FeedbackRequest struct {
+	Rating  string   `json:"rating" binding:"required,oneof=up down"`
+	Reasons []string `json:"reasons"`
+	Comment string   `json:"comment" binding:"max=2000"`
+}
+
+// FeedbackHandler 消息评价处理器
+type FeedbackHandler struct {
+	feedbackService *services.FeedbackService
+}
+
+// NewFeedbackHandler 创建消息评价处理器
+func NewFeedbackHandler(feedbackService *services.FeedbackService) *FeedbackHandler {
+	return &FeedbackHandler{
+		feedbackService: feedbackService,
+	}
+}
+
+// RateMessage 评价AI回复，重复提交覆盖之前的评价
+func (h *FeedbackHandler) RateMessage(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	messageID, ok := parseUUIDParam(c, "message_id", "无效的消息ID")
+	if !ok {
+		return
+	}
+
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，rating 需要 up 或 down",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	feedback, err := h.feedbackService.RateMessage(user.ID, conversationID, messageID, req.Rating, req.Reasons, req.Comment)
+	if err != nil {
+		respondFeedbackError(c, err, "FEEDBACK_FAILED", "保存评价失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    feedback,
+		Message: "评价成功",
+	})
+}
+
+// DeleteFeedback 撤销对AI回复的评价
+func (h *FeedbackHandler) DeleteFeedback(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	messageID, ok := parseUUIDParam(c, "message_id", "无效的消息ID")
+	if !ok {
+		return
+	}
+
+	if err := h.feedbackService.DeleteFeedback(user.ID, conversationID, messageID); err != nil {
+		respondFeedbackError(c, err, "FEEDBACK_DELETE_FAILED", "撤销评价失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "评价已撤销",
+	})
+}
+
+// ExportFeedback 管理员以 JSONL 导出评价及对应的提问、上下文和生成参数
+func (h *FeedbackHandler) ExportFeedback(c *gin.Context) {
+	filter, ok := feedbackFilter(c)
+	if !ok {
+		return
+	}
+
+	// 边查询边写出，开始写入后无法再返回错误响应，失败时只记录日志
+	filename := fmt.Sprintf("feedback-%s.jsonl", time.Now().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+	count, err := h.feedbackService.ExportFeedback(filter, c.Writer)
+	if err != nil {
+		logrus.WithError(err).WithField("exported", count).Error("导出评价失败")
+	}
+}
+
+// GetFeedbackStats 管理员查看按模型汇总的评价
+func (h *FeedbackHandler) GetFeedbackStats(c *gin.Context) {
+	filter, ok := feedbackFilter(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.feedbackService.ModelStats(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "FEEDBACK_STATS_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"models": stats,
+		},
+	})
+}
+
+// feedbackFilter 解析导出和统计的过滤参数 from、to、rating、model
+func feedbackFilter(c *gin.Context) (services.FeedbackFilter, bool) {
+	filter := services.FeedbackFilter{
+		Rating: c.Query("rating"),
+		Model:  c.Query("model"),
+	}
+	if filter.Rating != "" && filter.Rating != models.FeedbackUp && filter.Rating != models.FeedbackDown {
+		respondInvalidSearch(c, services.ErrInvalidRating.Error())
+		return filter, false
+	}
+
+	if raw := c.Query("from"); raw != "" {
+		from, _, err := parseSearchTime(raw)
+		if err != nil {
+			respondInvalidSearch(c, "from 需要 RFC3339 时间或 YYYY-MM-DD 日期")
+			return filter, false
+		}
+		filter.From = &from
+	}
+	if raw := c.Query("to"); raw != "" {
+		to, dateOnly, err := parseSearchTime(raw)
+		if err != nil {
+			respondInvalidSearch(c, "to 需要 RFC3339 时间或 YYYY-MM-DD 日期")
+			return filter, false
+		}
+		// 只给日期时包含当天
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+	return filter, true
+}
+
+// respondFeedbackError 将评价错误映射为响应，参数错误返回 400，找不到返回 404
+func respondFeedbackError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidRating), errors.Is(err, services.ErrInvalidReason), errors.Is(err, services.ErrFeedbackTarget):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	case errors.Is(err, services.ErrFeedbackNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FolderRequest 创建或重命名文件夹请求结构
+type FolderRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// FolderHandler 对话文件夹处理器
+type FolderHandler struct {
+	folderService *services.FolderService
+}
+
+// NewFolderHandler 创建文件夹处理器
+func NewFolderHandler(folderService *services.FolderService) *FolderHandler {
+	return &FolderHandler{
+		folderService: folderService,
+	}
+}
+
+// CreateFolder 创建文件夹
+func (h *FolderHandler) CreateFolder(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req FolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 name",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	folder, err := h.folderService.CreateFolder(user.ID, req.Name)
+	if err != nil {
+		respondOrganizeError(c, err, "FOLDER_CREATE_FAILED", "创建文件夹失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    folder,
+		Message: "文件夹创建成功",
+	})
+}
+
+// ListFolders 获取文件夹列表及其中的对话数量
+func (h *FolderHandler) ListFolders(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	folders, err := h.folderService.ListFolders(user.ID)
+	if err != nil {
+		respondOrganizeError(c, err, "FOLDER_LIST_FAILED", "获取文件夹列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: folders,
+	})
+}
+
+// RenameFolder 重命名文件夹
+func (h *FolderHandler) RenameFolder(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	folderID, ok := parseUUIDParam(c, "id", "无效的文件夹ID")
+	if !ok {
+		return
+	}
+
+	var req FolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 name",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	folder, err := h.folderService.RenameFolder(user.ID, folderID, req.Name)
+	if err != nil {
+		respondOrganizeError(c, err, "FOLDER_UPDATE_FAILED", "重命名文件夹失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    folder,
+		Message: "文件夹已更新",
+	})
+}
+
+// DeleteFolder 删除文件夹，其中的对话移出文件夹
+func (h *FolderHandler) DeleteFolder(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	folderID, ok := parseUUIDParam(c, "id", "无效的文件夹ID")
+	if !ok {
+		return
+	}
+
+	if err := h.folderService.DeleteFolder(user.ID, folderID); err != nil {
+		respondOrganizeError(c, err, "FOLDER_DELETE_FAILED", "删除文件夹失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "文件夹已删除",
+	})
+}
+
+// respondOrganizeError 将文件夹、标签和批量操作的错误映射为HTTP响应
+func respondOrganizeError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrFolderNotFound), errors.Is(err, services.ErrTagNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	case errors.Is(err, services.ErrFolderExists), errors.Is(err, services.ErrTagExists):
+		c.JSON(http.StatusConflict, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "ALREADY_EXISTS",
+		})
+	case errors.Is(err, services.ErrEmptyFolderName), errors.Is(err, services.ErrEmptyTagName),
+		errors.Is(err, services.ErrInvalidBulkAction), errors.Is(err, services.ErrInvalidBulkRequest),
+		errors.Is(err, services.ErrBulkTagsRequired):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
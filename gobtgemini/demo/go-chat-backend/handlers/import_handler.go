@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/config"
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportHandler 对话导入处理器
+type ImportHandler struct {
+	importService *services.ImportService
+}
+
+// NewImportHandler 创建对话导入处理器
+func NewImportHandler(importService *services.ImportService) *ImportHandler {
+	return &ImportHandler{
+		importService: importService,
+	}
+}
+
+// CreateImport 上传导入文件（multipart 字段名为 file），创建后台导入任务。
+// 表单字段 source 为 auto（默认）、native 或 chatgpt，backfill_memory 为 true 时导入的消息写入记忆库
+func (h *ImportHandler) CreateImport(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请通过 file 字段上传导入文件",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	maxSize := int64(config.Get().ImportMaxUploadMB) << 20
+	if fileHeader.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, utils.ErrorResponse{
+			Error: "导入文件大小超出限制",
+			Code:  "IMPORT_TOO_LARGE",
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "读取上传文件失败",
+			Code:  "INVALID_REQUEST",
+		})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "读取上传文件失败",
+			Code:  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	backfill, _ := strconv.ParseBool(c.DefaultPostForm("backfill_memory", "false"))
+	job, err := h.importService.CreateImportJob(user.ID, filepath.Base(fileHeader.Filename), data, c.DefaultPostForm("source", services.ImportSourceAuto), backfill)
+	if err != nil {
+		if errors.Is(err, services.ErrEmptyImportFile) || errors.Is(err, services.ErrInvalidImportSource) {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+				Error: err.Error(),
+				Code:  "INVALID_REQUEST",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "IMPORT_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, utils.SuccessResponse{
+		Data:    job,
+		Message: "导入任务已创建，正在后台处理",
+	})
+}
+
+// ListImports 获取当前用户最近的导入任务
+func (h *ImportHandler) ListImports(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	jobs, err := h.importService.ListImportJobs(user.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "IMPORT_LIST_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: jobs,
+	})
+}
+
+// GetImport 查询导入任务状态和进度
+func (h *ImportHandler) GetImport(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	jobID, ok := parseUUIDParam(c, "id", "无效的导入任务ID")
+	if !ok {
+		return
+	}
+
+	job, err := h.importService.GetImportJob(user.ID, jobID)
+	if err != nil {
+		if errors.Is(err, services.ErrImportJobNotFound) {
+			c.JSON(http.StatusNotFound, utils.ErrorResponse{
+				Error: err.Error(),
+				Code:  "NOT_FOUND",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "IMPORT_FETCH_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: job,
+	})
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/config"
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// KnowledgeHandler 知识库处理器
+type KnowledgeHandler struct {
+	knowledgeService *services.KnowledgeService
+}
+
+// NewKnowledgeHandler 创建知识库处理器
+func NewKnowledgeHandler(knowledgeService *services.KnowledgeService) *KnowledgeHandler {
+	return &KnowledgeHandler{
+		knowledgeService: knowledgeService,
+	}
+}
+
+// CreateKnowledgeBaseRequest 创建知识库请求结构
+type CreateKnowledgeBaseRequest struct {
+	Name        string `json:"name" binding:"required,max=200"`
+	Description string `json:"description"`
+}
+
+// AttachKnowledgeBaseRequest 对话挂载知识库请求结构
+type AttachKnowledgeBaseRequest struct {
+	KnowledgeBaseID uuid.UUID `json:"knowledge_base_id" binding:"required"`
+}
+
+// CreateKnowledgeBase 创建知识库
+func (h *KnowledgeHandler) CreateKnowledgeBase(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req CreateKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	kb, err := h.knowledgeService.CreateKnowledgeBase(user.ID, req.Name, req.Description)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "KNOWLEDGE_BASE_CREATE_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    kb,
+		Message: "知识库创建成功",
+	})
+}
+
+// ListKnowledgeBases 获取知识库列表
+func (h *KnowledgeHandler) ListKnowledgeBases(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	kbs, err := h.knowledgeService.ListKnowledgeBases(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "KNOWLEDGE_BASE_FETCH_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: kbs,
+	})
+}
+
+// DeleteKnowledgeBase 删除知识库
+func (h *KnowledgeHandler) DeleteKnowledgeBase(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	kbID, ok := parseUUIDParam(c, "id", "无效的知识库ID")
+	if !ok {
+		return
+	}
+
+	if err := h.knowledgeService.DeleteKnowledgeBase(user.ID, kbID); err != nil {
+		respondKnowledgeError(c, err, "KNOWLEDGE_BASE_DELETE_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "知识库删除成功",
+	})
+}
+
+// UploadDocument 上传文档到知识库（multipart 字段名为 file）
+func (h *KnowledgeHandler) UploadDocument(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	kbID, ok := parseUUIDParam(c, "id", "无效的知识库ID")
+	if !ok {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请通过 file 字段上传文档",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	maxSize := int64(config.Get().KnowledgeMaxUploadMB) << 20
+	if fileHeader.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, utils.ErrorResponse{
+			Error: "文档大小超出限制",
+			Code:  "DOCUMENT_TOO_LARGE",
+		})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "读取上传文件失败",
+			Code:  "INVALID_REQUEST",
+		})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: "读取上传文件失败",
+			Code:  "INVALID_REQUEST",
+		})
+		return
+	}
+
+	doc, err := h.knowledgeService.UploadDocument(user.ID, kbID, filepath.Base(fileHeader.Filename), data)
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedDocumentFormat) {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+				Error: err.Error(),
+				Code:  "UNSUPPORTED_DOCUMENT_FORMAT",
+			})
+			return
+		}
+		respondKnowledgeError(c, err, "DOCUMENT_UPLOAD_FAILED")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":           user.ID,
+		"knowledge_base_id": kbID,
+		"document_id":       doc.ID,
+	}).Info("文档已上传，开始后台处理")
+
+	// 文档在后台解析和向量化，客户端通过文档列表查看处理状态
+	c.JSON(http.StatusAccepted, utils.SuccessResponse{
+		Data:    doc,
+		Message: "文档已上传，正在处理",
+	})
+}
+
+// ListDocuments 获取知识库中的文档
+func (h *KnowledgeHandler) ListDocuments(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	kbID, ok := parseUUIDParam(c, "id", "无效的知识库ID")
+	if !ok {
+		return
+	}
+
+	docs, err := h.knowledgeService.ListDocuments(user.ID, kbID)
+	if err != nil {
+		respondKnowledgeError(c, err, "DOCUMENT_FETCH_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: docs,
+	})
+}
+
+// DeleteDocument 删除知识库中的文档
+func (h *KnowledgeHandler) DeleteDocument(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	kbID, ok := parseUUIDParam(c, "id", "无效的知识库ID")
+	if !ok {
+		return
+	}
+	docID, ok := parseUUIDParam(c, "doc_id", "无效的文档ID")
+	if !ok {
+		return
+	}
+
+	if err := h.knowledgeService.DeleteDocument(user.ID, kbID, docID); err != nil {
+		respondKnowledgeError(c, err, "DOCUMENT_DELETE_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "文档删除成功",
+	})
+}
+
+// ListConversationKnowledgeBases 获取对话挂载的知识库
+func (h *KnowledgeHandler) ListConversationKnowledgeBases(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	kbs, err := h.knowledgeService.ListConversationKnowledgeBases(user.ID, conversationID)
+	if err != nil {
+		respondKnowledgeError(c, err, "KNOWLEDGE_BASE_FETCH_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: kbs,
+	})
+}
+
+// AttachKnowledgeBase 为对话挂载知识库
+func (h *KnowledgeHandler) AttachKnowledgeBase(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req AttachKnowledgeBaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 knowledge_base_id",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := h.knowledgeService.AttachKnowledgeBase(user.ID, conversationID, req.KnowledgeBaseID); err != nil {
+		respondKnowledgeError(c, err, "KNOWLEDGE_BASE_ATTACH_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "知识库挂载成功",
+	})
+}
+
+// DetachKnowledgeBase 从对话移除知识库
+func (h *KnowledgeHandler) DetachKnowledgeBase(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	kbID, ok := parseUUIDParam(c, "kb_id", "无效的知识库ID")
+	if !ok {
+		return
+	}
+
+	if err := h.knowledgeService.DetachKnowledgeBase(user.ID, conversationID, kbID); err != nil {
+		respondKnowledgeError(c, err, "KNOWLEDGE_BASE_DETACH_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "知识库已移除",
+	})
+}
+
+// parseUUIDParam 解析路径中的UUID参数，失败时直接写入400响应
+func parseUUIDParam(c *gin.Context, name, errMsg string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(name))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: errMsg,
+			Code:  "INVALID_ID",
+		})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// respondKnowledgeError 将知识库服务错误映射为HTTP响应
+func respondKnowledgeError(c *gin.Context, err error, code string) {
+	switch {
+	case errors.Is(err, services.ErrKnowledgeBaseNotFound),
+		errors.Is(err, services.ErrKnowledgeDocumentNotFound),
+		errors.Is(err, services.ErrConversationNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	case errors.Is(err, services.ErrConversationForbidden):
+		c.JSON(http.StatusForbidden, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "FORBIDDEN",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  code,
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageRequest 读取游标分页参数：cursor、direction（before/after）、limit
+func pageRequest(c *gin.Context) services.PageRequest {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return services.PageRequest{
+		Cursor:    c.Query("cursor"),
+		Direction: c.Query("direction"),
+		Limit:     limit,
+	}
+}
+
+// syncRequest 读取增量同步参数，请求中没有 since 时返回 false
+func syncRequest(c *gin.Context) (services.SyncRequest, bool) {
+	since, ok := c.GetQuery("since")
+	if !ok {
+		return services.SyncRequest{}, false
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return services.SyncRequest{Since: since, Limit: limit}, true
+}
+
+// respondPageError 分页或同步参数错误时返回 400，其余错误交给 respondChatError
+func respondPageError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_CURSOR",
+		})
+	case errors.Is(err, services.ErrInvalidPageDirection):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"go-chat-backend/websocket"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// AddParticipantRequest 邀请参与者请求结构
+type AddParticipantRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role" binding:"required"`
+}
+
+// UpdateParticipantRequest 修改参与者角色请求结构
+type UpdateParticipantRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ListParticipants 获取对话的参与者
+func (h *ChatHandler) ListParticipants(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	participants, err := h.chatService.ListParticipants(user.ID, conversationID)
+	if err != nil {
+		respondParticipantError(c, err, "PARTICIPANT_LIST_FAILED", "获取参与者失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"participants": participants,
+		},
+	})
+}
+
+// AddParticipant 按用户名邀请用户加入对话
+func (h *ChatHandler) AddParticipant(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req AddParticipantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 username 和 role",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	participant, err := h.chatService.AddParticipant(user.ID, conversationID, req.Username, req.Role)
+	if err != nil {
+		respondParticipantError(c, err, "PARTICIPANT_ADD_FAILED", "邀请参与者失败")
+		return
+	}
+
+	h.notifyParticipants(user, conversationID, "participant_added", participant.UserID, participant.Role)
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    participant,
+		Message: "已邀请参与者",
+	})
+}
+
+// UpdateParticipant 修改参与者的角色
+func (h *ChatHandler) UpdateParticipant(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	participantID, ok := parseUUIDParam(c, "user_id", "无效的用户ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateParticipantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 role",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := h.chatService.UpdateParticipantRole(user.ID, conversationID, participantID, req.Role); err != nil {
+		respondParticipantError(c, err, "PARTICIPANT_UPDATE_FAILED", "修改参与者角色失败")
+		return
+	}
+
+	h.notifyParticipants(user, conversationID, "participant_updated", participantID, req.Role)
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "参与者角色已修改",
+	})
+}
+
+// RemoveParticipant 将参与者移出对话，移除自己即为退出对话
+func (h *ChatHandler) RemoveParticipant(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+	participantID, ok := parseUUIDParam(c, "user_id", "无效的用户ID")
+	if !ok {
+		return
+	}
+
+	if err := h.chatService.RemoveParticipant(user.ID, conversationID, participantID); err != nil {
+		respondParticipantError(c, err, "PARTICIPANT_REMOVE_FAILED", "移除参与者失败")
+		return
+	}
+
+	// 被移除的用户已不在参与者列表中，单独通知
+	h.notifyParticipants(user, conversationID, "participant_removed", participantID, "")
+	if h.hub != nil {
+		if err := h.hub.SendToUser(participantID, participantEvent(user, conversationID, "participant_removed", participantID, "")); err != nil {
+			logrus.WithError(err).Warn("发送WebSocket消息失败")
+		}
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "参与者已移除",
+	})
+}
+
+// notifyParticipants 向对话的所有参与者推送参与者变化
+func (h *ChatHandler) notifyParticipants(user *models.User, conversationID uuid.UUID, eventType string, participantID uuid.UUID, role string) {
+	h.notifyConversation(conversationID, participantEvent(user, conversationID, eventType, participantID, role))
+}
+
+// participantEvent 参与者变化的WebSocket消息，UserID 为操作者
+func participantEvent(user *models.User, conversationID uuid.UUID, eventType string, participantID uuid.UUID, role string) websocket.Message {
+	return websocket.Message{
+		Type:      eventType,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Timestamp: time.Now(),
+		Data: gin.H{
+			"conversation_id": conversationID,
+			"participant_id":  participantID,
+			"role":            role,
+		},
+	}
+}
+
+// respondParticipantError 将参与者管理的错误映射为HTTP响应
+func respondParticipantError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidParticipantRole), errors.Is(err, services.ErrConversationCreator):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	case errors.Is(err, services.ErrParticipantNotFound), errors.Is(err, services.ErrParticipantUser):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	case errors.Is(err, services.ErrParticipantExists):
+		c.JSON(http.StatusConflict, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "ALREADY_EXISTS",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
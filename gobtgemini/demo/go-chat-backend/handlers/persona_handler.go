@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PersonaRequest 创建或替换人设请求结构
+type PersonaRequest struct {
+	Name     string                    `json:"name" binding:"required,max=100"`
+	Settings models.GenerationSettings `json:"settings"`
+}
+
+// PersonaHandler 人设处理器
+type PersonaHandler struct {
+	personaService *services.PersonaService
+}
+
+// NewPersonaHandler 创建人设处理器
+func NewPersonaHandler(personaService *services.PersonaService) *PersonaHandler {
+	return &PersonaHandler{
+		personaService: personaService,
+	}
+}
+
+// CreatePersona 创建人设
+func (h *PersonaHandler) CreatePersona(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req PersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 name",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	persona, err := h.personaService.CreatePersona(user.ID, req.Name, req.Settings)
+	if err != nil {
+		respondPersonaError(c, err, "PERSONA_CREATE_FAILED", "创建人设失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    persona,
+		Message: "人设创建成功",
+	})
+}
+
+// ListPersonas 获取人设列表
+func (h *PersonaHandler) ListPersonas(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	personas, err := h.personaService.ListPersonas(user.ID)
+	if err != nil {
+		respondPersonaError(c, err, "PERSONA_LIST_FAILED", "获取人设列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: personas,
+	})
+}
+
+// UpdatePersona 替换人设的名称和生成参数
+func (h *PersonaHandler) UpdatePersona(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	personaID, ok := parseUUIDParam(c, "id", "无效的人设ID")
+	if !ok {
+		return
+	}
+
+	var req PersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 name",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	persona, err := h.personaService.UpdatePersona(user.ID, personaID, req.Name, req.Settings)
+	if err != nil {
+		respondPersonaError(c, err, "PERSONA_UPDATE_FAILED", "更新人设失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    persona,
+		Message: "人设已更新",
+	})
+}
+
+// DeletePersona 删除人设，使用它的对话不再使用人设
+func (h *PersonaHandler) DeletePersona(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	personaID, ok := parseUUIDParam(c, "id", "无效的人设ID")
+	if !ok {
+		return
+	}
+
+	if err := h.personaService.DeletePersona(user.ID, personaID); err != nil {
+		respondPersonaError(c, err, "PERSONA_DELETE_FAILED", "删除人设失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "人设已删除",
+	})
+}
+
+// respondPersonaError 将人设和生成参数的错误映射为HTTP响应
+func respondPersonaError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrPersonaNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	case errors.Is(err, services.ErrPersonaExists):
+		c.JSON(http.StatusConflict, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "ALREADY_EXISTS",
+		})
+	case errors.Is(err, services.ErrEmptyPersonaName):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	case errors.Is(err, services.ErrInvalidSettings):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_SETTINGS",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RetentionPolicyRequest 设置保留策略请求结构，字段为 null 时用户策略沿用全局策略、全局策略不清理
+type RetentionPolicyRequest struct {
+	MessageDays *int `json:"message_days"`
+	DeletedDays *int `json:"deleted_days"`
+}
+
+// RetentionHandler 数据保留策略管理处理器
+type RetentionHandler struct {
+	retentionService *services.RetentionService
+}
+
+// NewRetentionHandler 创建数据保留策略管理处理器
+func NewRetentionHandler(retentionService *services.RetentionService) *RetentionHandler {
+	return &RetentionHandler{
+		retentionService: retentionService,
+	}
+}
+
+// GetPolicies 获取全局策略和用户策略
+func (h *RetentionHandler) GetPolicies(c *gin.Context) {
+	policies, err := h.retentionService.ListPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "RETENTION_LIST_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: policies,
+	})
+}
+
+// UpdateGlobalPolicy 设置全局保留策略
+func (h *RetentionHandler) UpdateGlobalPolicy(c *gin.Context) {
+	h.updatePolicy(c, nil)
+}
+
+// UpdateUserPolicy 设置用户的保留策略，覆盖全局策略中非 null 的项
+func (h *RetentionHandler) UpdateUserPolicy(c *gin.Context) {
+	userID, ok := parseUUIDParam(c, "user_id", "无效的用户ID")
+	if !ok {
+		return
+	}
+	h.updatePolicy(c, &userID)
+}
+
+// updatePolicy 替换全局策略（userID 为空）或用户策略
+func (h *RetentionHandler) updatePolicy(c *gin.Context, userID *uuid.UUID) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req RetentionPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	policy, err := h.retentionService.SetPolicy(auditContext(c, &admin.ID), userID, req.MessageDays, req.DeletedDays)
+	if err != nil {
+		respondRetentionError(c, err, "RETENTION_UPDATE_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    policy,
+		Message: "保留策略已更新",
+	})
+}
+
+// DeleteUserPolicy 删除用户的保留策略，之后沿用全局策略
+func (h *RetentionHandler) DeleteUserPolicy(c *gin.Context) {
+	admin, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	userID, ok := parseUUIDParam(c, "user_id", "无效的用户ID")
+	if !ok {
+		return
+	}
+
+	if err := h.retentionService.DeleteUserPolicy(auditContext(c, &admin.ID), userID); err != nil {
+		respondRetentionError(c, err, "RETENTION_DELETE_FAILED")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "用户保留策略已删除",
+	})
+}
+
+// GetReport 试运行：按当前策略统计将被删除的数据，不做修改
+func (h *RetentionHandler) GetReport(c *gin.Context) {
+	report, err := h.retentionService.Report()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "RETENTION_REPORT_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: report,
+	})
+}
+
+// RunRetention 立即按当前策略执行一轮清理，不等待后台定时任务
+func (h *RetentionHandler) RunRetention(c *gin.Context) {
+	report, err := h.retentionService.Run(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "RETENTION_RUN_FAILED",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    report,
+		Message: "已按保留策略完成清理",
+	})
+}
+
+// respondRetentionError 将保留策略的错误映射为HTTP响应
+func respondRetentionError(c *gin.Context, err error, code string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidRetention):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	case errors.Is(err, services.ErrRetentionUser), errors.Is(err, services.ErrRetentionPolicyNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  code,
+		})
+	}
+}
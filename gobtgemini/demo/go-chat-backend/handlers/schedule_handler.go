@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"go-chat-backend/websocket"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var _ services.PromptRunner = (*ChatHandler)(nil)
+
+// CreateScheduleRequest 创建定时提示词请求结构
+type CreateScheduleRequest struct {
+	ConversationID uuid.UUID `json:"conversation_id" binding:"required"`
+	Name           string    `json:"name" binding:"max=200"`
+	Prompt         string    `json:"prompt" binding:"required,max=4000"`
+	Cron           string    `json:"cron" binding:"required"`
+	Timezone       string    `json:"timezone"`
+	Enabled        *bool     `json:"enabled"` // 默认启用
+}
+
+// UpdateScheduleRequest 修改定时提示词请求结构，未提供的字段保持不变
+type UpdateScheduleRequest struct {
+	Name     *string `json:"name" binding:"omitempty,max=200"`
+	Prompt   *string `json:"prompt" binding:"omitempty,max=4000"`
+	Cron     *string `json:"cron"`
+	Timezone *string `json:"timezone"`
+	Enabled  *bool   `json:"enabled"`
+}
+
+// ScheduleHandler 定时提示词处理器
+type ScheduleHandler struct {
+	scheduleService *services.ScheduleService
+}
+
+// NewScheduleHandler 创建定时提示词处理器
+func NewScheduleHandler(scheduleService *services.ScheduleService) *ScheduleHandler {
+	return &ScheduleHandler{
+		scheduleService: scheduleService,
+	}
+}
+
+// ListSchedules 获取当前用户的定时提示词
+func (h *ScheduleHandler) ListSchedules(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	schedules, err := h.scheduleService.ListSchedules(user.ID)
+	if err != nil {
+		respondScheduleError(c, err, "SCHEDULE_LIST_FAILED", "获取定时任务失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"schedules": schedules,
+		},
+	})
+}
+
+// CreateSchedule 创建定时提示词
+func (h *ScheduleHandler) CreateSchedule(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req CreateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 conversation_id、prompt 和 cron",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	schedule, err := h.scheduleService.CreateSchedule(user.ID, services.ScheduleInput{
+		ConversationID: req.ConversationID,
+		Name:           req.Name,
+		Prompt:         req.Prompt,
+		Cron:           req.Cron,
+		Timezone:       req.Timezone,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	})
+	if err != nil {
+		respondScheduleError(c, err, "SCHEDULE_CREATE_FAILED", "创建定时任务失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    schedule,
+		Message: "定时任务创建成功",
+	})
+}
+
+// GetSchedule 获取定时提示词
+func (h *ScheduleHandler) GetSchedule(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	scheduleID, ok := parseUUIDParam(c, "id", "无效的定时任务ID")
+	if !ok {
+		return
+	}
+
+	schedule, err := h.scheduleService.GetSchedule(user.ID, scheduleID)
+	if err != nil {
+		respondScheduleError(c, err, "SCHEDULE_GET_FAILED", "获取定时任务失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: schedule,
+	})
+}
+
+// UpdateSchedule 修改定时提示词，包括启用和停用
+func (h *ScheduleHandler) UpdateSchedule(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	scheduleID, ok := parseUUIDParam(c, "id", "无效的定时任务ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	schedule, err := h.scheduleService.UpdateSchedule(user.ID, scheduleID, services.ScheduleUpdate{
+		Name:     req.Name,
+		Prompt:   req.Prompt,
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Enabled:  req.Enabled,
+	})
+	if err != nil {
+		respondScheduleError(c, err, "SCHEDULE_UPDATE_FAILED", "更新定时任务失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    schedule,
+		Message: "定时任务已更新",
+	})
+}
+
+// DeleteSchedule 删除定时提示词及其执行记录
+func (h *ScheduleHandler) DeleteSchedule(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	scheduleID, ok := parseUUIDParam(c, "id", "无效的定时任务ID")
+	if !ok {
+		return
+	}
+
+	if err := h.scheduleService.DeleteSchedule(user.ID, scheduleID); err != nil {
+		respondScheduleError(c, err, "SCHEDULE_DELETE_FAILED", "删除定时任务失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "定时任务已删除",
+	})
+}
+
+// ListScheduleRuns 获取定时提示词最近的执行记录
+// 查询参数：limit（默认20，最多100）
+func (h *ScheduleHandler) ListScheduleRuns(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	scheduleID, ok := parseUUIDParam(c, "id", "无效的定时任务ID")
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	runs, err := h.scheduleService.ListRuns(user.ID, scheduleID, limit)
+	if err != nil {
+		respondScheduleError(c, err, "SCHEDULE_RUNS_FAILED", "获取执行记录失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: gin.H{
+			"runs": runs,
+		},
+	})
+}
+
+// RunPrompt 实现 services.PromptRunner：按发送消息接口的流程执行定时提示词
+func (h *ChatHandler) RunPrompt(user *models.User, conversationID uuid.UUID, content string, metadata map[string]interface{}) (*models.ChatMessage, *models.ChatMessage, error) {
+	userMessage, reply, err := h.sendAndReply(user, conversationID, content, metadata)
+	if err != nil {
+		return userMessage, nil, err
+	}
+	return userMessage, reply.message, nil
+}
+
+// NotifyScheduleRun 实现 services.PromptRunner：通过WebSocket通知任务创建者执行结果，
+// 成功时对话的参与者已通过 chat_response 收到回复
+func (h *ChatHandler) NotifyScheduleRun(schedule *models.ScheduledPrompt, run *models.ScheduledPromptRun) {
+	if h.hub == nil {
+		return
+	}
+	message := websocket.Message{
+		Type:      "schedule_run",
+		Content:   schedule.Name,
+		UserID:    schedule.UserID,
+		Timestamp: time.Now(),
+		Data: gin.H{
+			"schedule_id":     schedule.ID,
+			"conversation_id": schedule.ConversationID,
+			"run":             run,
+			"next_run_at":     schedule.NextRunAt,
+			"enabled":         schedule.Enabled,
+		},
+	}
+	if err := h.hub.SendToUser(schedule.UserID, message); err != nil {
+		logrus.WithError(err).Warn("发送WebSocket消息失败")
+	}
+}
+
+// respondScheduleError 将定时任务的错误映射为HTTP响应
+func respondScheduleError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidSchedule), errors.Is(err, services.ErrInvalidCron):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_SCHEDULE",
+		})
+	case errors.Is(err, services.ErrScheduleLimit):
+		c.JSON(http.StatusConflict, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "SCHEDULE_LIMIT",
+		})
+	case errors.Is(err, services.ErrScheduleNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SearchHandler 消息搜索处理器
+type SearchHandler struct {
+	searchService *services.SearchService
+}
+
+// NewSearchHandler 创建消息搜索处理器
+func NewSearchHandler(searchService *services.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+	}
+}
+
+// Search 搜索当前用户所有对话中的消息
+// 查询参数：q（必填）、conversation_id、role、from、to、cursor、limit、semantic
+func (h *SearchHandler) Search(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	params := services.SearchParams{
+		Query:  c.Query("q"),
+		Role:   c.Query("role"),
+		Cursor: c.Query("cursor"),
+	}
+	params.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	params.Semantic, _ = strconv.ParseBool(c.DefaultQuery("semantic", "false"))
+
+	if params.Role != "" && params.Role != "user" && params.Role != "assistant" {
+		respondInvalidSearch(c, "role 只能是 user 或 assistant")
+		return
+	}
+
+	if raw := c.Query("conversation_id"); raw != "" {
+		conversationID, err := uuid.Parse(raw)
+		if err != nil {
+			respondInvalidSearch(c, "无效的对话ID")
+			return
+		}
+		params.ConversationID = &conversationID
+	}
+
+	if raw := c.Query("from"); raw != "" {
+		from, _, err := parseSearchTime(raw)
+		if err != nil {
+			respondInvalidSearch(c, "from 需要 RFC3339 时间或 YYYY-MM-DD 日期")
+			return
+		}
+		params.From = &from
+	}
+	if raw := c.Query("to"); raw != "" {
+		to, dateOnly, err := parseSearchTime(raw)
+		if err != nil {
+			respondInvalidSearch(c, "to 需要 RFC3339 时间或 YYYY-MM-DD 日期")
+			return
+		}
+		// 只给日期时包含当天
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		params.To = &to
+	}
+
+	result, err := h.searchService.Search(user.ID, params)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrEmptySearchQuery), errors.Is(err, utils.ErrInvalidCursor):
+			respondInvalidSearch(c, err.Error())
+		default:
+			respondChatError(c, err, "SEARCH_FAILED", "搜索消息失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    result,
+		Message: "搜索成功",
+	})
+}
+
+// parseSearchTime 解析 RFC3339 时间或 YYYY-MM-DD 日期，第二个返回值表示是否只有日期
+func parseSearchTime(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	return t, true, err
+}
+
+// respondInvalidSearch 搜索参数错误
+func respondInvalidSearch(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+		Error: message,
+		Code:  "INVALID_REQUEST",
+	})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"go-chat-backend/middleware"
+	"go-chat-backend/models"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateShareRequest 创建分享链接请求结构
+type CreateShareRequest struct {
+	Mode      string     `json:"mode"`                 // snapshot（默认）或 live
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // 为空表示不过期
+}
+
+// shareResponse 分享链接信息，token 只返回给对话所有者
+type shareResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	Token          string     `json:"token"`
+	URL            string     `json:"url"`
+	Mode           string     `json:"mode"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	ViewCount      int        `json:"view_count"`
+	LastViewedAt   *time.Time `json:"last_viewed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// ShareHandler 对话分享处理器
+type ShareHandler struct {
+	shareService *services.ShareService
+}
+
+// NewShareHandler 创建对话分享处理器
+func NewShareHandler(shareService *services.ShareService) *ShareHandler {
+	return &ShareHandler{
+		shareService: shareService,
+	}
+}
+
+// CreateShare 为对话创建分享链接
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req CreateShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+				Error:   "请求参数错误",
+				Code:    "INVALID_REQUEST",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	share, err := h.shareService.CreateShare(auditContext(c, &user.ID), user.ID, conversationID, req.Mode, req.ExpiresAt)
+	if err != nil {
+		respondShareError(c, err, "SHARE_CREATE_FAILED", "创建分享链接失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    newShareResponse(share),
+		Message: "分享链接已创建",
+	})
+}
+
+// ListShares 获取对话的全部分享链接
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	shares, err := h.shareService.ListShares(user.ID, conversationID)
+	if err != nil {
+		respondShareError(c, err, "SHARE_LIST_FAILED", "获取分享链接失败")
+		return
+	}
+
+	items := make([]shareResponse, 0, len(shares))
+	for i := range shares {
+		items = append(items, newShareResponse(&shares[i]))
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    items,
+		Message: "获取分享链接成功",
+	})
+}
+
+// RevokeShare 撤销分享链接
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	shareID, ok := parseUUIDParam(c, "share_id", "无效的分享ID")
+	if !ok {
+		return
+	}
+
+	if err := h.shareService.RevokeShare(auditContext(c, &user.ID), user.ID, shareID); err != nil {
+		respondShareError(c, err, "SHARE_REVOKE_FAILED", "撤销分享链接失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "分享链接已撤销",
+	})
+}
+
+// ViewShare 公开访问分享的对话，无需登录；format=html 时返回独立页面
+func (h *ShareHandler) ViewShare(c *gin.Context) {
+	view, err := h.shareService.ViewShare(auditContext(c, nil), c.Param("token"))
+	if err != nil {
+		respondShareError(c, err, "SHARE_VIEW_FAILED", "获取分享内容失败")
+		return
+	}
+
+	// 分享页面不应被搜索引擎收录或被中间代理缓存
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+
+	if c.Query("format") == services.ExportFormatHTML {
+		content, err := services.RenderSharedHTML(view)
+		if err != nil {
+			respondShareError(c, err, "SHARE_VIEW_FAILED", "获取分享内容失败")
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", content)
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    view,
+		Message: "获取分享内容成功",
+	})
+}
+
+// ForkShare 将分享的对话复制到当前用户的账号下
+func (h *ShareHandler) ForkShare(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	session, err := h.shareService.ForkShare(auditContext(c, &user.ID), user.ID, c.Param("token"))
+	if err != nil {
+		respondShareError(c, err, "SHARE_FORK_FAILED", "复制分享对话失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    session,
+		Message: "已复制到我的对话",
+	})
+}
+
+// auditContext 从请求中提取审计信息
+func auditContext(c *gin.Context, userID *uuid.UUID) services.AuditContext {
+	return services.AuditContext{
+		UserID:    userID,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// newShareResponse 生成分享链接响应，url 为相对于 API 前缀的公开访问路径
+func newShareResponse(share *models.ConversationShare) shareResponse {
+	return shareResponse{
+		ID:             share.ID,
+		ConversationID: share.ConversationID,
+		Token:          share.Token,
+		URL:            "/api/v1/shares/" + share.Token,
+		Mode:           share.Mode,
+		ExpiresAt:      share.ExpiresAt,
+		RevokedAt:      share.RevokedAt,
+		ViewCount:      share.ViewCount,
+		LastViewedAt:   share.LastViewedAt,
+		CreatedAt:      share.CreatedAt,
+	}
+}
+
+// respondShareError 将分享服务错误映射为HTTP响应
+func respondShareError(c *gin.Context, err error, code, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidShareMode), errors.Is(err, services.ErrShareExpiry):
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "INVALID_REQUEST",
+		})
+	case errors.Is(err, services.ErrShareNotFound):
+		c.JSON(http.StatusNotFound, utils.ErrorResponse{
+			Error: err.Error(),
+			Code:  "NOT_FOUND",
+		})
+	default:
+		respondChatError(c, err, code, message)
+	}
+}
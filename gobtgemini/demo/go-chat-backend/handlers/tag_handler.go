@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"go-chat-backend/middleware"
+	"go-chat-backend/services"
+	"go-chat-backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// CreateTagRequest 创建标签请求结构
+type CreateTagRequest struct {
+	Name  string `json:"name" binding:"required,max=50"`
+	Color string `json:"color" binding:"max=20"`
+}
+
+// UpdateTagRequest 修改标签请求结构，未提供的字段保持不变
+type UpdateTagRequest struct {
+	Name  *string `json:"name" binding:"omitempty,max=50"`
+	Color *string `json:"color" binding:"omitempty,max=20"`
+}
+
+// SetConversationTagsRequest 设置对话标签请求结构
+type SetConversationTagsRequest struct {
+	TagIDs []uuid.UUID `json:"tag_ids"`
+}
+
+// TagHandler 对话标签处理器
+type TagHandler struct {
+	tagService *services.TagService
+}
+
+// NewTagHandler 创建标签处理器
+func NewTagHandler(tagService *services.TagService) *TagHandler {
+	return &TagHandler{
+		tagService: tagService,
+	}
+}
+
+// CreateTag 创建标签
+func (h *TagHandler) CreateTag(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	var req CreateTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 name",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	tag, err := h.tagService.CreateTag(user.ID, req.Name, req.Color)
+	if err != nil {
+		respondOrganizeError(c, err, "TAG_CREATE_FAILED", "创建标签失败")
+		return
+	}
+
+	c.JSON(http.StatusCreated, utils.SuccessResponse{
+		Data:    tag,
+		Message: "标签创建成功",
+	})
+}
+
+// ListTags 获取标签列表及使用数量
+func (h *TagHandler) ListTags(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	tags, err := h.tagService.ListTags(user.ID)
+	if err != nil {
+		respondOrganizeError(c, err, "TAG_LIST_FAILED", "获取标签列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data: tags,
+	})
+}
+
+// UpdateTag 修改标签名称或颜色
+func (h *TagHandler) UpdateTag(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	tagID, ok := parseUUIDParam(c, "id", "无效的标签ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateTagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	tag, err := h.tagService.UpdateTag(user.ID, tagID, req.Name, req.Color)
+	if err != nil {
+		respondOrganizeError(c, err, "TAG_UPDATE_FAILED", "更新标签失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    tag,
+		Message: "标签已更新",
+	})
+}
+
+// DeleteTag 删除标签并从所有对话上移除
+func (h *TagHandler) DeleteTag(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	tagID, ok := parseUUIDParam(c, "id", "无效的标签ID")
+	if !ok {
+		return
+	}
+
+	if err := h.tagService.DeleteTag(user.ID, tagID); err != nil {
+		respondOrganizeError(c, err, "TAG_DELETE_FAILED", "删除标签失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Message: "标签已删除",
+	})
+}
+
+// SetConversationTags 替换对话的全部标签
+func (h *TagHandler) SetConversationTags(c *gin.Context) {
+	user, err := middleware.GetUserFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, utils.ErrorResponse{
+			Error: "无效的认证信息",
+			Code:  "INVALID_AUTH",
+		})
+		return
+	}
+
+	conversationID, ok := parseUUIDParam(c, "id", "无效的对话ID")
+	if !ok {
+		return
+	}
+
+	var req SetConversationTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, utils.ErrorResponse{
+			Error:   "请求参数错误，需要 tag_ids",
+			Code:    "INVALID_REQUEST",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	tags, err := h.tagService.SetConversationTags(user.ID, conversationID, req.TagIDs)
+	if err != nil {
+		respondOrganizeError(c, err, "TAG_UPDATE_FAILED", "设置对话标签失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.SuccessResponse{
+		Data:    tags,
+		Message: "对话标签已更新",
+	})
+}
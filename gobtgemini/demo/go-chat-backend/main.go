@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"go-chat-backend/config"
 	"go-chat-backend/database"
 	"go-chat-backend/handlers"
@@ -9,13 +11,21 @@ import (
 	"go-chat-backend/websocket"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		os.Exit(runReindexCommand(os.Args[2:]))
+	}
+
 	// 加载配置
 	config.LoadConfig()
 
@@ -32,24 +42,90 @@ func main() {
 	userService := services.NewUserService(db)
 	chatService := services.NewChatService(db)
 	llmService := services.NewLLMService()
-	chromaService,err_chroma := services.NewChromaService()
-	if err_chroma != nil {
-        logrus.Fatalf("初始化Chroma服务失败: %v", err_chroma)
-    }
+	// 向量化服务由记忆库和知识库共用，共享向量缓存
+	embeddingService := services.NewEmbeddingService(db)
+	memoryStore, chromaService, err := setupMemoryStore(db, embeddingService)
+	if err != nil {
+		logrus.Fatalf("初始化记忆库失败: %v", err)
+	}
+	if config.Get().MemoryHybridSearch {
+		// 关键词 + 向量混合检索，融合结果注入 SendMessage 的记忆上下文
+		memoryStore = services.NewHybridMemoryStore(memoryStore, db)
+	}
+
+	knowledgeService := services.NewKnowledgeService(db, chromaService)
+	// 重启前未处理完的文档无法继续，标记为失败
+	knowledgeService.Start(context.Background())
+	searchService := services.NewSearchService(db, memoryStore)
+	exportService := services.NewExportService(db)
+	shareService := services.NewShareService(db)
+	folderService := services.NewFolderService(db)
+	personaService := services.NewPersonaService(db)
+	tagService := services.NewTagService(db)
+	feedbackService := services.NewFeedbackService(db)
+	scheduleService := services.NewScheduleService(db)
+
+	// 应用重建索引后切换的记忆集合
+	reindexService := services.NewReindexService(db, memoryStore)
+	if err := reindexService.LoadActiveCollection(); err != nil {
+		logrus.Fatalf("加载记忆集合设置失败: %v", err)
+	}
+	// 其他实例完成重建并切换集合后，本实例在下一次轮询时跟随切换
+	reindexService.Start(context.Background())
+
+	// 启动记忆写入队列
+	memoryQueue := services.NewMemoryQueue(db, memoryStore)
+	memoryQueue.Start(context.Background())
+
+	// 后台执行对话导入任务
+	importService := services.NewImportService(db, memoryQueue)
+	importService.Start(context.Background())
+
+	// 定期彻底删除回收站中过期的对话
+	services.NewConversationJanitor(db, memoryStore).Start(context.Background())
+
+	// 按保留策略定期清理过期的消息、对话和知识库文档
+	retentionService := services.NewRetentionService(db, memoryStore, chromaService)
+	retentionService.Start(context.Background())
 
 	// 初始化处理器
 	authHandler := handlers.NewAuthHandler(userService)
-	chatHandler := handlers.NewChatHandler(chatService, llmService, chromaService)
+	chatHandler := handlers.NewChatHandler(chatService, llmService, memoryStore)
 	chatHandler.SetUserService(userService) // 设置用户服务
+	chatHandler.SetKnowledgeService(knowledgeService)
+	chatHandler.SetMemoryQueue(memoryQueue)
+	knowledgeHandler := handlers.NewKnowledgeHandler(knowledgeService)
+	adminHandler := handlers.NewAdminHandler(memoryQueue, reindexService, embeddingService)
+	searchHandler := handlers.NewSearchHandler(searchService)
+	exportHandler := handlers.NewExportHandler(exportService)
+	importHandler := handlers.NewImportHandler(importService)
+	shareHandler := handlers.NewShareHandler(shareService)
+	folderHandler := handlers.NewFolderHandler(folderService)
+	personaHandler := handlers.NewPersonaHandler(personaService)
+	tagHandler := handlers.NewTagHandler(tagService)
+	feedbackHandler := handlers.NewFeedbackHandler(feedbackService)
+	retentionHandler := handlers.NewRetentionHandler(retentionService)
+	scheduleHandler := handlers.NewScheduleHandler(scheduleService)
 
 	// 初始化WebSocket Hub
 	hub := websocket.NewHub()
+	hub.SetChatProcessor(chatHandler) // WebSocket聊天与HTTP发送消息使用相同的流程
+	broker, err := setupWebSocketBroker(db)
+	if err != nil {
+		logrus.Fatalf("初始化WebSocket跨节点分发失败: %v", err)
+	}
+	hub.SetBroker(broker, time.Duration(config.Get().WSPresenceSeconds)*time.Second)
 	go hub.Run()
 	wsHandler := websocket.NewHandler(hub)
 	chatHandler.SetWebSocketHub(hub) // 设置WebSocket Hub
+	adminHandler.SetWebSocketHub(hub)
+
+	// 定时提示词与发送消息使用相同的流程，多实例时由 advisory lock 选出的主节点触发
+	scheduleService.SetPromptRunner(chatHandler)
+	scheduleService.Start(context.Background())
 
 	// 设置路由
-	router := setupRouter(authHandler, chatHandler, wsHandler)
+	router := setupRouter(authHandler, chatHandler, knowledgeHandler, searchHandler, exportHandler, importHandler, shareHandler, folderHandler, personaHandler, tagHandler, feedbackHandler, retentionHandler, scheduleHandler, adminHandler, wsHandler)
 
 	// 启动服务器
 	port := config.GetString("PORT", "8080")
@@ -67,7 +143,52 @@ func setupLogger() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 }
 
-func setupRouter(authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHandler, wsHandler *websocket.Handler) *gin.Engine {
+// setupMemoryStore 根据 MEMORY_BACKEND 创建记忆库。
+// pgvector 模式下 Chroma 只用于知识库，连接失败时仅禁用知识库检索。
+func setupMemoryStore(db *gorm.DB, embedder *services.EmbeddingService) (services.MemoryStore, *services.ChromaService, error) {
+	switch config.Get().MemoryBackend {
+	case services.MemoryBackendPGVector:
+		store, err := services.NewPGVectorStore(db, embedder)
+		if err != nil {
+			return nil, nil, err
+		}
+		chromaService, err := services.NewChromaService(db, embedder)
+		if err != nil {
+			logrus.Warnf("Chroma不可用，知识库功能已禁用: %v", err)
+			chromaService = nil
+		}
+		logrus.Info("记忆库后端: pgvector")
+		return store, chromaService, nil
+	case services.MemoryBackendChroma, "":
+		chromaService, err := services.NewChromaService(db, embedder)
+		if err != nil {
+			return nil, nil, fmt.Errorf("初始化Chroma服务失败: %w", err)
+		}
+		return chromaService, chromaService, nil
+	default:
+		return nil, nil, fmt.Errorf("不支持的记忆库后端: %s", config.Get().MemoryBackend)
+	}
+}
+
+// setupWebSocketBroker 根据 WS_BROKER 创建跨节点分发。
+// 多副本部署时使用 postgres，回复和通知可以送达连接在其他实例上的用户。
+func setupWebSocketBroker(db *gorm.DB) (websocket.Broker, error) {
+	switch config.Get().WSBroker {
+	case "memory", "":
+		return websocket.NewMemoryBroker(), nil
+	case "postgres":
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		logrus.WithField("channel", config.Get().WSNotifyChannel).Info("WebSocket跨节点分发: postgres")
+		return websocket.NewPostgresBroker(sqlDB, config.Get().WSNotifyChannel), nil
+	default:
+		return nil, fmt.Errorf("不支持的WebSocket分发方式: %s", config.Get().WSBroker)
+	}
+}
+
+func setupRouter(authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHandler, knowledgeHandler *handlers.KnowledgeHandler, searchHandler *handlers.SearchHandler, exportHandler *handlers.ExportHandler, importHandler *handlers.ImportHandler, shareHandler *handlers.ShareHandler, folderHandler *handlers.FolderHandler, personaHandler *handlers.PersonaHandler, tagHandler *handlers.TagHandler, feedbackHandler *handlers.FeedbackHandler, retentionHandler *handlers.RetentionHandler, scheduleHandler *handlers.ScheduleHandler, adminHandler *handlers.AdminHandler, wsHandler *websocket.Handler) *gin.Engine {
 	// 设置Gin模式
 	ginMode := config.GetString("GIN_MODE", "debug")
 	gin.SetMode(ginMode)
@@ -77,7 +198,7 @@ func setupRouter(authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHa
 	// CORS中间件
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -102,6 +223,10 @@ func setupRouter(authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHa
 			auth.POST("/refresh", middleware.JWTAuthMiddleware(), authHandler.RefreshToken)
 		}
 
+		// 公开分享链接，查看无需登录，复制到自己账号需要登录
+		api.GET("/shares/:token", shareHandler.ViewShare)
+		api.POST("/shares/:token/fork", middleware.JWTAuthMiddleware(), shareHandler.ForkShare)
+
 		// 受保护的路由
 		protected := api.Group("/")
 		protected.Use(middleware.JWTAuthMiddleware())
@@ -117,15 +242,101 @@ func setupRouter(authHandler *handlers.AuthHandler, chatHandler *handlers.ChatHa
 				chat.GET("/history", chatHandler.GetChatHistory)
 				chat.DELETE("/history/:id", chatHandler.DeleteMessage)
 				chat.POST("/clear", chatHandler.ClearHistory)
+				chat.GET("/search", searchHandler.Search)
 				chat.GET("/conversations", chatHandler.GetConversations)//获取对话列表
 				chat.POST("/conversations", chatHandler.CreateConversation)//创建对话列表
+				chat.GET("/conversations/trash", chatHandler.GetDeletedConversations)
+				chat.POST("/conversations/bulk", chatHandler.BulkUpdateConversations)
+				chat.GET("/conversations/export", exportHandler.ExportAllConversations)
+				chat.PATCH("/conversations/:id", chatHandler.UpdateConversation)
+				chat.GET("/conversations/:id/settings", chatHandler.GetConversationSettings)
+				chat.PUT("/conversations/:id/settings", chatHandler.UpdateConversationSettings)
+				chat.DELETE("/conversations/:id", chatHandler.DeleteConversation)
+				chat.POST("/conversations/:id/restore", chatHandler.RestoreConversation)
 				chat.GET("/conversations/:id/history", chatHandler.GetOneConversationHistory)
+				chat.GET("/conversations/:id/export", exportHandler.ExportConversation)
+				chat.POST("/conversations/:id/shares", shareHandler.CreateShare)
+				chat.GET("/conversations/:id/shares", shareHandler.ListShares)
+				chat.DELETE("/shares/:share_id", shareHandler.RevokeShare)
+				chat.PUT("/conversations/:id/tags", tagHandler.SetConversationTags)
+				chat.GET("/conversations/:id/participants", chatHandler.ListParticipants)
+				chat.POST("/conversations/:id/participants", chatHandler.AddParticipant)
+				chat.PATCH("/conversations/:id/participants/:user_id", chatHandler.UpdateParticipant)
+				chat.DELETE("/conversations/:id/participants/:user_id", chatHandler.RemoveParticipant)
+				chat.GET("/folders", folderHandler.ListFolders)
+				chat.POST("/folders", folderHandler.CreateFolder)
+				chat.PATCH("/folders/:id", folderHandler.RenameFolder)
+				chat.DELETE("/folders/:id", folderHandler.DeleteFolder)
+				chat.GET("/personas", personaHandler.ListPersonas)
+				chat.POST("/personas", personaHandler.CreatePersona)
+				chat.PUT("/personas/:id", personaHandler.UpdatePersona)
+				chat.DELETE("/personas/:id", personaHandler.DeletePersona)
+				chat.GET("/tags", tagHandler.ListTags)
+				chat.POST("/tags", tagHandler.CreateTag)
+				chat.PATCH("/tags/:id", tagHandler.UpdateTag)
+				chat.DELETE("/tags/:id", tagHandler.DeleteTag)
+				chat.POST("/imports", importHandler.CreateImport)
+				chat.GET("/imports", importHandler.ListImports)
+				chat.GET("/imports/:id", importHandler.GetImport)
+				chat.POST("/conversations/:id/messages/:message_id/edit", chatHandler.EditMessage)
+				chat.POST("/conversations/:id/messages/:message_id/regenerate", chatHandler.RegenerateMessage)
+				chat.PUT("/conversations/:id/messages/:message_id/feedback", feedbackHandler.RateMessage)
+				chat.DELETE("/conversations/:id/messages/:message_id/feedback", feedbackHandler.DeleteFeedback)
+				chat.PUT("/conversations/:id/active-branch", chatHandler.SwitchBranch)
+				chat.GET("/conversations/:id/knowledge-bases", knowledgeHandler.ListConversationKnowledgeBases)
+				chat.POST("/conversations/:id/knowledge-bases", knowledgeHandler.AttachKnowledgeBase)
+				chat.DELETE("/conversations/:id/knowledge-bases/:kb_id", knowledgeHandler.DetachKnowledgeBase)
 				
 			}
 
+			// 知识库相关
+			knowledge := protected.Group("/knowledge-bases")
+			{
+				knowledge.POST("", knowledgeHandler.CreateKnowledgeBase)
+				knowledge.GET("", knowledgeHandler.ListKnowledgeBases)
+				knowledge.DELETE("/:id", knowledgeHandler.DeleteKnowledgeBase)
+				knowledge.POST("/:id/documents", knowledgeHandler.UploadDocument)
+				knowledge.GET("/:id/documents", knowledgeHandler.ListDocuments)
+				knowledge.DELETE("/:id/documents/:doc_id", knowledgeHandler.DeleteDocument)
+			}
+
+			// 定时提示词
+			schedules := protected.Group("/schedules")
+			{
+				schedules.GET("", scheduleHandler.ListSchedules)
+				schedules.POST("", scheduleHandler.CreateSchedule)
+				schedules.GET("/:id", scheduleHandler.GetSchedule)
+				schedules.PATCH("/:id", scheduleHandler.UpdateSchedule)
+				schedules.DELETE("/:id", scheduleHandler.DeleteSchedule)
+				schedules.GET("/:id/runs", scheduleHandler.ListScheduleRuns)
+			}
+
 			// WebSocket连接
 			protected.GET("/ws/chat", wsHandler.HandleWebSocket)
 		}
+
+		// 管理接口
+		admin := api.Group("/admin")
+		admin.Use(middleware.JWTAuthMiddleware(), middleware.AdminMiddleware())
+		{
+			admin.GET("/metrics", adminHandler.GetMetrics)
+			admin.GET("/memory-queue", adminHandler.GetMemoryQueueStatus)
+			admin.POST("/memory-queue/retry", adminHandler.RetryDeadMemoryJobs)
+			admin.GET("/memory/collection", adminHandler.GetMemoryCollection)
+			admin.POST("/memory/collection/switch", adminHandler.SwitchMemoryCollection)
+			admin.POST("/memory/reindex", adminHandler.StartReindex)
+			admin.GET("/memory/reindex", adminHandler.ListReindexJobs)
+			admin.GET("/memory/reindex/:id", adminHandler.GetReindexJob)
+			admin.POST("/memory/reindex/:id/cancel", adminHandler.CancelReindex)
+			admin.GET("/feedback/export", feedbackHandler.ExportFeedback)
+			admin.GET("/feedback/stats", feedbackHandler.GetFeedbackStats)
+			admin.GET("/retention/policies", retentionHandler.GetPolicies)
+			admin.PUT("/retention/policies/global", retentionHandler.UpdateGlobalPolicy)
+			admin.PUT("/retention/policies/users/:user_id", retentionHandler.UpdateUserPolicy)
+			admin.DELETE("/retention/policies/users/:user_id", retentionHandler.DeleteUserPolicy)
+			admin.GET("/retention/report", retentionHandler.GetReport)
+			admin.POST("/retention/run", retentionHandler.RunRetention)
+		}
 	}
 
 	return router
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"go-chat-backend/config"
 	"go-chat-backend/models"
 	"go-chat-backend/utils"
 	"net/http"
@@ -126,3 +127,24 @@ func ErrorHandlerMiddleware() gin.HandlerFunc {
 		}
 	}
 }
+
+// AdminMiddleware 管理员权限中间件，需在 JWTAuthMiddleware 之后使用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, _ := c.Get("username")
+		name, _ := username.(string)
+
+		for _, admin := range config.Get().AdminUsernames {
+			if name != "" && name == admin {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "无权限访问",
+			"code":  "FORBIDDEN",
+		})
+		c.Abort()
+	}
+}
@@ -27,6 +27,7 @@ type ChatMessage struct {
 	ID        uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	UserID    uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
 	ConversationID uuid.UUID `gorm:"type:uuid;index;not null" json:"conversation_id"`
+	ParentID  *uuid.UUID     `gorm:"type:uuid;index" json:"parent_id"` // 上一条消息，编辑和重新生成会产生同一父消息下的分支
 	User      User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Content   string         `gorm:"type:text;not null" json:"content"`
 	Role      string         `gorm:"size:20;not null" json:"role"`     // "user" or "assistant"
@@ -39,16 +40,47 @@ type ChatMessage struct {
 
 // ChatSession 聊天会话模型
 type ChatSession struct {
-	ID          uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID      uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
-	User        User           `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	Title       string         `gorm:"size:200" json:"title"`
-	Description string         `gorm:"type:text" json:"description,omitempty"`
-	Messages    []ChatMessage  `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
-	IsActive    bool           `gorm:"default:true" json:"is_active"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                 uuid.UUID          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID             uuid.UUID          `gorm:"type:uuid;not null;index" json:"user_id"`
+	User               User               `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Title              string             `gorm:"size:200" json:"title"`
+	Description        string             `gorm:"type:text" json:"description,omitempty"`
+	Messages           []ChatMessage      `gorm:"foreignKey:ConversationID" json:"messages,omitempty"`
+	IsActive           bool               `gorm:"default:true" json:"is_active"`
+	Pinned             bool               `gorm:"default:false" json:"pinned"`                      // 创建者的置顶状态，参与者的保存在 conversation_participants
+	Archived           bool               `gorm:"default:false;index" json:"archived"`              // 创建者的归档状态
+	ActiveLeafID       *uuid.UUID         `gorm:"type:uuid" json:"active_leaf_id"`                  // 当前分支最后一条消息
+	FolderID           *uuid.UUID         `gorm:"type:uuid;index" json:"folder_id"`                 // 创建者的文件夹，为空时不在任何文件夹中
+	Tags               []Tag              `gorm:"-" json:"tags,omitempty"`                          // 当前用户的标签，由服务层按 conversation_tags 填充
+	LastMessageAt      time.Time          `gorm:"index" json:"last_message_at"`                     // 最后一条消息的时间，没有消息时为创建时间
+	MessageCount       int                `gorm:"default:0" json:"message_count"`                   // 未删除的消息数，包含所有分支
+	LastMessagePreview string             `gorm:"size:200" json:"last_message_preview"`             // 最后一条消息的摘要
+	Settings           GenerationSettings `gorm:"embedded;embeddedPrefix:setting_" json:"settings"` // 对话级生成参数，为空的字段沿用人设和用户设置
+	PersonaID          *uuid.UUID         `gorm:"type:uuid;index" json:"persona_id"`                // 对话使用的人设，为空时不使用
+	Role               string             `gorm:"-" json:"role,omitempty"`                          // 当前用户在对话中的角色，由服务层填充
+	CreatedAt          time.Time          `json:"created_at"`
+	UpdatedAt          time.Time          `json:"updated_at"`
+	DeletedAt          gorm.DeletedAt     `gorm:"index" json:"deleted_at"`
+}
+
+// GenerationSettings 可覆盖的生成参数，字段为 nil 表示不覆盖，沿用上一级的设置
+type GenerationSettings struct {
+	Model         *string  `gorm:"size:100" json:"model"`
+	Temperature   *float32 `json:"temperature"`
+	MaxTokens     *int     `json:"max_tokens"`
+	SystemPrompt  *string  `gorm:"type:text" json:"system_prompt"`
+	ContextWindow *int     `json:"context_window"`
+	MemoryEnabled *bool    `json:"memory_enabled"`
+}
+
+// Persona 用户保存的一组生成参数（人设），对话选用后作为对话设置和用户偏好之间的一级
+type Persona struct {
+	ID        uuid.UUID          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID          `gorm:"type:uuid;not null;uniqueIndex:idx_persona_user_name" json:"user_id"`
+	Name      string             `gorm:"size:100;not null;uniqueIndex:idx_persona_user_name" json:"name"`
+	Settings  GenerationSettings `gorm:"embedded;embeddedPrefix:setting_" json:"settings"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
 }
 
 // UserPreference 用户偏好设置模型
@@ -77,3 +109,300 @@ type RefreshToken struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// KnowledgeBase 知识库模型
+type KnowledgeBase struct {
+	ID            uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID        uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name          string         `gorm:"size:200;not null" json:"name"`
+	Description   string         `gorm:"type:text" json:"description,omitempty"`
+	DocumentCount int            `gorm:"default:0" json:"document_count"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// KnowledgeDocument 知识库文档模型
+type KnowledgeDocument struct {
+	ID              uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	KnowledgeBaseID uuid.UUID      `gorm:"type:uuid;not null;index" json:"knowledge_base_id"`
+	UserID          uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	FileName        string         `gorm:"size:255;not null" json:"file_name"`
+	Format          string         `gorm:"size:20;not null" json:"format"` // "markdown", "text", "html", "pdf"
+	Size            int64          `json:"size"`
+	ChunkCount      int            `gorm:"default:0" json:"chunk_count"`
+	Status          string         `gorm:"size:20;not null;default:'processing'" json:"status"` // "processing", "ready", "failed"
+	Error           string         `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// KnowledgeChunk 文档分块模型（向量库之外保留一份原文，便于引用与重建索引）
+type KnowledgeChunk struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	DocumentID      uuid.UUID `gorm:"type:uuid;not null;index" json:"document_id"`
+	KnowledgeBaseID uuid.UUID `gorm:"type:uuid;not null;index" json:"knowledge_base_id"`
+	ChunkIndex      int       `gorm:"not null" json:"chunk_index"`
+	Location        string    `gorm:"size:500" json:"location,omitempty"` // 章节标题路径或页码
+	StartOffset     int       `json:"start_offset"`
+	EndOffset       int       `json:"end_offset"`
+	Content         string    `gorm:"type:text;not null" json:"content"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ConversationKnowledgeBase 对话与知识库的关联
+type ConversationKnowledgeBase struct {
+	ConversationID  uuid.UUID `gorm:"type:uuid;primary_key" json:"conversation_id"`
+	KnowledgeBaseID uuid.UUID `gorm:"type:uuid;primary_key" json:"knowledge_base_id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Folder 用户自定义的对话文件夹
+type Folder struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_folder_user_name" json:"user_id"`
+	Name      string    `gorm:"size:100;not null;uniqueIndex:idx_folder_user_name" json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Tag 用户自定义的对话标签
+type Tag struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_tag_user_name" json:"user_id"`
+	Name      string    `gorm:"size:50;not null;uniqueIndex:idx_tag_user_name" json:"name"`
+	Color     string    `gorm:"size:20" json:"color,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ConversationTag 对话与标签的关联
+type ConversationTag struct {
+	ConversationID uuid.UUID `gorm:"type:uuid;primary_key" json:"conversation_id"`
+	TagID          uuid.UUID `gorm:"type:uuid;primary_key;index" json:"tag_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// 对话参与者角色
+const (
+	ParticipantOwner  = "owner"  // 管理对话和参与者
+	ParticipantEditor = "editor" // 发送消息
+	ParticipantViewer = "viewer" // 只读
+)
+
+// ConversationParticipant 对话的参与者，对话创建者（ChatSession.UserID）不在此表中，始终拥有 owner 权限
+type ConversationParticipant struct {
+	ConversationID uuid.UUID  `gorm:"type:uuid;primary_key" json:"conversation_id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;primary_key;index" json:"user_id"`
+	Role           string     `gorm:"size:20;not null" json:"role"`
+	InvitedBy      uuid.UUID  `gorm:"type:uuid" json:"invited_by"`
+	Pinned         bool       `gorm:"default:false" json:"-"` // 参与者自己的置顶、归档和文件夹，与创建者和其他参与者互不影响
+	Archived       bool       `gorm:"default:false" json:"-"`
+	FolderID       *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// 消息评价
+const (
+	FeedbackUp   = "up"
+	FeedbackDown = "down"
+)
+
+// MessageFeedback 用户对AI回复的评价，每个用户对每条回复只保留一条
+type MessageFeedback struct {
+	ID             uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	MessageID      uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_feedback_message_user" json:"message_id"`
+	UserID         uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_feedback_message_user;index" json:"user_id"`
+	ConversationID uuid.UUID      `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	Rating         string         `gorm:"size:10;not null" json:"rating"`
+	Reasons        datatypes.JSON `gorm:"type:jsonb" json:"reasons,omitempty"` // 原因分类数组
+	Comment        string         `gorm:"type:text" json:"comment,omitempty"`
+	Model          string         `gorm:"size:100;index" json:"model"` // 生成回复的模型，来自消息元数据
+	CreatedAt      time.Time      `gorm:"index" json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// RetentionPolicy 数据保留策略，UserID 为空时为全局策略。
+// 天数为 nil 时用户策略沿用全局策略、全局策略不清理，0 表示永久保留
+type RetentionPolicy struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID      *uuid.UUID `gorm:"type:uuid;uniqueIndex" json:"user_id,omitempty"`
+	MessageDays *int       `json:"message_days"` // 删除早于 N 天的消息和不活跃的对话
+	DeletedDays *int       `json:"deleted_days"` // 软删除的消息、对话和知识库文档 M 天后彻底删除
+	UpdatedBy   *uuid.UUID `gorm:"type:uuid" json:"updated_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// 定时提示词执行状态
+const (
+	ScheduleRunRunning   = "running"
+	ScheduleRunSucceeded = "succeeded"
+	ScheduleRunFailed    = "failed"
+)
+
+// ScheduledPrompt 定时提示词，按 cron 表达式在指定时区触发，以创建者身份发送到对话并生成回复
+type ScheduledPrompt struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ConversationID uuid.UUID  `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	Name           string     `gorm:"size:200" json:"name"`
+	Prompt         string     `gorm:"type:text;not null" json:"prompt"`
+	CronExpr       string     `gorm:"size:100;not null" json:"cron"`
+	Timezone       string     `gorm:"size:64;not null" json:"timezone"`
+	Enabled        bool       `gorm:"default:true;index" json:"enabled"`
+	NextRunAt      *time.Time `gorm:"index" json:"next_run_at,omitempty"` // 停用或没有后续触发时间时为空
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	LastStatus     string     `gorm:"size:20" json:"last_status,omitempty"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ScheduledPromptRun 定时提示词的一次执行记录
+type ScheduledPromptRun struct {
+	ID                 uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ScheduleID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"schedule_id"`
+	ConversationID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	ScheduledFor       time.Time  `json:"scheduled_for"` // 计划的触发时间
+	Status             string     `gorm:"size:20;not null" json:"status"`
+	UserMessageID      *uuid.UUID `gorm:"type:uuid" json:"user_message_id,omitempty"`
+	AssistantMessageID *uuid.UUID `gorm:"type:uuid" json:"assistant_message_id,omitempty"`
+	Error              string     `gorm:"type:text" json:"error,omitempty"`
+	Node               string     `gorm:"size:100" json:"node"`
+	StartedAt          time.Time  `gorm:"index" json:"started_at"`
+	FinishedAt         *time.Time `json:"finished_at,omitempty"`
+}
+
+// 记忆写入任务状态
+const (
+	MemoryJobPending    = "pending"
+	MemoryJobProcessing = "processing"
+	MemoryJobDone       = "done"
+	MemoryJobDead       = "dead" // 超过最大重试次数，进入死信
+)
+
+// MemoryJob 记忆写入任务（持久化队列），内容以 chat_messages 为准
+type MemoryJob struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	MessageID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"message_id"` // chat_messages.id
+	UserID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	ConversationID uuid.UUID  `gorm:"type:uuid;not null" json:"conversation_id"`
+	Status         string     `gorm:"size:20;not null;default:'pending';index:idx_memory_jobs_status_next_run,priority:1" json:"status"`
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	NextRunAt      time.Time  `gorm:"not null;index:idx_memory_jobs_status_next_run,priority:2" json:"next_run_at"`
+	LockedAt       *time.Time `json:"locked_at,omitempty"`
+	LockedBy       string     `gorm:"size:100" json:"locked_by,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// SystemSetting 运行时系统设置（键值对）
+type SystemSetting struct {
+	Key       string    `gorm:"size:100;primary_key" json:"key"`
+	Value     string    `gorm:"type:text" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// 重建索引任务状态
+const (
+	ReindexRunning   = "running"
+	ReindexPaused    = "paused" // 被中断，可从游标处继续
+	ReindexFailed    = "failed"
+	ReindexCompleted = "completed"
+)
+
+// ReindexJob 记忆集合重建任务，按 (created_at, id) 游标分批处理，可断点续跑
+type ReindexJob struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	SourceCollection string     `gorm:"size:200" json:"source_collection"`
+	TargetCollection string     `gorm:"size:200;not null;index" json:"target_collection"`
+	Status           string     `gorm:"size:20;not null;index" json:"status"`
+	SwitchOnComplete bool       `gorm:"default:false" json:"switch_on_complete"`
+	BatchSize        int        `gorm:"default:64" json:"batch_size"`
+	CursorCreatedAt  *time.Time `json:"cursor_created_at,omitempty"`
+	CursorID         *uuid.UUID `gorm:"type:uuid" json:"cursor_id,omitempty"`
+	Total            int64      `json:"total"`
+	Processed        int64      `json:"processed"`
+	LastError        string     `gorm:"type:text" json:"last_error,omitempty"`
+	StartedAt        time.Time  `json:"started_at"`
+	FinishedAt       *time.Time `json:"finished_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// EmbeddingCacheEntry 向量缓存，Key 为 模型名+文本 的 SHA-256
+type EmbeddingCacheEntry struct {
+	Key        string    `gorm:"primaryKey;size:64" json:"key"`
+	Model      string    `gorm:"size:200;index" json:"model"`
+	Dimensions int       `json:"dimensions"`
+	Vector     []byte    `gorm:"type:bytea;not null" json:"-"` // float32 小端序
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// 导入任务状态
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// ImportJob 对话导入任务，上传内容暂存在 Payload 中由后台逐个对话导入，结束后清空
+type ImportJob struct {
+	ID                    uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID                uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Source                string     `gorm:"size:20;not null" json:"source"` // "native" 或 "chatgpt"
+	FileName              string     `gorm:"size:255" json:"file_name"`
+	Status                string     `gorm:"size:20;not null;index" json:"status"`
+	BackfillMemory        bool       `gorm:"default:false" json:"backfill_memory"`
+	Payload               []byte     `gorm:"type:bytea" json:"-"`
+	TotalConversations    int        `json:"total_conversations"`
+	ImportedConversations int        `json:"imported_conversations"`
+	SkippedConversations  int        `json:"skipped_conversations"`
+	ImportedMessages      int        `json:"imported_messages"`
+	LastError             string     `gorm:"type:text" json:"last_error,omitempty"`
+	StartedAt             *time.Time `json:"started_at,omitempty"`
+	FinishedAt            *time.Time `json:"finished_at,omitempty"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
+
+// 分享模式
+const (
+	ShareModeSnapshot = "snapshot" // 创建时的内容，之后的新消息不可见
+	ShareModeLive     = "live"     // 始终展示对话当前分支的最新内容
+)
+
+// ConversationShare 对话的公开只读分享链接
+type ConversationShare struct {
+	ID             uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ConversationID uuid.UUID      `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	UserID         uuid.UUID      `gorm:"type:uuid;not null;index" json:"user_id"`
+	Token          string         `gorm:"size:64;not null;uniqueIndex" json:"token"`
+	Mode           string         `gorm:"size:20;not null" json:"mode"`
+	Snapshot       datatypes.JSON `gorm:"type:jsonb" json:"-"` // snapshot 模式下保存的只读视图
+	ExpiresAt      *time.Time     `json:"expires_at,omitempty"`
+	RevokedAt      *time.Time     `json:"revoked_at,omitempty"`
+	ViewCount      int            `gorm:"default:0" json:"view_count"`
+	LastViewedAt   *time.Time     `json:"last_viewed_at,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// AuditLog 审计日志，匿名访问时 UserID 为空
+type AuditLog struct {
+	ID           uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID       *uuid.UUID     `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	Action       string         `gorm:"size:50;not null;index" json:"action"`
+	ResourceType string         `gorm:"size:50;not null" json:"resource_type"`
+	ResourceID   uuid.UUID      `gorm:"type:uuid;not null;index" json:"resource_id"`
+	IP           string         `gorm:"size:64" json:"ip,omitempty"`
+	UserAgent    string         `gorm:"size:500" json:"user_agent,omitempty"`
+	Details      datatypes.JSON `gorm:"type:jsonb" json:"details,omitempty"`
+	CreatedAt    time.Time      `gorm:"index" json:"created_at"`
+}
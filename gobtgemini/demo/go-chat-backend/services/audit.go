@@ -0,0 +1,43 @@
+package services
+
+import (
+	"encoding/json"
+	"go-chat-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// AuditContext 发起操作的请求信息，匿名访问时 UserID 为空
+type AuditContext struct {
+	UserID    *uuid.UUID
+	IP        string
+	UserAgent string
+}
+
+// recordAudit 写入审计日志；写入失败只记录日志，不影响业务操作
+func recordAudit(db *gorm.DB, actx AuditContext, action, resourceType string, resourceID uuid.UUID, details map[string]interface{}) {
+	entry := models.AuditLog{
+		ID:           uuid.New(),
+		UserID:       actx.UserID,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		IP:           actx.IP,
+		UserAgent:    truncateRunes(actx.UserAgent, 450),
+	}
+	if len(details) > 0 {
+		if raw, err := json.Marshal(details); err == nil {
+			entry.Details = datatypes.JSON(raw)
+		}
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"action":      action,
+			"resource_id": resourceID,
+		}).Warn("写入审计日志失败")
+	}
+}
@@ -1,31 +1,89 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
+	"go-chat-backend/config"
 	"go-chat-backend/models"
+	"go-chat-backend/utils"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
+// ErrConversationNotFound 对话不存在或不属于当前用户
+var ErrConversationNotFound = errors.New("对话不存在或无权访问")
+
 // ChatService 聊天服务
 type ChatService struct {
 	db *gorm.DB
+
+	// 已删除对话可恢复的期限，过期后由 ConversationJanitor 彻底删除
+	trashRetention time.Duration
+	// 同步令牌回退的时间窗口，见 defaultSyncOverlap
+	syncOverlap time.Duration
 }
 
 // NewChatService 创建聊天服务
 func NewChatService(db *gorm.DB) *ChatService {
-	return &ChatService{db: db}
+	return &ChatService{
+		db:             db,
+		trashRetention: time.Duration(config.Get().ConversationTrashDays) * 24 * time.Hour,
+		syncOverlap:    defaultSyncOverlap,
+	}
+}
+
+// SendMessage 在对话当前分支的末尾追加消息，metadata 可为空；对话不存在或无权写入时返回 ErrConversationNotFound
+func (s *ChatService) SendMessage(userID uuid.UUID, conversationID uuid.UUID, content string, role string, metadata map[string]interface{}) (*models.ChatMessage, error) {
+	if content == "" {
+		return nil, errors.New("消息内容不能为空")
+	}
+
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	parentID, err := s.activeLeaf(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createMessage(userID, conversationID, parentID, content, role, metadata)
 }
 
-// SendMessage 发送消息
-func (s *ChatService) SendMessage(userID uuid.UUID, conversationID uuid.UUID, content string, role string) (*models.ChatMessage, error) {
+// AddMessage 以 parentID 为父消息追加消息（为空时作为新的根消息），并切换到新消息所在的分支
+func (s *ChatService) AddMessage(userID, conversationID uuid.UUID, parentID *uuid.UUID, content string, role string, metadata map[string]interface{}) (*models.ChatMessage, error) {
 	if content == "" {
 		return nil, errors.New("消息内容不能为空")
 	}
 
+	if _, err := s.AuthorizeConversation(userID, conversationID, ConversationWrite); err != nil {
+		return nil, err
+	}
+
+	if parentID != nil {
+		var count int64
+		err := s.db.Model(&models.ChatMessage{}).
+			Where("id = ? AND conversation_id = ?", *parentID, conversationID).
+			Count(&count).Error
+		if err != nil {
+			logrus.WithError(err).Error("查询父消息失败")
+			return nil, errors.New("消息保存失败")
+		}
+		if count == 0 {
+			return nil, ErrMessageNotFound
+		}
+	}
+
+	return s.createMessage(userID, conversationID, parentID, content, role, metadata)
+}
+
+// createMessage 保存消息并将其设为对话当前分支的最后一条消息，同时更新对话的最后消息时间、消息数和摘要
+func (s *ChatService) createMessage(userID, conversationID uuid.UUID, parentID *uuid.UUID, content string, role string, metadata map[string]interface{}) (*models.ChatMessage, error) {
 	// 创建消息
 	message := &models.ChatMessage{
 		// ⭐ 新增：为每条消息生成一个新的、唯一的ID ⭐
@@ -33,13 +91,28 @@ func (s *ChatService) SendMessage(userID uuid.UUID, conversationID uuid.UUID, co
 
 		// ⭐ 新增：关联消息到指定的对话 ⭐
 		ConversationID: conversationID,
+		ParentID:       parentID,
 
 		UserID:  userID,
 		Content: content,
 		Role:    role,
 	}
 
-	if err := s.db.Create(message).Error; err != nil {
+	if len(metadata) > 0 {
+		raw, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, errors.New("消息元数据序列化失败")
+		}
+		message.Metadata = datatypes.JSON(raw)
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		return recordSessionMessage(tx, message)
+	})
+	if err != nil {
 		logrus.WithError(err).Error("消息保存失败")
 		return nil, errors.New("消息保存失败")
 	}
@@ -47,69 +120,155 @@ func (s *ChatService) SendMessage(userID uuid.UUID, conversationID uuid.UUID, co
 	return message, nil
 }
 
-// GetChatHistory 获取聊天历史
-func (s *ChatService) GetChatHistory(userID uuid.UUID, limit int, offset int) ([]models.ChatMessage, error) {
-	var messages []models.ChatMessage
-
-	if limit <= 0 || limit > 100 {
-		limit = 50 // 默认限制
+// activeLeaf 对话当前分支的最后一条消息；未记录时（早期数据）取最新的消息，空对话返回 nil
+func (s *ChatService) activeLeaf(session *models.ChatSession) (*uuid.UUID, error) {
+	if session.ActiveLeafID != nil {
+		return session.ActiveLeafID, nil
 	}
 
-	err := s.db.Where("user_id = ?", userID).
+	var latest models.ChatMessage
+	err := s.db.Where("conversation_id = ?", session.ID).
 		Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&messages).Error
+		Limit(1).
+		Find(&latest).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询最新消息失败")
+		return nil, errors.New("查询最新消息失败")
+	}
+	if latest.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &latest.ID, nil
+}
 
+// GetChatHistory 获取用户可以查看的所有对话中的消息（包括其他参与者的），回收站中的对话除外；
+// 按游标分页，每页从旧到新排列
+func (s *ChatService) GetChatHistory(userID uuid.UUID, page PageRequest) ([]models.ChatMessage, *PageInfo, error) {
+	pc, err := parsePage(page, 50)
 	if err != nil {
-		logrus.WithError(err).Error("获取聊天历史失败")
-		return nil, errors.New("获取聊天历史失败")
+		return nil, nil, err
 	}
 
-	// 反转数组，让最早的消息在前
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	var messages []models.ChatMessage
+	err = pc.apply(s.db.Where("conversation_id IN (?)", activeConversations(s.db, userID)), "").Find(&messages).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取聊天历史失败")
+		return nil, nil, errors.New("获取聊天历史失败")
 	}
 
-	return messages, nil
+	messages, info := finishPage(messages, pc, true, messageKey)
+	return messages, info, nil
+}
+
+// SyncedMessage 增量同步返回的消息，Deleted 为 true 表示已被删除
+type SyncedMessage struct {
+	models.ChatMessage
+	Deleted bool `json:"deleted"`
 }
 
-// GetRecentMessages 获取最近的消息（用于上下文）
-func (s *ChatService) GetRecentMessages(conversationID uuid.UUID, limit int) ([]models.ChatMessage, error) {
+// MessageChanges 同步令牌之后变更的消息
+type MessageChanges struct {
+	Messages  []SyncedMessage `json:"messages"`
+	SyncToken string          `json:"sync_token"` // 下次同步使用
+	HasMore   bool            `json:"has_more"`   // 为 true 时应立即用新令牌继续同步
+}
+
+// SyncMessages 获取同步令牌之后新增、修改或删除的消息；conversationID 不为空时只同步该对话（包括所有分支）。
+// 回收站中的对话不返回消息，其删除由 SyncChatSessions 报告；恢复时消息的 updated_at 会更新，再次同步即可取回
+func (s *ChatService) SyncMessages(userID uuid.UUID, conversationID *uuid.UUID, sync SyncRequest) (*MessageChanges, error) {
+	since, sinceID, err := parseSyncToken(sync.Since)
+	if err != nil {
+		return nil, err
+	}
+	limit := sync.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	query := s.db.Where("conversation_id IN (?)", activeConversations(s.db, userID))
+	if conversationID != nil {
+		if _, err := s.AuthorizeConversation(userID, *conversationID, ConversationRead); err != nil {
+			return nil, err
+		}
+		query = s.db.Where("conversation_id = ?", *conversationID)
+	}
+
+	start := time.Now()
 	var messages []models.ChatMessage
+	if err := applySync(query, since, sinceID, limit).Find(&messages).Error; err != nil {
+		logrus.WithError(err).Error("同步消息失败")
+		return nil, errors.New("同步消息失败")
+	}
 
-	if limit <= 0 || limit > 50 {
-		limit = 10 // 默认上下文窗口大小
+	changes := &MessageChanges{Messages: []SyncedMessage{}, SyncToken: sync.Since}
+	if len(messages) > limit {
+		changes.HasMore = true
+		messages = messages[:limit]
+	}
+	for _, msg := range messages {
+		changes.Messages = append(changes.Messages, SyncedMessage{ChatMessage: msg, Deleted: msg.DeletedAt.Valid})
 	}
+	if len(messages) > 0 {
+		last := messages[len(messages)-1]
+		changes.SyncToken = s.nextSyncToken(last.UpdatedAt, last.ID, changes.HasMore)
+	} else if sync.Since == "" {
+		changes.SyncToken = s.nextSyncToken(start, uuid.Nil, false)
+	}
+	return changes, nil
+}
 
-	err := s.db.Where("conversation_id = ?", conversationID).
-		Order("created_at DESC").
-		Limit(limit).
-		Find(&messages).Error
+// messageKey 消息的分页键
+func messageKey(msg models.ChatMessage) (time.Time, uuid.UUID) {
+	return msg.CreatedAt, msg.ID
+}
 
+// GetRecentMessages 获取当前分支上最近的消息（用于上下文）
+func (s *ChatService) GetRecentMessages(userID, conversationID uuid.UUID, limit int) ([]models.ChatMessage, error) {
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationRead)
 	if err != nil {
-		logrus.WithError(err).Error("获取最近消息失败")
-		return nil, errors.New("获取最近消息失败")
+		return nil, err
+	}
+
+	if limit <= 0 || limit > 50 {
+		limit = 10 // 默认上下文窗口大小
 	}
 
-	// 反转数组，让最早的消息在前
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	leafID, err := s.activeLeaf(session)
+	if err != nil || leafID == nil {
+		return nil, err
 	}
 
-	return messages, nil
+	return s.pathMessages(conversationID, *leafID, limit)
 }
 
-// DeleteMessage 删除消息
+// DeleteMessage 删除消息，删除其他参与者的消息（包括他们触发的AI回复）需要管理权限
 func (s *ChatService) DeleteMessage(userID, messageID uuid.UUID) error {
-	result := s.db.Where("id = ? AND user_id = ?", messageID, userID).Delete(&models.ChatMessage{})
-	if result.Error != nil {
-		logrus.WithError(result.Error).Error("删除消息失败")
-		return errors.New("删除消息失败")
+	message, err := s.AuthorizeMessage(userID, messageID, ConversationWrite)
+	if err != nil {
+		return err
+	}
+	if message.UserID != userID {
+		if _, err := s.AuthorizeConversation(userID, message.ConversationID, ConversationManage); err != nil {
+			return err
+		}
 	}
 
-	if result.RowsAffected == 0 {
-		return errors.New("消息不存在或无权删除")
+	// 删除的是当前分支的最后一条消息时，当前分支回退到其父消息
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := softDeleteMessages(tx, "id = ?", message.ID); err != nil {
+			return err
+		}
+		err := tx.Model(&models.ChatSession{}).
+			Where("id = ? AND active_leaf_id = ?", message.ConversationID, message.ID).
+			Update("active_leaf_id", message.ParentID).Error
+		if err != nil {
+			return err
+		}
+		return refreshSessionActivity(tx, message.ConversationID)
+	})
+	if err != nil {
+		logrus.WithError(err).Error("删除消息失败")
+		return errors.New("删除消息失败")
 	}
 
 	return nil
@@ -117,7 +276,17 @@ func (s *ChatService) DeleteMessage(userID, messageID uuid.UUID) error {
 
 // ClearHistory 清空聊天历史
 func (s *ChatService) ClearHistory(userID uuid.UUID) error {
-	err := s.db.Where("user_id = ?", userID).Delete(&models.ChatMessage{}).Error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var conversationIDs []uuid.UUID
+		err := tx.Model(&models.ChatMessage{}).Distinct("conversation_id").Where("user_id = ?", userID).Pluck("conversation_id", &conversationIDs).Error
+		if err != nil {
+			return err
+		}
+		if err := softDeleteMessages(tx, "user_id = ?", userID); err != nil {
+			return err
+		}
+		return refreshSessionActivity(tx, conversationIDs...)
+	})
 	if err != nil {
 		logrus.WithError(err).Error("清空聊天历史失败")
 		return errors.New("清空聊天历史失败")
@@ -126,17 +295,29 @@ func (s *ChatService) ClearHistory(userID uuid.UUID) error {
 	return nil
 }
 
+// softDeleteMessages 软删除消息，同时更新 updated_at 让增量同步能获取到删除
+func softDeleteMessages(tx *gorm.DB, query interface{}, args ...interface{}) error {
+	now := time.Now()
+	return tx.Model(&models.ChatMessage{}).Where(query, args...).Updates(map[string]interface{}{
+		"deleted_at": now,
+		"updated_at": now,
+	}).Error
+}
+
 // CreateChatSession 创建聊天会话
 func (s *ChatService) CreateChatSession(userID uuid.UUID, title string) (*models.ChatSession, error) {
 	if title == "" {
 		title = "新的聊天" + time.Now().Format("01-02 15:04")
 	}
 
+	now := time.Now()
 	session := &models.ChatSession{
-		ID:       uuid.New(), // 明确地在代码中生成ID
-		UserID:   userID,
-		Title:    title,
-		IsActive: true,
+		ID:            uuid.New(), // 明确地在代码中生成ID
+		UserID:        userID,
+		Title:         title,
+		IsActive:      true,
+		LastMessageAt: now,
+		CreatedAt:     now,
 	}
 
 	if err := s.db.Create(session).Error; err != nil {
@@ -152,114 +333,342 @@ func (s *ChatService) CreateChatSession(userID uuid.UUID, title string) (*models
 	return session, nil
 }
 
-// GetChatSessions 获取用户的聊天会话列表
-func (s *ChatService) GetChatSessions(userID uuid.UUID, limit int, offset int) ([]models.ChatSession, error) {
+// ConversationFilter 对话列表的过滤条件
+type ConversationFilter struct {
+	Archived bool       // 为 true 时只返回已归档的对话，否则只返回未归档的
+	Pinned   *bool      // 不为空时按置顶状态过滤
+	FolderID *uuid.UUID // 不为空时只返回该文件夹中的对话
+	Unfiled  bool       // 为 true 时只返回不在任何文件夹中的对话
+	TagID    *uuid.UUID // 不为空时只返回带有该标签的对话
+}
+
+// GetChatSessions 获取用户的聊天会话列表，按最后一条消息的时间从新到旧游标分页，每个对话附带标签
+func (s *ChatService) GetChatSessions(userID uuid.UUID, filter ConversationFilter, page PageRequest) ([]models.ChatSession, *PageInfo, error) {
+	pc, err := parsePage(page, 20)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 置顶、归档和文件夹按当前用户自己的状态过滤
+	personal := personalConversations(s.db, userID, func(query *gorm.DB) *gorm.DB {
+		query = query.Where("archived = ?", filter.Archived)
+		if filter.Pinned != nil {
+			query = query.Where("pinned = ?", *filter.Pinned)
+		}
+		if filter.FolderID != nil {
+			query = query.Where("folder_id = ?", *filter.FolderID)
+		} else if filter.Unfiled {
+			query = query.Where("folder_id IS NULL")
+		}
+		return query
+	})
+	query := s.db.Where("id IN (?) AND is_active = true", personal)
+	if filter.TagID != nil {
+		query = query.Where("id IN (?)", s.db.Table("conversation_tags").
+			Select("conversation_tags.conversation_id").
+			Joins("JOIN tags ON tags.id = conversation_tags.tag_id").
+			Where("conversation_tags.tag_id = ? AND tags.user_id = ?", *filter.TagID, userID))
+	}
+
 	var sessions []models.ChatSession
+	if err := pc.applyOn(query, "last_message_at", "id").Find(&sessions).Error; err != nil {
+		logrus.WithError(err).Error("获取聊天会话列表失败")
+		return nil, nil, errors.New("获取聊天会话列表失败")
+	}
 
-	if limit <= 0 || limit > 100 {
-		limit = 20
+	sessions, info := finishPage(sessions, pc, false, sessionKey)
+	if err := loadParticipantState(s.db, userID, sessions); err != nil {
+		logrus.WithError(err).Error("获取对话角色失败")
+		return nil, nil, errors.New("获取聊天会话列表失败")
+	}
+	if err := loadConversationTags(s.db, userID, sessions); err != nil {
+		logrus.WithError(err).Error("获取对话标签失败")
+		return nil, nil, errors.New("获取聊天会话列表失败")
 	}
+	return sessions, info, nil
+}
 
-	err := s.db.Where("user_id = ? AND is_active = true", userID).
-		Order("updated_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&sessions).Error
+// SessionChanges 同步令牌之后变更的对话，已删除的对话 is_active 为 false 且带有 deleted_at
+type SessionChanges struct {
+	Conversations []models.ChatSession `json:"conversations"`
+	SyncToken     string               `json:"sync_token"`
+	HasMore       bool                 `json:"has_more"`
+}
 
+// SyncChatSessions 获取同步令牌之后新建、修改、删除或恢复的对话
+func (s *ChatService) SyncChatSessions(userID uuid.UUID, sync SyncRequest) (*SessionChanges, error) {
+	since, sinceID, err := parseSyncToken(sync.Since)
 	if err != nil {
-		logrus.WithError(err).Error("获取聊天会话列表失败")
-		return nil, errors.New("获取聊天会话列表失败")
+		return nil, err
+	}
+	limit := sync.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	start := time.Now()
+	var sessions []models.ChatSession
+	if err := applySync(s.db.Where("id IN (?)", visibleConversations(s.db, userID)), since, sinceID, limit).Find(&sessions).Error; err != nil {
+		logrus.WithError(err).Error("同步对话失败")
+		return nil, errors.New("同步对话失败")
+	}
+
+	changes := &SessionChanges{Conversations: sessions, SyncToken: sync.Since}
+	if len(sessions) > limit {
+		changes.HasMore = true
+		changes.Conversations = sessions[:limit]
+	}
+	if err := loadParticipantState(s.db, userID, changes.Conversations); err != nil {
+		logrus.WithError(err).Error("获取对话角色失败")
+		return nil, errors.New("同步对话失败")
+	}
+	if err := loadConversationTags(s.db, userID, changes.Conversations); err != nil {
+		logrus.WithError(err).Error("获取对话标签失败")
+		return nil, errors.New("同步对话失败")
+	}
+	if n := len(changes.Conversations); n > 0 {
+		last := changes.Conversations[n-1]
+		changes.SyncToken = s.nextSyncToken(last.UpdatedAt, last.ID, changes.HasMore)
+	} else if sync.Since == "" {
+		changes.SyncToken = s.nextSyncToken(start, uuid.Nil, false)
+	}
+	return changes, nil
+}
+
+// SyncToken 以当前时间生成同步令牌，列表接口返回给客户端作为增量同步的起点
+func (s *ChatService) SyncToken() string {
+	return s.nextSyncToken(time.Now(), uuid.Nil, false)
+}
+
+// nextSyncToken 同步响应中的令牌。还有更多记录时从最后一条记录处继续；
+// 已追上最新变更时回退 syncOverlap，下次同步重新读取窗口内的记录
+func (s *ChatService) nextSyncToken(updatedAt time.Time, id uuid.UUID, hasMore bool) string {
+	if !hasMore {
+		updatedAt = updatedAt.Add(-s.syncOverlap)
 	}
+	return utils.EncodeCursor(updatedAt, id)
+}
 
-	return sessions, nil
+// sessionKey 对话的分页键，按最后一条消息的时间排序
+func sessionKey(session models.ChatSession) (time.Time, uuid.UUID) {
+	return session.LastMessageAt, session.ID
 }
 
-// UpdateChatSession 更新聊天会话
+// UpdateChatSession 更新聊天会话。标题和描述是对话共有的，需要管理权限；
+// 置顶、归档和文件夹只影响当前用户自己，能查看对话即可修改
 func (s *ChatService) UpdateChatSession(userID, sessionID uuid.UUID, updates map[string]interface{}) error {
-	allowedFields := map[string]bool{
+	sharedFields := map[string]bool{
 		"title":       true,
 		"description": true,
 	}
+	personalFields := map[string]bool{
+		"pinned":    true,
+		"archived":  true,
+		"folder_id": true,
+	}
 
-	filteredUpdates := make(map[string]interface{})
+	sharedUpdates := make(map[string]interface{})
+	personalUpdates := make(map[string]interface{})
 	for key, value := range updates {
-		if allowedFields[key] {
-			filteredUpdates[key] = value
+		if sharedFields[key] {
+			sharedUpdates[key] = value
+		} else if personalFields[key] {
+			personalUpdates[key] = value
 		}
 	}
 
-	if len(filteredUpdates) == 0 {
+	if len(sharedUpdates) == 0 && len(personalUpdates) == 0 {
 		return errors.New("没有有效的更新字段")
 	}
 
-	result := s.db.Model(&models.ChatSession{}).
-		Where("id = ? AND user_id = ?", sessionID, userID).
-		Updates(filteredUpdates)
-
-	if result.Error != nil {
-		logrus.WithError(result.Error).Error("更新聊天会话失败")
-		return errors.New("更新聊天会话失败")
+	access := ConversationRead
+	if len(sharedUpdates) > 0 {
+		access = ConversationManage
+	}
+	if _, err := s.AuthorizeConversation(userID, sessionID, access); err != nil {
+		return err
 	}
+	if folderID, ok := personalUpdates["folder_id"].(*uuid.UUID); ok && folderID != nil {
+		if _, err := findFolder(s.db, userID, *folderID); err != nil {
+			return err
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(sharedUpdates) > 0 {
+			if err := tx.Model(&models.ChatSession{}).Where("id = ?", sessionID).Updates(sharedUpdates).Error; err != nil {
+				return err
+			}
+		}
+		if len(personalUpdates) > 0 {
+			return updatePersonalState(tx, userID, []uuid.UUID{sessionID}, personalUpdates)
+		}
+		return nil
+	})
 
-	if result.RowsAffected == 0 {
-		return errors.New("会话不存在或无权修改")
+	if err != nil {
+		logrus.WithError(err).Error("更新聊天会话失败")
+		return errors.New("更新聊天会话失败")
 	}
 
 	return nil
 }
 
-// DeleteChatSession 删除聊天会话
+// DeleteChatSession 将聊天会话移入回收站。回收站列表和恢复只对创建者开放，删除同样只限创建者，
+// 作为 owner 加入的参与者返回 ErrConversationForbidden
 func (s *ChatService) DeleteChatSession(userID, sessionID uuid.UUID) error {
-	// 软删除，只是标记为非激活状态
-	result := s.db.Model(&models.ChatSession{}).
-		Where("id = ? AND user_id = ?", sessionID, userID).
-		Update("is_active", false)
+	session, err := s.AuthorizeConversation(userID, sessionID, ConversationManage)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return ErrConversationForbidden
+	}
+
+	// 软删除：标记为非激活并记录删除时间，保留期内可从回收站恢复
+	err = s.db.Model(&models.ChatSession{}).
+		Where("id = ?", sessionID).
+		Updates(map[string]interface{}{
+			"is_active":  false,
+			"deleted_at": time.Now(),
+		}).Error
 
-	if result.Error != nil {
-		logrus.WithError(result.Error).Error("删除聊天会话失败")
+	if err != nil {
+		logrus.WithError(err).Error("删除聊天会话失败")
 		return errors.New("删除聊天会话失败")
 	}
 
-	if result.RowsAffected == 0 {
-		return errors.New("会话不存在或无权删除")
+	return nil
+}
+
+// GetChatSession 获取单个聊天会话
+func (s *ChatService) GetChatSession(userID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	session, err := s.AuthorizeConversation(userID, sessionID, ConversationRead)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []models.ChatSession{*session}
+	if err := loadConversationTags(s.db, userID, sessions); err != nil {
+		logrus.WithError(err).Error("获取对话标签失败")
+		return nil, errors.New("获取聊天会话失败")
 	}
+	return &sessions[0], nil
+}
 
-	return nil
+// TrashRetention 已删除对话的保留期限
+func (s *ChatService) TrashRetention() time.Duration {
+	return s.trashRetention
 }
 
-func (s *ChatService) GetOneConversationHistory(conversationID uuid.UUID, limit, offset int) ([]models.ChatMessage, error) {
-	// 1. 声明一个空的 ChatMessage 切片，用于存放查询结果
-	var messages []models.ChatMessage
+// GetDeletedChatSessions 获取回收站中仍可恢复的会话，按删除时间从新到旧游标分页
+func (s *ChatService) GetDeletedChatSessions(userID uuid.UUID, page PageRequest) ([]models.ChatSession, *PageInfo, error) {
+	pc, err := parsePage(page, 20)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := s.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at > ?", userID, time.Now().Add(-s.trashRetention))
+
+	var sessions []models.ChatSession
+	if err := pc.applyOn(query, "deleted_at", "id").Find(&sessions).Error; err != nil {
+		logrus.WithError(err).Error("获取回收站会话失败")
+		return nil, nil, errors.New("获取回收站会话失败")
+	}
+
+	sessions, info := finishPage(sessions, pc, false, deletedSessionKey)
+	return sessions, info, nil
+}
+
+// deletedSessionKey 回收站的分页键，按删除时间排序
+func deletedSessionKey(session models.ChatSession) (time.Time, uuid.UUID) {
+	return session.DeletedAt.Time, session.ID
+}
+
+// RestoreChatSession 从回收站恢复会话，超过保留期限后视为不存在
+func (s *ChatService) RestoreChatSession(userID, sessionID uuid.UUID) (*models.ChatSession, error) {
+	var session models.ChatSession
+	err := s.db.Unscoped().
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL AND deleted_at > ?", sessionID, userID, time.Now().Add(-s.trashRetention)).
+		First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		logrus.WithError(err).Error("查询回收站会话失败")
+		return nil, errors.New("恢复聊天会话失败")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Model(&session).Updates(map[string]interface{}{
+			"is_active":  true,
+			"deleted_at": nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+		// 对话在回收站期间增量同步不返回其消息，恢复后让客户端重新获取
+		return tx.Model(&models.ChatMessage{}).Where("conversation_id = ?", session.ID).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("恢复聊天会话失败")
+		return nil, errors.New("恢复聊天会话失败")
+	}
+
+	session.IsActive = true
+	session.DeletedAt = gorm.DeletedAt{}
+	return &session, nil
+}
 
-	// 2. (可选但推荐) 对分页参数进行安全检查和设置默认值
-	if limit <= 0 || limit > 200 { // 设置一个最大值，防止一次请求过多数据
-		limit = 50
+// GetOneConversationHistory 获取指定对话当前分支上的消息，附带分支切换点信息；按游标分页，默认返回最新的一页，
+// 每页从旧到新排列。对话不存在或无权查看时返回 ErrConversationNotFound
+func (s *ChatService) GetOneConversationHistory(userID, conversationID uuid.UUID, page PageRequest) ([]PathMessage, *PageInfo, error) {
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationRead)
+	if err != nil {
+		return nil, nil, err
 	}
-	if offset < 0 {
-		offset = 0
+
+	pc, err := parsePage(page, 50)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// 3. 使用 GORM 构建数据库查询
-	err := s.db.
-		// 关键查询条件：只查找属于特定 conversation_id 的消息
-		Where("conversation_id = ?", conversationID).
-		// 按创建时间升序排列，确保聊天记录从旧到新，顺序正确
-		Order("created_at ASC").
-		// 应用分页：限制返回的记录数量
-		Limit(limit).
-		// 应用分页：跳过指定的记录数量
-		Offset(offset).
-		// 执行查询，并将结果填充到 messages 切片中
-		Find(&messages).Error
+	leafID, err := s.activeLeaf(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	if leafID == nil {
+		_, info := finishPage([]*models.ChatMessage{}, pc, true, messagePtrKey)
+		return []PathMessage{}, info, nil
+	}
 
-	// 4. 检查查询过程中是否发生错误
+	tree, err := s.loadMessageTree(conversationID)
 	if err != nil {
-		logrus.WithError(err).
-			WithField("conversation_id", conversationID). // 在日志中记录上下文信息，便于排查
-			Error("从数据库获取对话历史失败")
-		return nil, errors.New("获取对话历史失败")
+		return nil, nil, err
+	}
+
+	// 当前分支从根到叶按时间递增，在内存中按查询方向遍历并按游标截取，多取一条用于判断是否还有数据
+	path := tree.pathTo(*leafID)
+	var selected []*models.ChatMessage
+	for i := range path {
+		msg := path[i]
+		if pc.page.Direction != PageAfter {
+			msg = path[len(path)-1-i]
+		}
+		if pc.includes(msg.CreatedAt, msg.ID) {
+			selected = append(selected, msg)
+			if len(selected) > pc.page.Limit {
+				break
+			}
+		}
 	}
 
-	// 5. 如果没有错误，返回查询到的消息列表
-	return messages, nil
+	selected, info := finishPage(selected, pc, true, messagePtrKey)
+	return tree.annotate(selected), info, nil
+}
+
+// messagePtrKey 消息的分页键
+func messagePtrKey(msg *models.ChatMessage) (time.Time, uuid.UUID) {
+	return msg.CreatedAt, msg.ID
 }
@@ -8,10 +8,13 @@ import (
 	"go-chat-backend/config"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type EmbeddingRequest struct {
@@ -20,9 +23,10 @@ type EmbeddingRequest struct {
 }
 
 type Collection struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	// API 还返回其他字段，如 metadata, tenant 等，但我们这里不需要
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Metadata map[string]interface{} `json:"metadata"`
+	// API 还返回其他字段，如 tenant, database 等，但我们这里不需要
 }
 
 type EmbeddingResponse struct {
@@ -43,19 +47,57 @@ type ChromaService struct {
 	collection   string
 	httpClient   *http.Client
 	collectionId string
+	embedder     *EmbeddingService
+	// db 用于查询重建索引任务涉及的记忆集合，为空时只操作当前集合
+	db *gorm.DB
+
+	// 保护 collection/collectionId，重建索引后可在运行时切换记忆集合
+	mu sync.RWMutex
+
+	// 知识库使用独立的集合，避免与对话记忆混在一起
+	knowledgeCollection   string
+	knowledgeCollectionID string
+
+	// apiVersion 为 v1 或 v2，初始化时根据服务端探测结果确定
+	apiVersion    string
+	serverVersion string
+	tenant        string
+	database      string
+	distance      string
+
+	authToken   string
+	tokenHeader string
+	username    string
+	password    string
 }
 
-// NewChromaService 创建Chroma服务
-func NewChromaService() (*ChromaService, error) {
+const (
+	chromaAPIV1 = "v1"
+	chromaAPIV2 = "v2"
+)
+
+// NewChromaService 创建Chroma服务，向量化服务与其他组件共用以共享缓存
+func NewChromaService(db *gorm.DB, embedder *EmbeddingService) (*ChromaService, error) {
 	cfg := config.Get()
 	baseURL := fmt.Sprintf("http://%s:%s", cfg.ChromaHost, cfg.ChromaPort)
 
 	service := &ChromaService{
-		baseURL:    baseURL,
-		collection: cfg.ChromaCollection,
+		baseURL:             baseURL,
+		collection:          cfg.ChromaCollection,
+		knowledgeCollection: cfg.ChromaKnowledgeCollection,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		embedder:    embedder,
+		db:          db,
+		apiVersion:  strings.ToLower(cfg.ChromaAPIVersion),
+		tenant:      cfg.ChromaTenant,
+		database:    cfg.ChromaDatabase,
+		distance:    cfg.ChromaDistance,
+		authToken:   cfg.ChromaAuthToken,
+		tokenHeader: cfg.ChromaTokenHeader,
+		username:    cfg.ChromaUsername,
+		password:    cfg.ChromaPassword,
 	}
 
 	// 修改点2：在创建实例后，立即调用初始化函数
@@ -76,17 +118,24 @@ type Document struct {
 
 // QueryResult 查询结果结构
 type QueryResult struct {
-	IDs       [][]string            `json:"ids"`
-	Documents [][]string            `json:"documents"`
-	Metadatas [][]map[string]string `json:"metadatas"`
-	Distances [][]float64           `json:"distances"`
+	IDs       [][]string                 `json:"ids"`
+	Documents [][]string                 `json:"documents"`
+	Metadatas [][]map[string]interface{} `json:"metadatas"`
+	Distances [][]float64                `json:"distances"`
 }
 
 // AddRequest 添加文档请求结构
 type AddRequest struct {
-	IDs       []string                 `json:"ids"`
-	Documents []string                 `json:"documents"`
-	Metadatas []map[string]interface{} `json:"metadatas,omitempty"`
+	IDs        []string                 `json:"ids"`
+	Documents  []string                 `json:"documents"`
+	Embeddings [][]float64              `json:"embeddings,omitempty"`
+	Metadatas  []map[string]interface{} `json:"metadatas,omitempty"`
+}
+
+// DeleteRequest 按条件删除文档请求结构
+type DeleteRequest struct {
+	IDs   []string               `json:"ids,omitempty"`
+	Where map[string]interface{} `json:"where,omitempty"`
 }
 
 // QueryRequest 查询请求结构
@@ -96,128 +145,265 @@ type QueryRequest struct {
 	Where      map[string]interface{} `json:"where,omitempty"`
 }
 
-// InitCollection 初始化集合
+// InitCollection 探测服务端API版本，确保数据库和集合存在
 func (s *ChromaService) InitCollection() error {
-	// 检查集合是否存在
-	exists, err := s.collectionExists()
-	if err != nil {
-		return fmt.Errorf("检查集合存在性失败: %w", err)
+	if err := s.detectAPIVersion(); err != nil {
+		return err
 	}
 
-	if !exists {
-		// 创建集合
-		if err := s.createCollection(); err != nil {
-			return fmt.Errorf("创建集合失败: %w", err)
+	if s.apiVersion == chromaAPIV2 {
+		if err := s.ensureDatabase(); err != nil {
+			return err
 		}
-
-		time.Sleep(10 * time.Second)
-		logrus.Info("成功创建Chroma集合: ", s.collection)
-	} else {
-		logrus.Info("Chroma集合已存在: ", s.collection)
 	}
-	coID, err1 := s.getCollectionIDByName()
-	if err1 != nil {
-		return fmt.Errorf("获取集合ID失败：%w", err)
+
+	coID, err := s.ensureCollection(s.collection)
+	if err != nil {
+		return err
 	}
 	s.collectionId = coID
 
+	if s.knowledgeCollection != "" {
+		kbID, err := s.ensureCollection(s.knowledgeCollection)
+		if err != nil {
+			return fmt.Errorf("初始化知识库集合失败: %w", err)
+		}
+		s.knowledgeCollectionID = kbID
+	}
+
 	return nil
 }
 
-// 获取集合的id
-func (s *ChromaService) getCollectionIDByName() (string, error) {
-	url := fmt.Sprintf("%s/api/v1/collections", s.baseURL)
+// detectAPIVersion 通过 /version 接口探测服务端版本，未指定API版本时优先使用 v2
+func (s *ChromaService) detectAPIVersion() error {
+	candidates := []string{chromaAPIV2, chromaAPIV1}
+	switch s.apiVersion {
+	case chromaAPIV1, chromaAPIV2:
+		candidates = []string{s.apiVersion}
+	case "", "auto":
+	default:
+		return fmt.Errorf("不支持的 Chroma API 版本: %s", s.apiVersion)
+	}
+
+	var lastErr error
+	for _, version := range candidates {
+		serverVersion, err := s.fetchServerVersion(version)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.apiVersion = version
+		s.serverVersion = serverVersion
+		logrus.WithFields(logrus.Fields{
+			"api_version":    version,
+			"server_version": serverVersion,
+			"tenant":         s.tenant,
+			"database":       s.database,
+		}).Info("已连接Chroma服务")
+		return nil
+	}
+	return fmt.Errorf("探测Chroma API版本失败: %w", lastErr)
+}
 
-	// 2. 发送 HTTP GET 请求
-	fmt.Printf("正在向 %s 发送请求以获取所有集合...\n", url)
-	resp, err := s.httpClient.Get(url)
+// fetchServerVersion 请求指定API版本的 /version 接口
+func (s *ChromaService) fetchServerVersion(apiVersion string) (string, error) {
+	url := fmt.Sprintf("%s/api/%s/version", s.baseURL, apiVersion)
+	resp, err := s.send(http.MethodGet, url, nil)
 	if err != nil {
-		return "", fmt.Errorf("发送 HTTP GET 请求失败: %w", err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	// 3. 检查响应状态码
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("获取集合列表失败，服务器返回状态码: %d", resp.StatusCode)
+		return "", fmt.Errorf("%s/version 返回状态码: %d", apiVersion, resp.StatusCode)
 	}
 
-	// 4. 读取并解析 JSON 响应体
-	body, err := io.ReadAll(resp.Body)
+	// 响应体是一个JSON字符串，如 "1.0.12"
+	var version string
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("解析版本号失败: %w", err)
+	}
+	return version, nil
+}
+
+// ensureDatabase 确保 v2 API 下的数据库存在（租户需预先创建，默认租户始终存在）
+func (s *ChromaService) ensureDatabase() error {
+	url := fmt.Sprintf("%s/api/v2/tenants/%s/databases/%s", s.baseURL, s.tenant, s.database)
+	resp, err := s.send(http.MethodGet, url, nil)
 	if err != nil {
-		return "", fmt.Errorf("读取响应体失败: %w", err)
+		return fmt.Errorf("检查Chroma数据库失败: %w", err)
 	}
+	resp.Body.Close()
 
-	var collections []Collection
-	if err := json.Unmarshal(body, &collections); err != nil {
-		return "", fmt.Errorf("解析 JSON 响应失败: %w", err)
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("检查Chroma数据库时收到意外的状态码: %d", resp.StatusCode)
 	}
 
-	fmt.Printf("成功获取到 %d 个集合的信息。\n", len(collections))
+	createURL := fmt.Sprintf("%s/api/v2/tenants/%s/databases", s.baseURL, s.tenant)
+	if err := s.postJSON(createURL, map[string]string{"name": s.database}, nil); err != nil {
+		return fmt.Errorf("创建Chroma数据库失败: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"tenant":   s.tenant,
+		"database": s.database,
+	}).Info("成功创建Chroma数据库")
+	return nil
+}
 
-	// 5. 遍历返回的集合列表，查找名称匹配的集合
-	for _, c := range collections {
-		if c.Name == s.collection {
-			fmt.Printf("找到了！集合 '%s' 对应的 ID 是: %s\n", s.collection, c.ID)
-			s.collectionId = c.ID
-			return c.ID, nil // 找到后，返回 ID 和 nil 错误
+// ensureCollection 确保集合存在并返回集合ID
+func (s *ChromaService) ensureCollection(name string) (string, error) {
+	collection, err := s.getCollection(name)
+	if err != nil {
+		return "", fmt.Errorf("检查集合存在性失败: %w", err)
+	}
+
+	if collection == nil {
+		// get_or_create 保证多个实例同时启动时不会重复创建
+		collection, err = s.createCollection(name)
+		if err != nil {
+			return "", fmt.Errorf("创建集合失败: %w", err)
 		}
+		logrus.Info("成功创建Chroma集合: ", name)
+	} else {
+		logrus.Info("Chroma集合已存在: ", name)
+	}
+
+	// 距离函数在集合创建后无法修改，已有集合与配置不一致时只给出提示
+	if space := collectionSpace(collection); space != s.distance {
+		logrus.WithFields(logrus.Fields{
+			"collection": name,
+			"space":      space,
+			"configured": s.distance,
+		}).Warn("Chroma集合的距离函数与配置不一致，如需更换请重建记忆集合")
 	}
 
-	// 6. 如果循环结束仍未找到，返回错误
-	return "", fmt.Errorf("未找到名为 '%s' 的集合", s.collection)
+	return collection.ID, nil
 }
 
-// collectionExists 检查集合是否存在
-func (s *ChromaService) collectionExists() (bool, error) {
-	url := fmt.Sprintf("%s/api/v1/collections/%s", s.baseURL, s.collection)
-	resp, err := s.httpClient.Get(url)
+// getCollection 按名称获取集合，不存在时返回 nil
+func (s *ChromaService) getCollection(name string) (*Collection, error) {
+	url := s.collectionsURL(name)
+	resp, err := s.send(http.MethodGet, url, nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		return true, nil // 200 OK: 集合已存在
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var collection Collection
+		if err := json.NewDecoder(resp.Body).Decode(&collection); err != nil {
+			return nil, fmt.Errorf("解析集合信息失败: %w", err)
+		}
+		return &collection, nil
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusInternalServerError:
+		// 旧版 ChromaDB 对不存在的集合返回 500，视为“不存在”，但打印一条警告日志
+		logrus.Warnf("检查Chroma集合'%s'是否存在时收到500错误，暂时将其视为不存在", name)
+		return nil, nil
 	}
-	// 按照 RESTful 最佳实践，也应该处理 404 Not Found 的情况
-	if resp.StatusCode == http.StatusNotFound {
-		return false, nil // 404 Not Found: 集合不存在，这是正常情况
+
+	return nil, fmt.Errorf("检查Chroma集合存在性时收到意外的状态码: %d", resp.StatusCode)
+}
+
+// createCollection 以 get_or_create 方式创建集合，并设置距离函数
+func (s *ChromaService) createCollection(name string) (*Collection, error) {
+	requestBody := map[string]interface{}{
+		"name":          name,
+		"get_or_create": true,
+		"metadata": map[string]interface{}{
+			"hnsw:space": s.distance,
+		},
 	}
 
-	// 对于 ChromaDB 返回 500 的特殊情况，我们也将其视为“不存在”，但打印一条警告日志
-	if resp.StatusCode == http.StatusInternalServerError {
-		logrus.Warnf("检查Chroma集合'%s'是否存在时收到500错误，暂时将其视为不存在", s.collection)
-		return false, nil
+	var collection Collection
+	if err := s.postJSON(s.collectionsURL(""), requestBody, &collection); err != nil {
+		return nil, err
+	}
+	if collection.ID == "" {
+		return nil, errors.New("Chroma 未返回集合ID")
 	}
+	return &collection, nil
+}
 
-	// 其他所有非预期的状态码都应被视为一个真正的错误
-	return false, fmt.Errorf("检查Chroma集合存在性时收到意外的状态码: %d", resp.StatusCode)
+// collectionSpace 集合使用的距离函数，未设置时为 Chroma 默认的 l2
+func collectionSpace(collection *Collection) string {
+	if space, ok := collection.Metadata["hnsw:space"].(string); ok && space != "" {
+		return space
+	}
+	return "l2"
+}
 
+// collectionsURL 集合列表/创建接口地址，name 不为空时为单个集合地址
+func (s *ChromaService) collectionsURL(name string) string {
+	var url string
+	if s.apiVersion == chromaAPIV2 {
+		url = fmt.Sprintf("%s/api/v2/tenants/%s/databases/%s/collections", s.baseURL, s.tenant, s.database)
+	} else {
+		url = fmt.Sprintf("%s/api/v1/collections", s.baseURL)
+	}
+	if name != "" {
+		url += "/" + name
+	}
+	if s.apiVersion != chromaAPIV2 {
+		url += fmt.Sprintf("?tenant=%s&database=%s", s.tenant, s.database)
+	}
+	return url
 }
 
-// createCollection 创建集合
-func (s *ChromaService) createCollection() error {
-	url := fmt.Sprintf("%s/api/v1/collections", s.baseURL)
-	requestBody := map[string]string{
-		"name": s.collection,
+// collectionURL 集合操作接口地址，如 add、upsert、query、delete
+func (s *ChromaService) collectionURL(collectionID, operation string) string {
+	if s.apiVersion == chromaAPIV2 {
+		return fmt.Sprintf("%s/api/v2/tenants/%s/databases/%s/collections/%s/%s", s.baseURL, s.tenant, s.database, collectionID, operation)
 	}
+	return fmt.Sprintf("%s/api/v1/collections/%s/%s", s.baseURL, collectionID, operation)
+}
 
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return err
+// send 发送请求并附加认证头，body 为空时不设置 Content-Type
+func (s *ChromaService) send(method, url string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
 	}
 
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(method, url, reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	s.setAuthHeaders(req)
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("创建集合失败，状态码: %d", resp.StatusCode)
+	return s.httpClient.Do(req)
+}
+
+// setAuthHeaders 设置认证头：配置了令牌时使用令牌认证，否则配置了用户名时使用 Basic 认证
+func (s *ChromaService) setAuthHeaders(req *http.Request) {
+	switch {
+	case s.authToken != "":
+		if strings.EqualFold(s.tokenHeader, "Authorization") {
+			req.Header.Set("Authorization", "Bearer "+s.authToken)
+		} else {
+			req.Header.Set(s.tokenHeader, s.authToken)
+		}
+	case s.username != "":
+		req.SetBasicAuth(s.username, s.password)
 	}
+}
 
-	return nil
+// APIVersion 当前使用的API版本（v1 或 v2）
+func (s *ChromaService) APIVersion() string {
+	return s.apiVersion
+}
+
+// ServerVersion 服务端版本号
+func (s *ChromaService) ServerVersion() string {
+	return s.serverVersion
 }
 
 // AddMemory 添加记忆
@@ -236,9 +422,101 @@ func (s *ChromaService) AddMemory(userID uuid.UUID, content string, messageType
 	return s.addDocument(docID, content, metadata)
 }
 
+// EnsureCollection 确保集合存在并返回集合ID
+func (s *ChromaService) EnsureCollection(name string) (string, error) {
+	return s.ensureCollection(name)
+}
+
+// SwitchCollection 将记忆读写切换到指定集合，集合不存在时自动创建
+func (s *ChromaService) SwitchCollection(name string) error {
+	coID, err := s.ensureCollection(name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	previous := s.collection
+	s.collection = name
+	s.collectionId = coID
+	s.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"from": previous,
+		"to":   name,
+	}).Info("记忆集合已切换")
+	return nil
+}
+
+// CollectionName 当前使用的记忆集合名称
+func (s *ChromaService) CollectionName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collection
+}
+
+// activeCollectionID 当前使用的记忆集合ID
+func (s *ChromaService) activeCollectionID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collectionId
+}
+
+// MemoryEntry 待写入记忆库的一条消息
+type MemoryEntry struct {
+	ID             string // 使用 chat_messages.id，保证重复写入时幂等
+	UserID         uuid.UUID
+	ConversationID uuid.UUID
+	Role           string
+	Content        string
+	Timestamp      time.Time
+}
+
+// AddMemories 批量写入记忆，一次请求完成向量化，再以 upsert 写入集合
+func (s *ChromaService) AddMemories(entries []MemoryEntry) error {
+	return s.AddMemoriesTo(s.activeCollectionID(), entries)
+}
+
+// AddMemoriesTo 批量写入记忆到指定集合（重建索引时写入新集合）
+func (s *ChromaService) AddMemoriesTo(collectionID string, entries []MemoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	texts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		texts = append(texts, entry.Content)
+	}
+	embeddings, err := s.CreateEmbeddings(texts)
+	if err != nil {
+		return fmt.Errorf("批量创建向量失败: %w", err)
+	}
+
+	requestBody := AddRequest{
+		IDs:        make([]string, 0, len(entries)),
+		Documents:  texts,
+		Embeddings: embeddings,
+		Metadatas:  make([]map[string]interface{}, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		requestBody.IDs = append(requestBody.IDs, entry.ID)
+		requestBody.Metadatas = append(requestBody.Metadatas, map[string]interface{}{
+			"user_id":         entry.UserID.String(),
+			"conversation_id": entry.ConversationID.String(),
+			"message_type":    entry.Role,
+			"timestamp":       entry.Timestamp.Unix(),
+		})
+	}
+
+	url := s.collectionURL(collectionID, "upsert")
+	if err := s.postJSON(url, requestBody, nil); err != nil {
+		return fmt.Errorf("写入记忆失败: %w", err)
+	}
+	return nil
+}
+
 // addDocument 添加文档
 func (s *ChromaService) addDocument(id, content string, metadata map[string]interface{}) error {
-	url := fmt.Sprintf("%s/api/v1/collections/%s/add", s.baseURL, s.collectionId)
+	url := s.collectionURL(s.activeCollectionID(), "add")
 
 	requestBody := AddRequest{
 		IDs:       []string{id},
@@ -251,7 +529,7 @@ func (s *ChromaService) addDocument(id, content string, metadata map[string]inte
 		return fmt.Errorf("序列化请求失败: %w", err)
 	}
 
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := s.send(http.MethodPost, url, jsonData)
 	if err != nil {
 		return fmt.Errorf("发送请求失败: %w", err)
 	}
@@ -272,43 +550,12 @@ func (s *ChromaService) addDocument(id, content string, metadata map[string]inte
 
 // CreateEmbedding 为给定的文本创建向量
 func (s *ChromaService) CreateEmbedding(text string) ([]float64, error) {
-	embeddingServiceURL := "http://embedding-service/embed"
-
-	requestBody := map[string]interface{}{
-		"inputs":    text,
-		"normalize": true, // 推荐开启，使向量长度归一化
-		"truncate":  true, // 自动截断超长文本
-	}
-	jsonData, _ := json.Marshal(requestBody)
-
-	req, err := http.NewRequest("POST", embeddingServiceURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("创建 embedding 请求失败: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("请求 embedding 服务失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		// 可以添加更多日志来读取 resp.Body 的错误信息
-		return nil, fmt.Errorf("embedding 服务返回错误状态码: %d", resp.StatusCode)
-	}
-
-	// ⭐ 修改点 3：响应体是一个直接的二维数组
-	var embeddings [][]float64
-	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
-		return nil, fmt.Errorf("解析 embedding 响应失败: %w", err)
-	}
-
-	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
-		return nil, errors.New("从 embedding 服务收到的向量为空")
-	}
+	return s.embedder.CreateEmbedding(text)
+}
 
-	return embeddings[0], nil
+// CreateEmbeddings 批量创建向量，返回顺序与输入一致
+func (s *ChromaService) CreateEmbeddings(texts []string) ([][]float64, error) {
+	return s.embedder.CreateEmbeddings(texts)
 }
 
 // SearchMemory 搜索相关记忆
@@ -322,7 +569,7 @@ func (s *ChromaService) SearchMemory(userID uuid.UUID, query string, limit int)
 		return nil, fmt.Errorf("创建查询向量失败: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/api/v1/collections/%s/query", s.baseURL, s.collectionId)
+	url := s.collectionURL(s.activeCollectionID(), "query")
 
 	// 构建查询请求
 	requestBody := QueryRequestWithEmbeddings{
@@ -342,7 +589,7 @@ func (s *ChromaService) SearchMemory(userID uuid.UUID, query string, limit int)
 		return nil, fmt.Errorf("序列化查询请求失败: %w", err)
 	}
 	logrus.Infof("发送给 Chroma 的查询请求: %s", string(jsonData))
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := s.send(http.MethodPost, url, jsonData)
 	if err != nil {
 		return nil, fmt.Errorf("发送查询请求失败: %w", err)
 	}
@@ -372,14 +619,190 @@ func (s *ChromaService) SearchMemory(userID uuid.UUID, query string, limit int)
 	return documents, nil
 }
 
-// ClearUserMemory 清空用户记忆
+// AddKnowledgeChunks 批量写入知识库分块（携带预先计算好的向量）
+func (s *ChromaService) AddKnowledgeChunks(ids []string, documents []string, embeddings [][]float64, metadatas []map[string]interface{}) error {
+	if s.knowledgeCollectionID == "" {
+		return errors.New("知识库集合未初始化")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	url := s.collectionURL(s.knowledgeCollectionID, "add")
+	requestBody := AddRequest{
+		IDs:        ids,
+		Documents:  documents,
+		Embeddings: embeddings,
+		Metadatas:  metadatas,
+	}
+
+	if err := s.postJSON(url, requestBody, nil); err != nil {
+		return fmt.Errorf("写入知识库分块失败: %w", err)
+	}
+	return nil
+}
+
+// QueryKnowledge 在指定知识库范围内检索最相关的分块
+func (s *ChromaService) QueryKnowledge(embedding []float64, knowledgeBaseIDs []string, limit int) (*QueryResult, error) {
+	if s.knowledgeCollectionID == "" {
+		return nil, errors.New("知识库集合未初始化")
+	}
+	if len(knowledgeBaseIDs) == 0 {
+		return &QueryResult{}, nil
+	}
+
+	var where map[string]interface{}
+	if len(knowledgeBaseIDs) == 1 {
+		where = map[string]interface{}{"knowledge_base_id": map[string]string{"$eq": knowledgeBaseIDs[0]}}
+	} else {
+		where = map[string]interface{}{"knowledge_base_id": map[string]interface{}{"$in": knowledgeBaseIDs}}
+	}
+
+	url := s.collectionURL(s.knowledgeCollectionID, "query")
+	requestBody := QueryRequestWithEmbeddings{
+		QueryEmbeddings: [][]float64{embedding},
+		NResults:        limit,
+		Where:           where,
+	}
+
+	var result QueryResult
+	if err := s.postJSON(url, requestBody, &result); err != nil {
+		return nil, fmt.Errorf("检索知识库失败: %w", err)
+	}
+	return &result, nil
+}
+
+// DeleteKnowledge 按元数据删除知识库分块，如 {"document_id": "..."}
+func (s *ChromaService) DeleteKnowledge(field, value string) error {
+	if s.knowledgeCollectionID == "" {
+		return errors.New("知识库集合未初始化")
+	}
+
+	url := s.collectionURL(s.knowledgeCollectionID, "delete")
+	requestBody := DeleteRequest{
+		Where: map[string]interface{}{field: map[string]string{"$eq": value}},
+	}
+
+	if err := s.postJSON(url, requestBody, nil); err != nil {
+		return fmt.Errorf("删除知识库分块失败: %w", err)
+	}
+	return nil
+}
+
+// postJSON 发送JSON请求，out 不为空时解析响应体
+func (s *ChromaService) postJSON(url string, body interface{}, out interface{}) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	resp, err := s.send(http.MethodPost, url, jsonData)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("状态码: %d, 响应: %s", resp.StatusCode, string(detail))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("解析响应失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// ClearUserMemory 按 user_id 元数据删除用户在所有记忆集合中的记忆
 func (s *ChromaService) ClearUserMemory(userID uuid.UUID) error {
-	// 注意：Chroma不支持按元数据删除，这里只是示例实现
-	// 实际使用中可能需要重新创建集合或使用其他策略
-	logrus.WithField("user_id", userID.String()).Info("请求清空用户记忆（暂不支持）")
+	requestBody := DeleteRequest{
+		Where: map[string]interface{}{"user_id": map[string]string{"$eq": userID.String()}},
+	}
+	if err := s.deleteFromMemoryCollections(requestBody); err != nil {
+		return fmt.Errorf("清空用户记忆失败: %w", err)
+	}
+	return nil
+}
+
+// DeleteConversationMemories 按 conversation_id 元数据删除对话在所有记忆集合中的记忆
+func (s *ChromaService) DeleteConversationMemories(conversationID uuid.UUID) error {
+	requestBody := DeleteRequest{
+		Where: map[string]interface{}{"conversation_id": map[string]string{"$eq": conversationID.String()}},
+	}
+	if err := s.deleteFromMemoryCollections(requestBody); err != nil {
+		return fmt.Errorf("删除对话记忆失败: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessageMemories 按消息ID（即记忆文档ID）删除所有记忆集合中的记忆
+func (s *ChromaService) DeleteMessageMemories(messageIDs []uuid.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	requestBody := DeleteRequest{IDs: make([]string, len(messageIDs))}
+	for i, id := range messageIDs {
+		requestBody.IDs[i] = id.String()
+	}
+
+	if err := s.deleteFromMemoryCollections(requestBody); err != nil {
+		return fmt.Errorf("删除消息记忆失败: %w", err)
+	}
+	return nil
+}
+
+// deleteFromMemoryCollections 在所有记忆集合中执行删除。重建索引后旧集合仍然保留，
+// 只删当前集合的话，切换回旧集合或从旧集合重建时被删除的记忆会重新出现
+func (s *ChromaService) deleteFromMemoryCollections(requestBody DeleteRequest) error {
+	collectionIDs, err := s.memoryCollectionIDs()
+	if err != nil {
+		return err
+	}
+	for _, collectionID := range collectionIDs {
+		if err := s.postJSON(s.collectionURL(collectionID, "delete"), requestBody, nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// memoryCollectionIDs 记忆集合ID：当前集合，以及重建索引任务记录的源集合和目标集合。
+// 同一租户/数据库中的其他集合可能属于别的应用，不在范围内；已不存在的集合跳过
+func (s *ChromaService) memoryCollectionIDs() ([]string, error) {
+	active := s.activeCollectionID()
+	ids := []string{active}
+	if s.db == nil {
+		return ids, nil
+	}
+
+	var names []string
+	err := s.db.Raw("SELECT source_collection FROM reindex_jobs UNION SELECT target_collection FROM reindex_jobs").
+		Scan(&names).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询重建索引集合失败: %w", err)
+	}
+
+	seen := map[string]bool{active: true, s.knowledgeCollectionID: true}
+	current := s.CollectionName()
+	for _, name := range names {
+		if name == "" || name == current || name == s.knowledgeCollection {
+			continue
+		}
+		collection, err := s.getCollection(name)
+		if err != nil {
+			return nil, fmt.Errorf("获取集合 %s 失败: %w", name, err)
+		}
+		if collection == nil || seen[collection.ID] {
+			continue
+		}
+		seen[collection.ID] = true
+		ids = append(ids, collection.ID)
+	}
+	return ids, nil
+}
+
 // GetMemoryStats 获取记忆统计信息
 func (s *ChromaService) GetMemoryStats(userID uuid.UUID) (map[string]interface{}, error) {
 	// 这里返回一些模拟数据，实际使用中可以通过查询获取真实统计
@@ -387,7 +810,7 @@ func (s *ChromaService) GetMemoryStats(userID uuid.UUID) (map[string]interface{}
 		"user_id":         userID.String(),
 		"total_memories":  0,
 		"last_updated":    time.Now().Format(time.RFC3339),
-		"collection_name": s.collection,
+		"collection_name": s.CollectionName(),
 	}
 
 	return stats, nil
@@ -395,8 +818,8 @@ func (s *ChromaService) GetMemoryStats(userID uuid.UUID) (map[string]interface{}
 
 // HealthCheck 健康检查
 func (s *ChromaService) HealthCheck() error {
-	url := fmt.Sprintf("%s/api/v1/heartbeat", s.baseURL)
-	resp, err := s.httpClient.Get(url)
+	url := fmt.Sprintf("%s/api/%s/heartbeat", s.baseURL, s.apiVersion)
+	resp, err := s.send(http.MethodGet, url, nil)
 	if err != nil {
 		return fmt.Errorf("Chroma连接失败: %w", err)
 	}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"encoding/json"
+	"go-chat-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestChromaService 创建指向测试服务器的 ChromaService
+func newTestChromaService(baseURL string) *ChromaService {
+	return &ChromaService{
+		baseURL:     baseURL,
+		collection:  "chat_memory",
+		httpClient:  &http.Client{Timeout: 5 * time.Second},
+		apiVersion:  "auto",
+		tenant:      "default_tenant",
+		database:    "chat",
+		distance:    "cosine",
+		tokenHeader: "Authorization",
+	}
+}
+
+// TestChromaInitCollectionV2 测试探测到 v2 时使用租户/数据库路径并以 get_or_create 创建集合
+func TestChromaInitCollectionV2(t *testing.T) {
+	var created map[string]interface{}
+	var databaseCreated bool
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/version", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		json.NewEncoder(w).Encode("1.0.12")
+	})
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases", func(w http.ResponseWriter, r *http.Request) {
+		databaseCreated = true
+		json.NewEncoder(w).Encode(map[string]string{"name": "chat"})
+	})
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat/collections/chat_memory", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat/collections", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		json.NewDecoder(r.Body).Decode(&created)
+		json.NewEncoder(w).Encode(Collection{ID: "c-1", Name: "chat_memory", Metadata: created["metadata"].(map[string]interface{})})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := newTestChromaService(server.URL)
+	s.authToken = "secret"
+	require.NoError(t, s.InitCollection())
+
+	assert.Equal(t, "v2", s.APIVersion())
+	assert.Equal(t, "1.0.12", s.ServerVersion())
+	assert.True(t, databaseCreated)
+	assert.Equal(t, "c-1", s.activeCollectionID())
+	assert.Equal(t, true, created["get_or_create"])
+	assert.Equal(t, "cosine", created["metadata"].(map[string]interface{})["hnsw:space"])
+	assert.Equal(t, server.URL+"/api/v2/tenants/default_tenant/databases/chat/collections/c-1/query", s.collectionURL("c-1", "query"))
+}
+
+// TestChromaInitCollectionFallbackV1 测试服务端不支持 v2 时回退到 v1，并使用 Basic 认证
+func TestChromaInitCollectionFallbackV1(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/version", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "pw", pass)
+		json.NewEncoder(w).Encode("0.5.5")
+	})
+	mux.HandleFunc("/api/v1/collections/chat_memory", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "chat", r.URL.Query().Get("database"))
+		json.NewEncoder(w).Encode(Collection{ID: "c-old", Name: "chat_memory"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := newTestChromaService(server.URL)
+	s.username = "admin"
+	s.password = "pw"
+	require.NoError(t, s.InitCollection())
+
+	assert.Equal(t, "v1", s.APIVersion())
+	assert.Equal(t, "c-old", s.activeCollectionID())
+	assert.Equal(t, server.URL+"/api/v1/collections/c-old/upsert", s.collectionURL("c-old", "upsert"))
+}
+
+// TestChromaDeleteMemoriesAllCollections 测试删除记忆时覆盖重建索引涉及的旧集合，
+// 但不动知识库集合和同一数据库中其他应用的集合
+func TestChromaDeleteMemoriesAllCollections(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.Create(&[]models.ReindexJob{
+		{ID: uuid.New(), SourceCollection: "chat_memory", TargetCollection: "chat_memory_v2", Status: "completed"},
+		{ID: uuid.New(), SourceCollection: "chat_memory_v2", TargetCollection: "chat_memory_dropped", Status: "failed"},
+	}).Error)
+
+	collections := map[string]string{
+		"chat_memory":    "c-old",
+		"chat_memory_v2": "c-new",
+		"other_app":      "c-other",
+		"knowledge":      "c-kb",
+	}
+	deleted := map[string][]string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat/collections/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v2/tenants/default_tenant/databases/chat/collections/"), "/")
+		if r.Method == http.MethodGet {
+			id, ok := collections[parts[0]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(Collection{ID: id, Name: parts[0]})
+			return
+		}
+		var body DeleteRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		deleted[parts[0]] = body.IDs
+		w.WriteHeader(http.StatusOK)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := newTestChromaService(server.URL)
+	s.apiVersion = "v2"
+	s.db = db
+	s.collection = "chat_memory_v2"
+	s.collectionId = "c-new"
+	s.knowledgeCollection = "knowledge"
+	s.knowledgeCollectionID = "c-kb"
+
+	messageID := uuid.New()
+	require.NoError(t, s.DeleteMessageMemories([]uuid.UUID{messageID}))
+	assert.Equal(t, map[string][]string{
+		"c-new": {messageID.String()},
+		"c-old": {messageID.String()},
+	}, deleted)
+}
+
+// TestChromaClearUserMemory 测试按 user_id 元数据清空用户在记忆集合中的记忆
+func TestChromaClearUserMemory(t *testing.T) {
+	var where map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat/collections/c-1/delete", func(w http.ResponseWriter, r *http.Request) {
+		var body DeleteRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		where = body.Where
+		w.WriteHeader(http.StatusOK)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := newTestChromaService(server.URL)
+	s.apiVersion = "v2"
+	s.collectionId = "c-1"
+
+	userID := uuid.New()
+	require.NoError(t, s.ClearUserMemory(userID))
+	assert.Equal(t, map[string]interface{}{"user_id": map[string]interface{}{"$eq": userID.String()}}, where)
+}
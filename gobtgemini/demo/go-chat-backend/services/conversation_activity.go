@@ -0,0 +1,80 @@
+package services
+
+import (
+	"go-chat-backend/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 对话列表中最后一条消息摘要的长度（字符）
+const sessionPreviewLength = 100
+
+// messagePreview 生成消息摘要：合并空白并截取开头
+func messagePreview(content string) string {
+	return truncateRunes(strings.Join(strings.Fields(content), " "), sessionPreviewLength)
+}
+
+// recordSessionMessage 新消息写入后更新对话的当前分支、最后消息时间、消息数和摘要，需要与写入消息在同一事务中
+func recordSessionMessage(tx *gorm.DB, message *models.ChatMessage) error {
+	return tx.Model(&models.ChatSession{}).
+		Where("id = ?", message.ConversationID).
+		Updates(map[string]interface{}{
+			"active_leaf_id":       message.ID,
+			"last_message_at":      message.CreatedAt,
+			"message_count":        gorm.Expr("message_count + ?", 1),
+			"last_message_preview": messagePreview(message.Content),
+		}).Error
+}
+
+// refreshSessionActivity 删除消息后按剩余的消息重新计算对话的最后消息时间、消息数和摘要
+func refreshSessionActivity(tx *gorm.DB, conversationIDs ...uuid.UUID) error {
+	for _, conversationID := range conversationIDs {
+		var count int64
+		if err := tx.Model(&models.ChatMessage{}).Where("conversation_id = ?", conversationID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		var latest models.ChatMessage
+		err := tx.Where("conversation_id = ?", conversationID).
+			Order("created_at DESC, id DESC").
+			Limit(1).
+			Find(&latest).Error
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"message_count":        count,
+			"last_message_at":      latest.CreatedAt,
+			"last_message_preview": messagePreview(latest.Content),
+			"updated_at":           time.Now(),
+		}
+		if latest.ID == uuid.Nil {
+			updates["last_message_at"] = gorm.Expr("created_at")
+		}
+		err = tx.Model(&models.ChatSession{}).Unscoped().Where("id = ?", conversationID).Updates(updates).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySessionActivity 为导入的对话填充最后消息时间、消息数和摘要，在写入对话之前调用
+func applySessionActivity(session *models.ChatSession, messages []*models.ChatMessage) {
+	session.MessageCount = len(messages)
+	session.LastMessageAt = session.CreatedAt
+	var latest *models.ChatMessage
+	for _, msg := range messages {
+		if latest == nil || msg.CreatedAt.After(latest.CreatedAt) {
+			latest = msg
+		}
+	}
+	if latest != nil {
+		session.LastMessageAt = latest.CreatedAt
+		session.LastMessagePreview = messagePreview(latest.Content)
+	}
+}
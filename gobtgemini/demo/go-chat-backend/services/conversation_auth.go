@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 权限相关错误
+var (
+	// ErrMessageNotFound 消息不存在或所在对话无权访问
+	ErrMessageNotFound = errors.New("消息不存在或无权访问")
+	// ErrConversationForbidden 参与者的角色不足以执行操作；非参与者仍返回 ErrConversationNotFound
+	ErrConversationForbidden = errors.New("当前角色无权执行此操作")
+)
+
+// ConversationAccess 对话操作所需的权限级别
+type ConversationAccess int
+
+const (
+	// ConversationRead 查看对话和消息
+	ConversationRead ConversationAccess = iota
+	// ConversationWrite 发送消息、删除自己的消息
+	ConversationWrite
+	// ConversationManage 修改、删除对话，挂载知识库，管理参与者，删除他人的消息
+	ConversationManage
+)
+
+// participantAccess 参与者角色对应的权限级别
+var participantAccess = map[string]ConversationAccess{
+	models.ParticipantViewer: ConversationRead,
+	models.ParticipantEditor: ConversationWrite,
+	models.ParticipantOwner:  ConversationManage,
+}
+
+// AuthorizeConversation 校验用户对对话的访问权限。
+// 对话不存在和无权访问统一返回 ErrConversationNotFound，不向调用方泄露他人对话是否存在。
+func (s *ChatService) AuthorizeConversation(userID, conversationID uuid.UUID, access ConversationAccess) (*models.ChatSession, error) {
+	return authorizeConversation(s.db, userID, conversationID, access)
+}
+
+// AuthorizeMessage 通过消息所在的对话校验权限
+func (s *ChatService) AuthorizeMessage(userID, messageID uuid.UUID, access ConversationAccess) (*models.ChatMessage, error) {
+	return authorizeMessage(s.db, userID, messageID, access)
+}
+
+// authorizeMessage 各服务共用的消息权限校验，所在对话无权访问时返回 ErrMessageNotFound
+func authorizeMessage(db *gorm.DB, userID, messageID uuid.UUID, access ConversationAccess) (*models.ChatMessage, error) {
+	var message models.ChatMessage
+	err := db.Where("id = ?", messageID).First(&message).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		logrus.WithError(err).Error("查询消息失败")
+		return nil, errors.New("查询消息失败")
+	}
+
+	if _, err := authorizeConversation(db, userID, message.ConversationID, access); err != nil {
+		if errors.Is(err, ErrConversationNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// authorizeConversation 各服务共用的对话权限校验，已删除（非激活）的对话视为不存在。
+// 创建者拥有全部权限，参与者按角色授权；返回的对话 Role、置顶、归档和文件夹为当前用户自己的
+func authorizeConversation(db *gorm.DB, userID, conversationID uuid.UUID, access ConversationAccess) (*models.ChatSession, error) {
+	var session models.ChatSession
+	err := db.Where("id = ? AND is_active = ?", conversationID, true).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConversationNotFound
+		}
+		logrus.WithError(err).Error("查询对话失败")
+		return nil, errors.New("查询对话失败")
+	}
+
+	// 创建者拥有全部权限
+	if session.UserID == userID {
+		session.Role = models.ParticipantOwner
+		return &session, nil
+	}
+
+	var participant models.ConversationParticipant
+	err = db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).Limit(1).Find(&participant).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询对话参与者失败")
+		return nil, errors.New("查询对话失败")
+	}
+	if granted, ok := participantAccess[participant.Role]; ok {
+		if granted < access {
+			return nil, ErrConversationForbidden
+		}
+		applyParticipantState(&session, participant)
+		return &session, nil
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":         userID,
+		"conversation_id": conversationID,
+		"access":          access,
+	}).Warn("拒绝访问他人的对话")
+	return nil, ErrConversationNotFound
+}
+
+// activeConversations 用户可以查看且不在回收站中的对话ID子查询，用于返回消息内容的查询
+func activeConversations(db *gorm.DB, userID uuid.UUID) *gorm.DB {
+	return db.Model(&models.ChatSession{}).Select("id").Where("id IN (?) AND is_active = ?", visibleConversations(db, userID), true)
+}
+
+// visibleConversations 用户可以查看的对话ID子查询：自己创建的和作为参与者加入的，包括回收站中的
+func visibleConversations(db *gorm.DB, userID uuid.UUID) *gorm.DB {
+	return db.Model(&models.ChatSession{}).Unscoped().Select("id").Where("user_id = ?", userID).
+		Or("id IN (?)", db.Model(&models.ConversationParticipant{}).Select("conversation_id").Where("user_id = ?", userID))
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConversationCrossUserAccess 测试他人无法读写对话，且与对话不存在时的错误一致
+func TestConversationCrossUserAccess(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "alice 的对话")
+	require.NoError(t, err)
+	message, err := chatService.SendMessage(alice.ID, conversation.ID, "订单 ORD-1024", "user", nil)
+	require.NoError(t, err)
+
+	_, err = chatService.SendMessage(bob.ID, conversation.ID, "插入一条消息", "user", nil)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	_, _, err = chatService.GetOneConversationHistory(bob.ID, conversation.ID, PageRequest{})
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	_, err = chatService.GetRecentMessages(bob.ID, conversation.ID, 10)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	err = chatService.UpdateChatSession(bob.ID, conversation.ID, map[string]interface{}{"title": "改名"})
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	err = chatService.DeleteChatSession(bob.ID, conversation.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	err = chatService.DeleteMessage(bob.ID, message.ID)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	// 不存在的对话返回同样的错误
+	_, _, err = chatService.GetOneConversationHistory(bob.ID, uuid.New(), PageRequest{})
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	// 所有者不受影响
+	messages, _, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "订单 ORD-1024", messages[0].Content)
+	assert.NoError(t, chatService.DeleteMessage(alice.ID, message.ID))
+}
+
+// TestDeletedConversationNotAccessible 测试已删除的对话对所有者也不可访问
+func TestDeletedConversationNotAccessible(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "你好", "user", nil)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+}
+
+// TestKnowledgeBaseAttachCrossUser 测试不能给他人的对话挂载知识库
+func TestKnowledgeBaseAttachCrossUser(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	knowledgeService := NewKnowledgeService(db, nil)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	kb, err := knowledgeService.CreateKnowledgeBase(bob.ID, "bob 的知识库", "")
+	require.NoError(t, err)
+
+	err = knowledgeService.AttachKnowledgeBase(bob.ID, conversation.ID, kb.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	_, err = knowledgeService.ListConversationKnowledgeBases(bob.ID, conversation.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+}
+
+// TestConversationParticipants 测试参与者按角色读写对话、查看彼此的消息，以及角色的修改和移除
+func TestConversationParticipants(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+	dave := createTestUser(t, db, "dave")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "团队讨论")
+	require.NoError(t, err)
+	aliceMessage, err := chatService.SendMessage(alice.ID, conversation.ID, "大家好", "user", nil)
+	require.NoError(t, err)
+
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "bob", "editor")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "carol", "viewer")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "bob", "viewer")
+	assert.ErrorIs(t, err, ErrParticipantExists)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "nobody", "viewer")
+	assert.ErrorIs(t, err, ErrParticipantUser)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "dave", "admin")
+	assert.ErrorIs(t, err, ErrInvalidParticipantRole)
+	_, err = chatService.AddParticipant(bob.ID, conversation.ID, "dave", "viewer")
+	assert.ErrorIs(t, err, ErrConversationForbidden)
+
+	// 编辑者可以发送消息，查看者只能读
+	bobMessage, err := chatService.SendMessage(bob.ID, conversation.ID, "收到", "user", nil)
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(carol.ID, conversation.ID, "我也来", "user", nil)
+	assert.ErrorIs(t, err, ErrConversationForbidden)
+	messages, _, err := chatService.GetOneConversationHistory(carol.ID, conversation.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, alice.ID, messages[0].UserID)
+	assert.Equal(t, bob.ID, messages[1].UserID)
+
+	// 非参与者仍视为对话不存在
+	_, _, err = chatService.GetOneConversationHistory(dave.ID, conversation.ID, PageRequest{})
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	// 编辑者只能删除自己的消息
+	assert.ErrorIs(t, chatService.DeleteMessage(bob.ID, aliceMessage.ID), ErrConversationForbidden)
+	require.NoError(t, chatService.DeleteMessage(bob.ID, bobMessage.ID))
+
+	// 参与者的对话列表中包含共享的对话及自己的角色
+	sessions, _, err := chatService.GetChatSessions(carol.ID, ConversationFilter{}, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "viewer", sessions[0].Role)
+
+	members, err := chatService.ConversationMemberIDs(conversation.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uuid.UUID{alice.ID, bob.ID, carol.ID}, members)
+
+	participants, err := chatService.ListParticipants(carol.ID, conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, participants, 3)
+	assert.True(t, participants[0].Creator)
+	assert.Equal(t, "alice", participants[0].Username)
+
+	// 提升为 owner 后可以管理参与者，但不能修改创建者
+	require.NoError(t, chatService.UpdateParticipantRole(alice.ID, conversation.ID, bob.ID, "owner"))
+	_, err = chatService.AddParticipant(bob.ID, conversation.ID, "dave", "viewer")
+	require.NoError(t, err)
+	assert.ErrorIs(t, chatService.RemoveParticipant(bob.ID, conversation.ID, alice.ID), ErrConversationCreator)
+
+	// 查看者可以自己退出，之后无法访问
+	require.NoError(t, chatService.RemoveParticipant(carol.ID, conversation.ID, carol.ID))
+	_, err = chatService.GetChatSession(carol.ID, conversation.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	assert.ErrorIs(t, chatService.RemoveParticipant(alice.ID, conversation.ID, carol.ID), ErrParticipantNotFound)
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 批量操作类型
+const (
+	BulkMove      = "move"  // 移动到 FolderID 指定的文件夹，FolderID 为空时移出文件夹
+	BulkTag       = "tag"   // 添加 TagIDs 中的标签
+	BulkUntag     = "untag" // 移除 TagIDs 中的标签
+	BulkPin       = "pin"
+	BulkUnpin     = "unpin"
+	BulkArchive   = "archive"
+	BulkUnarchive = "unarchive"
+	BulkDelete    = "delete" // 移入回收站
+)
+
+// 单次批量操作的对话数量上限
+const maxBulkConversations = 500
+
+// 批量操作相关错误
+var (
+	ErrInvalidBulkAction  = errors.New("不支持的批量操作")
+	ErrInvalidBulkRequest = errors.New("批量操作需要 1 到 500 个对话ID")
+	ErrBulkTagsRequired   = errors.New("标签操作需要 tag_ids")
+)
+
+// BulkOperation 对多个对话执行的同一操作
+type BulkOperation struct {
+	ConversationIDs []uuid.UUID
+	Action          string
+	FolderID        *uuid.UUID
+	TagIDs          []uuid.UUID
+}
+
+// BulkResult 批量操作结果，NotFound 为不存在、已删除或无权操作的对话
+type BulkResult struct {
+	Updated  []uuid.UUID `json:"updated"`
+	NotFound []uuid.UUID `json:"not_found"`
+}
+
+// BulkUpdateChatSessions 对多个对话执行移动、标签、置顶、归档或删除，在一个事务中完成。
+// 移动、标签、置顶和归档只改变当前用户自己的整理状态，可用于所有可见的对话；
+// 删除只处理当前用户创建的对话。其余的在结果中列为 NotFound
+func (s *ChatService) BulkUpdateChatSessions(userID uuid.UUID, op BulkOperation) (*BulkResult, error) {
+	if len(op.ConversationIDs) == 0 || len(op.ConversationIDs) > maxBulkConversations {
+		return nil, ErrInvalidBulkRequest
+	}
+
+	updates := map[string]interface{}{}
+	switch op.Action {
+	case BulkMove:
+		if op.FolderID != nil {
+			if _, err := findFolder(s.db, userID, *op.FolderID); err != nil {
+				return nil, err
+			}
+		}
+		updates["folder_id"] = op.FolderID
+	case BulkTag, BulkUntag:
+		if len(op.TagIDs) == 0 {
+			return nil, ErrBulkTagsRequired
+		}
+		if _, err := findTags(s.db, userID, op.TagIDs); err != nil {
+			return nil, err
+		}
+	case BulkPin, BulkUnpin:
+		updates["pinned"] = op.Action == BulkPin
+	case BulkArchive, BulkUnarchive:
+		updates["archived"] = op.Action == BulkArchive
+	case BulkDelete:
+		updates["is_active"] = false
+		updates["deleted_at"] = time.Now()
+	default:
+		return nil, ErrInvalidBulkAction
+	}
+
+	query := s.db.Model(&models.ChatSession{}).Where("id IN ? AND is_active = ?", op.ConversationIDs, true)
+	if op.Action == BulkDelete {
+		query = query.Where("user_id = ?", userID)
+	} else {
+		query = query.Where("id IN (?)", visibleConversations(s.db, userID))
+	}
+	var ids []uuid.UUID
+	err := query.Pluck("id", &ids).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询批量操作的对话失败")
+		return nil, errors.New("批量操作失败")
+	}
+
+	result := &BulkResult{Updated: ids, NotFound: []uuid.UUID{}}
+	found := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		found[id] = true
+	}
+	for _, id := range op.ConversationIDs {
+		if !found[id] {
+			result.NotFound = append(result.NotFound, id)
+			found[id] = true
+		}
+	}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		switch op.Action {
+		case BulkTag:
+			if err := addConversationTags(tx, ids, op.TagIDs); err != nil {
+				return err
+			}
+		case BulkUntag:
+			err := tx.Where("conversation_id IN ? AND tag_id IN ?", ids, op.TagIDs).Delete(&models.ConversationTag{}).Error
+			if err != nil {
+				return err
+			}
+		case BulkMove, BulkPin, BulkUnpin, BulkArchive, BulkUnarchive:
+			return updatePersonalState(tx, userID, ids, updates)
+		}
+		// 标签操作只更新 updated_at，让增量同步能获取到变化
+		updates["updated_at"] = time.Now()
+		return tx.Model(&models.ChatSession{}).Where("id IN ?", ids).Updates(updates).Error
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("action", op.Action).Error("批量操作对话失败")
+		return nil, errors.New("批量操作失败")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id": userID,
+		"action":  op.Action,
+		"count":   len(ids),
+	}).Info("批量操作对话完成")
+	return result, nil
+}
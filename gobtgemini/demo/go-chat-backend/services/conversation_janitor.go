@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// conversationPurgeBatch 每轮最多彻底删除的对话数量
+const conversationPurgeBatch = 100
+
+// ConversationJanitor 定期彻底删除回收站中过期的对话，连同消息、关联数据和记忆向量
+type ConversationJanitor struct {
+	db          *gorm.DB
+	memoryStore MemoryStore
+	retention   time.Duration
+	interval    time.Duration
+}
+
+// NewConversationJanitor 创建对话清理器
+func NewConversationJanitor(db *gorm.DB, memoryStore MemoryStore) *ConversationJanitor {
+	return &ConversationJanitor{
+		db:          db,
+		memoryStore: memoryStore,
+		retention:   time.Duration(config.Get().ConversationTrashDays) * 24 * time.Hour,
+		interval:    time.Hour,
+	}
+}
+
+// Start 启动后台清理，启动时先执行一次
+func (j *ConversationJanitor) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			if purged, err := j.PurgeExpired(); err != nil {
+				logrus.WithError(err).Warn("清理过期对话失败")
+			} else if purged > 0 {
+				logrus.WithField("count", purged).Info("已彻底删除过期对话")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// PurgeExpired 彻底删除超过保留期限的对话，返回删除的数量。
+// 记忆向量删除失败的对话保留到下一轮重试，避免留下无法追溯的向量。
+func (j *ConversationJanitor) PurgeExpired() (int, error) {
+	// 旧版本删除对话只标记 is_active=false，补上删除时间以便进入回收站流程
+	err := j.db.Unscoped().Model(&models.ChatSession{}).
+		Where("is_active = ? AND deleted_at IS NULL", false).
+		Update("deleted_at", gorm.Expr("updated_at")).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var sessions []models.ChatSession
+	err = j.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at <= ?", time.Now().Add(-j.retention)).
+		Order("deleted_at ASC").
+		Limit(conversationPurgeBatch).
+		Find(&sessions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, session := range sessions {
+		if j.memoryStore != nil {
+			if err := j.memoryStore.DeleteConversationMemories(session.ID); err != nil {
+				logrus.WithError(err).WithField("conversation_id", session.ID).Warn("删除对话记忆失败，稍后重试")
+				continue
+			}
+		}
+		if err := purgeConversation(j.db, session.ID); err != nil {
+			logrus.WithError(err).WithField("conversation_id", session.ID).Warn("彻底删除对话失败")
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeConversation 在一个事务中删除对话及其关联数据，记忆向量由调用方先行删除
+func purgeConversation(db *gorm.DB, conversationID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.MemoryJob{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.MessageFeedback{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ConversationKnowledgeBase{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ConversationParticipant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ConversationTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ConversationShare{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ScheduledPromptRun{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("conversation_id = ?", conversationID).Delete(&models.ScheduledPrompt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("conversation_id = ?", conversationID).Delete(&models.ChatMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", conversationID).Delete(&models.ChatSession{}).Error
+	})
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"go-chat-backend/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMemoryStore 记录被删除记忆的对话和消息
+type fakeMemoryStore struct {
+	MemoryStore
+	deleted         []uuid.UUID
+	deletedMessages []uuid.UUID
+}
+
+func (f *fakeMemoryStore) DeleteConversationMemories(conversationID uuid.UUID) error {
+	f.deleted = append(f.deleted, conversationID)
+	return nil
+}
+
+func (f *fakeMemoryStore) DeleteMessageMemories(messageIDs []uuid.UUID) error {
+	f.deletedMessages = append(f.deletedMessages, messageIDs...)
+	return nil
+}
+
+// TestConversationTrashRestore 测试删除后进入回收站并可恢复
+func TestConversationTrashRestore(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+
+	sessions, _, err := chatService.GetChatSessions(alice.ID, ConversationFilter{}, PageRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	trash, _, err := chatService.GetDeletedChatSessions(alice.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+
+	// 他人无法恢复
+	_, err = chatService.RestoreChatSession(bob.ID, conversation.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	restored, err := chatService.RestoreChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	assert.True(t, restored.IsActive)
+
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "恢复后继续聊天", "user", nil)
+	assert.NoError(t, err)
+}
+
+// TestConversationTrashCursor 测试回收站按删除时间从新到旧游标分页
+func TestConversationTrashCursor(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	var ids []uuid.UUID
+	for i, title := range []string{"A", "B", "C"} {
+		conversation, err := chatService.CreateChatSession(alice.ID, title)
+		require.NoError(t, err)
+		require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+		require.NoError(t, db.Unscoped().Model(&models.ChatSession{}).Where("id = ?", conversation.ID).
+			Update("deleted_at", time.Now().Add(time.Duration(i-3)*time.Hour)).Error)
+		ids = append(ids, conversation.ID)
+	}
+
+	trash, page, err := chatService.GetDeletedChatSessions(alice.ID, PageRequest{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, trash, 2)
+	assert.Equal(t, "C", trash[0].Title)
+	assert.Equal(t, "B", trash[1].Title)
+	assert.True(t, page.HasMore)
+
+	rest, page, err := chatService.GetDeletedChatSessions(alice.ID, PageRequest{Cursor: page.Before, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.Equal(t, ids[0], rest[0].ID)
+	assert.False(t, page.HasMore)
+}
+
+// TestConversationJanitorPurge 测试过期对话被彻底删除，未过期的保留
+func TestConversationJanitorPurge(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	expired, err := chatService.CreateChatSession(alice.ID, "过期")
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, expired.ID, "旧消息", "user", nil)
+	require.NoError(t, err)
+	recent, err := chatService.CreateChatSession(alice.ID, "最近删除")
+	require.NoError(t, err)
+
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, expired.ID))
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, recent.ID))
+	require.NoError(t, db.Unscoped().Model(&models.ChatSession{}).Where("id = ?", expired.ID).
+		Update("deleted_at", time.Now().Add(-chatService.TrashRetention()-time.Hour)).Error)
+
+	store := &fakeMemoryStore{}
+	janitor := NewConversationJanitor(db, store)
+	purged, err := janitor.PurgeExpired()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, []uuid.UUID{expired.ID}, store.deleted)
+
+	var count int64
+	db.Unscoped().Model(&models.ChatMessage{}).Where("conversation_id = ?", expired.ID).Count(&count)
+	assert.Zero(t, count)
+	db.Unscoped().Model(&models.ChatSession{}).Where("id IN ?", []uuid.UUID{expired.ID, recent.ID}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// 过期后不能再恢复
+	_, err = chatService.RestoreChatSession(alice.ID, expired.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+}
+
+// TestTrashedConversationHistory 测试回收站中的对话的消息不出现在历史和增量同步中，对共享对话的其他参与者同样如此；
+// 恢复后重新同步可取回
+func TestTrashedConversationHistory(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "bob", models.ParticipantViewer)
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "秘密", "user", nil)
+	require.NoError(t, err)
+
+	for _, user := range []*models.User{alice, bob} {
+		messages, _, err := chatService.GetChatHistory(user.ID, PageRequest{})
+		require.NoError(t, err)
+		assert.Len(t, messages, 1)
+	}
+	synced, err := chatService.SyncMessages(bob.ID, nil, SyncRequest{})
+	require.NoError(t, err)
+	token := synced.SyncToken
+
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+	for _, user := range []*models.User{alice, bob} {
+		messages, _, err := chatService.GetChatHistory(user.ID, PageRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, messages)
+		synced, err := chatService.SyncMessages(user.ID, nil, SyncRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, synced.Messages)
+	}
+
+	// 对话本身的删除仍由对话同步报告
+	sessions, err := chatService.SyncChatSessions(bob.ID, SyncRequest{})
+	require.NoError(t, err)
+	require.Len(t, sessions.Conversations, 1)
+	assert.False(t, sessions.Conversations[0].IsActive)
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = chatService.RestoreChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	synced, err = chatService.SyncMessages(bob.ID, nil, SyncRequest{Since: token})
+	require.NoError(t, err)
+	require.Len(t, synced.Messages, 1)
+	assert.Equal(t, "秘密", synced.Messages[0].Content)
+}
+
+// TestParticipantOwnerCannotTrash 测试作为 owner 加入的参与者不能删除对话，避免删除后在回收站中看不到也无法恢复
+func TestParticipantOwnerCannotTrash(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "bob", models.ParticipantOwner)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, chatService.DeleteChatSession(bob.ID, conversation.ID), ErrConversationForbidden)
+	_, err = chatService.GetChatSession(bob.ID, conversation.ID)
+	require.NoError(t, err)
+
+	// 创建者删除后只有创建者能在回收站中看到并恢复
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+	trash, _, err := chatService.GetDeletedChatSessions(bob.ID, PageRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, trash)
+	_, err = chatService.RestoreChatSession(bob.ID, conversation.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	trash, _, err = chatService.GetDeletedChatSessions(alice.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	_, err = chatService.RestoreChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+}
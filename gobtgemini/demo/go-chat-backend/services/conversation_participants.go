@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 对话参与者相关错误
+var (
+	ErrParticipantNotFound    = errors.New("参与者不存在")
+	ErrParticipantExists      = errors.New("该用户已是对话参与者")
+	ErrParticipantUser        = errors.New("邀请的用户不存在")
+	ErrInvalidParticipantRole = errors.New("角色只能是 owner、editor 或 viewer")
+	ErrConversationCreator    = errors.New("不能修改或移除对话创建者")
+)
+
+// Participant 对话参与者及其用户名，Creator 为 true 表示对话创建者
+type Participant struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Creator  bool      `json:"creator"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ListParticipants 获取对话的全部参与者，创建者在前，其余按加入时间排序
+func (s *ChatService) ListParticipants(userID, conversationID uuid.UUID) ([]Participant, error) {
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationRead)
+	if err != nil {
+		return nil, err
+	}
+
+	var creator models.User
+	if err := s.db.Select("id, username").Where("id = ?", session.UserID).First(&creator).Error; err != nil {
+		logrus.WithError(err).Error("查询对话创建者失败")
+		return nil, errors.New("获取参与者失败")
+	}
+	participants := []Participant{{
+		UserID:   creator.ID,
+		Username: creator.Username,
+		Role:     models.ParticipantOwner,
+		Creator:  true,
+		JoinedAt: session.CreatedAt,
+	}}
+
+	var rows []struct {
+		UserID    uuid.UUID
+		Username  string
+		Role      string
+		CreatedAt time.Time
+	}
+	err = s.db.Table("conversation_participants AS p").
+		Select("p.user_id, u.username, p.role, p.created_at").
+		Joins("JOIN users AS u ON u.id = p.user_id").
+		Where("p.conversation_id = ?", conversationID).
+		Order("p.created_at ASC").
+		Scan(&rows).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询对话参与者失败")
+		return nil, errors.New("获取参与者失败")
+	}
+	for _, row := range rows {
+		participants = append(participants, Participant{
+			UserID:   row.UserID,
+			Username: row.Username,
+			Role:     row.Role,
+			JoinedAt: row.CreatedAt,
+		})
+	}
+	return participants, nil
+}
+
+// AddParticipant 按用户名邀请用户加入对话，需要管理权限
+func (s *ChatService) AddParticipant(userID, conversationID uuid.UUID, username, role string) (*Participant, error) {
+	if _, ok := participantAccess[role]; !ok {
+		return nil, ErrInvalidParticipantRole
+	}
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationManage)
+	if err != nil {
+		return nil, err
+	}
+
+	var invitee models.User
+	err = s.db.Where("username = ? AND is_active = ?", username, true).First(&invitee).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrParticipantUser
+		}
+		logrus.WithError(err).Error("查询用户失败")
+		return nil, errors.New("邀请参与者失败")
+	}
+	if invitee.ID == session.UserID {
+		return nil, ErrParticipantExists
+	}
+
+	participant := &models.ConversationParticipant{
+		ConversationID: conversationID,
+		UserID:         invitee.ID,
+		Role:           role,
+		InvitedBy:      userID,
+	}
+	result := s.db.Where("conversation_id = ? AND user_id = ?", conversationID, invitee.ID).FirstOrCreate(participant)
+	if result.Error != nil {
+		logrus.WithError(result.Error).Error("添加参与者失败")
+		return nil, errors.New("邀请参与者失败")
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrParticipantExists
+	}
+
+	return &Participant{
+		UserID:   invitee.ID,
+		Username: invitee.Username,
+		Role:     role,
+		JoinedAt: participant.CreatedAt,
+	}, nil
+}
+
+// UpdateParticipantRole 修改参与者的角色，需要管理权限；创建者的角色不能修改
+func (s *ChatService) UpdateParticipantRole(userID, conversationID, participantID uuid.UUID, role string) error {
+	if _, ok := participantAccess[role]; !ok {
+		return ErrInvalidParticipantRole
+	}
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationManage)
+	if err != nil {
+		return err
+	}
+	if participantID == session.UserID {
+		return ErrConversationCreator
+	}
+
+	result := s.db.Model(&models.ConversationParticipant{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, participantID).
+		Update("role", role)
+	if result.Error != nil {
+		logrus.WithError(result.Error).Error("修改参与者角色失败")
+		return errors.New("修改参与者角色失败")
+	}
+	if result.RowsAffected == 0 {
+		return ErrParticipantNotFound
+	}
+	return nil
+}
+
+// RemoveParticipant 将参与者移出对话；参与者可以自己退出，移除他人需要管理权限，创建者不能被移除
+func (s *ChatService) RemoveParticipant(userID, conversationID, participantID uuid.UUID) error {
+	access := ConversationManage
+	if participantID == userID {
+		access = ConversationRead
+	}
+	session, err := s.AuthorizeConversation(userID, conversationID, access)
+	if err != nil {
+		return err
+	}
+	if participantID == session.UserID {
+		return ErrConversationCreator
+	}
+
+	result := s.db.Where("conversation_id = ? AND user_id = ?", conversationID, participantID).Delete(&models.ConversationParticipant{})
+	if result.Error != nil {
+		logrus.WithError(result.Error).Error("移除参与者失败")
+		return errors.New("移除参与者失败")
+	}
+	if result.RowsAffected == 0 {
+		return ErrParticipantNotFound
+	}
+	return nil
+}
+
+// ConversationMemberIDs 对话创建者和全部参与者的用户ID，用于实时推送
+func (s *ChatService) ConversationMemberIDs(conversationID uuid.UUID) ([]uuid.UUID, error) {
+	var session models.ChatSession
+	if err := s.db.Select("id, user_id").Where("id = ?", conversationID).First(&session).Error; err != nil {
+		return nil, err
+	}
+
+	var participantIDs []uuid.UUID
+	err := s.db.Model(&models.ConversationParticipant{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("user_id", &participantIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return append([]uuid.UUID{session.UserID}, participantIDs...), nil
+}
+
+// loadParticipantState 为对话列表填充当前用户的角色，共享对话的置顶、归档和文件夹替换为当前用户自己的
+func loadParticipantState(db *gorm.DB, userID uuid.UUID, sessions []models.ChatSession) error {
+	var shared []uuid.UUID
+	for i := range sessions {
+		if sessions[i].UserID == userID {
+			sessions[i].Role = models.ParticipantOwner
+		} else {
+			shared = append(shared, sessions[i].ID)
+		}
+	}
+	if len(shared) == 0 {
+		return nil
+	}
+
+	var participants []models.ConversationParticipant
+	err := db.Where("user_id = ? AND conversation_id IN ?", userID, shared).Find(&participants).Error
+	if err != nil {
+		return err
+	}
+	byConversation := make(map[uuid.UUID]models.ConversationParticipant, len(participants))
+	for _, participant := range participants {
+		byConversation[participant.ConversationID] = participant
+	}
+	for i := range sessions {
+		if participant, ok := byConversation[sessions[i].ID]; ok {
+			applyParticipantState(&sessions[i], participant)
+		}
+	}
+	return nil
+}
+
+// applyParticipantState 用参与者自己的角色和整理状态替换对话上创建者的
+func applyParticipantState(session *models.ChatSession, participant models.ConversationParticipant) {
+	session.Role = participant.Role
+	session.Pinned = participant.Pinned
+	session.Archived = participant.Archived
+	session.FolderID = participant.FolderID
+}
+
+// personalConversations 按当前用户自己的置顶、归档和文件夹过滤可见对话的ID子查询。
+// 创建者的状态在 chat_sessions 上，参与者的在 conversation_participants 上，两张表的列名相同
+func personalConversations(db *gorm.DB, userID uuid.UUID, scope func(*gorm.DB) *gorm.DB) *gorm.DB {
+	owned := scope(db.Model(&models.ChatSession{}).Unscoped().Select("id").Where("user_id = ?", userID))
+	shared := scope(db.Model(&models.ConversationParticipant{}).Select("conversation_id").Where("user_id = ?", userID))
+	return db.Model(&models.ChatSession{}).Unscoped().Select("id").
+		Where("id IN (?)", owned).
+		Or("id IN (?)", shared)
+}
+
+// updatePersonalState 修改当前用户对这些对话的置顶、归档或文件夹，不影响其他参与者。
+// 同时更新对话的 updated_at，让当前用户的增量同步能获取到变化
+func updatePersonalState(tx *gorm.DB, userID uuid.UUID, conversationIDs []uuid.UUID, updates map[string]interface{}) error {
+	now := time.Now()
+	values := make(map[string]interface{}, len(updates)+1)
+	for key, value := range updates {
+		values[key] = value
+	}
+	values["updated_at"] = now
+
+	err := tx.Model(&models.ChatSession{}).
+		Where("id IN ? AND user_id = ?", conversationIDs, userID).
+		Updates(values).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&models.ConversationParticipant{}).
+		Where("conversation_id IN ? AND user_id = ?", conversationIDs, userID).
+		Updates(values).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.ChatSession{}).
+		Where("id IN ? AND user_id <> ?", conversationIDs, userID).
+		Update("updated_at", now).Error
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCronNext 测试 cron 表达式在不同时区、日与星期组合及夏令时切换时的触发时间
+func TestCronNext(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		expr     string
+		timezone string
+		after    time.Time
+		want     time.Time
+	}{
+		{
+			name:     "工作日9点，周五之后是周一",
+			expr:     "0 9 * * MON-FRI",
+			timezone: "Asia/Shanghai",
+			after:    time.Date(2024, 1, 19, 9, 0, 0, 0, shanghai), // 周五
+			want:     time.Date(2024, 1, 22, 9, 0, 0, 0, shanghai),
+		},
+		{
+			name:     "按时区换算",
+			expr:     "30 8 * * *",
+			timezone: "Asia/Shanghai",
+			after:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), // 上海 08:00
+			want:     time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			name:  "步长和列表",
+			expr:  "*/15 9,18 * * *",
+			after: time.Date(2024, 1, 1, 9, 50, 0, 0, time.UTC),
+			want:  time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "日和星期都有限制时满足其一",
+			expr:  "0 0 13 * 5",
+			after: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC), // 周五早于13号
+		},
+		{
+			name:  "跳过没有31号的月份",
+			expr:  "@monthly",
+			after: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+			want:  time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "2月29日",
+			expr:  "0 0 29 2 *",
+			after: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			want:  time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "夏令时开始当天不存在的2:30顺延到下一天",
+			expr:     "30 2 * * *",
+			timezone: "America/New_York",
+			after:    time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			want:     time.Date(2024, 3, 11, 2, 30, 0, 0, newYork),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule, err := ParseCron(tc.expr, tc.timezone)
+			require.NoError(t, err)
+			assert.True(t, tc.want.Equal(schedule.Next(tc.after)), "got %s", schedule.Next(tc.after))
+		})
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "0 9 * * MON-", "0 9 32 * *", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := ParseCron(expr, "")
+		assert.ErrorIs(t, err, ErrInvalidCron, expr)
+	}
+	_, err = ParseCron("0 9 * * *", "Mars/Olympus")
+	assert.ErrorIs(t, err, ErrInvalidCron)
+}
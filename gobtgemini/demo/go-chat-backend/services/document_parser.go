@@ -0,0 +1,521 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// 支持的文档格式
+const (
+	DocumentFormatMarkdown = "markdown"
+	DocumentFormatText     = "text"
+	DocumentFormatHTML     = "html"
+	DocumentFormatPDF      = "pdf"
+)
+
+// DocumentSection 文档解析后的一个片段，Location 用于引用（标题路径或页码）
+type DocumentSection struct {
+	Location string
+	Text     string
+}
+
+// TextChunk 分块结果，偏移量以字符(rune)计，相对于整篇文档
+type TextChunk struct {
+	Index       int
+	Location    string
+	StartOffset int
+	EndOffset   int
+	Content     string
+}
+
+// DetectDocumentFormat 根据文件扩展名判断文档格式，不支持时返回空字符串
+func DetectDocumentFormat(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".md", ".markdown":
+		return DocumentFormatMarkdown
+	case ".txt", ".text":
+		return DocumentFormatText
+	case ".html", ".htm":
+		return DocumentFormatHTML
+	case ".pdf":
+		return DocumentFormatPDF
+	default:
+		return ""
+	}
+}
+
+// ParseDocument 将原始文件内容解析为带位置信息的文本片段
+func ParseDocument(format string, data []byte) ([]DocumentSection, error) {
+	var sections []DocumentSection
+	var err error
+
+	switch format {
+	case DocumentFormatMarkdown:
+		sections = parseMarkdown(string(data))
+	case DocumentFormatText:
+		sections = []DocumentSection{{Text: string(data)}}
+	case DocumentFormatHTML:
+		sections, err = parseHTML(data)
+	case DocumentFormatPDF:
+		sections, err = parsePDF(data)
+	default:
+		return nil, fmt.Errorf("不支持的文档格式: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 过滤空片段
+	result := make([]DocumentSection, 0, len(sections))
+	for _, section := range sections {
+		if !utf8.ValidString(section.Text) {
+			section.Text = strings.ToValidUTF8(section.Text, "")
+		}
+		if strings.TrimSpace(section.Text) != "" {
+			result = append(result, section)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("文档中没有可提取的文本内容")
+	}
+
+	return result, nil
+}
+
+// parseMarkdown 按标题切分 Markdown，Location 为标题路径，如 "安装 > Docker"
+func parseMarkdown(content string) []DocumentSection {
+	var sections []DocumentSection
+	var headings [6]string
+	var current strings.Builder
+	location := ""
+	inCodeBlock := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			sections = append(sections, DocumentSection{Location: location, Text: current.String()})
+			current.Reset()
+		}
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inCodeBlock = !inCodeBlock
+		}
+
+		if !inCodeBlock && strings.HasPrefix(trimmed, "#") {
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			title := strings.TrimSpace(trimmed[level:])
+			if level <= len(headings) && title != "" && strings.HasPrefix(trimmed[level:], " ") {
+				flush()
+				headings[level-1] = title
+				for i := level; i < len(headings); i++ {
+					headings[i] = ""
+				}
+				path := make([]string, 0, level)
+				for _, h := range headings[:level] {
+					if h != "" {
+						path = append(path, h)
+					}
+				}
+				location = strings.Join(path, " > ")
+			}
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush()
+
+	return sections
+}
+
+// parseHTML 提取HTML正文，按 h1-h6 标题切分
+func parseHTML(data []byte) ([]DocumentSection, error) {
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+
+	var sections []DocumentSection
+	var current strings.Builder
+	var location string
+	skipDepth := 0
+	inHeading := false
+	var heading strings.Builder
+
+	flush := func() {
+		if strings.TrimSpace(current.String()) != "" {
+			sections = append(sections, DocumentSection{Location: location, Text: current.String()})
+		}
+		current.Reset()
+	}
+
+	for {
+		tokenType := tokenizer.Next()
+		switch tokenType {
+		case html.ErrorToken:
+			if err := tokenizer.Err(); err != nil && err != io.EOF {
+				return nil, fmt.Errorf("解析HTML失败: %w", err)
+			}
+			flush()
+			return sections, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "noscript", "head":
+				if tokenType == html.StartTagToken {
+					skipDepth++
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				flush()
+				inHeading = true
+				heading.Reset()
+			case "br", "p", "div", "li", "tr", "section", "article", "pre", "blockquote":
+				current.WriteString("\n")
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch tag {
+			case "script", "style", "noscript", "head":
+				if skipDepth > 0 {
+					skipDepth--
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				inHeading = false
+				location = strings.TrimSpace(heading.String())
+				current.WriteString(location)
+				current.WriteString("\n")
+			case "p", "div", "li", "tr", "section", "article", "pre", "blockquote":
+				current.WriteString("\n")
+			}
+
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			text := string(tokenizer.Text())
+			if inHeading {
+				heading.WriteString(text)
+				continue
+			}
+			current.WriteString(text)
+		}
+	}
+}
+
+var (
+	pdfStreamPattern = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfEndStream     = []byte("endstream")
+)
+
+// ErrPDFTooLarge PDF 内容流解压后超过大小限制
+var ErrPDFTooLarge = errors.New("PDF 解压后的内容超过大小限制")
+
+// PDF 解压上限：单个内容流和整个文档，防止压缩炸弹耗尽内存
+var (
+	pdfMaxStreamBytes   int64 = 16 << 20
+	pdfMaxInflatedBytes int64 = 64 << 20
+)
+
+// parsePDF 从PDF内容流中提取文本，每个包含文本的内容流视为一页。
+// 只处理未压缩或 FlateDecode 压缩、使用标准编码的文本，扫描件和CID字体无法提取。
+func parsePDF(data []byte) ([]DocumentSection, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("%PDF")) {
+		return nil, errors.New("文件不是有效的PDF")
+	}
+
+	var sections []DocumentSection
+	page := 0
+	remaining := pdfMaxInflatedBytes
+
+	for _, loc := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], pdfEndStream)
+		if end < 0 {
+			break
+		}
+		raw := data[start : start+end]
+
+		// 跳过图片、字体等非内容流
+		if bytes.Contains(dict, []byte("/Subtype")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+
+		content := raw
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			limit := pdfMaxStreamBytes
+			if remaining < limit {
+				limit = remaining
+			}
+			content, err = io.ReadAll(io.LimitReader(reader, limit+1))
+			reader.Close()
+			if int64(len(content)) > limit {
+				return nil, ErrPDFTooLarge
+			}
+			remaining -= int64(len(content))
+			if err != nil && len(content) == 0 {
+				continue
+			}
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+
+		text := extractPDFText(content)
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		page++
+		sections = append(sections, DocumentSection{
+			Location: fmt.Sprintf("第%d页", page),
+			Text:     text,
+		})
+	}
+
+	if len(sections) == 0 {
+		return nil, errors.New("无法从PDF中提取文本（可能是扫描件或使用了不支持的字体编码）")
+	}
+
+	return sections, nil
+}
+
+// extractPDFText 解析内容流中的文本操作符(Tj, TJ, ', ")
+func extractPDFText(content []byte) string {
+	var out strings.Builder
+	var pending strings.Builder
+	inText := false
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '(':
+			str, next := readPDFLiteral(content, i)
+			pending.WriteString(str)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			str, next := readPDFHex(content, i)
+			pending.WriteString(str)
+			i = next
+		case isPDFOperatorStart(c):
+			j := i
+			for j < len(content) && isPDFOperatorChar(content[j]) {
+				j++
+			}
+			op := string(content[i:j])
+			switch op {
+			case "BT":
+				inText = true
+				pending.Reset()
+			case "ET":
+				inText = false
+				out.WriteString("\n")
+				pending.Reset()
+			case "Tj", "TJ":
+				if inText {
+					out.WriteString(pending.String())
+				}
+				pending.Reset()
+			case "'", "\"":
+				if inText {
+					out.WriteString("\n")
+					out.WriteString(pending.String())
+				}
+				pending.Reset()
+			case "Td", "TD", "T*":
+				if inText {
+					out.WriteString("\n")
+				}
+				pending.Reset()
+			default:
+				pending.Reset()
+			}
+			i = j - 1
+		}
+	}
+
+	// 合并多余空行
+	lines := strings.Split(out.String(), "\n")
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			result = append(result, strings.TrimRight(line, " "))
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+func isPDFOperatorStart(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '\'' || c == '"'
+}
+
+func isPDFOperatorChar(c byte) bool {
+	return isPDFOperatorStart(c) || c == '*'
+}
+
+// readPDFLiteral 读取 (...) 字符串，返回内容和结束位置
+func readPDFLiteral(content []byte, start int) (string, int) {
+	var sb strings.Builder
+	depth := 0
+	for i := start; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			if i+1 >= len(content) {
+				return sb.String(), i
+			}
+			i++
+			switch e := content[i]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					val := 0
+					k := 0
+					for ; k < 3 && i+k < len(content) && content[i+k] >= '0' && content[i+k] <= '7'; k++ {
+						val = val*8 + int(content[i+k]-'0')
+					}
+					i += k - 1
+					sb.WriteRune(rune(val))
+				} else {
+					sb.WriteByte(e)
+				}
+			}
+		case '(':
+			if depth > 0 {
+				sb.WriteByte(c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return sb.String(), i
+			}
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), len(content)
+}
+
+// readPDFHex 读取 <...> 十六进制字符串，双字节内容按 UTF-16BE 解码
+func readPDFHex(content []byte, start int) (string, int) {
+	end := bytes.IndexByte(content[start:], '>')
+	if end < 0 {
+		return "", len(content)
+	}
+	hexStr := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" \r\n\t", r) {
+			return -1
+		}
+		return r
+	}, string(content[start+1:start+end]))
+	if len(hexStr)%2 == 1 {
+		hexStr += "0"
+	}
+
+	raw := make([]byte, 0, len(hexStr)/2)
+	for i := 0; i+1 < len(hexStr); i += 2 {
+		var b byte
+		if _, err := fmt.Sscanf(hexStr[i:i+2], "%02x", &b); err != nil {
+			return "", start + end
+		}
+		raw = append(raw, b)
+	}
+
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		var sb strings.Builder
+		for i := 2; i+1 < len(raw); i += 2 {
+			sb.WriteRune(rune(raw[i])<<8 | rune(raw[i+1]))
+		}
+		return sb.String(), start + end
+	}
+	return string(raw), start + end
+}
+
+// ChunkSections 将文档片段切分为带重叠的文本块。
+// 尽量在段落或句子边界处断开，块大小与重叠量均以字符计。
+func ChunkSections(sections []DocumentSection, chunkSize, overlap int) []TextChunk {
+	if chunkSize <= 0 {
+		chunkSize = 800
+	}
+	if overlap < 0 || overlap >= chunkSize {
+		overlap = chunkSize / 5
+	}
+
+	var chunks []TextChunk
+	offset := 0
+
+	for _, section := range sections {
+		runes := []rune(section.Text)
+		start := 0
+		for start < len(runes) {
+			end := start + chunkSize
+			if end >= len(runes) {
+				end = len(runes)
+			} else if boundary := findChunkBoundary(runes, start+chunkSize/2, end); boundary > 0 {
+				end = boundary
+			}
+
+			content := strings.TrimSpace(string(runes[start:end]))
+			if content != "" {
+				chunks = append(chunks, TextChunk{
+					Index:       len(chunks),
+					Location:    section.Location,
+					StartOffset: offset + start,
+					EndOffset:   offset + end,
+					Content:     content,
+				})
+			}
+
+			if end >= len(runes) {
+				break
+			}
+			next := end - overlap
+			if next <= start {
+				next = end
+			}
+			start = next
+		}
+		offset += len(runes)
+	}
+
+	return chunks
+}
+
+// findChunkBoundary 在 [from, to) 内从后往前查找段落或句子结尾，返回断点（不含）位置
+func findChunkBoundary(runes []rune, from, to int) int {
+	for i := to - 1; i >= from; i-- {
+		if runes[i] == '\n' && i > 0 && runes[i-1] == '\n' {
+			return i + 1
+		}
+	}
+	for i := to - 1; i >= from; i-- {
+		switch runes[i] {
+		case '\n', '。', '！', '？', '；', '.', '!', '?', ';':
+			return i + 1
+		}
+	}
+	return 0
+}
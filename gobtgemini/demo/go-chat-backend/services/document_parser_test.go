@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDetectDocumentFormat 测试根据扩展名识别文档格式
+func TestDetectDocumentFormat(t *testing.T) {
+	assert.Equal(t, DocumentFormatMarkdown, DetectDocumentFormat("README.md"))
+	assert.Equal(t, DocumentFormatText, DetectDocumentFormat("notes.TXT"))
+	assert.Equal(t, DocumentFormatHTML, DetectDocumentFormat("page.htm"))
+	assert.Equal(t, DocumentFormatPDF, DetectDocumentFormat("manual.pdf"))
+	assert.Equal(t, "", DetectDocumentFormat("image.png"))
+}
+
+// TestParseMarkdownLocations 测试Markdown按标题路径切分
+func TestParseMarkdownLocations(t *testing.T) {
+	content := "简介段落\n# 安装\n说明\n## Docker\n运行容器\n```\n# 这是注释不是标题\n```\n# 使用\n开始聊天\n"
+
+	sections, err := ParseDocument(DocumentFormatMarkdown, []byte(content))
+	require.NoError(t, err)
+	require.Len(t, sections, 4)
+
+	assert.Equal(t, "", sections[0].Location)
+	assert.Equal(t, "安装", sections[1].Location)
+	assert.Equal(t, "安装 > Docker", sections[2].Location)
+	assert.Contains(t, sections[2].Text, "# 这是注释不是标题")
+	assert.Equal(t, "使用", sections[3].Location)
+}
+
+// TestParseHTML 测试HTML正文提取
+func TestParseHTML(t *testing.T) {
+	content := `<html><head><title>忽略</title><style>p{}</style></head>
+<body><h1>概述</h1><p>第一段&amp;内容</p><script>alert(1)</script><h2>细节</h2><p>第二段</p></body></html>`
+
+	sections, err := ParseDocument(DocumentFormatHTML, []byte(content))
+	require.NoError(t, err)
+	require.Len(t, sections, 2)
+
+	assert.Equal(t, "概述", sections[0].Location)
+	assert.Contains(t, sections[0].Text, "第一段&内容")
+	assert.NotContains(t, sections[0].Text, "alert")
+	assert.NotContains(t, sections[0].Text, "忽略")
+	assert.Equal(t, "细节", sections[1].Location)
+}
+
+// TestParsePDF 测试从压缩内容流中提取文本
+func TestParsePDF(t *testing.T) {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write([]byte("BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\)) Tj 0 -14 Td [(Wor) -20 (ld)] TJ ET"))
+	w.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n4 0 obj\n")
+	pdf.WriteString(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len()))
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+
+	sections, err := ParseDocument(DocumentFormatPDF, pdf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, sections, 1)
+	assert.Equal(t, "第1页", sections[0].Location)
+	assert.Equal(t, "Hello (PDF)\nWorld", sections[0].Text)
+
+	_, err = ParseDocument(DocumentFormatPDF, []byte("not a pdf"))
+	assert.Error(t, err)
+}
+
+// TestParsePDFInflateLimit 测试单个内容流或整个文档解压后超过上限时拒绝解析
+func TestParsePDFInflateLimit(t *testing.T) {
+	stream := func(content string) []byte {
+		var compressed bytes.Buffer
+		w := zlib.NewWriter(&compressed)
+		w.Write([]byte(content))
+		w.Close()
+
+		var out bytes.Buffer
+		out.WriteString(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len()))
+		out.Write(compressed.Bytes())
+		out.WriteString("\nendstream\n")
+		return out.Bytes()
+	}
+	page := "BT (" + strings.Repeat("a", 600) + ") Tj ET"
+
+	originalStream, originalTotal := pdfMaxStreamBytes, pdfMaxInflatedBytes
+	t.Cleanup(func() { pdfMaxStreamBytes, pdfMaxInflatedBytes = originalStream, originalTotal })
+	pdfMaxStreamBytes, pdfMaxInflatedBytes = 1024, 1500
+
+	pdf := append([]byte("%PDF-1.4\n"), stream(page)...)
+	sections, err := ParseDocument(DocumentFormatPDF, pdf)
+	require.NoError(t, err)
+	assert.Len(t, sections, 1)
+
+	_, err = ParseDocument(DocumentFormatPDF, append([]byte("%PDF-1.4\n"), stream(strings.Repeat(page, 2))...))
+	assert.ErrorIs(t, err, ErrPDFTooLarge)
+
+	pdf = append(pdf, stream(page)...)
+	pdf = append(pdf, stream(page)...)
+	_, err = ParseDocument(DocumentFormatPDF, pdf)
+	assert.ErrorIs(t, err, ErrPDFTooLarge)
+}
+
+// TestChunkSectionsOverlap 测试分块大小、重叠和偏移量
+func TestChunkSectionsOverlap(t *testing.T) {
+	sentence := "这是一个测试句子。"
+	text := strings.Repeat(sentence, 30) // 270 个字符
+	sections := []DocumentSection{{Location: "第1页", Text: text}}
+
+	chunks := ChunkSections(sections, 100, 20)
+	require.True(t, len(chunks) > 2)
+
+	for i, chunk := range chunks {
+		assert.Equal(t, i, chunk.Index)
+		assert.Equal(t, "第1页", chunk.Location)
+		assert.LessOrEqual(t, len([]rune(chunk.Content)), 100)
+		// 在句号处断开
+		assert.True(t, strings.HasSuffix(chunk.Content, "。"))
+		if i > 0 {
+			// 相邻分块存在重叠
+			assert.Less(t, chunk.StartOffset, chunks[i-1].EndOffset)
+		}
+	}
+	assert.Equal(t, len([]rune(text)), chunks[len(chunks)-1].EndOffset)
+}
+
+// TestChunkSectionsOffsetsAcrossSections 测试多片段的全局偏移量
+func TestChunkSectionsOffsetsAcrossSections(t *testing.T) {
+	sections := []DocumentSection{
+		{Location: "A", Text: "第一节内容"},
+		{Location: "B", Text: "第二节内容"},
+	}
+
+	chunks := ChunkSections(sections, 100, 10)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, 0, chunks[0].StartOffset)
+	assert.Equal(t, 5, chunks[1].StartOffset)
+	assert.Equal(t, "B", chunks[1].Location)
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"sync"
+)
+
+// embeddingCacheKey 缓存键：模型名与文本内容的 SHA-256，更换模型后旧缓存自然失效
+func embeddingCacheKey(model, text string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// encodeEmbedding 将向量编码为 float32 小端字节序，节省缓存表空间
+func encodeEmbedding(embedding []float64) []byte {
+	buf := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	return buf
+}
+
+// decodeEmbedding 解码 encodeEmbedding 的结果
+func decodeEmbedding(buf []byte) []float64 {
+	embedding := make([]float64, len(buf)/4)
+	for i := range embedding {
+		embedding[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:])))
+	}
+	return embedding
+}
+
+// embeddingLRU 并发安全的定长 LRU 缓存
+type embeddingLRU struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type embeddingLRUEntry struct {
+	key       string
+	embedding []float64
+}
+
+func newEmbeddingLRU(capacity int) *embeddingLRU {
+	return &embeddingLRU{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// Get 读取并标记为最近使用
+func (c *embeddingLRU) Get(key string) ([]float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*embeddingLRUEntry).embedding, true
+}
+
+// Add 写入缓存，超出容量时淘汰最久未使用的条目
+func (c *embeddingLRU) Add(key string, embedding []float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		elem.Value.(*embeddingLRUEntry).embedding = embedding
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&embeddingLRUEntry{key: key, embedding: embedding})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*embeddingLRUEntry).key)
+	}
+}
+
+// Len 当前缓存条目数
+func (c *embeddingLRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
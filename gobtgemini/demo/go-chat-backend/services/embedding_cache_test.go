@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEmbeddingLRUEviction 测试超出容量时淘汰最久未使用的条目
+func TestEmbeddingLRUEviction(t *testing.T) {
+	cache := newEmbeddingLRU(2)
+	cache.Add("a", []float64{1})
+	cache.Add("b", []float64{2})
+
+	_, ok := cache.Get("a") // a 变为最近使用
+	assert.True(t, ok)
+
+	cache.Add("c", []float64{3})
+	assert.Equal(t, 2, cache.Len())
+
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+}
+
+// TestEmbeddingCacheKey 测试缓存键包含模型名
+func TestEmbeddingCacheKey(t *testing.T) {
+	assert.Equal(t, embeddingCacheKey("bge-m3", "你好"), embeddingCacheKey("bge-m3", "你好"))
+	assert.NotEqual(t, embeddingCacheKey("bge-m3", "你好"), embeddingCacheKey("bge-large-zh", "你好"))
+	assert.Len(t, embeddingCacheKey("bge-m3", "你好"), 64)
+}
+
+// TestEncodeEmbedding 测试向量编码往返
+func TestEncodeEmbedding(t *testing.T) {
+	embedding := []float64{0.5, -0.25, 1}
+	assert.Equal(t, embedding, decodeEmbedding(encodeEmbedding(embedding)))
+}
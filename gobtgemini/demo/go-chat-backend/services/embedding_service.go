@@ -0,0 +1,278 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmbeddingService 文本向量化服务（TEI /embed 接口），Chroma 与 pgvector 记忆库、知识库共用。
+// 向量按 模型名+内容哈希 缓存：进程内 LRU，可选 Postgres 表作为二级缓存，多实例和重启后共享。
+type EmbeddingService struct {
+	url        string
+	httpClient *http.Client
+
+	// model 为空时启动后通过 TEI /info 获取，获取失败则不使用缓存
+	model     string
+	modelOnce sync.Once
+
+	cache *embeddingLRU
+	db    *gorm.DB
+
+	hits   atomic.Int64
+	dbHits atomic.Int64
+	misses atomic.Int64
+}
+
+// EmbeddingCacheStats 向量缓存统计
+type EmbeddingCacheStats struct {
+	Model     string  `json:"model"`
+	Size      int     `json:"size"`
+	Capacity  int     `json:"capacity"`
+	DBEnabled bool    `json:"db_enabled"`
+	Hits      int64   `json:"hits"`
+	DBHits    int64   `json:"db_hits"`
+	Misses    int64   `json:"misses"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// NewEmbeddingService 创建向量化服务，db 为空或未开启 EMBEDDING_CACHE_DB 时只使用进程内缓存
+func NewEmbeddingService(db *gorm.DB) *EmbeddingService {
+	cfg := config.Get()
+
+	s := &EmbeddingService{
+		url:   cfg.EmbeddingServiceURL,
+		model: cfg.EmbeddingModel,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+	if cfg.EmbeddingCacheSize > 0 {
+		s.cache = newEmbeddingLRU(cfg.EmbeddingCacheSize)
+	}
+	if cfg.EmbeddingCacheDB {
+		s.db = db
+	}
+	return s
+}
+
+// CreateEmbedding 为给定的文本创建向量
+func (s *EmbeddingService) CreateEmbedding(text string) ([]float64, error) {
+	embeddings, err := s.CreateEmbeddings([]string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// CreateEmbeddings 批量创建向量，返回顺序与输入一致；命中缓存的文本不再请求 embedding 服务
+func (s *EmbeddingService) CreateEmbeddings(texts []string) ([][]float64, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+
+	model := s.modelName()
+	if model == "" || (s.cache == nil && s.db == nil) {
+		return s.fetchEmbeddings(texts)
+	}
+
+	results := make([][]float64, len(texts))
+	keys := make([]string, len(texts))
+	missing := make(map[string][]int) // key -> 在 texts 中的位置，相同文本只请求一次
+
+	for i, text := range texts {
+		keys[i] = embeddingCacheKey(model, text)
+		if s.cache != nil {
+			if embedding, ok := s.cache.Get(keys[i]); ok {
+				results[i] = embedding
+				s.hits.Add(1)
+				continue
+			}
+		}
+		missing[keys[i]] = append(missing[keys[i]], i)
+	}
+
+	if len(missing) > 0 && s.db != nil {
+		for key, embedding := range s.loadFromDB(missing) {
+			for _, i := range missing[key] {
+				results[i] = embedding
+			}
+			s.dbHits.Add(int64(len(missing[key])))
+			if s.cache != nil {
+				s.cache.Add(key, embedding)
+			}
+			delete(missing, key)
+		}
+	}
+
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	fetchKeys := make([]string, 0, len(missing))
+	fetchTexts := make([]string, 0, len(missing))
+	for key, positions := range missing {
+		fetchKeys = append(fetchKeys, key)
+		fetchTexts = append(fetchTexts, texts[positions[0]])
+		s.misses.Add(int64(len(positions)))
+	}
+
+	embeddings, err := s.fetchEmbeddings(fetchTexts)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range fetchKeys {
+		for _, pos := range missing[key] {
+			results[pos] = embeddings[i]
+		}
+		if s.cache != nil {
+			s.cache.Add(key, embeddings[i])
+		}
+	}
+	if s.db != nil {
+		s.saveToDB(model, fetchKeys, embeddings)
+	}
+
+	return results, nil
+}
+
+// Stats 获取缓存命中统计
+func (s *EmbeddingService) Stats() EmbeddingCacheStats {
+	stats := EmbeddingCacheStats{
+		Model:     s.modelName(),
+		DBEnabled: s.db != nil,
+		Hits:      s.hits.Load(),
+		DBHits:    s.dbHits.Load(),
+		Misses:    s.misses.Load(),
+	}
+	if s.cache != nil {
+		stats.Size = s.cache.Len()
+		stats.Capacity = s.cache.capacity
+	}
+	if total := stats.Hits + stats.DBHits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits+stats.DBHits) / float64(total)
+	}
+	return stats
+}
+
+// modelName 缓存键中的模型名，未配置时从 TEI /info 接口获取
+func (s *EmbeddingService) modelName() string {
+	s.modelOnce.Do(func() {
+		if s.model != "" {
+			return
+		}
+		infoURL := strings.TrimSuffix(s.url, "/embed") + "/info"
+		resp, err := s.httpClient.Get(infoURL)
+		if err != nil {
+			logrus.WithError(err).Warn("获取 embedding 模型信息失败，向量缓存已禁用")
+			return
+		}
+		defer resp.Body.Close()
+
+		var info struct {
+			ModelID string `json:"model_id"`
+		}
+		if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&info) != nil || info.ModelID == "" {
+			logrus.Warn("无法识别 embedding 模型，向量缓存已禁用，可通过 EMBEDDING_MODEL 指定")
+			return
+		}
+		s.model = info.ModelID
+		logrus.Info("embedding 模型: ", s.model)
+	})
+	return s.model
+}
+
+// loadFromDB 从 Postgres 缓存表批量读取
+func (s *EmbeddingService) loadFromDB(missing map[string][]int) map[string][]float64 {
+	keys := make([]string, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+
+	var entries []models.EmbeddingCacheEntry
+	if err := s.db.Where("key IN ?", keys).Find(&entries).Error; err != nil {
+		logrus.WithError(err).Warn("读取向量缓存失败")
+		return nil
+	}
+
+	found := make(map[string][]float64, len(entries))
+	for _, entry := range entries {
+		found[entry.Key] = decodeEmbedding(entry.Vector)
+	}
+	return found
+}
+
+// saveToDB 写入 Postgres 缓存表，失败只记录日志
+func (s *EmbeddingService) saveToDB(model string, keys []string, embeddings [][]float64) {
+	entries := make([]models.EmbeddingCacheEntry, 0, len(keys))
+	for i, key := range keys {
+		entries = append(entries, models.EmbeddingCacheEntry{
+			Key:        key,
+			Model:      model,
+			Dimensions: len(embeddings[i]),
+			Vector:     encodeEmbedding(embeddings[i]),
+		})
+	}
+
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entries).Error
+	if err != nil {
+		logrus.WithError(err).Warn("写入向量缓存失败")
+	}
+}
+
+// fetchEmbeddings 请求 embedding 服务
+func (s *EmbeddingService) fetchEmbeddings(texts []string) ([][]float64, error) {
+	requestBody := map[string]interface{}{
+		"inputs":    texts,
+		"normalize": true, // 推荐开启，使向量长度归一化
+		"truncate":  true, // 自动截断超长文本
+	}
+	jsonData, _ := json.Marshal(requestBody)
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建 embedding 请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 embedding 服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// 可以添加更多日志来读取 resp.Body 的错误信息
+		return nil, fmt.Errorf("embedding 服务返回错误状态码: %d", resp.StatusCode)
+	}
+
+	// ⭐ 修改点 3：响应体是一个直接的二维数组
+	var embeddings [][]float64
+	if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+		return nil, fmt.Errorf("解析 embedding 响应失败: %w", err)
+	}
+
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding 服务返回的向量数量不匹配: 期望 %d, 实际 %d", len(texts), len(embeddings))
+	}
+	for _, embedding := range embeddings {
+		if len(embedding) == 0 {
+			return nil, errors.New("从 embedding 服务收到的向量为空")
+		}
+	}
+
+	return embeddings, nil
+}
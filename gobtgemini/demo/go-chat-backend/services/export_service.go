@@ -0,0 +1,404 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat-backend/models"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 导出格式
+const (
+	ExportFormatJSON     = "json"
+	ExportFormatMarkdown = "markdown"
+	ExportFormatHTML     = "html"
+)
+
+// ExportSchema 导出 JSON 的格式标识和版本，字段有不兼容的变化时递增版本号
+const (
+	ExportSchema        = "go-chat-backend.conversation"
+	ExportSchemaVersion = 1
+)
+
+// ErrUnsupportedExportFormat 不支持的导出格式
+var ErrUnsupportedExportFormat = errors.New("不支持的导出格式，仅支持 json、markdown 和 html")
+
+// ConversationExport 单个对话的导出文件（JSON 格式，可重新导入）
+type ConversationExport struct {
+	Schema       string               `json:"schema"`
+	Version      int                  `json:"version"`
+	ExportedAt   time.Time            `json:"exported_at"`
+	Conversation ExportedConversation `json:"conversation"`
+}
+
+// ExportedConversation 导出的对话
+type ExportedConversation struct {
+	ID             uuid.UUID                  `json:"id"`
+	Title          string                     `json:"title"`
+	Description    string                     `json:"description,omitempty"`
+	Pinned         bool                       `json:"pinned"`
+	Archived       bool                       `json:"archived"`
+	ActiveLeafID   *uuid.UUID                 `json:"active_leaf_id,omitempty"`
+	KnowledgeBases []ExportedKnowledgeBaseRef `json:"knowledge_bases"`
+	Messages       []ExportedMessage          `json:"messages"`
+	CreatedAt      time.Time                  `json:"created_at"`
+	UpdatedAt      time.Time                  `json:"updated_at"`
+}
+
+// ExportedKnowledgeBaseRef 对话挂载的知识库（只导出引用，不含文档内容）
+type ExportedKnowledgeBaseRef struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// ExportedMessage 导出的消息，包含全部分支；parent_id 指向同一文件中的消息
+type ExportedMessage struct {
+	ID        uuid.UUID       `json:"id"`
+	ParentID  *uuid.UUID      `json:"parent_id"`
+	Role      string          `json:"role"`
+	Content   string          `json:"content"`
+	Metadata  json.RawMessage `json:"metadata,omitempty"` // 如 citations 引用的知识库文档
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// exportManifest 批量导出压缩包中的清单
+type exportManifest struct {
+	Schema        string                `json:"schema"`
+	Version       int                   `json:"version"`
+	Format        string                `json:"format"`
+	ExportedAt    time.Time             `json:"exported_at"`
+	Conversations []exportManifestEntry `json:"conversations"`
+}
+
+type exportManifestEntry struct {
+	ID    uuid.UUID `json:"id"`
+	Title string    `json:"title"`
+	File  string    `json:"file"`
+}
+
+// ExportService 对话导出服务
+type ExportService struct {
+	db *gorm.DB
+}
+
+// NewExportService 创建导出服务
+func NewExportService(db *gorm.DB) *ExportService {
+	return &ExportService{db: db}
+}
+
+// ExportFileExtension 导出格式对应的文件扩展名
+func ExportFileExtension(format string) string {
+	switch format {
+	case ExportFormatMarkdown:
+		return "md"
+	case ExportFormatHTML:
+		return "html"
+	default:
+		return "json"
+	}
+}
+
+// ExportConversation 导出单个对话；对话不存在或无权访问时返回 ErrConversationNotFound
+func (s *ExportService) ExportConversation(userID, conversationID uuid.UUID, format string) ([]byte, error) {
+	if !validExportFormat(format) {
+		return nil, ErrUnsupportedExportFormat
+	}
+
+	session, err := authorizeConversation(s.db, userID, conversationID, ConversationRead)
+	if err != nil {
+		return nil, err
+	}
+
+	export, path, err := s.buildExport(session)
+	if err != nil {
+		return nil, err
+	}
+	return renderExport(export, path, format)
+}
+
+// WriteArchive 将用户的全部对话（不含回收站）按指定格式写入 zip，附带 manifest.json
+func (s *ExportService) WriteArchive(userID uuid.UUID, format string, w io.Writer) error {
+	if !validExportFormat(format) {
+		return ErrUnsupportedExportFormat
+	}
+
+	var sessions []models.ChatSession
+	err := s.db.Where("user_id = ? AND is_active = ?", userID, true).
+		Order("created_at ASC").
+		Find(&sessions).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取导出对话列表失败")
+		return errors.New("获取导出对话列表失败")
+	}
+
+	archive := zip.NewWriter(w)
+	manifest := exportManifest{
+		Schema:        ExportSchema,
+		Version:       ExportSchemaVersion,
+		Format:        format,
+		ExportedAt:    time.Now().UTC(),
+		Conversations: make([]exportManifestEntry, 0, len(sessions)),
+	}
+
+	for i := range sessions {
+		export, path, err := s.buildExport(&sessions[i])
+		if err != nil {
+			return err
+		}
+		content, err := renderExport(export, path, format)
+		if err != nil {
+			return err
+		}
+
+		name := fmt.Sprintf("conversations/%s.%s", sessions[i].ID, ExportFileExtension(format))
+		file, err := archive.Create(name)
+		if err != nil {
+			return fmt.Errorf("写入导出文件失败: %w", err)
+		}
+		if _, err := file.Write(content); err != nil {
+			return fmt.Errorf("写入导出文件失败: %w", err)
+		}
+		manifest.Conversations = append(manifest.Conversations, exportManifestEntry{
+			ID:    sessions[i].ID,
+			Title: sessions[i].Title,
+			File:  name,
+		})
+	}
+
+	file, err := archive.Create("manifest.json")
+	if err != nil {
+		return fmt.Errorf("写入导出清单失败: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return fmt.Errorf("写入导出清单失败: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":       userID.String(),
+		"format":        format,
+		"conversations": len(sessions),
+	}).Info("批量导出对话完成")
+
+	return archive.Close()
+}
+
+// buildExport 组装对话的导出数据，同时返回当前分支上的消息（Markdown 和 HTML 只展示当前分支）
+func (s *ExportService) buildExport(session *models.ChatSession) (*ConversationExport, []*models.ChatMessage, error) {
+	var messages []models.ChatMessage
+	err := s.db.Unscoped().
+		Where("conversation_id = ?", session.ID).
+		Order("created_at ASC").
+		Find(&messages).Error
+	if err != nil {
+		logrus.WithError(err).WithField("conversation_id", session.ID).Error("加载导出消息失败")
+		return nil, nil, errors.New("加载导出消息失败")
+	}
+	tree := buildMessageTree(messages)
+
+	var knowledgeBases []ExportedKnowledgeBaseRef
+	err = s.db.Table("conversation_knowledge_bases AS ckb").
+		Select("kb.id, kb.name").
+		Joins("JOIN knowledge_bases AS kb ON kb.id = ckb.knowledge_base_id AND kb.deleted_at IS NULL").
+		Where("ckb.conversation_id = ?", session.ID).
+		Order("ckb.created_at ASC").
+		Scan(&knowledgeBases).Error
+	if err != nil {
+		logrus.WithError(err).WithField("conversation_id", session.ID).Error("加载对话知识库失败")
+		return nil, nil, errors.New("加载对话知识库失败")
+	}
+
+	export := &ConversationExport{
+		Schema:     ExportSchema,
+		Version:    ExportSchemaVersion,
+		ExportedAt: time.Now().UTC(),
+		Conversation: ExportedConversation{
+			ID:             session.ID,
+			Title:          session.Title,
+			Description:    session.Description,
+			Pinned:         session.Pinned,
+			Archived:       session.Archived,
+			KnowledgeBases: knowledgeBases,
+			Messages:       make([]ExportedMessage, 0, len(messages)),
+			CreatedAt:      session.CreatedAt,
+			UpdatedAt:      session.UpdatedAt,
+		},
+	}
+	if export.Conversation.KnowledgeBases == nil {
+		export.Conversation.KnowledgeBases = []ExportedKnowledgeBaseRef{}
+	}
+
+	// 已删除的消息不导出，其子消息的 parent_id 改为最近的未删除祖先
+	for i := range messages {
+		msg := &messages[i]
+		if msg.DeletedAt.Valid {
+			continue
+		}
+		item := ExportedMessage{
+			ID:        msg.ID,
+			Role:      msg.Role,
+			Content:   msg.Content,
+			CreatedAt: msg.CreatedAt,
+			UpdatedAt: msg.UpdatedAt,
+		}
+		if parent := tree.visibleParent(msg); parent != uuid.Nil {
+			item.ParentID = &parent
+		}
+		if len(msg.Metadata) > 0 {
+			item.Metadata = json.RawMessage(msg.Metadata)
+		}
+		export.Conversation.Messages = append(export.Conversation.Messages, item)
+	}
+
+	path := tree.activePath(session.ActiveLeafID)
+	if len(path) > 0 {
+		leaf := path[len(path)-1].ID
+		export.Conversation.ActiveLeafID = &leaf
+	}
+
+	return export, path, nil
+}
+
+func validExportFormat(format string) bool {
+	return format == ExportFormatJSON || format == ExportFormatMarkdown || format == ExportFormatHTML
+}
+
+// renderExport 按格式输出导出文件
+func renderExport(export *ConversationExport, path []*models.ChatMessage, format string) ([]byte, error) {
+	switch format {
+	case ExportFormatMarkdown:
+		return renderMarkdownExport(export, path), nil
+	case ExportFormatHTML:
+		return renderHTMLExport(export, path)
+	default:
+		return json.MarshalIndent(export, "", "  ")
+	}
+}
+
+// exportRoleNames 导出文件中显示的角色名
+var exportRoleNames = map[string]string{
+	"user":      "用户",
+	"assistant": "助手",
+	"system":    "系统",
+}
+
+func exportRoleName(role string) string {
+	if name, ok := exportRoleNames[role]; ok {
+		return name
+	}
+	return role
+}
+
+// messageCitations 从消息元数据中取出引用的文档名
+func messageCitations(msg *models.ChatMessage) []string {
+	if len(msg.Metadata) == 0 {
+		return nil
+	}
+	var metadata struct {
+		Citations []KnowledgeHit `json:"citations"`
+	}
+	if err := json.Unmarshal(msg.Metadata, &metadata); err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, hit := range metadata.Citations {
+		name := hit.DocumentName
+		if hit.Location != "" {
+			name += "（" + hit.Location + "）"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// renderMarkdownExport 以 Markdown 输出当前分支
+func renderMarkdownExport(export *ConversationExport, path []*models.ChatMessage) []byte {
+	var b strings.Builder
+	conv := export.Conversation
+
+	fmt.Fprintf(&b, "# %s\n\n", conv.Title)
+	if conv.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", conv.Description)
+	}
+	fmt.Fprintf(&b, "- 创建时间：%s\n", conv.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- 导出时间：%s\n", export.ExportedAt.Format(time.RFC3339))
+	if len(conv.KnowledgeBases) > 0 {
+		names := make([]string, 0, len(conv.KnowledgeBases))
+		for _, kb := range conv.KnowledgeBases {
+			names = append(names, kb.Name)
+		}
+		fmt.Fprintf(&b, "- 知识库：%s\n", strings.Join(names, "、"))
+	}
+
+	for _, msg := range path {
+		fmt.Fprintf(&b, "\n## %s · %s\n\n", exportRoleName(msg.Role), msg.CreatedAt.Format(time.RFC3339))
+		b.WriteString(strings.TrimSpace(msg.Content))
+		b.WriteString("\n")
+		if citations := messageCitations(msg); len(citations) > 0 {
+			b.WriteString("\n参考资料：\n")
+			for _, name := range citations {
+				fmt.Fprintf(&b, "- %s\n", name)
+			}
+		}
+	}
+	return []byte(b.String())
+}
+
+var htmlExportTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"role":      exportRoleName,
+	"citations": messageCitations,
+	"time": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.Export.Conversation.Title}}</title>
+<style>
+body { max-width: 800px; margin: 2em auto; font-family: sans-serif; line-height: 1.6; color: #222; }
+.message { margin: 1em 0; padding: 0.8em 1em; border-radius: 8px; }
+.user { background: #eef4ff; }
+.assistant { background: #f5f5f5; }
+.meta { font-size: 0.85em; color: #666; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Export.Conversation.Title}}</h1>
+{{with .Export.Conversation.Description}}<p>{{.}}</p>{{end}}
+<p class="meta">创建时间：{{time .Export.Conversation.CreatedAt}} · 导出时间：{{time .Export.ExportedAt}}</p>
+{{range .Path}}<div class="message {{.Role}}">
+<div class="meta">{{role .Role}} · {{time .CreatedAt}}</div>
+<div class="content">{{.Content}}</div>
+{{with citations .}}<div class="meta">参考资料：{{range $i, $name := .}}{{if $i}}、{{end}}{{$name}}{{end}}</div>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
+
+// renderHTMLExport 以独立的 HTML 页面输出当前分支，内容经过转义
+func renderHTMLExport(export *ConversationExport, path []*models.ChatMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	err := htmlExportTemplate.Execute(&buf, struct {
+		Export *ConversationExport
+		Path   []*models.ChatMessage
+	}{export, path})
+	if err != nil {
+		return nil, fmt.Errorf("生成HTML失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExportConversation 测试 JSON 导出包含全部分支，Markdown 和 HTML 只包含当前分支
+func TestExportConversation(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	exportService := NewExportService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "退款问题")
+	require.NoError(t, err)
+	q1, err := chatService.SendMessage(alice.ID, conversation.ID, "原来的问题", "user", nil)
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "原来的回答", "assistant", map[string]interface{}{
+		"citations": []KnowledgeHit{{DocumentName: "退款政策.md", Location: "第二节"}},
+	})
+	require.NoError(t, err)
+	edited, err := chatService.EditMessage(alice.ID, conversation.ID, q1.ID, "修改后的问题 <script>")
+	require.NoError(t, err)
+
+	raw, err := exportService.ExportConversation(alice.ID, conversation.ID, ExportFormatJSON)
+	require.NoError(t, err)
+	var export ConversationExport
+	require.NoError(t, json.Unmarshal(raw, &export))
+	assert.Equal(t, ExportSchema, export.Schema)
+	assert.Equal(t, ExportSchemaVersion, export.Version)
+	assert.Equal(t, "退款问题", export.Conversation.Title)
+	require.Len(t, export.Conversation.Messages, 3)
+	assert.Equal(t, q1.ID, *export.Conversation.Messages[1].ParentID)
+	assert.Contains(t, string(export.Conversation.Messages[1].Metadata), "退款政策.md")
+	assert.Equal(t, edited.ID, *export.Conversation.ActiveLeafID)
+
+	markdown, err := exportService.ExportConversation(alice.ID, conversation.ID, ExportFormatMarkdown)
+	require.NoError(t, err)
+	assert.Contains(t, string(markdown), "# 退款问题")
+	assert.Contains(t, string(markdown), "修改后的问题")
+	assert.NotContains(t, string(markdown), "原来的问题")
+
+	page, err := exportService.ExportConversation(alice.ID, conversation.ID, ExportFormatHTML)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "修改后的问题 &lt;script&gt;")
+
+	_, err = exportService.ExportConversation(bob.ID, conversation.ID, ExportFormatJSON)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	_, err = exportService.ExportConversation(alice.ID, conversation.ID, "pdf")
+	assert.ErrorIs(t, err, ErrUnsupportedExportFormat)
+}
+
+// TestExportArchive 测试批量导出的压缩包包含每个对话和清单，不含回收站中的对话
+func TestExportArchive(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	exportService := NewExportService(db)
+	alice := createTestUser(t, db, "alice")
+
+	kept, err := chatService.CreateChatSession(alice.ID, "保留")
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, kept.ID, "你好", "user", nil)
+	require.NoError(t, err)
+	deleted, err := chatService.CreateChatSession(alice.ID, "已删除")
+	require.NoError(t, err)
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, deleted.ID))
+
+	var buf bytes.Buffer
+	require.NoError(t, exportService.WriteArchive(alice.ID, ExportFormatMarkdown, &buf))
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(r)
+		require.NoError(t, err)
+		files[f.Name] = content
+	}
+
+	var manifest exportManifest
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	require.Len(t, manifest.Conversations, 1)
+	assert.Equal(t, kept.ID, manifest.Conversations[0].ID)
+	assert.Contains(t, string(files[manifest.Conversations[0].File]), "你好")
+	assert.Len(t, files, 2)
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat-backend/models"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 评价相关错误
+var (
+	ErrFeedbackTarget   = errors.New("只能评价AI回复")
+	ErrInvalidRating    = errors.New("rating 只能是 up 或 down")
+	ErrInvalidReason    = errors.New("不支持的评价原因")
+	ErrFeedbackNotFound = errors.New("评价不存在")
+)
+
+// 评价原因分类，赞和踩各有一组
+var feedbackReasons = map[string]map[string]bool{
+	models.FeedbackUp: {
+		"accurate":      true, // 准确
+		"helpful":       true, // 有帮助
+		"clear":         true, // 表达清楚
+		"good_citation": true, // 引用恰当
+		"other":         true,
+	},
+	models.FeedbackDown: {
+		"inaccurate":   true, // 事实错误
+		"incomplete":   true, // 回答不完整
+		"unhelpful":    true, // 没有帮助
+		"off_topic":    true, // 答非所问
+		"unsafe":       true, // 不安全或不当内容
+		"bad_citation": true, // 引用错误
+		"formatting":   true, // 格式问题
+		"other":        true,
+	},
+}
+
+const (
+	// 评价备注的最大长度（字符）
+	maxFeedbackComment = 2000
+	// 导出时每批读取的评价数
+	feedbackExportBatch = 200
+)
+
+// FeedbackFilter 评价导出和统计的过滤条件
+type FeedbackFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Rating string
+	Model  string
+}
+
+// FeedbackExportRecord 评价导出的一行，包含提问、上下文、回复和生成参数，用于离线评估
+type FeedbackExportRecord struct {
+	FeedbackID     uuid.UUID              `json:"feedback_id"`
+	MessageID      uuid.UUID              `json:"message_id"`
+	ConversationID uuid.UUID              `json:"conversation_id"`
+	Rating         string                 `json:"rating"`
+	Reasons        []string               `json:"reasons"`
+	Comment        string                 `json:"comment,omitempty"`
+	Model          string                 `json:"model"`
+	Params         *GenerationInfo        `json:"params,omitempty"`
+	Prompt         string                 `json:"prompt"`
+	Context        []FeedbackContextEntry `json:"context"` // 提问之前的上下文消息，从旧到新
+	Response       string                 `json:"response"`
+	Citations      json.RawMessage        `json:"citations,omitempty"`
+	RespondedAt    time.Time              `json:"responded_at"`
+	RatedAt        time.Time              `json:"rated_at"`
+}
+
+// FeedbackContextEntry 导出中的上下文消息
+type FeedbackContextEntry struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ModelFeedbackStats 单个模型的评价统计
+type ModelFeedbackStats struct {
+	Model  string  `json:"model"`
+	Total  int64   `json:"total"`
+	Up     int64   `json:"up"`
+	Down   int64   `json:"down"`
+	UpRate float64 `json:"up_rate"`
+}
+
+// FeedbackService 消息评价服务
+type FeedbackService struct {
+	db *gorm.DB
+}
+
+// NewFeedbackService 创建消息评价服务
+func NewFeedbackService(db *gorm.DB) *FeedbackService {
+	return &FeedbackService{db: db}
+}
+
+// RateMessage 评价AI回复，重复评价时覆盖之前的评价；有查看权限的用户都可以评价
+func (s *FeedbackService) RateMessage(userID, conversationID, messageID uuid.UUID, rating string, reasons []string, comment string) (*models.MessageFeedback, error) {
+	allowed, ok := feedbackReasons[rating]
+	if !ok {
+		return nil, ErrInvalidRating
+	}
+	seen := make(map[string]bool, len(reasons))
+	cleaned := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		if !allowed[reason] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidReason, reason)
+		}
+		if !seen[reason] {
+			seen[reason] = true
+			cleaned = append(cleaned, reason)
+		}
+	}
+	comment = strings.TrimSpace(comment)
+	if runes := []rune(comment); len(runes) > maxFeedbackComment {
+		comment = string(runes[:maxFeedbackComment])
+	}
+
+	message, err := authorizeMessage(s.db, userID, messageID, ConversationRead)
+	if err != nil {
+		return nil, err
+	}
+	if message.ConversationID != conversationID {
+		return nil, ErrMessageNotFound
+	}
+	if message.Role != "assistant" {
+		return nil, ErrFeedbackTarget
+	}
+
+	rawReasons, _ := json.Marshal(cleaned)
+	feedback := &models.MessageFeedback{
+		ID:             uuid.New(),
+		MessageID:      messageID,
+		UserID:         userID,
+		ConversationID: conversationID,
+		Rating:         rating,
+		Reasons:        datatypes.JSON(rawReasons),
+		Comment:        comment,
+	}
+	if info := generationInfo(message); info != nil {
+		feedback.Model = info.Model
+	}
+
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rating", "reasons", "comment", "model", "updated_at"}),
+	}).Create(feedback).Error
+	if err != nil {
+		logrus.WithError(err).Error("保存评价失败")
+		return nil, errors.New("保存评价失败")
+	}
+
+	// 覆盖已有评价时返回数据库中的记录
+	if err := s.db.Where("message_id = ? AND user_id = ?", messageID, userID).First(feedback).Error; err != nil {
+		logrus.WithError(err).Error("查询评价失败")
+		return nil, errors.New("保存评价失败")
+	}
+	return feedback, nil
+}
+
+// DeleteFeedback 撤销用户对消息的评价
+func (s *FeedbackService) DeleteFeedback(userID, conversationID, messageID uuid.UUID) error {
+	message, err := authorizeMessage(s.db, userID, messageID, ConversationRead)
+	if err != nil {
+		return err
+	}
+	if message.ConversationID != conversationID {
+		return ErrMessageNotFound
+	}
+
+	result := s.db.Where("message_id = ? AND user_id = ?", messageID, userID).Delete(&models.MessageFeedback{})
+	if result.Error != nil {
+		logrus.WithError(result.Error).Error("删除评价失败")
+		return errors.New("删除评价失败")
+	}
+	if result.RowsAffected == 0 {
+		return ErrFeedbackNotFound
+	}
+	return nil
+}
+
+// ExportFeedback 将符合条件的评价以 JSONL 写出，每行一条 FeedbackExportRecord，返回写出的条数。
+// 被删除的消息仍会导出，便于评估历史回复
+func (s *FeedbackService) ExportFeedback(filter FeedbackFilter, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	// 按 (created_at, id) 手动翻页；FindInBatches 按主键翻页，与时间排序混用会漏掉数据
+	count := 0
+	pc := pageCursor{page: PageRequest{Direction: PageAfter, Limit: feedbackExportBatch}}
+	for {
+		var batch []models.MessageFeedback
+		if err := pc.apply(s.filtered(filter), "").Find(&batch).Error; err != nil {
+			return count, fmt.Errorf("导出评价失败: %w", err)
+		}
+		hasMore := len(batch) > pc.page.Limit
+		if hasMore {
+			batch = batch[:pc.page.Limit]
+		}
+
+		for i := range batch {
+			record, err := s.exportRecord(&batch[i])
+			if err != nil {
+				logrus.WithError(err).WithField("feedback_id", batch[i].ID).Warn("评价对应的消息不存在，已跳过")
+				continue
+			}
+			if err := encoder.Encode(record); err != nil {
+				return count, fmt.Errorf("导出评价失败: %w", err)
+			}
+			count++
+		}
+
+		if !hasMore {
+			return count, nil
+		}
+		last := batch[len(batch)-1]
+		pc.time, pc.id, pc.valid = last.CreatedAt, last.ID, true
+	}
+}
+
+// ModelStats 按模型汇总评价，赞的比例高的在前
+func (s *FeedbackService) ModelStats(filter FeedbackFilter) ([]ModelFeedbackStats, error) {
+	var rows []struct {
+		Model  string
+		Rating string
+		Count  int64
+	}
+	err := s.filtered(filter).
+		Select("model, rating, COUNT(*) AS count").
+		Group("model, rating").
+		Scan(&rows).Error
+	if err != nil {
+		logrus.WithError(err).Error("统计评价失败")
+		return nil, errors.New("统计评价失败")
+	}
+
+	byModel := make(map[string]*ModelFeedbackStats)
+	var order []string
+	for _, row := range rows {
+		stats, ok := byModel[row.Model]
+		if !ok {
+			stats = &ModelFeedbackStats{Model: row.Model}
+			byModel[row.Model] = stats
+			order = append(order, row.Model)
+		}
+		stats.Total += row.Count
+		switch row.Rating {
+		case models.FeedbackUp:
+			stats.Up += row.Count
+		case models.FeedbackDown:
+			stats.Down += row.Count
+		}
+	}
+
+	result := make([]ModelFeedbackStats, 0, len(order))
+	for _, model := range order {
+		stats := byModel[model]
+		if stats.Total > 0 {
+			stats.UpRate = float64(stats.Up) / float64(stats.Total)
+		}
+		result = append(result, *stats)
+	}
+	// 按赞的比例从高到低，相同时样本多的在前
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].UpRate != result[j].UpRate {
+			return result[i].UpRate > result[j].UpRate
+		}
+		return result[i].Total > result[j].Total
+	})
+	return result, nil
+}
+
+// filtered 按过滤条件构造评价查询
+func (s *FeedbackService) filtered(filter FeedbackFilter) *gorm.DB {
+	query := s.db.Model(&models.MessageFeedback{})
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.Rating != "" {
+		query = query.Where("rating = ?", filter.Rating)
+	}
+	if filter.Model != "" {
+		query = query.Where("model = ?", filter.Model)
+	}
+	return query
+}
+
+// exportRecord 组装一条评价的导出记录：回复、提问和提问之前的上下文
+func (s *FeedbackService) exportRecord(feedback *models.MessageFeedback) (*FeedbackExportRecord, error) {
+	var message models.ChatMessage
+	if err := s.db.Unscoped().Where("id = ?", feedback.MessageID).First(&message).Error; err != nil {
+		return nil, err
+	}
+
+	record := &FeedbackExportRecord{
+		FeedbackID:     feedback.ID,
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		Rating:         feedback.Rating,
+		Reasons:        []string{},
+		Comment:        feedback.Comment,
+		Model:          feedback.Model,
+		Params:         generationInfo(&message),
+		Context:        []FeedbackContextEntry{},
+		Response:       message.Content,
+		RespondedAt:    message.CreatedAt,
+		RatedAt:        feedback.UpdatedAt,
+	}
+	if len(feedback.Reasons) > 0 {
+		_ = json.Unmarshal(feedback.Reasons, &record.Reasons)
+	}
+	var metadata struct {
+		Citations json.RawMessage `json:"citations"`
+	}
+	if len(message.Metadata) > 0 && json.Unmarshal(message.Metadata, &metadata) == nil {
+		record.Citations = metadata.Citations
+	}
+
+	// 上下文窗口优先取生成时实际发送的消息数，旧消息没有记录时取10条
+	window := 10
+	if record.Params != nil && record.Params.ContextMessages > 0 {
+		window = record.Params.ContextMessages
+	}
+	ancestors, err := s.ancestors(message.ParentID, window)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(ancestors); n > 0 {
+		record.Prompt = ancestors[n-1].Content
+		for _, msg := range ancestors[:n-1] {
+			record.Context = append(record.Context, FeedbackContextEntry{Role: msg.Role, Content: msg.Content})
+		}
+	}
+	return record, nil
+}
+
+// ancestors 从 parentID 沿父消息向上取最多 limit 条，按从旧到新返回
+func (s *FeedbackService) ancestors(parentID *uuid.UUID, limit int) ([]models.ChatMessage, error) {
+	var chain []models.ChatMessage
+	for parentID != nil && len(chain) < limit {
+		var msg models.ChatMessage
+		if err := s.db.Unscoped().Where("id = ?", *parentID).First(&msg).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			return nil, err
+		}
+		chain = append(chain, msg)
+		parentID = msg.ParentID
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// generationInfo 读取AI回复元数据中的生成参数，没有记录时返回 nil
+func generationInfo(message *models.ChatMessage) *GenerationInfo {
+	if len(message.Metadata) == 0 {
+		return nil
+	}
+	var metadata struct {
+		Generation *GenerationInfo `json:"generation"`
+	}
+	if err := json.Unmarshal(message.Metadata, &metadata); err != nil {
+		return nil
+	}
+	return metadata.Generation
+}
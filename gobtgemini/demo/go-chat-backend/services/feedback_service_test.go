@@ -0,0 +1,127 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-chat-backend/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMessageFeedback 测试评价AI回复、覆盖评价、JSONL 导出以及按模型统计
+func TestMessageFeedback(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	feedbackService := NewFeedbackService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	reply := func(model, prompt, answer string) (question, response uuid.UUID) {
+		q, err := chatService.SendMessage(alice.ID, conversation.ID, prompt, "user", nil)
+		require.NoError(t, err)
+		a, err := chatService.SendMessage(alice.ID, conversation.ID, answer, "assistant", map[string]interface{}{
+			"generation": GenerationInfo{Model: model, Temperature: 0.3, ContextMessages: 4},
+		})
+		require.NoError(t, err)
+		return q.ID, a.ID
+	}
+	_, first := reply("model-a", "你好", "你好，有什么可以帮你？")
+	question, second := reply("model-b", "1+1等于几", "等于3")
+
+	_, err = feedbackService.RateMessage(alice.ID, conversation.ID, question, "up", nil, "")
+	assert.ErrorIs(t, err, ErrFeedbackTarget)
+	_, err = feedbackService.RateMessage(alice.ID, conversation.ID, second, "down", []string{"helpful"}, "")
+	assert.ErrorIs(t, err, ErrInvalidReason)
+	_, err = feedbackService.RateMessage(bob.ID, conversation.ID, second, "down", nil, "")
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	_, err = feedbackService.RateMessage(alice.ID, conversation.ID, first, "up", []string{"helpful"}, "")
+	require.NoError(t, err)
+	_, err = feedbackService.RateMessage(alice.ID, conversation.ID, second, "up", nil, "")
+	require.NoError(t, err)
+	// 再次评价覆盖之前的结果
+	feedback, err := feedbackService.RateMessage(alice.ID, conversation.ID, second, "down", []string{"inaccurate", "inaccurate"}, " 算错了 ")
+	require.NoError(t, err)
+	assert.Equal(t, "down", feedback.Rating)
+	assert.Equal(t, "model-b", feedback.Model)
+	assert.Equal(t, "算错了", feedback.Comment)
+
+	stats, err := feedbackService.ModelStats(FeedbackFilter{})
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	assert.Equal(t, ModelFeedbackStats{Model: "model-a", Total: 1, Up: 1, UpRate: 1}, stats[0])
+	assert.Equal(t, ModelFeedbackStats{Model: "model-b", Total: 1, Down: 1}, stats[1])
+
+	var buf bytes.Buffer
+	count, err := feedbackService.ExportFeedback(FeedbackFilter{Rating: "down"}, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	scanner := bufio.NewScanner(&buf)
+	require.True(t, scanner.Scan())
+	var record FeedbackExportRecord
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+	assert.False(t, scanner.Scan())
+	assert.Equal(t, second, record.MessageID)
+	assert.Equal(t, []string{"inaccurate"}, record.Reasons)
+	assert.Equal(t, "1+1等于几", record.Prompt)
+	assert.Equal(t, "等于3", record.Response)
+	require.NotNil(t, record.Params)
+	assert.Equal(t, float32(0.3), record.Params.Temperature)
+	assert.Equal(t, []FeedbackContextEntry{
+		{Role: "user", Content: "你好"},
+		{Role: "assistant", Content: "你好，有什么可以帮你？"},
+	}, record.Context)
+
+	require.NoError(t, feedbackService.DeleteFeedback(alice.ID, conversation.ID, second))
+	assert.ErrorIs(t, feedbackService.DeleteFeedback(alice.ID, conversation.ID, second), ErrFeedbackNotFound)
+}
+
+// TestExportFeedbackBatches 测试评价超过一批时按创建时间完整导出，不因主键顺序与时间顺序不一致而漏掉
+func TestExportFeedbackBatches(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	feedbackService := NewFeedbackService(db)
+	alice := createTestUser(t, db, "alice")
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+
+	const total = feedbackExportBatch + 57
+	start := time.Now().Add(-time.Hour)
+	want := make([]uuid.UUID, 0, total)
+	for i := 0; i < total; i++ {
+		message, err := chatService.SendMessage(alice.ID, conversation.ID, fmt.Sprintf("回复 %d", i), "assistant", nil)
+		require.NoError(t, err)
+		feedback := models.MessageFeedback{
+			MessageID:      message.ID,
+			UserID:         alice.ID,
+			ConversationID: conversation.ID,
+			Rating:         "up",
+			CreatedAt:      start.Add(time.Duration(i) * time.Second),
+		}
+		require.NoError(t, db.Create(&feedback).Error)
+		want = append(want, feedback.ID)
+	}
+
+	var buf bytes.Buffer
+	count, err := feedbackService.ExportFeedback(FeedbackFilter{}, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, total, count)
+
+	var got []uuid.UUID
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var record FeedbackExportRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		got = append(got, record.FeedbackID)
+	}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 文件夹相关错误
+var (
+	ErrFolderNotFound  = errors.New("文件夹不存在或无权访问")
+	ErrFolderExists    = errors.New("已存在同名文件夹")
+	ErrEmptyFolderName = errors.New("文件夹名称不能为空")
+)
+
+// FolderWithCount 文件夹及其中未删除对话的数量
+type FolderWithCount struct {
+	models.Folder
+	ConversationCount int64 `json:"conversation_count"`
+}
+
+// FolderService 对话文件夹服务
+type FolderService struct {
+	db *gorm.DB
+}
+
+// NewFolderService 创建文件夹服务
+func NewFolderService(db *gorm.DB) *FolderService {
+	return &FolderService{db: db}
+}
+
+// CreateFolder 创建文件夹，同一用户下名称不能重复
+func (s *FolderService) CreateFolder(userID uuid.UUID, name string) (*models.Folder, error) {
+	name, err := s.checkName(userID, uuid.Nil, name)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := &models.Folder{
+		ID:     uuid.New(),
+		UserID: userID,
+		Name:   name,
+	}
+	if err := s.db.Create(folder).Error; err != nil {
+		logrus.WithError(err).Error("创建文件夹失败")
+		return nil, errors.New("创建文件夹失败")
+	}
+	return folder, nil
+}
+
+// ListFolders 获取用户的文件夹，按名称排序
+func (s *FolderService) ListFolders(userID uuid.UUID) ([]FolderWithCount, error) {
+	var folders []models.Folder
+	if err := s.db.Where("user_id = ?", userID).Order("name ASC").Find(&folders).Error; err != nil {
+		logrus.WithError(err).Error("获取文件夹列表失败")
+		return nil, errors.New("获取文件夹列表失败")
+	}
+
+	// 自己创建的对话和参与的共享对话分别统计，文件夹状态各存一处
+	var owned, shared []struct {
+		FolderID uuid.UUID
+		Count    int64
+	}
+	err := s.db.Model(&models.ChatSession{}).
+		Select("folder_id, COUNT(*) AS count").
+		Where("user_id = ? AND is_active = ? AND folder_id IS NOT NULL", userID, true).
+		Group("folder_id").
+		Scan(&owned).Error
+	if err == nil {
+		err = s.db.Table("conversation_participants").
+			Select("conversation_participants.folder_id, COUNT(*) AS count").
+			Joins("JOIN chat_sessions ON chat_sessions.id = conversation_participants.conversation_id").
+			Where("conversation_participants.user_id = ? AND chat_sessions.is_active = ?", userID, true).
+			Where("conversation_participants.folder_id IS NOT NULL").
+			Group("conversation_participants.folder_id").
+			Scan(&shared).Error
+	}
+	if err != nil {
+		logrus.WithError(err).Error("统计文件夹对话数失败")
+		return nil, errors.New("获取文件夹列表失败")
+	}
+	countByFolder := make(map[uuid.UUID]int64, len(owned)+len(shared))
+	for _, row := range append(owned, shared...) {
+		countByFolder[row.FolderID] += row.Count
+	}
+
+	result := make([]FolderWithCount, 0, len(folders))
+	for _, folder := range folders {
+		result = append(result, FolderWithCount{Folder: folder, ConversationCount: countByFolder[folder.ID]})
+	}
+	return result, nil
+}
+
+// RenameFolder 重命名文件夹
+func (s *FolderService) RenameFolder(userID, folderID uuid.UUID, name string) (*models.Folder, error) {
+	folder, err := findFolder(s.db, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	name, err = s.checkName(userID, folderID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(folder).Update("name", name).Error; err != nil {
+		logrus.WithError(err).Error("重命名文件夹失败")
+		return nil, errors.New("重命名文件夹失败")
+	}
+	return folder, nil
+}
+
+// DeleteFolder 删除文件夹，其中的对话移出文件夹而不会被删除
+func (s *FolderService) DeleteFolder(userID, folderID uuid.UUID) error {
+	folder, err := findFolder(s.db, userID, folderID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&models.ChatSession{}).Unscoped().
+			Where("folder_id = ?", folder.ID).
+			Updates(map[string]interface{}{"folder_id": nil, "updated_at": now}).Error
+		if err != nil {
+			return err
+		}
+		// 参与者的文件夹在 conversation_participants 上，同样更新对话的 updated_at 让增量同步获取到
+		shared := tx.Model(&models.ConversationParticipant{}).Select("conversation_id").Where("folder_id = ?", folder.ID)
+		err = tx.Model(&models.ChatSession{}).Unscoped().Where("id IN (?)", shared).Update("updated_at", now).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.ConversationParticipant{}).
+			Where("folder_id = ?", folder.ID).
+			Updates(map[string]interface{}{"folder_id": nil, "updated_at": now}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(folder).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("删除文件夹失败")
+		return errors.New("删除文件夹失败")
+	}
+	return nil
+}
+
+// checkName 校验文件夹名称，excludeID 为重命名时的文件夹自身
+func (s *FolderService) checkName(userID, excludeID uuid.UUID, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrEmptyFolderName
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+
+	var count int64
+	err := s.db.Model(&models.Folder{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).
+		Count(&count).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询文件夹失败")
+		return "", errors.New("查询文件夹失败")
+	}
+	if count > 0 {
+		return "", ErrFolderExists
+	}
+	return name, nil
+}
+
+// findFolder 查询用户的文件夹，不存在或属于他人时返回 ErrFolderNotFound
+func findFolder(db *gorm.DB, userID, folderID uuid.UUID) (*models.Folder, error) {
+	var folder models.Folder
+	err := db.Where("id = ? AND user_id = ?", folderID, userID).First(&folder).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFolderNotFound
+		}
+		logrus.WithError(err).Error("查询文件夹失败")
+		return nil, errors.New("查询文件夹失败")
+	}
+	return &folder, nil
+}
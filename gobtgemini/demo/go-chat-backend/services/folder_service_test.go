@@ -0,0 +1,227 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sessionTitles 对话列表的标题
+func sessionTitles(t *testing.T, chatService *ChatService, userID uuid.UUID, filter ConversationFilter) []string {
+	t.Helper()
+
+	sessions, _, err := chatService.GetChatSessions(userID, filter, PageRequest{})
+	require.NoError(t, err)
+	titles := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		titles = append(titles, session.Title)
+	}
+	return titles
+}
+
+// TestFoldersAndTags 测试文件夹、标签以及按它们过滤对话列表
+func TestFoldersAndTags(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	folderService := NewFolderService(db)
+	tagService := NewTagService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	work, err := folderService.CreateFolder(alice.ID, " 工作 ")
+	require.NoError(t, err)
+	assert.Equal(t, "工作", work.Name)
+	_, err = folderService.CreateFolder(alice.ID, "工作")
+	assert.ErrorIs(t, err, ErrFolderExists)
+	_, err = folderService.CreateFolder(bob.ID, "工作")
+	require.NoError(t, err)
+
+	urgent, err := tagService.CreateTag(alice.ID, "紧急", "#ff0000")
+	require.NoError(t, err)
+
+	report, err := chatService.CreateChatSession(alice.ID, "周报")
+	require.NoError(t, err)
+	_, err = chatService.CreateChatSession(alice.ID, "闲聊")
+	require.NoError(t, err)
+
+	require.NoError(t, chatService.UpdateChatSession(alice.ID, report.ID, map[string]interface{}{"folder_id": &work.ID}))
+	tags, err := tagService.SetConversationTags(alice.ID, report.ID, []uuid.UUID{urgent.ID})
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+
+	assert.Equal(t, []string{"周报"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{FolderID: &work.ID}))
+	assert.Equal(t, []string{"闲聊"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{Unfiled: true}))
+	assert.Equal(t, []string{"周报"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{TagID: &urgent.ID}))
+
+	session, err := chatService.GetChatSession(alice.ID, report.ID)
+	require.NoError(t, err)
+	require.Len(t, session.Tags, 1)
+	assert.Equal(t, "紧急", session.Tags[0].Name)
+
+	folders, err := folderService.ListFolders(alice.ID)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	assert.Equal(t, int64(1), folders[0].ConversationCount)
+
+	// 不能使用别人的文件夹和标签
+	bobTag, err := tagService.CreateTag(bob.ID, "私人", "")
+	require.NoError(t, err)
+	_, err = tagService.SetConversationTags(alice.ID, report.ID, []uuid.UUID{bobTag.ID})
+	assert.ErrorIs(t, err, ErrTagNotFound)
+	bobFolders, err := folderService.ListFolders(bob.ID)
+	require.NoError(t, err)
+	err = chatService.UpdateChatSession(alice.ID, report.ID, map[string]interface{}{"folder_id": &bobFolders[0].ID})
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+
+	// 删除文件夹和标签后对话保留
+	require.NoError(t, folderService.DeleteFolder(alice.ID, work.ID))
+	require.NoError(t, tagService.DeleteTag(alice.ID, urgent.ID))
+	session, err = chatService.GetChatSession(alice.ID, report.ID)
+	require.NoError(t, err)
+	assert.Nil(t, session.FolderID)
+	assert.Empty(t, session.Tags)
+}
+
+// TestBulkUpdateConversations 测试批量移动、打标签、归档和删除
+func TestBulkUpdateConversations(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	folderService := NewFolderService(db)
+	tagService := NewTagService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	folder, err := folderService.CreateFolder(alice.ID, "归档项目")
+	require.NoError(t, err)
+	tag, err := tagService.CreateTag(alice.ID, "待整理", "")
+	require.NoError(t, err)
+
+	var ids []uuid.UUID
+	for _, title := range []string{"一", "二", "三"} {
+		session, err := chatService.CreateChatSession(alice.ID, title)
+		require.NoError(t, err)
+		ids = append(ids, session.ID)
+	}
+	foreign, err := chatService.CreateChatSession(bob.ID, "别人的")
+	require.NoError(t, err)
+
+	result, err := chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{
+		ConversationIDs: append([]uuid.UUID{foreign.ID}, ids[:2]...),
+		Action:          BulkMove,
+		FolderID:        &folder.ID,
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, ids[:2], result.Updated)
+	assert.Equal(t, []uuid.UUID{foreign.ID}, result.NotFound)
+	assert.ElementsMatch(t, []string{"一", "二"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{FolderID: &folder.ID}))
+
+	_, err = chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{ConversationIDs: ids, Action: BulkTag, TagIDs: []uuid.UUID{tag.ID}})
+	require.NoError(t, err)
+	_, err = chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{ConversationIDs: ids[:1], Action: BulkUntag, TagIDs: []uuid.UUID{tag.ID}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"二", "三"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{TagID: &tag.ID}))
+
+	_, err = chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{ConversationIDs: ids[1:], Action: BulkArchive})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"二", "三"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{Archived: true}))
+
+	_, err = chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{ConversationIDs: ids, Action: BulkDelete})
+	require.NoError(t, err)
+	assert.Empty(t, sessionTitles(t, chatService, alice.ID, ConversationFilter{}))
+	trash, _, err := chatService.GetDeletedChatSessions(alice.ID, PageRequest{})
+	require.NoError(t, err)
+	assert.Len(t, trash, 3)
+
+	_, err = chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{ConversationIDs: ids, Action: "explode"})
+	assert.ErrorIs(t, err, ErrInvalidBulkAction)
+	_, err = chatService.BulkUpdateChatSessions(alice.ID, BulkOperation{ConversationIDs: ids, Action: BulkTag})
+	assert.ErrorIs(t, err, ErrBulkTagsRequired)
+}
+
+// TestSharedConversationPersonalState 测试共享对话的置顶、归档、文件夹和标签按用户各自保存
+func TestSharedConversationPersonalState(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	folderService := NewFolderService(db)
+	tagService := NewTagService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "团队讨论")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "bob", "owner")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(alice.ID, conversation.ID, "carol", "viewer")
+	require.NoError(t, err)
+
+	aliceTag, err := tagService.CreateTag(alice.ID, "工作", "")
+	require.NoError(t, err)
+	bobTag, err := tagService.CreateTag(bob.ID, "待跟进", "")
+	require.NoError(t, err)
+	bobFolder, err := folderService.CreateFolder(bob.ID, "项目")
+	require.NoError(t, err)
+
+	// bob 整理共享对话不影响 alice
+	require.NoError(t, chatService.UpdateChatSession(bob.ID, conversation.ID, map[string]interface{}{
+		"pinned":    true,
+		"folder_id": &bobFolder.ID,
+	}))
+	_, err = tagService.SetConversationTags(bob.ID, conversation.ID, []uuid.UUID{bobTag.ID})
+	require.NoError(t, err)
+	_, err = tagService.SetConversationTags(alice.ID, conversation.ID, []uuid.UUID{aliceTag.ID})
+	require.NoError(t, err)
+
+	session, err := chatService.GetChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	assert.False(t, session.Pinned)
+	assert.Nil(t, session.FolderID)
+	require.Len(t, session.Tags, 1)
+	assert.Equal(t, "工作", session.Tags[0].Name)
+
+	session, err = chatService.GetChatSession(bob.ID, conversation.ID)
+	require.NoError(t, err)
+	assert.True(t, session.Pinned)
+	require.NotNil(t, session.FolderID)
+	assert.Equal(t, bobFolder.ID, *session.FolderID)
+	require.Len(t, session.Tags, 1)
+	assert.Equal(t, "待跟进", session.Tags[0].Name)
+
+	pinned := true
+	assert.Equal(t, []string{"团队讨论"}, sessionTitles(t, chatService, bob.ID, ConversationFilter{Pinned: &pinned}))
+	assert.Empty(t, sessionTitles(t, chatService, alice.ID, ConversationFilter{Pinned: &pinned}))
+	assert.Equal(t, []string{"团队讨论"}, sessionTitles(t, chatService, bob.ID, ConversationFilter{FolderID: &bobFolder.ID}))
+	assert.Equal(t, []string{"团队讨论"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{Unfiled: true}))
+	assert.Empty(t, sessionTitles(t, chatService, alice.ID, ConversationFilter{TagID: &bobTag.ID}))
+
+	folders, err := folderService.ListFolders(bob.ID)
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	assert.Equal(t, int64(1), folders[0].ConversationCount)
+	tags, err := tagService.ListTags(bob.ID)
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	assert.Equal(t, int64(1), tags[0].ConversationCount)
+
+	// 查看者也能归档自己的视图，但不能修改标题
+	result, err := chatService.BulkUpdateChatSessions(carol.ID, BulkOperation{ConversationIDs: []uuid.UUID{conversation.ID}, Action: BulkArchive})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{conversation.ID}, result.Updated)
+	assert.Equal(t, []string{"团队讨论"}, sessionTitles(t, chatService, carol.ID, ConversationFilter{Archived: true}))
+	assert.Equal(t, []string{"团队讨论"}, sessionTitles(t, chatService, alice.ID, ConversationFilter{}))
+	err = chatService.UpdateChatSession(carol.ID, conversation.ID, map[string]interface{}{"title": "改名"})
+	assert.ErrorIs(t, err, ErrConversationForbidden)
+
+	// 参与者不能删除别人创建的对话
+	result, err = chatService.BulkUpdateChatSessions(bob.ID, BulkOperation{ConversationIDs: []uuid.UUID{conversation.ID}, Action: BulkDelete})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{conversation.ID}, result.NotFound)
+
+	// 删除文件夹后 bob 的对话移出文件夹
+	require.NoError(t, folderService.DeleteFolder(bob.ID, bobFolder.ID))
+	session, err = chatService.GetChatSession(bob.ID, conversation.ID)
+	require.NoError(t, err)
+	assert.Nil(t, session.FolderID)
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// 生成参数的来源，按优先级从低到高
+const (
+	SettingsSourceDefault      = "default"
+	SettingsSourceUser         = "user"
+	SettingsSourcePersona      = "persona"
+	SettingsSourceConversation = "conversation"
+)
+
+// 生成参数的取值范围
+const (
+	maxSettingsTemperature   = 2.0
+	maxSettingsMaxTokens     = 8192
+	maxSettingsContextWindow = 50 // 与 GetPathMessages 的上限一致
+	maxSettingsSystemPrompt  = 4000
+)
+
+// ErrInvalidSettings 生成参数不合法
+var ErrInvalidSettings = errors.New("生成参数不合法")
+
+// SettingsLayer 一级生成参数覆盖及其来源
+type SettingsLayer struct {
+	Source   string
+	Settings models.GenerationSettings
+}
+
+// EffectiveSettings 逐级合并后实际使用的生成参数，Sources 记录每个参数来自哪一级
+type EffectiveSettings struct {
+	Model         string            `json:"model"`
+	Temperature   float32           `json:"temperature"`
+	MaxTokens     int               `json:"max_tokens"`
+	SystemPrompt  string            `json:"system_prompt"`
+	ContextWindow int               `json:"context_window"`
+	MemoryEnabled bool              `json:"memory_enabled"`
+	Sources       map[string]string `json:"sources"`
+}
+
+// Preference 转换为 LLMService 使用的偏好结构
+func (e EffectiveSettings) Preference() *models.UserPreference {
+	return &models.UserPreference{
+		LLMModel:      e.Model,
+		Temperature:   e.Temperature,
+		MaxTokens:     e.MaxTokens,
+		SystemPrompt:  e.SystemPrompt,
+		ContextWindow: e.ContextWindow,
+		MemoryEnabled: e.MemoryEnabled,
+	}
+}
+
+// ResolveSettings 从服务端默认值开始，按 layers 的顺序逐级覆盖（后面的优先），
+// 调用方按 用户 < 人设 < 对话 的顺序传入
+func ResolveSettings(layers ...SettingsLayer) EffectiveSettings {
+	effective := EffectiveSettings{
+		Model:         config.Get().LLMModel,
+		Temperature:   0.7,
+		MaxTokens:     2000,
+		ContextWindow: 10,
+		MemoryEnabled: true,
+		Sources: map[string]string{
+			"model":          SettingsSourceDefault,
+			"temperature":    SettingsSourceDefault,
+			"max_tokens":     SettingsSourceDefault,
+			"system_prompt":  SettingsSourceDefault,
+			"context_window": SettingsSourceDefault,
+			"memory_enabled": SettingsSourceDefault,
+		},
+	}
+
+	for _, layer := range layers {
+		settings := layer.Settings
+		if settings.Model != nil && ModelAllowed(*settings.Model) {
+			effective.Model = *settings.Model
+			effective.Sources["model"] = layer.Source
+		}
+		if settings.Temperature != nil {
+			effective.Temperature = *settings.Temperature
+			effective.Sources["temperature"] = layer.Source
+		}
+		if settings.MaxTokens != nil {
+			effective.MaxTokens = *settings.MaxTokens
+			effective.Sources["max_tokens"] = layer.Source
+		}
+		if settings.SystemPrompt != nil {
+			effective.SystemPrompt = *settings.SystemPrompt
+			effective.Sources["system_prompt"] = layer.Source
+		}
+		if settings.ContextWindow != nil {
+			effective.ContextWindow = *settings.ContextWindow
+			effective.Sources["context_window"] = layer.Source
+		}
+		if settings.MemoryEnabled != nil {
+			effective.MemoryEnabled = *settings.MemoryEnabled
+			effective.Sources["memory_enabled"] = layer.Source
+		}
+	}
+	return effective
+}
+
+// UserSettingsLayer 用户偏好作为一级覆盖；偏好中的模型不在可选列表中时（如旧版本的默认值）沿用服务端默认模型
+func UserSettingsLayer(preference *models.UserPreference) SettingsLayer {
+	layer := SettingsLayer{Source: SettingsSourceUser}
+	if preference == nil {
+		return layer
+	}
+	layer.Settings = models.GenerationSettings{
+		Temperature:   &preference.Temperature,
+		MaxTokens:     &preference.MaxTokens,
+		ContextWindow: &preference.ContextWindow,
+		MemoryEnabled: &preference.MemoryEnabled,
+	}
+	if preference.LLMModel != "" {
+		layer.Settings.Model = &preference.LLMModel
+	}
+	if preference.SystemPrompt != "" {
+		layer.Settings.SystemPrompt = &preference.SystemPrompt
+	}
+	return layer
+}
+
+// PersonaSettingsLayer 对话选用的人设作为一级覆盖，persona 为空时不覆盖
+func PersonaSettingsLayer(persona *models.Persona) SettingsLayer {
+	layer := SettingsLayer{Source: SettingsSourcePersona}
+	if persona != nil {
+		layer.Settings = persona.Settings
+	}
+	return layer
+}
+
+// ConversationSettingsLayer 对话级设置作为一级覆盖
+func ConversationSettingsLayer(session *models.ChatSession) SettingsLayer {
+	return SettingsLayer{Source: SettingsSourceConversation, Settings: session.Settings}
+}
+
+// AvailableModels 用户和对话可以选择的模型
+func AvailableModels() []string {
+	return config.Get().LLMModels
+}
+
+// ModelAllowed 判断模型是否在可选列表中
+func ModelAllowed(model string) bool {
+	for _, allowed := range AvailableModels() {
+		if model == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSettings 校验生成参数的取值范围，为 nil 的字段不校验
+func ValidateSettings(settings models.GenerationSettings) error {
+	if settings.Model != nil && !ModelAllowed(*settings.Model) {
+		return fmt.Errorf("%w: 不支持的模型 %s", ErrInvalidSettings, *settings.Model)
+	}
+	if settings.Temperature != nil && (*settings.Temperature < 0 || *settings.Temperature > maxSettingsTemperature) {
+		return fmt.Errorf("%w: temperature 需要在 0 到 %.0f 之间", ErrInvalidSettings, maxSettingsTemperature)
+	}
+	if settings.MaxTokens != nil && (*settings.MaxTokens < 1 || *settings.MaxTokens > maxSettingsMaxTokens) {
+		return fmt.Errorf("%w: max_tokens 需要在 1 到 %d 之间", ErrInvalidSettings, maxSettingsMaxTokens)
+	}
+	if settings.ContextWindow != nil && (*settings.ContextWindow < 1 || *settings.ContextWindow > maxSettingsContextWindow) {
+		return fmt.Errorf("%w: context_window 需要在 1 到 %d 之间", ErrInvalidSettings, maxSettingsContextWindow)
+	}
+	if settings.SystemPrompt != nil && len([]rune(*settings.SystemPrompt)) > maxSettingsSystemPrompt {
+		return fmt.Errorf("%w: system_prompt 不能超过 %d 字", ErrInvalidSettings, maxSettingsSystemPrompt)
+	}
+	return nil
+}
+
+// UpdateConversationSettings 替换对话级生成参数和选用的人设，为 nil 的字段恢复为沿用上一级；
+// 只有对话所有者可以修改，人设只能选用自己的
+func (s *ChatService) UpdateConversationSettings(userID, conversationID uuid.UUID, settings models.GenerationSettings, personaID *uuid.UUID) (*models.ChatSession, error) {
+	if err := ValidateSettings(settings); err != nil {
+		return nil, err
+	}
+	session, err := s.AuthorizeConversation(userID, conversationID, ConversationManage)
+	if err != nil {
+		return nil, err
+	}
+	if personaID != nil {
+		if _, err := findPersona(s.db, userID, *personaID); err != nil {
+			return nil, err
+		}
+	}
+
+	// 用 map 更新，确保为 nil 的字段也写入 NULL
+	err = s.db.Model(session).Updates(map[string]interface{}{
+		"setting_model":          settings.Model,
+		"setting_temperature":    settings.Temperature,
+		"setting_max_tokens":     settings.MaxTokens,
+		"setting_system_prompt":  settings.SystemPrompt,
+		"setting_context_window": settings.ContextWindow,
+		"setting_memory_enabled": settings.MemoryEnabled,
+		"persona_id":             personaID,
+	}).Error
+	if err != nil {
+		logrus.WithError(err).Error("更新对话设置失败")
+		return nil, errors.New("更新对话设置失败")
+	}
+
+	session.Settings = settings
+	session.PersonaID = personaID
+	return session, nil
+}
+
+// ConversationPersona 获取对话选用的人设，没有选用或人设已删除时返回 nil
+func (s *ChatService) ConversationPersona(session *models.ChatSession) (*models.Persona, error) {
+	if session.PersonaID == nil {
+		return nil, nil
+	}
+	var persona models.Persona
+	if err := s.db.Where("id = ?", *session.PersonaID).Limit(1).Find(&persona).Error; err != nil {
+		logrus.WithError(err).Error("查询对话人设失败")
+		return nil, errors.New("查询对话人设失败")
+	}
+	if persona.ID == uuid.Nil {
+		return nil, nil
+	}
+	return &persona, nil
+}
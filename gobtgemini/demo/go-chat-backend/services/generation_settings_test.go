@@ -0,0 +1,110 @@
+package services
+
+import (
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConversationSettings 测试对话设置的保存、校验，以及 对话 > 人设 > 用户 > 默认值 的合并顺序
+func TestConversationSettings(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	originalModels := config.Get().LLMModels
+	config.Get().LLMModels = []string{config.Get().LLMModel, "gemini-2.5-pro"}
+	t.Cleanup(func() { config.Get().LLMModels = originalModels })
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+
+	model := "gemini-2.5-pro"
+	temperature := float32(0.2)
+	memory := false
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{
+		Model:         &model,
+		Temperature:   &temperature,
+		MemoryEnabled: &memory,
+	}, nil)
+	require.NoError(t, err)
+
+	unknown := "gpt-4"
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{Model: &unknown}, nil)
+	assert.ErrorIs(t, err, ErrInvalidSettings)
+	window := 500
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{ContextWindow: &window}, nil)
+	assert.ErrorIs(t, err, ErrInvalidSettings)
+	_, err = chatService.UpdateConversationSettings(bob.ID, conversation.ID, models.GenerationSettings{}, nil)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	// 人设覆盖用户偏好，但被对话级设置覆盖
+	personaService := NewPersonaService(db)
+	personaPrompt := "人设提示"
+	personaTokens := 3000
+	personaTemperature := float32(0.7)
+	persona, err := personaService.CreatePersona(alice.ID, "翻译", models.GenerationSettings{
+		Temperature:  &personaTemperature,
+		MaxTokens:    &personaTokens,
+		SystemPrompt: &personaPrompt,
+	})
+	require.NoError(t, err)
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{
+		Model:         &model,
+		Temperature:   &temperature,
+		MemoryEnabled: &memory,
+	}, &persona.ID)
+	require.NoError(t, err)
+
+	session, err := chatService.GetChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	require.NotNil(t, session.PersonaID)
+	assert.Equal(t, persona.ID, *session.PersonaID)
+	require.NotNil(t, session.Settings.Model)
+	assert.Equal(t, model, *session.Settings.Model)
+	assert.Nil(t, session.Settings.MaxTokens)
+
+	// 用户偏好中旧版本的默认模型不在可选列表中，沿用服务端默认模型
+	preference := &models.UserPreference{
+		LLMModel:      "gpt-3.5-turbo",
+		Temperature:   0.9,
+		MaxTokens:     1000,
+		SystemPrompt:  "用户提示",
+		ContextWindow: 6,
+		MemoryEnabled: true,
+	}
+	selected, err := chatService.ConversationPersona(session)
+	require.NoError(t, err)
+	require.NotNil(t, selected)
+	effective := ResolveSettings(UserSettingsLayer(preference), PersonaSettingsLayer(selected), ConversationSettingsLayer(session))
+	assert.Equal(t, model, effective.Model)
+	assert.Equal(t, temperature, effective.Temperature)
+	assert.Equal(t, 3000, effective.MaxTokens)
+	assert.Equal(t, "人设提示", effective.SystemPrompt)
+	assert.Equal(t, 6, effective.ContextWindow)
+	assert.False(t, effective.MemoryEnabled)
+	assert.Equal(t, map[string]string{
+		"model":          SettingsSourceConversation,
+		"temperature":    SettingsSourceConversation,
+		"max_tokens":     SettingsSourcePersona,
+		"system_prompt":  SettingsSourcePersona,
+		"context_window": SettingsSourceUser,
+		"memory_enabled": SettingsSourceConversation,
+	}, effective.Sources)
+
+	defaults := ResolveSettings(UserSettingsLayer(preference))
+	assert.Equal(t, config.Get().LLMModel, defaults.Model)
+	assert.Equal(t, SettingsSourceDefault, defaults.Sources["model"])
+
+	// 替换为空设置后全部沿用上一级
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{}, nil)
+	require.NoError(t, err)
+	session, err = chatService.GetChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.GenerationSettings{}, session.Settings)
+	assert.Nil(t, session.PersonaID)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"fmt"
+	"go-chat-backend/config"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// HybridMemoryStore 在向量检索之外，用 Postgres 全文检索在 chat_messages 中查找关键词命中，
+// 再用倒数排名融合(RRF)合并两路结果，弥补向量检索对订单号、错误码等精确标识符不敏感的问题。
+// 写入、集合切换等操作直接委托给底层记忆库。
+type HybridMemoryStore struct {
+	MemoryStore
+	db               *gorm.DB
+	textSearchConfig string
+	rrfK             int
+}
+
+// NewHybridMemoryStore 包装记忆库以启用混合检索
+func NewHybridMemoryStore(store MemoryStore, db *gorm.DB) *HybridMemoryStore {
+	cfg := config.Get()
+
+	rrfK := cfg.MemoryRRFK
+	if rrfK <= 0 {
+		rrfK = 60
+	}
+
+	return &HybridMemoryStore{
+		MemoryStore:      store,
+		db:               db,
+		textSearchConfig: cfg.TextSearchConfig,
+		rrfK:             rrfK,
+	}
+}
+
+// SearchMemory 分别取向量和关键词候选，融合后返回前 limit 条；任一路失败时退化为另一路结果
+func (s *HybridMemoryStore) SearchMemory(userID uuid.UUID, query string, limit int) ([]string, error) {
+	if limit <= 0 || limit > 20 {
+		limit = 5
+	}
+	candidates := min(limit*3, 20)
+
+	vectorResults, vectorErr := s.MemoryStore.SearchMemory(userID, query, candidates)
+	if vectorErr != nil {
+		logrus.WithError(vectorErr).Warn("向量记忆检索失败，仅使用关键词结果")
+	}
+
+	keywordResults, keywordErr := s.keywordSearch(userID, query, candidates)
+	if keywordErr != nil {
+		logrus.WithError(keywordErr).Warn("关键词记忆检索失败，仅使用向量结果")
+	}
+
+	if vectorErr != nil && keywordErr != nil {
+		return nil, vectorErr
+	}
+
+	fused := reciprocalRankFusion(s.rrfK, limit, vectorResults, keywordResults)
+
+	logrus.WithFields(logrus.Fields{
+		"user_id": userID.String(),
+		"vector":  len(vectorResults),
+		"keyword": len(keywordResults),
+		"results": len(fused),
+	}).Debug("混合检索记忆完成")
+
+	return fused, nil
+}
+
+// 中日韩文字子串匹配时最多使用的查询词数量
+const maxKeywordTerms = 16
+
+// keywordSearch 在用户的历史消息中做全文检索。
+// 查询词之间取“或”，按命中词数量和密度(ts_rank_cd)排序；排除与查询完全相同的消息（即刚保存的本条消息）。
+// 分词配置不能切分查询中的中日韩文字时改用 substringSearch。
+func (s *HybridMemoryStore) keywordSearch(userID uuid.UUID, query string, limit int) ([]string, error) {
+	if needsTrigram(s.textSearchConfig, query) {
+		return s.substringSearch(userID, query, limit)
+	}
+
+	sql := fmt.Sprintf(`WITH q AS (
+	SELECT to_tsquery('%[1]s', replace(plainto_tsquery('%[1]s', ?)::text, '&', '|')) AS query
+)
+SELECT m.content
+FROM chat_messages m, q
+WHERE m.user_id = ? AND m.deleted_at IS NULL AND m.content <> ?
+	AND q.query::text <> '' AND to_tsvector('%[1]s', m.content) @@ q.query
+ORDER BY ts_rank_cd(to_tsvector('%[1]s', m.content), q.query) DESC, m.created_at DESC
+LIMIT ?`, s.textSearchConfig)
+
+	var contents []string
+	if err := s.db.Raw(sql, query, userID, query, limit).Scan(&contents).Error; err != nil {
+		return nil, fmt.Errorf("全文检索失败: %w", err)
+	}
+	return contents, nil
+}
+
+// substringSearch 用 pg_trgm 索引对 keywordTerms 拆出的查询词做子串匹配，
+// 词之间取“或”，按命中词数量排序，同样排除与查询完全相同的消息
+func (s *HybridMemoryStore) substringSearch(userID uuid.UUID, query string, limit int) ([]string, error) {
+	terms := keywordTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	matches := make([]string, 0, len(terms))
+	patterns := make([]interface{}, 0, len(terms))
+	for _, term := range terms {
+		matches = append(matches, "m.content ILIKE ?")
+		patterns = append(patterns, "%"+escapeLike(term)+"%")
+	}
+	sql := fmt.Sprintf(`SELECT m.content
+FROM chat_messages m
+WHERE m.user_id = ? AND m.deleted_at IS NULL AND m.content <> ? AND (%s)
+ORDER BY (CASE WHEN %s THEN 1 ELSE 0 END) DESC, m.created_at DESC
+LIMIT ?`, strings.Join(matches, " OR "), strings.Join(matches, " THEN 1 ELSE 0 END + CASE WHEN "))
+
+	args := append([]interface{}{userID, query}, patterns...)
+	args = append(args, patterns...)
+	args = append(args, limit)
+
+	var contents []string
+	err := s.db.Raw(sql, args...).Scan(&contents).Error
+	if err != nil {
+		return nil, fmt.Errorf("子串检索失败: %w", err)
+	}
+	return contents, nil
+}
+
+// keywordTerms 把查询拆成子串匹配用的词：按空白和标点分隔，中日韩文字连续超过 3 个字时
+// 切成相邻的 3 字片段（pg_trgm 至少需要 3 个字才能用上索引），其他文字保留 2 个字符以上的整词。
+// 结果去重，最多 maxKeywordTerms 个
+func keywordTerms(query string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(term string) {
+		key := strings.ToLower(term)
+		if len(terms) < maxKeywordTerms && !seen[key] {
+			seen[key] = true
+			terms = append(terms, term)
+		}
+	}
+
+	fields := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '-' && r != '_'
+	})
+	for _, field := range fields {
+		var run []rune
+		cjk := false
+		flush := func() {
+			switch {
+			case cjk && len(run) > 3:
+				for i := 0; i+3 <= len(run); i++ {
+					add(string(run[i : i+3]))
+				}
+			case cjk || len(run) >= 2:
+				add(string(run))
+			}
+			run = run[:0]
+		}
+		for _, r := range field {
+			isCJK := containsCJK(string(r))
+			if len(run) > 0 && isCJK != cjk {
+				flush()
+			}
+			cjk = isCJK
+			run = append(run, r)
+		}
+		flush()
+	}
+	return terms
+}
+
+// reciprocalRankFusion 按 RRF 合并多个有序结果列表：score = Σ 1/(k + rank)，rank 从 1 开始。
+// 结果按内容去重，同分时保留先出现的顺序。
+func reciprocalRankFusion(k, limit int, lists ...[]string) []string {
+	scores := make(map[string]float64)
+	var order []string
+
+	for _, list := range lists {
+		seen := make(map[string]bool, len(list))
+		for rank, item := range list {
+			key := strings.TrimSpace(item)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if _, ok := scores[key]; !ok {
+				order = append(order, key)
+			}
+			scores[key] += 1.0 / float64(k+rank+1)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	if limit > 0 && len(order) > limit {
+		order = order[:limit]
+	}
+	return order
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReciprocalRankFusion 测试两路结果融合后，两路都命中的内容排在前面
+func TestReciprocalRankFusion(t *testing.T) {
+	vector := []string{"A", "B", "C"}
+	keyword := []string{"订单 ORD-1024 未发货", "B"}
+
+	fused := reciprocalRankFusion(60, 3, vector, keyword)
+	assert.Equal(t, []string{"B", "A", "订单 ORD-1024 未发货"}, fused)
+}
+
+// TestReciprocalRankFusionDedup 测试同一列表内的重复内容只计一次
+func TestReciprocalRankFusionDedup(t *testing.T) {
+	fused := reciprocalRankFusion(60, 0, []string{"A", " A ", "B"}, nil)
+	assert.Equal(t, []string{"A", "B"}, fused)
+}
+
+// TestKeywordTerms 测试中日韩文字切成 3 字片段，其他文字按整词保留
+func TestKeywordTerms(t *testing.T) {
+	assert.Equal(t, []string{"我的订", "的订单", "订单号", "ORD-1024"}, keywordTerms("我的订单号 ORD-1024？"))
+	assert.Equal(t, []string{"报错", "E42"}, keywordTerms("报错E42"))
+	assert.Equal(t, []string{"Go", "错误码"}, keywordTerms("Go 错误码, go a"))
+	assert.Empty(t, keywordTerms("a ！"))
+	assert.Len(t, keywordTerms("一二三四五六七八九十甲乙丙丁戊己庚辛壬癸子丑"), maxKeywordTerms)
+}
+
+// vectorMemoryStore 返回固定的向量检索结果，并记录请求的候选数量
+type vectorMemoryStore struct {
+	MemoryStore
+	results []string
+	err     error
+	limits  []int
+}
+
+func (f *vectorMemoryStore) SearchMemory(userID uuid.UUID, query string, limit int) ([]string, error) {
+	f.limits = append(f.limits, limit)
+	return f.results, f.err
+}
+
+// TestHybridMemoryFallback 测试一路检索失败时退化为另一路结果，两路都失败时返回错误。
+// SQLite 不支持全文检索，关键词一路总是失败
+func TestHybridMemoryFallback(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice")
+	vector := &vectorMemoryStore{results: []string{"A", "B", "C"}}
+	store := &HybridMemoryStore{MemoryStore: vector, db: db, textSearchConfig: "simple", rrfK: 60}
+
+	results, err := store.SearchMemory(user.ID, "订单", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A", "B"}, results)
+
+	// 未指定或超出范围的 limit 使用默认 5 条，候选数量为 3 倍且不超过 20
+	results, err = store.SearchMemory(user.ID, "order", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A", "B", "C"}, results)
+	_, err = store.SearchMemory(user.ID, "order", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{6, 15, 20}, vector.limits)
+
+	vector.err = errors.New("向量库不可用")
+	_, err = store.SearchMemory(user.ID, "order", 5)
+	assert.ErrorIs(t, err, vector.err)
+}
+
+// TestHybridKeywordSearch 测试关键词一路：英文标识符走全文检索，中日韩文字走子串匹配，
+// 只返回当前用户未删除的消息，并排除与查询相同的消息。需要 Postgres，见 newPostgresTestDB
+func TestHybridKeywordSearch(t *testing.T) {
+	db := newPostgresTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE chat_messages (
+	id uuid PRIMARY KEY,
+	user_id uuid NOT NULL,
+	content text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	deleted_at timestamptz
+)`).Error)
+
+	alice, bob := uuid.New(), uuid.New()
+	insert := func(userID uuid.UUID, content string, deleted bool) {
+		var deletedAt *time.Time
+		if deleted {
+			now := time.Now()
+			deletedAt = &now
+		}
+		require.NoError(t, db.Exec("INSERT INTO chat_messages (id, user_id, content, deleted_at) VALUES (?, ?, ?, ?)",
+			uuid.New(), userID, content, deletedAt).Error)
+	}
+	insert(alice, "订单 ORD-1024 还没有发货", false)
+	insert(alice, "我的订单号是 ORD-2048", false)
+	insert(alice, "今天天气不错", false)
+	insert(alice, "已删除的订单号 ORD-1024", true)
+	insert(bob, "bob 的订单号 ORD-1024", false)
+	insert(alice, "我的订单号是多少", false)
+
+	store := &HybridMemoryStore{db: db, textSearchConfig: "simple", rrfK: 60}
+
+	// 查询词之间取“或”，ORD-2048 只命中 ord 一个词，排在后面
+	results, err := store.keywordSearch(alice, "ORD-1024 status", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"订单 ORD-1024 还没有发货", "我的订单号是 ORD-2048"}, results)
+
+	// 与查询完全相同的消息（刚保存的本条）不返回
+	results, err = store.keywordSearch(alice, "我的订单号是多少", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"我的订单号是 ORD-2048"}, results)
+
+	results, err = store.keywordSearch(alice, "天气", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"今天天气不错"}, results)
+}
@@ -0,0 +1,421 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 导入来源
+const (
+	ImportSourceAuto    = "auto"
+	ImportSourceNative  = "native"  // 本服务的 JSON 导出（单个文件或批量导出的 zip）
+	ImportSourceChatGPT = "chatgpt" // ChatGPT 导出的 conversations.json（或包含它的 zip）
+)
+
+// 导入解析相关错误
+var (
+	ErrUnrecognizedImport = errors.New("无法识别的导入文件，支持本服务导出的 JSON/zip 和 ChatGPT 的 conversations.json")
+	ErrImportTooLarge     = errors.New("压缩包解压后的内容超过大小限制")
+)
+
+// maxImportExtractedBytes 单个压缩包解压后的总大小上限，防止压缩炸弹耗尽内存
+var maxImportExtractedBytes int64 = 256 << 20
+
+// importRoles 导入时允许的消息角色
+var importRoles = map[string]bool{"user": true, "assistant": true, "system": true}
+
+// importedConversation 解析后与来源无关的对话
+type importedConversation struct {
+	Title        string
+	Description  string
+	Pinned       bool
+	Archived     bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Messages     []importedMessage // 父消息总在子消息之前
+	ActiveLeafID string            // 来源中的消息ID，为空时取最后一条
+}
+
+// importedMessage 解析后的消息，ID 和 ParentID 为来源中的标识，导入时重新生成
+type importedMessage struct {
+	ID        string
+	ParentID  string
+	Role      string
+	Content   string
+	Metadata  json.RawMessage
+	CreatedAt time.Time
+}
+
+// parseImport 解析上传的文件，source 为 auto 时根据内容识别来源，返回实际来源
+func parseImport(data []byte, source string) ([]importedConversation, string, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseImportArchive(data, source)
+	}
+
+	if source == ImportSourceAuto {
+		source = detectImportSource(data)
+	}
+	switch source {
+	case ImportSourceNative:
+		conversations, err := parseNativeImport(data)
+		return conversations, source, err
+	case ImportSourceChatGPT:
+		conversations, err := parseChatGPTImport(data)
+		return conversations, source, err
+	default:
+		return nil, "", ErrUnrecognizedImport
+	}
+}
+
+// parseImportArchive 解析 zip：含 manifest.json 的为本服务批量导出，含 conversations.json 的为 ChatGPT 导出
+func parseImportArchive(data []byte, source string) ([]importedConversation, string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", fmt.Errorf("读取压缩包失败: %w", err)
+	}
+
+	budget := maxImportExtractedBytes
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	if f := findZipFile(files, "conversations.json"); f != nil && source != ImportSourceNative {
+		content, err := readZipFile(f, &budget)
+		if err != nil {
+			return nil, "", err
+		}
+		conversations, err := parseChatGPTImport(content)
+		return conversations, ImportSourceChatGPT, err
+	}
+
+	manifestFile, ok := files["manifest.json"]
+	if !ok || source == ImportSourceChatGPT {
+		return nil, "", ErrUnrecognizedImport
+	}
+	content, err := readZipFile(manifestFile, &budget)
+	if err != nil {
+		return nil, "", err
+	}
+	var manifest exportManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, "", fmt.Errorf("解析导出清单失败: %w", err)
+	}
+	if manifest.Schema != ExportSchema || manifest.Format != ExportFormatJSON {
+		return nil, "", errors.New("只能导入 JSON 格式的批量导出")
+	}
+
+	var conversations []importedConversation
+	for _, entry := range manifest.Conversations {
+		f, ok := files[entry.File]
+		if !ok {
+			return nil, "", fmt.Errorf("压缩包中缺少文件 %s", entry.File)
+		}
+		content, err := readZipFile(f, &budget)
+		if err != nil {
+			return nil, "", err
+		}
+		parsed, err := parseNativeImport(content)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", entry.File, err)
+		}
+		conversations = append(conversations, parsed...)
+	}
+	return conversations, ImportSourceNative, nil
+}
+
+// findZipFile 按文件名查找，允许位于子目录中
+func findZipFile(files map[string]*zip.File, name string) *zip.File {
+	if f, ok := files[name]; ok {
+		return f
+	}
+	for fullName, f := range files {
+		if path.Base(fullName) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// readZipFile 读取压缩包中的文件，budget 为整个压缩包剩余可解压的字节数。
+// 不信任文件头中声明的大小，按实际解压出的字节计数。
+func readZipFile(f *zip.File, budget *int64) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %w", f.Name, err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(io.LimitReader(r, *budget+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %w", f.Name, err)
+	}
+	if int64(len(content)) > *budget {
+		return nil, ErrImportTooLarge
+	}
+	*budget -= int64(len(content))
+	return content, nil
+}
+
+// detectImportSource 根据 JSON 结构识别来源
+func detectImportSource(data []byte) string {
+	var probe struct {
+		Schema  string          `json:"schema"`
+		Mapping json.RawMessage `json:"mapping"`
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil || len(items) == 0 {
+			return ""
+		}
+		trimmed = items[0]
+	}
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return ""
+	}
+
+	switch {
+	case probe.Schema == ExportSchema:
+		return ImportSourceNative
+	case len(probe.Mapping) > 0:
+		return ImportSourceChatGPT
+	default:
+		return ""
+	}
+}
+
+// parseNativeImport 解析本服务的 JSON 导出，可以是单个对话或对话数组
+func parseNativeImport(data []byte) ([]importedConversation, error) {
+	var exports []ConversationExport
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		if err := json.Unmarshal(trimmed, &exports); err != nil {
+			return nil, fmt.Errorf("解析导出文件失败: %w", err)
+		}
+	} else {
+		var export ConversationExport
+		if err := json.Unmarshal(trimmed, &export); err != nil {
+			return nil, fmt.Errorf("解析导出文件失败: %w", err)
+		}
+		exports = append(exports, export)
+	}
+
+	conversations := make([]importedConversation, 0, len(exports))
+	for _, export := range exports {
+		if export.Schema != ExportSchema {
+			return nil, ErrUnrecognizedImport
+		}
+		if export.Version > ExportSchemaVersion {
+			return nil, fmt.Errorf("不支持的导出版本 %d，当前支持到 %d", export.Version, ExportSchemaVersion)
+		}
+
+		conv := export.Conversation
+		imported := importedConversation{
+			Title:       conv.Title,
+			Description: conv.Description,
+			Pinned:      conv.Pinned,
+			Archived:    conv.Archived,
+			CreatedAt:   conv.CreatedAt,
+			UpdatedAt:   conv.UpdatedAt,
+		}
+		if conv.ActiveLeafID != nil {
+			imported.ActiveLeafID = conv.ActiveLeafID.String()
+		}
+		for _, msg := range conv.Messages {
+			if !importRoles[msg.Role] {
+				return nil, fmt.Errorf("消息 %s 的角色 %q 无效，仅支持 user、assistant 和 system", msg.ID, msg.Role)
+			}
+			item := importedMessage{
+				ID:        msg.ID.String(),
+				Role:      msg.Role,
+				Content:   msg.Content,
+				Metadata:  msg.Metadata,
+				CreatedAt: msg.CreatedAt,
+			}
+			if msg.ParentID != nil {
+				item.ParentID = msg.ParentID.String()
+			}
+			imported.Messages = append(imported.Messages, item)
+		}
+		imported.Messages = orderImportedMessages(imported.Messages)
+		conversations = append(conversations, imported)
+	}
+	return conversations, nil
+}
+
+// chatGPTConversation ChatGPT 导出中的对话，消息以 mapping 中的节点组成树
+type chatGPTConversation struct {
+	ID          string                 `json:"id"`
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	UpdateTime  float64                `json:"update_time"`
+	CurrentNode string                 `json:"current_node"`
+	IsArchived  bool                   `json:"is_archived"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	ID      string          `json:"id"`
+	Parent  string          `json:"parent"`
+	Message *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	ID     string `json:"id"`
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug        string `json:"model_slug"`
+		IsVisuallyHidden bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// parseChatGPTImport 解析 ChatGPT 的 conversations.json。
+// 只导入用户和助手的文本消息，系统、工具消息和隐藏消息被跳过，其子消息挂到最近的已导入祖先。
+func parseChatGPTImport(data []byte) ([]importedConversation, error) {
+	var raw []chatGPTConversation
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析 conversations.json 失败: %w", err)
+	}
+
+	conversations := make([]importedConversation, 0, len(raw))
+	for _, conv := range raw {
+		imported := importedConversation{
+			Title:     conv.Title,
+			Archived:  conv.IsArchived,
+			CreatedAt: unixSeconds(conv.CreateTime),
+			UpdatedAt: unixSeconds(conv.UpdateTime),
+		}
+
+		kept := make(map[string]bool, len(conv.Mapping))
+		for id, node := range conv.Mapping {
+			if text, ok := chatGPTText(node.Message); ok {
+				kept[id] = true
+				item := importedMessage{
+					ID:        id,
+					Role:      node.Message.Author.Role,
+					Content:   text,
+					CreatedAt: imported.CreatedAt,
+				}
+				if node.Message.CreateTime != nil {
+					item.CreatedAt = unixSeconds(*node.Message.CreateTime)
+				}
+				metadata := map[string]interface{}{"source": ImportSourceChatGPT, "source_id": id}
+				if node.Message.Metadata.ModelSlug != "" {
+					metadata["model"] = node.Message.Metadata.ModelSlug
+				}
+				item.Metadata, _ = json.Marshal(map[string]interface{}{"import": metadata})
+				imported.Messages = append(imported.Messages, item)
+			}
+		}
+
+		// 父节点被跳过时向上找最近的已导入祖先
+		for i := range imported.Messages {
+			imported.Messages[i].ParentID = nearestKeptNode(conv.Mapping, kept, conv.Mapping[imported.Messages[i].ID].Parent)
+		}
+		imported.ActiveLeafID = nearestKeptNode(conv.Mapping, kept, conv.CurrentNode)
+
+		imported.Messages = orderImportedMessages(imported.Messages)
+		conversations = append(conversations, imported)
+	}
+	return conversations, nil
+}
+
+// nearestKeptNode 从 id 开始向上找第一个已导入的节点，最多走 len(mapping) 步以防数据成环
+func nearestKeptNode(mapping map[string]chatGPTNode, kept map[string]bool, id string) string {
+	for steps := 0; id != "" && steps <= len(mapping); steps++ {
+		if kept[id] {
+			return id
+		}
+		id = mapping[id].Parent
+	}
+	return ""
+}
+
+// chatGPTText 提取可导入的文本内容
+func chatGPTText(msg *chatGPTMessage) (string, bool) {
+	if msg == nil || msg.Metadata.IsVisuallyHidden {
+		return "", false
+	}
+	if msg.Author.Role != "user" && msg.Author.Role != "assistant" {
+		return "", false
+	}
+
+	var parts []string
+	for _, part := range msg.Content.Parts {
+		var text string
+		// 图片等非文本片段是对象，跳过
+		if err := json.Unmarshal(part, &text); err == nil && strings.TrimSpace(text) != "" {
+			parts = append(parts, text)
+		}
+	}
+	if len(parts) == 0 && msg.Content.Text != "" {
+		parts = append(parts, msg.Content.Text)
+	}
+
+	text := strings.TrimSpace(strings.Join(parts, "\n"))
+	return text, text != ""
+}
+
+// unixSeconds 将带小数的 Unix 秒转换为时间
+func unixSeconds(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+// orderImportedMessages 按创建时间排序，并保证父消息在子消息之前；
+// 父消息不存在的消息作为根消息
+func orderImportedMessages(messages []importedMessage) []importedMessage {
+	byID := make(map[string]bool, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = true
+	}
+
+	children := make(map[string][]importedMessage)
+	for _, msg := range messages {
+		if msg.ParentID != "" && !byID[msg.ParentID] {
+			msg.ParentID = ""
+		}
+		children[msg.ParentID] = append(children[msg.ParentID], msg)
+	}
+	for _, list := range children {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].CreatedAt.Equal(list[j].CreatedAt) {
+				return list[i].ID < list[j].ID
+			}
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		})
+	}
+
+	ordered := make([]importedMessage, 0, len(messages))
+	var visit func(parent string)
+	visit = func(parent string) {
+		for _, msg := range children[parent] {
+			ordered = append(ordered, msg)
+			visit(msg.ID)
+		}
+	}
+	visit("")
+	return ordered
+}
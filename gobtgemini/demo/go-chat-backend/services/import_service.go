@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// 导入任务相关错误
+var (
+	ErrImportJobNotFound   = errors.New("导入任务不存在")
+	ErrEmptyImportFile     = errors.New("导入文件为空")
+	ErrInvalidImportSource = errors.New("不支持的导入来源，仅支持 auto、native 和 chatgpt")
+)
+
+// 运行中的任务超过该时间没有进度时视为所在实例已退出，可被重新领取
+const importStaleAfter = 5 * time.Minute
+
+// ImportService 对话导入服务。上传内容先保存为导入任务，由后台逐个对话导入，
+// 每个对话在一个事务中写入；实例重启后从已完成的对话数继续。
+type ImportService struct {
+	db          *gorm.DB
+	memoryQueue *MemoryQueue // 可为空，为空时不回填记忆
+
+	pollInterval time.Duration
+	wake         chan struct{}
+}
+
+// NewImportService 创建导入服务
+func NewImportService(db *gorm.DB, memoryQueue *MemoryQueue) *ImportService {
+	return &ImportService{
+		db:           db,
+		memoryQueue:  memoryQueue,
+		pollInterval: 10 * time.Second,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// CreateImportJob 保存上传内容并创建导入任务，任务在后台执行
+func (s *ImportService) CreateImportJob(userID uuid.UUID, fileName string, data []byte, source string, backfillMemory bool) (*models.ImportJob, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyImportFile
+	}
+	if source == "" {
+		source = ImportSourceAuto
+	}
+	if source != ImportSourceAuto && source != ImportSourceNative && source != ImportSourceChatGPT {
+		return nil, ErrInvalidImportSource
+	}
+
+	job := &models.ImportJob{
+		ID:             uuid.New(),
+		UserID:         userID,
+		Source:         source,
+		FileName:       fileName,
+		Status:         models.ImportPending,
+		BackfillMemory: backfillMemory && s.memoryQueue != nil,
+		Payload:        data,
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		logrus.WithError(err).Error("创建导入任务失败")
+		return nil, errors.New("创建导入任务失败")
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id":  job.ID,
+		"user_id": userID,
+		"source":  source,
+		"size":    len(data),
+	}).Info("已创建导入任务")
+
+	return job, nil
+}
+
+// GetImportJob 获取用户的导入任务
+func (s *ImportService) GetImportJob(userID, jobID uuid.UUID) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := s.db.Omit("payload").Where("id = ? AND user_id = ?", jobID, userID).First(&job).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImportJobNotFound
+		}
+		logrus.WithError(err).Error("查询导入任务失败")
+		return nil, errors.New("查询导入任务失败")
+	}
+	return &job, nil
+}
+
+// ListImportJobs 用户最近的导入任务
+func (s *ImportService) ListImportJobs(userID uuid.UUID, limit int) ([]models.ImportJob, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var jobs []models.ImportJob
+	err := s.db.Omit("payload").Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&jobs).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取导入任务列表失败")
+		return nil, errors.New("获取导入任务列表失败")
+	}
+	return jobs, nil
+}
+
+// Start 启动后台导入，ctx 取消后退出
+func (s *ImportService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			for s.runNext() {
+				if ctx.Err() != nil {
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.wake:
+			}
+		}
+	}()
+}
+
+// runNext 领取并执行一个待处理的任务，没有任务时返回 false
+func (s *ImportService) runNext() bool {
+	var job models.ImportJob
+	err := s.db.Omit("payload").
+		Where("status = ? OR (status = ? AND updated_at < ?)", models.ImportPending, models.ImportRunning, time.Now().Add(-importStaleAfter)).
+		Order("created_at ASC").
+		First(&job).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logrus.WithError(err).Error("查询导入任务失败")
+		}
+		return false
+	}
+
+	// 以状态和更新时间作为条件领取，避免多个实例重复执行
+	now := time.Now()
+	result := s.db.Model(&models.ImportJob{}).
+		Where("id = ? AND status = ? AND updated_at = ?", job.ID, job.Status, job.UpdatedAt).
+		Updates(map[string]interface{}{"status": models.ImportRunning, "started_at": now})
+	if result.Error != nil {
+		logrus.WithError(result.Error).Error("领取导入任务失败")
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return true // 已被其他实例领取，继续找下一个
+	}
+
+	if err := s.RunImport(job.ID); err != nil {
+		logrus.WithError(err).WithField("job_id", job.ID).Warn("导入任务失败")
+	}
+	return true
+}
+
+// RunImport 执行导入任务：解析上传内容，跳过已导入的对话，逐个写入
+func (s *ImportService) RunImport(jobID uuid.UUID) error {
+	var job models.ImportJob
+	if err := s.db.Where("id = ?", jobID).First(&job).Error; err != nil {
+		return fmt.Errorf("查询导入任务失败: %w", err)
+	}
+
+	conversations, source, err := parseImport(job.Payload, job.Source)
+	if err != nil {
+		return s.finish(&job, models.ImportFailed, err)
+	}
+
+	s.db.Model(&job).Updates(map[string]interface{}{
+		"source":              source,
+		"total_conversations": len(conversations),
+	})
+
+	logger := logrus.WithFields(logrus.Fields{"job_id": job.ID, "source": source})
+	logger.WithField("conversations", len(conversations)).Info("开始导入对话")
+
+	for i := job.ImportedConversations + job.SkippedConversations; i < len(conversations); i++ {
+		// 对话和导入进度在同一事务中提交，中断后续跑不会重复导入
+		var messages []*models.ChatMessage
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			_, messages, err = importConversation(tx, job.UserID, &conversations[i])
+			if err != nil {
+				return err
+			}
+			return tx.Model(&job).Updates(map[string]interface{}{
+				"imported_conversations": job.ImportedConversations + 1,
+				"imported_messages":      job.ImportedMessages + len(messages),
+			}).Error
+		})
+		if err != nil {
+			logger.WithError(err).WithField("index", i).Warn("导入对话失败，已跳过")
+			job.SkippedConversations++
+			err = s.db.Model(&job).Updates(map[string]interface{}{
+				"skipped_conversations": job.SkippedConversations,
+				"last_error":            fmt.Sprintf("第 %d 个对话: %v", i+1, err),
+			}).Error
+			if err != nil {
+				return fmt.Errorf("更新导入进度失败: %w", err)
+			}
+			continue
+		}
+
+		job.ImportedConversations++
+		job.ImportedMessages += len(messages)
+		if job.BackfillMemory && s.memoryQueue != nil && len(messages) > 0 {
+			if err := s.memoryQueue.Enqueue(messages...); err != nil {
+				logger.WithError(err).Warn("导入的消息加入记忆队列失败")
+			}
+		}
+	}
+
+	return s.finish(&job, models.ImportCompleted, nil)
+}
+
+// finish 结束任务并清空暂存的上传内容
+func (s *ImportService) finish(job *models.ImportJob, status string, cause error) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      status,
+		"finished_at": now,
+		"payload":     nil,
+	}
+	if cause != nil {
+		updates["last_error"] = cause.Error()
+	}
+	if err := s.db.Model(job).Updates(updates).Error; err != nil {
+		return fmt.Errorf("更新导入任务失败: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"job_id":   job.ID,
+		"status":   status,
+		"imported": job.ImportedConversations,
+		"skipped":  job.SkippedConversations,
+		"messages": job.ImportedMessages,
+	}).Info("导入任务结束")
+	return cause
+}
+
+// importConversation 创建对话和消息，保留原始时间和分支结构
+func importConversation(tx *gorm.DB, userID uuid.UUID, conv *importedConversation) (*models.ChatSession, []*models.ChatMessage, error) {
+	now := time.Now()
+	createdAt := conv.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	updatedAt := conv.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = createdAt
+	}
+	title := conv.Title
+	if title == "" {
+		title = "导入的对话 " + createdAt.Format("01-02 15:04")
+	}
+	if runes := []rune(title); len(runes) > 200 {
+		title = string(runes[:200])
+	}
+
+	session := &models.ChatSession{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Title:       title,
+		Description: conv.Description,
+		IsActive:    true,
+		Pinned:      conv.Pinned,
+		Archived:    conv.Archived,
+		CreatedAt:   createdAt,
+		UpdatedAt:   updatedAt,
+	}
+
+	ids := make(map[string]uuid.UUID, len(conv.Messages))
+	messages := make([]*models.ChatMessage, 0, len(conv.Messages))
+	for _, item := range conv.Messages {
+		msgTime := item.CreatedAt
+		if msgTime.IsZero() {
+			msgTime = createdAt
+		}
+		msg := &models.ChatMessage{
+			ID:             uuid.New(),
+			UserID:         userID,
+			ConversationID: session.ID,
+			Content:        item.Content,
+			Role:           item.Role,
+			MessageID:      uuid.New(),
+			CreatedAt:      msgTime,
+			UpdatedAt:      msgTime,
+		}
+		if parent, ok := ids[item.ParentID]; ok {
+			msg.ParentID = &parent
+		}
+		if len(item.Metadata) > 0 {
+			msg.Metadata = datatypes.JSON(item.Metadata)
+		}
+		ids[item.ID] = msg.ID
+		messages = append(messages, msg)
+	}
+
+	if leaf, ok := ids[conv.ActiveLeafID]; ok {
+		session.ActiveLeafID = &leaf
+	} else if len(messages) > 0 {
+		session.ActiveLeafID = &messages[len(messages)-1].ID
+	}
+
+	applySessionActivity(session, messages)
+	if err := tx.Create(session).Error; err != nil {
+		return nil, nil, fmt.Errorf("写入对话失败: %w", err)
+	}
+	if len(messages) > 0 {
+		if err := tx.CreateInBatches(messages, 200).Error; err != nil {
+			return nil, nil, fmt.Errorf("写入消息失败: %w", err)
+		}
+	}
+	return session, messages, nil
+}
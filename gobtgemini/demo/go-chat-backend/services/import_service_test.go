@@ -0,0 +1,154 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"go-chat-backend/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chatGPTSample ChatGPT conversations.json 片段：包含隐藏的系统消息和一次重新生成
+const chatGPTSample = `[{
+	"title": "旅行计划",
+	"create_time": 1705300000.5,
+	"update_time": 1705300100,
+	"current_node": "a2",
+	"mapping": {
+		"root": {"id": "root", "parent": null, "message": null},
+		"sys": {"id": "sys", "parent": "root", "message": {
+			"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]},
+			"metadata": {"is_visually_hidden_from_conversation": true}}},
+		"u1": {"id": "u1", "parent": "sys", "message": {
+			"author": {"role": "user"}, "create_time": 1705300010,
+			"content": {"content_type": "text", "parts": ["去杭州玩三天怎么安排？"]}}},
+		"a1": {"id": "a1", "parent": "u1", "message": {
+			"author": {"role": "assistant"}, "create_time": 1705300020,
+			"content": {"content_type": "text", "parts": ["第一天西湖……"]}, "metadata": {"model_slug": "gpt-4"}}},
+		"a2": {"id": "a2", "parent": "u1", "message": {
+			"author": {"role": "assistant"}, "create_time": 1705300030,
+			"content": {"content_type": "multimodal_text", "parts": [{"content_type": "image_asset_pointer"}, "重新规划：第一天灵隐寺……"]}}}
+	}
+}]`
+
+// TestParseChatGPTImport 测试解析 ChatGPT 导出：跳过系统消息，保留分支和当前节点
+func TestParseChatGPTImport(t *testing.T) {
+	conversations, source, err := parseImport([]byte(chatGPTSample), ImportSourceAuto)
+	require.NoError(t, err)
+	assert.Equal(t, ImportSourceChatGPT, source)
+	require.Len(t, conversations, 1)
+
+	conv := conversations[0]
+	assert.Equal(t, "旅行计划", conv.Title)
+	assert.Equal(t, time.Unix(1705300000, 500000000).UTC(), conv.CreatedAt)
+	assert.Equal(t, "a2", conv.ActiveLeafID)
+	require.Len(t, conv.Messages, 3)
+	assert.Equal(t, "u1", conv.Messages[0].ID)
+	assert.Empty(t, conv.Messages[0].ParentID)
+	assert.Equal(t, "u1", conv.Messages[1].ParentID)
+	assert.Equal(t, "u1", conv.Messages[2].ParentID)
+	assert.Equal(t, "重新规划：第一天灵隐寺……", conv.Messages[2].Content)
+	assert.Contains(t, string(conv.Messages[1].Metadata), "gpt-4")
+
+	_, _, err = parseImport([]byte(`{"foo": 1}`), ImportSourceAuto)
+	assert.ErrorIs(t, err, ErrUnrecognizedImport)
+}
+
+// TestImportRoundTrip 测试导出的 JSON 重新导入后保留分支、时间和当前分支
+func TestImportRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	exportService := NewExportService(db)
+	importService := NewImportService(db, nil)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "退款问题")
+	require.NoError(t, err)
+	q1, err := chatService.SendMessage(alice.ID, conversation.ID, "问题", "user", nil)
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "回答", "assistant", nil)
+	require.NoError(t, err)
+	_, err = chatService.EditMessage(alice.ID, conversation.ID, q1.ID, "修改后的问题")
+	require.NoError(t, err)
+
+	data, err := exportService.ExportConversation(alice.ID, conversation.ID, ExportFormatJSON)
+	require.NoError(t, err)
+
+	job, err := importService.CreateImportJob(bob.ID, "export.json", data, ImportSourceAuto, false)
+	require.NoError(t, err)
+	require.NoError(t, importService.RunImport(job.ID))
+
+	job, err = importService.GetImportJob(bob.ID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ImportCompleted, job.Status)
+	assert.Equal(t, ImportSourceNative, job.Source)
+	assert.Equal(t, 1, job.ImportedConversations)
+	assert.Equal(t, 3, job.ImportedMessages)
+
+	var payload models.ImportJob
+	require.NoError(t, db.Where("id = ?", job.ID).First(&payload).Error)
+	assert.Empty(t, payload.Payload)
+
+	sessions, _, err := chatService.GetChatSessions(bob.ID, ConversationFilter{}, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "退款问题", sessions[0].Title)
+	assert.True(t, conversation.CreatedAt.Equal(sessions[0].CreatedAt))
+
+	history, _, err := chatService.GetOneConversationHistory(bob.ID, sessions[0].ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "修改后的问题", history[0].Content)
+	assert.Equal(t, 2, history[0].SiblingCount)
+
+	_, err = importService.GetImportJob(alice.ID, job.ID)
+	assert.ErrorIs(t, err, ErrImportJobNotFound)
+}
+
+// TestImportArchiveLimit 测试压缩包解压后超过上限时导入任务失败
+func TestImportArchiveLimit(t *testing.T) {
+	db := newTestDB(t)
+	importService := NewImportService(db, nil)
+	alice := createTestUser(t, db, "alice")
+
+	original := maxImportExtractedBytes
+	maxImportExtractedBytes = 1024
+	t.Cleanup(func() { maxImportExtractedBytes = original })
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("conversations.json")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(strings.Repeat(" ", 4096) + chatGPTSample))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	_, _, err = parseImport(buf.Bytes(), ImportSourceAuto)
+	assert.ErrorIs(t, err, ErrImportTooLarge)
+
+	job, err := importService.CreateImportJob(alice.ID, "chatgpt.zip", buf.Bytes(), ImportSourceAuto, false)
+	require.NoError(t, err)
+	assert.ErrorIs(t, importService.RunImport(job.ID), ErrImportTooLarge)
+
+	job, err = importService.GetImportJob(alice.ID, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ImportFailed, job.Status)
+	assert.Equal(t, ErrImportTooLarge.Error(), job.LastError)
+}
+
+// TestParseNativeImportRole 测试本服务导出中只接受 user、assistant 和 system 角色
+func TestParseNativeImportRole(t *testing.T) {
+	export := `{"schema": "` + ExportSchema + `", "version": 1, "conversation": {"title": "t", "messages": [
+		{"id": "6f1c1f9e-8f5e-4d4c-9a51-1d2f3e4a5b6c", "role": "%s", "content": "hi"}]}}`
+
+	_, _, err := parseImport([]byte(strings.Replace(export, "%s", "user", 1)), ImportSourceAuto)
+	require.NoError(t, err)
+
+	_, _, err = parseImport([]byte(strings.Replace(export, "%s", "tool", 1)), ImportSourceAuto)
+	assert.ErrorContains(t, err, "角色")
+}
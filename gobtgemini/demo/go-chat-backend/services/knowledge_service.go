@@ -0,0 +1,540 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 知识库相关错误
+var (
+	ErrKnowledgeBaseNotFound     = errors.New("知识库不存在或无权访问")
+	ErrKnowledgeDocumentNotFound = errors.New("文档不存在或无权访问")
+	ErrUnsupportedDocumentFormat = errors.New("不支持的文档格式，仅支持 Markdown、纯文本、HTML 和 PDF")
+)
+
+// chroma 单次写入的分块数量
+const knowledgeAddBatchSize = 64
+
+// 处理中的文档每写入一批分块更新一次 updated_at，超过这段时间没有更新视为处理已中断（实例重启或崩溃）
+const knowledgeStaleProcessing = 10 * time.Minute
+
+// errDocumentGone 处理过程中文档已被删除或已被标记为失败，处理结果应丢弃
+var errDocumentGone = errors.New("文档已删除或已中断")
+
+// KnowledgeService 知识库服务
+type KnowledgeService struct {
+	db            *gorm.DB
+	chromaService *ChromaService
+}
+
+// NewKnowledgeService 创建知识库服务
+func NewKnowledgeService(db *gorm.DB, chromaService *ChromaService) *KnowledgeService {
+	return &KnowledgeService{
+		db:            db,
+		chromaService: chromaService,
+	}
+}
+
+// KnowledgeHit 检索命中的知识库分块
+type KnowledgeHit struct {
+	KnowledgeBaseID string  `json:"knowledge_base_id"`
+	DocumentID      string  `json:"document_id"`
+	DocumentName    string  `json:"document_name"`
+	ChunkIndex      int     `json:"chunk_index"`
+	Location        string  `json:"location,omitempty"`
+	Content         string  `json:"-"`
+	Distance        float64 `json:"distance"`
+}
+
+// CreateKnowledgeBase 创建知识库
+func (s *KnowledgeService) CreateKnowledgeBase(userID uuid.UUID, name, description string) (*models.KnowledgeBase, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("知识库名称不能为空")
+	}
+
+	kb := &models.KnowledgeBase{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Name:        name,
+		Description: description,
+	}
+
+	if err := s.db.Create(kb).Error; err != nil {
+		logrus.WithError(err).Error("创建知识库失败")
+		return nil, errors.New("创建知识库失败")
+	}
+
+	return kb, nil
+}
+
+// ListKnowledgeBases 获取用户的知识库列表
+func (s *KnowledgeService) ListKnowledgeBases(userID uuid.UUID) ([]models.KnowledgeBase, error) {
+	var kbs []models.KnowledgeBase
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&kbs).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取知识库列表失败")
+		return nil, errors.New("获取知识库列表失败")
+	}
+	return kbs, nil
+}
+
+// GetKnowledgeBase 获取用户拥有的知识库
+func (s *KnowledgeService) GetKnowledgeBase(userID, kbID uuid.UUID) (*models.KnowledgeBase, error) {
+	var kb models.KnowledgeBase
+	err := s.db.Where("id = ? AND user_id = ?", kbID, userID).First(&kb).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrKnowledgeBaseNotFound
+		}
+		logrus.WithError(err).Error("获取知识库失败")
+		return nil, errors.New("获取知识库失败")
+	}
+	return &kb, nil
+}
+
+// DeleteKnowledgeBase 删除知识库及其文档、分块和对话关联
+func (s *KnowledgeService) DeleteKnowledgeBase(userID, kbID uuid.UUID) error {
+	if _, err := s.GetKnowledgeBase(userID, kbID); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("knowledge_base_id = ?", kbID).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("knowledge_base_id = ?", kbID).Delete(&models.KnowledgeDocument{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("knowledge_base_id = ?", kbID).Delete(&models.ConversationKnowledgeBase{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.KnowledgeBase{}, "id = ?", kbID).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("删除知识库失败")
+		return errors.New("删除知识库失败")
+	}
+
+	if s.chromaService != nil {
+		if err := s.chromaService.DeleteKnowledge("knowledge_base_id", kbID.String()); err != nil {
+			logrus.WithError(err).WithField("knowledge_base_id", kbID).Warn("删除知识库向量失败")
+		}
+	}
+
+	return nil
+}
+
+// UploadDocument 保存文档记录并在后台完成解析、分块和向量化
+func (s *KnowledgeService) UploadDocument(userID, kbID uuid.UUID, fileName string, data []byte) (*models.KnowledgeDocument, error) {
+	if _, err := s.GetKnowledgeBase(userID, kbID); err != nil {
+		return nil, err
+	}
+
+	format := DetectDocumentFormat(fileName)
+	if format == "" {
+		return nil, ErrUnsupportedDocumentFormat
+	}
+	if s.chromaService == nil {
+		return nil, errors.New("向量服务不可用")
+	}
+
+	doc := &models.KnowledgeDocument{
+		ID:              uuid.New(),
+		KnowledgeBaseID: kbID,
+		UserID:          userID,
+		FileName:        fileName,
+		Format:          format,
+		Size:            int64(len(data)),
+		Status:          "processing",
+	}
+
+	if err := s.db.Create(doc).Error; err != nil {
+		logrus.WithError(err).Error("保存文档记录失败")
+		return nil, errors.New("保存文档记录失败")
+	}
+
+	go s.processDocument(doc, data)
+
+	return doc, nil
+}
+
+// processDocument 解析、分块、向量化文档并写入向量库
+func (s *KnowledgeService) processDocument(doc *models.KnowledgeDocument, data []byte) {
+	logger := logrus.WithFields(logrus.Fields{
+		"document_id":       doc.ID,
+		"knowledge_base_id": doc.KnowledgeBaseID,
+		"file_name":         doc.FileName,
+	})
+
+	fail := func(err error) {
+		logger.WithError(err).Warn("文档处理失败")
+		s.db.Model(&models.KnowledgeDocument{}).Where("id = ? AND status = ?", doc.ID, "processing").Updates(map[string]interface{}{
+			"status": "failed",
+			"error":  err.Error(),
+		})
+	}
+
+	sections, err := ParseDocument(doc.Format, data)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	cfg := config.Get()
+	textChunks := ChunkSections(sections, cfg.KnowledgeChunkSize, cfg.KnowledgeChunkOverlap)
+	if len(textChunks) == 0 {
+		fail(errors.New("文档中没有可提取的文本内容"))
+		return
+	}
+
+	chunks := make([]models.KnowledgeChunk, 0, len(textChunks))
+	for _, tc := range textChunks {
+		chunks = append(chunks, models.KnowledgeChunk{
+			ID:              uuid.New(),
+			DocumentID:      doc.ID,
+			KnowledgeBaseID: doc.KnowledgeBaseID,
+			ChunkIndex:      tc.Index,
+			Location:        tc.Location,
+			StartOffset:     tc.StartOffset,
+			EndOffset:       tc.EndOffset,
+			Content:         tc.Content,
+		})
+	}
+
+	for start := 0; start < len(chunks); start += knowledgeAddBatchSize {
+		end := start + knowledgeAddBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		if err := s.indexChunks(doc, chunks[start:end]); err != nil {
+			fail(err)
+			// 清理已写入的部分向量
+			s.deleteDocumentVectors(doc.ID, logger)
+			return
+		}
+		if !s.touchDocument(doc.ID) {
+			logger.Info("文档在处理过程中被删除，停止处理")
+			s.deleteDocumentVectors(doc.ID, logger)
+			return
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 先更新文档行：文档已删除（deleted_at 不为空）或已被标记为失败时放弃；
+		// Postgres 中同时锁住该行，与 DeleteDocument 串行
+		result := tx.Model(&models.KnowledgeDocument{}).Where("id = ? AND status = ?", doc.ID, "processing").Updates(map[string]interface{}{
+			"status":      "ready",
+			"chunk_count": len(chunks),
+			"error":       "",
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errDocumentGone
+		}
+		if err := tx.CreateInBatches(chunks, 100).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.KnowledgeBase{}).Where("id = ?", doc.KnowledgeBaseID).
+			UpdateColumn("document_count", gorm.Expr("document_count + 1")).Error
+	})
+	if errors.Is(err, errDocumentGone) {
+		logger.Info("文档在处理过程中被删除，丢弃处理结果")
+		s.deleteDocumentVectors(doc.ID, logger)
+		return
+	}
+	if err != nil {
+		fail(fmt.Errorf("保存文档分块失败: %w", err))
+		return
+	}
+
+	logger.WithField("chunks", len(chunks)).Info("文档处理完成")
+}
+
+// touchDocument 更新处理中文档的 updated_at 表示处理仍在进行；文档已删除或已被标记为失败时返回 false
+func (s *KnowledgeService) touchDocument(docID uuid.UUID) bool {
+	result := s.db.Model(&models.KnowledgeDocument{}).Where("id = ? AND status = ?", docID, "processing").
+		Update("updated_at", time.Now())
+	if result.Error != nil {
+		// 数据库暂时不可用时继续处理，由最终事务判断文档是否还在
+		logrus.WithError(result.Error).WithField("document_id", docID).Warn("更新文档处理进度失败")
+		return true
+	}
+	return result.RowsAffected > 0
+}
+
+// deleteDocumentVectors 删除文档已写入向量库的分块
+func (s *KnowledgeService) deleteDocumentVectors(docID uuid.UUID, logger *logrus.Entry) {
+	if err := s.chromaService.DeleteKnowledge("document_id", docID.String()); err != nil {
+		logger.WithError(err).Warn("清理文档向量失败")
+	}
+}
+
+// Start 启动时及之后定期将中断的文档标记为失败，ctx 取消后退出
+func (s *KnowledgeService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(knowledgeStaleProcessing / 2)
+		defer ticker.Stop()
+
+		for {
+			if failed, err := s.FailInterruptedDocuments(); err != nil {
+				logrus.WithError(err).Warn("检查中断的文档失败")
+			} else if failed > 0 {
+				logrus.WithField("count", failed).Warn("已将处理中断的文档标记为失败")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// FailInterruptedDocuments 将超过 knowledgeStaleProcessing 没有进展的处理中文档标记为失败，返回数量。
+// 文档内容只保存在处理它的实例内存中，实例重启或崩溃后无法继续，需要重新上传
+func (s *KnowledgeService) FailInterruptedDocuments() (int64, error) {
+	result := s.db.Model(&models.KnowledgeDocument{}).
+		Where("status = ? AND updated_at < ?", "processing", time.Now().Add(-knowledgeStaleProcessing)).
+		Updates(map[string]interface{}{
+			"status": "failed",
+			"error":  "处理中断（服务重启），请重新上传",
+		})
+	if result.Error != nil {
+		return 0, fmt.Errorf("标记中断的文档失败: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// indexChunks 为一批分块生成向量并写入知识库集合
+func (s *KnowledgeService) indexChunks(doc *models.KnowledgeDocument, chunks []models.KnowledgeChunk) error {
+	ids := make([]string, 0, len(chunks))
+	documents := make([]string, 0, len(chunks))
+	embeddings := make([][]float64, 0, len(chunks))
+	metadatas := make([]map[string]interface{}, 0, len(chunks))
+
+	for _, chunk := range chunks {
+		embedding, err := s.chromaService.CreateEmbedding(chunk.Content)
+		if err != nil {
+			return fmt.Errorf("生成分块向量失败: %w", err)
+		}
+		ids = append(ids, chunk.ID.String())
+		documents = append(documents, chunk.Content)
+		embeddings = append(embeddings, embedding)
+		metadatas = append(metadatas, map[string]interface{}{
+			"user_id":           doc.UserID.String(),
+			"knowledge_base_id": doc.KnowledgeBaseID.String(),
+			"document_id":       doc.ID.String(),
+			"document_name":     doc.FileName,
+			"chunk_index":       chunk.ChunkIndex,
+			"location":          chunk.Location,
+		})
+	}
+
+	return s.chromaService.AddKnowledgeChunks(ids, documents, embeddings, metadatas)
+}
+
+// ListDocuments 获取知识库中的文档
+func (s *KnowledgeService) ListDocuments(userID, kbID uuid.UUID) ([]models.KnowledgeDocument, error) {
+	if _, err := s.GetKnowledgeBase(userID, kbID); err != nil {
+		return nil, err
+	}
+
+	var docs []models.KnowledgeDocument
+	err := s.db.Where("knowledge_base_id = ?", kbID).Order("created_at DESC").Find(&docs).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取文档列表失败")
+		return nil, errors.New("获取文档列表失败")
+	}
+	return docs, nil
+}
+
+// DeleteDocument 删除文档及其分块
+func (s *KnowledgeService) DeleteDocument(userID, kbID, docID uuid.UUID) error {
+	var doc models.KnowledgeDocument
+	err := s.db.Where("id = ? AND knowledge_base_id = ? AND user_id = ?", docID, kbID, userID).First(&doc).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrKnowledgeDocumentNotFound
+		}
+		logrus.WithError(err).Error("获取文档失败")
+		return errors.New("删除文档失败")
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 锁住文档行并重新读取状态，与 processDocument 的最终事务串行，按删除时的状态决定是否减少文档数
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", docID).First(&doc).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("document_id = ?", docID).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&doc).Error; err != nil {
+			return err
+		}
+		if doc.Status == "ready" {
+			return tx.Model(&models.KnowledgeBase{}).Where("id = ? AND document_count > 0", kbID).
+				UpdateColumn("document_count", gorm.Expr("document_count - 1")).Error
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrKnowledgeDocumentNotFound
+	}
+	if err != nil {
+		logrus.WithError(err).Error("删除文档失败")
+		return errors.New("删除文档失败")
+	}
+
+	if s.chromaService != nil {
+		if err := s.chromaService.DeleteKnowledge("document_id", docID.String()); err != nil {
+			logrus.WithError(err).WithField("document_id", docID).Warn("删除文档向量失败")
+		}
+	}
+
+	return nil
+}
+
+// AttachKnowledgeBase 将知识库挂载到对话
+func (s *KnowledgeService) AttachKnowledgeBase(userID, conversationID, kbID uuid.UUID) error {
+	if _, err := authorizeConversation(s.db, userID, conversationID, ConversationManage); err != nil {
+		return err
+	}
+	if _, err := s.GetKnowledgeBase(userID, kbID); err != nil {
+		return err
+	}
+
+	link := &models.ConversationKnowledgeBase{
+		ConversationID:  conversationID,
+		KnowledgeBaseID: kbID,
+	}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(link).Error; err != nil {
+		logrus.WithError(err).Error("挂载知识库失败")
+		return errors.New("挂载知识库失败")
+	}
+	return nil
+}
+
+// DetachKnowledgeBase 从对话中移除知识库
+func (s *KnowledgeService) DetachKnowledgeBase(userID, conversationID, kbID uuid.UUID) error {
+	if _, err := authorizeConversation(s.db, userID, conversationID, ConversationManage); err != nil {
+		return err
+	}
+
+	err := s.db.Where("conversation_id = ? AND knowledge_base_id = ?", conversationID, kbID).
+		Delete(&models.ConversationKnowledgeBase{}).Error
+	if err != nil {
+		logrus.WithError(err).Error("移除知识库失败")
+		return errors.New("移除知识库失败")
+	}
+	return nil
+}
+
+// ListConversationKnowledgeBases 获取对话挂载的知识库
+func (s *KnowledgeService) ListConversationKnowledgeBases(userID, conversationID uuid.UUID) ([]models.KnowledgeBase, error) {
+	if _, err := authorizeConversation(s.db, userID, conversationID, ConversationRead); err != nil {
+		return nil, err
+	}
+
+	var kbs []models.KnowledgeBase
+	err := s.db.Joins("JOIN conversation_knowledge_bases ckb ON ckb.knowledge_base_id = knowledge_bases.id").
+		Where("ckb.conversation_id = ?", conversationID).
+		Order("ckb.created_at ASC").
+		Find(&kbs).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取对话知识库失败")
+		return nil, errors.New("获取对话知识库失败")
+	}
+	return kbs, nil
+}
+
+// Retrieve 在对话挂载的知识库中检索与问题相关的分块
+func (s *KnowledgeService) Retrieve(conversationID uuid.UUID, query string, limit int) ([]KnowledgeHit, error) {
+	if s.chromaService == nil {
+		return nil, nil
+	}
+	if limit <= 0 || limit > 20 {
+		limit = config.Get().KnowledgeTopK
+	}
+
+	var kbIDs []string
+	err := s.db.Model(&models.ConversationKnowledgeBase{}).
+		Joins("JOIN knowledge_bases kb ON kb.id = conversation_knowledge_bases.knowledge_base_id AND kb.deleted_at IS NULL").
+		Where("conversation_knowledge_bases.conversation_id = ?", conversationID).
+		Pluck("conversation_knowledge_bases.knowledge_base_id", &kbIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取对话知识库失败: %w", err)
+	}
+	if len(kbIDs) == 0 {
+		return nil, nil
+	}
+
+	embedding, err := s.chromaService.CreateEmbedding(query)
+	if err != nil {
+		return nil, fmt.Errorf("创建查询向量失败: %w", err)
+	}
+
+	result, err := s.chromaService.QueryKnowledge(embedding, kbIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Documents) == 0 {
+		return nil, nil
+	}
+
+	hits := make([]KnowledgeHit, 0, len(result.Documents[0]))
+	for i, content := range result.Documents[0] {
+		hit := KnowledgeHit{Content: content}
+		if len(result.Metadatas) > 0 && i < len(result.Metadatas[0]) {
+			meta := result.Metadatas[0][i]
+			hit.KnowledgeBaseID = metadataString(meta, "knowledge_base_id")
+			hit.DocumentID = metadataString(meta, "document_id")
+			hit.DocumentName = metadataString(meta, "document_name")
+			hit.Location = metadataString(meta, "location")
+			if idx, ok := meta["chunk_index"].(float64); ok {
+				hit.ChunkIndex = int(idx)
+			}
+		}
+		if len(result.Distances) > 0 && i < len(result.Distances[0]) {
+			hit.Distance = result.Distances[0][i]
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, nil
+}
+
+// FormatKnowledgeContext 将检索结果整理为系统提示中的参考资料，编号与引用顺序一致
+func FormatKnowledgeContext(hits []KnowledgeHit) string {
+	var sb strings.Builder
+	sb.WriteString("参考资料（回答时请在相关内容后用 [编号] 标注来源）：\n")
+	for i, hit := range hits {
+		source := hit.DocumentName
+		if hit.Location != "" {
+			source += " · " + hit.Location
+		}
+		sb.WriteString(fmt.Sprintf("[%d] %s\n%s\n\n", i+1, source, hit.Content))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// metadataString 从Chroma元数据中读取字符串字段
+func metadataString(meta map[string]interface{}, key string) string {
+	if v, ok := meta[key].(string); ok {
+		return v
+	}
+	return ""
+}
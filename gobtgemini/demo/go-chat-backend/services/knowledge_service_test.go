@@ -0,0 +1,119 @@
+package services
+
+import (
+	"encoding/json"
+	"go-chat-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestKnowledgeDocumentDeletedDuringProcessing 测试处理过程中文档被删除时不再写入分块和文档数，并清理已写入的向量
+func TestKnowledgeDocumentDeletedDuringProcessing(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice")
+
+	var (
+		knowledgeService *KnowledgeService
+		doc              *models.KnowledgeDocument
+		once             sync.Once
+		mu               sync.Mutex
+		deleted          []map[string]interface{}
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/embed", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Inputs []string `json:"inputs"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		// 第一次生成向量时用户删除了文档
+		once.Do(func() { assert.NoError(t, knowledgeService.DeleteDocument(alice.ID, doc.KnowledgeBaseID, doc.ID)) })
+		embeddings := make([][]float64, len(body.Inputs))
+		for i := range embeddings {
+			embeddings[i] = []float64{1, 0}
+		}
+		json.NewEncoder(w).Encode(embeddings)
+	})
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat/collections/kb/add", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("/api/v2/tenants/default_tenant/databases/chat/collections/kb/delete", func(w http.ResponseWriter, r *http.Request) {
+		var body DeleteRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		mu.Lock()
+		deleted = append(deleted, body.Where)
+		mu.Unlock()
+		w.Write([]byte("[]"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	chromaService := newTestChromaService(server.URL)
+	chromaService.apiVersion = "v2"
+	chromaService.knowledgeCollectionID = "kb"
+	chromaService.embedder = &EmbeddingService{url: server.URL + "/embed", model: "test", httpClient: server.Client()}
+	knowledgeService = NewKnowledgeService(db, chromaService)
+
+	kb, err := knowledgeService.CreateKnowledgeBase(alice.ID, "产品手册", "")
+	require.NoError(t, err)
+	doc = &models.KnowledgeDocument{
+		ID:              uuid.New(),
+		KnowledgeBaseID: kb.ID,
+		UserID:          alice.ID,
+		FileName:        "manual.txt",
+		Format:          DetectDocumentFormat("manual.txt"),
+		Status:          "processing",
+	}
+	require.NoError(t, db.Create(doc).Error)
+
+	knowledgeService.processDocument(doc, []byte("退货政策：收到商品 7 天内可无理由退货。"))
+
+	var count int64
+	db.Model(&models.KnowledgeChunk{}).Where("document_id = ?", doc.ID).Count(&count)
+	assert.Zero(t, count)
+	var reloaded models.KnowledgeBase
+	require.NoError(t, db.First(&reloaded, "id = ?", kb.ID).Error)
+	assert.Zero(t, reloaded.DocumentCount)
+
+	// 删除时和放弃处理时各清理一次向量
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, deleted, 2)
+	for _, where := range deleted {
+		assert.Equal(t, map[string]interface{}{"$eq": doc.ID.String()}, where["document_id"])
+	}
+}
+
+// TestFailInterruptedDocuments 测试长时间没有进展的处理中文档被标记为失败，仍在处理的不受影响
+func TestFailInterruptedDocuments(t *testing.T) {
+	db := newTestDB(t)
+	alice := createTestUser(t, db, "alice")
+	knowledgeService := NewKnowledgeService(db, nil)
+
+	kb, err := knowledgeService.CreateKnowledgeBase(alice.ID, "产品手册", "")
+	require.NoError(t, err)
+	stale := &models.KnowledgeDocument{ID: uuid.New(), KnowledgeBaseID: kb.ID, UserID: alice.ID, FileName: "stale.txt", Format: "text", Status: "processing"}
+	active := &models.KnowledgeDocument{ID: uuid.New(), KnowledgeBaseID: kb.ID, UserID: alice.ID, FileName: "active.txt", Format: "text", Status: "processing"}
+	require.NoError(t, db.Create(stale).Error)
+	require.NoError(t, db.Create(active).Error)
+	require.NoError(t, db.Model(&models.KnowledgeDocument{}).Where("id = ?", stale.ID).
+		UpdateColumn("updated_at", time.Now().Add(-knowledgeStaleProcessing-time.Minute)).Error)
+
+	failed, err := knowledgeService.FailInterruptedDocuments()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), failed)
+
+	var docs []models.KnowledgeDocument
+	require.NoError(t, db.Order("file_name").Find(&docs).Error)
+	require.Len(t, docs, 2)
+	assert.Equal(t, "processing", docs[0].Status)
+	assert.Equal(t, "failed", docs[1].Status)
+	assert.NotEmpty(t, docs[1].Error)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// leaderElector 多实例部署时选出唯一执行后台任务的实例
+type leaderElector interface {
+	// Acquire 尝试成为主节点，已是主节点时确认身份仍然有效
+	Acquire(ctx context.Context) (bool, error)
+	// Release 放弃主节点身份
+	Release()
+}
+
+// newLeaderElector Postgres 使用 advisory lock 选主，其他数据库（测试用的 SQLite）视为单实例
+func newLeaderElector(db *gorm.DB, key int64) leaderElector {
+	if db.Dialector.Name() == "postgres" {
+		return &advisoryLockElector{db: db, key: key}
+	}
+	return singleNodeElector{}
+}
+
+// advisoryLockElector 持有一个专用连接上的会话级 advisory lock，连接断开时锁自动释放，由其他实例接替
+type advisoryLockElector struct {
+	db  *gorm.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+func (e *advisoryLockElector) Acquire(ctx context.Context) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn != nil {
+		if err := e.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		logrus.WithField("lock_key", e.key).Warn("选主连接已断开，放弃主节点身份")
+		e.conn.Close()
+		e.conn = nil
+	}
+
+	sqlDB, err := e.db.DB()
+	if err != nil {
+		return false, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&locked); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !locked {
+		conn.Close()
+		return false, nil
+	}
+
+	logrus.WithField("lock_key", e.key).Info("已成为主节点")
+	e.conn = conn
+	return true, nil
+}
+
+func (e *advisoryLockElector) Release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		return
+	}
+	if _, err := e.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", e.key); err != nil {
+		logrus.WithError(err).Warn("释放 advisory lock 失败")
+	}
+	e.conn.Close()
+	e.conn = nil
+}
+
+// singleNodeElector 单实例时始终是主节点
+type singleNodeElector struct{}
+
+func (singleNodeElector) Acquire(context.Context) (bool, error) { return true, nil }
+
+func (singleNodeElector) Release() {}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +12,15 @@ import (
 	"go-chat-backend/utils"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// llmStreamTimeout 流式生成的整体超时，长回复需要比一次性请求更长的时间
+const llmStreamTimeout = 3 * time.Minute
+
 // LLMService 大语言模型服务
 type LLMService struct {
 	httpClient *utils.HTTPClient
@@ -27,6 +33,33 @@ func NewLLMService() *LLMService {
 	}
 }
 
+// GenerationInfo 生成回复时使用的模型和参数，记录在AI回复的 metadata.generation 中，
+// 用于评价导出和按模型统计
+type GenerationInfo struct {
+	Model           string  `json:"model"`
+	Temperature     float32 `json:"temperature"`
+	MaxTokens       int     `json:"max_tokens"`
+	ContextWindow   int     `json:"context_window"`
+	ContextMessages int     `json:"context_messages"` // 实际发送的上下文消息数，包括本次提问
+	MemoryEnabled   bool    `json:"memory_enabled"`
+	MemoryHits      int     `json:"memory_hits"`
+	KnowledgeHits   int     `json:"knowledge_hits"`
+	// 各参数来自哪一级设置（default/user/persona/conversation）
+	Sources map[string]string `json:"sources,omitempty"`
+}
+
+// NewGenerationInfo 根据实际使用的生成参数生成记录
+func NewGenerationInfo(settings EffectiveSettings) GenerationInfo {
+	return GenerationInfo{
+		Model:         settings.Model,
+		Temperature:   settings.Temperature,
+		MaxTokens:     settings.MaxTokens,
+		ContextWindow: settings.ContextWindow,
+		MemoryEnabled: settings.MemoryEnabled,
+		Sources:       settings.Sources,
+	}
+}
+
 // --- 新的 Gemini API 结构体 ---
 
 // GeminiPart 对应 Gemini API 请求体中的 "parts"
@@ -40,10 +73,16 @@ type GeminiContent struct {
 	Role  string       `json:"role,omitempty"`
 }
 
+// GeminiGenerationConfig 对应 Gemini API 请求体中的 "generationConfig"
+type GeminiGenerationConfig struct {
+	Temperature     *float32 `json:"temperature,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+}
+
 // GeminiChatRequest 对应 Gemini API 的完整请求体
 type GeminiChatRequest struct {
-	Contents []GeminiContent `json:"contents"`
-	// Gemini 的 Temperature/MaxTokens 等参数在 GenerationConfig 中，这里简化
+	Contents         []GeminiContent         `json:"contents"`
+	GenerationConfig *GeminiGenerationConfig `json:"generationConfig,omitempty"`
 }
 
 // --- 新的 Gemini API 响应结构体 ---
@@ -88,46 +127,9 @@ func (s *LLMService) GenerateResponse(messages []models.ChatMessage, userPrefere
 	}
 
 	// --- 1. 修改：构建 Gemini 格式的请求体 ---
-	geminiContents := make([]GeminiContent, 0, len(messages)+1)
-
-	// 添加系统提示 (Gemini 推荐将系统提示放在第一个 User 角色的内容里)
-	systemPrompt := "你是一个智能助手，请提供准确、有用的信息和帮助。请用中文回复。"
-	if userPreference.SystemPrompt != "" {
-		systemPrompt = userPreference.SystemPrompt
-	}
-
-	// 将系统提示和第一条用户消息合并
-	if len(messages) > 0 {
-		firstUserMessage := messages[0]
-		// 确保第一条消息是 user
-		if firstUserMessage.Role == "user" {
-			fullPrompt := systemPrompt + "\n\n" + firstUserMessage.Content
-			geminiContents = append(geminiContents, GeminiContent{
-				Role:  "user",
-				Parts: []GeminiPart{{Text: fullPrompt}},
-			})
-			// 从第二条消息开始处理
-			messages = messages[1:]
-		}
-	}
-
-	// 添加剩余的历史消息
-	for _, msg := range messages {
-		geminiContents = append(geminiContents, GeminiContent{
-			Role:  msg.Role,
-			Parts: []GeminiPart{{Text: msg.Content}},
-		})
-	}
-
-	// 构建请求
-	request := GeminiChatRequest{
-		Contents: geminiContents,
-	}
-
-	// 序列化请求
-	requestBody, err := json.Marshal(request)
+	requestBody, err := buildGeminiRequest(messages, userPreference)
 	if err != nil {
-		return "", fmt.Errorf("请求序列化失败: %w", err)
+		return "", err
 	}
 
 	// ======================= 调试步骤 1：打印将要发送的 JSON =======================
@@ -137,7 +139,7 @@ func (s *LLMService) GenerateResponse(messages []models.ChatMessage, userPrefere
 	// --- 2. 修改：URL 和认证头 ---
 	// 注意：Gemini 的模型名称是 URL 的一部分，需要确保 cfg.LLMAPIURL 已经包含模型名称
 	// 例如: "https://.../v1beta/models/gemini-pro:generateContent"
-	apiURL := cfg.LLMAPIURL
+	apiURL := modelAPIURL(cfg.LLMAPIURL, cfg.LLMModel, userPreference.LLMModel)
 
 	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestBody))
 	if err != nil {
@@ -213,6 +215,174 @@ func (s *LLMService) GenerateResponse(messages []models.ChatMessage, userPrefere
 	return content, nil
 }
 
+// buildGeminiRequest 构建 Gemini 格式的请求体，系统提示合并到第一条用户消息中
+func buildGeminiRequest(messages []models.ChatMessage, userPreference *models.UserPreference) ([]byte, error) {
+	geminiContents := make([]GeminiContent, 0, len(messages)+1)
+
+	// 添加系统提示 (Gemini 推荐将系统提示放在第一个 User 角色的内容里)
+	systemPrompt := "你是一个智能助手，请提供准确、有用的信息和帮助。请用中文回复。"
+	if userPreference.SystemPrompt != "" {
+		systemPrompt = userPreference.SystemPrompt
+	}
+
+	// 将系统提示和第一条用户消息合并
+	if len(messages) > 0 {
+		firstUserMessage := messages[0]
+		// 确保第一条消息是 user
+		if firstUserMessage.Role == "user" {
+			fullPrompt := systemPrompt + "\n\n" + firstUserMessage.Content
+			geminiContents = append(geminiContents, GeminiContent{
+				Role:  "user",
+				Parts: []GeminiPart{{Text: fullPrompt}},
+			})
+			// 从第二条消息开始处理
+			messages = messages[1:]
+		}
+	}
+
+	// 添加剩余的历史消息
+	for _, msg := range messages {
+		geminiContents = append(geminiContents, GeminiContent{
+			Role:  msg.Role,
+			Parts: []GeminiPart{{Text: msg.Content}},
+		})
+	}
+
+	// 构建请求
+	request := GeminiChatRequest{
+		Contents: geminiContents,
+		GenerationConfig: &GeminiGenerationConfig{
+			Temperature:     &userPreference.Temperature,
+			MaxOutputTokens: userPreference.MaxTokens,
+		},
+	}
+
+	// 序列化请求
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("请求序列化失败: %w", err)
+	}
+
+	return requestBody, nil
+}
+
+// StreamResponse 以流式接口生成回复，每收到一段文本调用一次 onDelta，返回完整回复。
+// onDelta 返回错误或 ctx 取消时中止生成；API 地址不是 generateContent 接口时退回一次性生成，整段作为一个片段
+func (s *LLMService) StreamResponse(ctx context.Context, messages []models.ChatMessage, userPreference *models.UserPreference, onDelta func(string) error) (string, error) {
+	if len(messages) == 0 {
+		return "", errors.New("传入的消息列表为空，无法生成回复")
+	}
+
+	cfg := config.Get()
+	if cfg.LLMAPIURL == "" {
+		return "", errors.New("未配置LLM API URL")
+	}
+
+	apiURL, ok := streamAPIURL(modelAPIURL(cfg.LLMAPIURL, cfg.LLMModel, userPreference.LLMModel))
+	if !ok {
+		content, err := s.GenerateResponse(messages, userPreference)
+		if err != nil {
+			return "", err
+		}
+		if err := onDelta(content); err != nil {
+			return "", err
+		}
+		return content, nil
+	}
+
+	requestBody, err := buildGeminiRequest(messages, userPreference)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if cfg.LLMAPIKey != "" {
+		req.Header.Set("X-goog-api-key", cfg.LLMAPIKey)
+	}
+
+	client := &http.Client{Timeout: llmStreamTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.WithError(err).Error("LLM API请求失败")
+		return "", fmt.Errorf("LLM API请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		logrus.Errorf("LLM API 返回错误状态码: %d, 响应: %s", resp.StatusCode, string(bodyBytes))
+		return "", fmt.Errorf("LLM API 返回错误状态码: %d", resp.StatusCode)
+	}
+
+	// 响应为 SSE，每个 data 行是一个 GeminiChatResponse 片段
+	var content strings.Builder
+	var usage GeminiUsageMetadata
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+
+		var chunk GeminiChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", fmt.Errorf("响应解析失败: %w", err)
+		}
+		if chunk.Error != nil {
+			logrus.WithFields(logrus.Fields{
+				"error_message": chunk.Error.Message,
+				"error_status":  chunk.Error.Status,
+			}).Error("LLM API返回错误")
+			return "", fmt.Errorf("LLM API错误: %s", chunk.Error.Message)
+		}
+		if chunk.UsageMetadata.TotalTokenCount > 0 {
+			usage = chunk.UsageMetadata
+		}
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+
+		var delta strings.Builder
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			delta.WriteString(part.Text)
+		}
+		if delta.Len() == 0 {
+			continue
+		}
+		content.WriteString(delta.String())
+		if err := onDelta(delta.String()); err != nil {
+			return "", err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("读取流式响应失败: %w", err)
+	}
+
+	if content.Len() == 0 {
+		return "", errors.New("LLM API返回的回复为空")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"prompt_tokens":     usage.PromptTokenCount,
+		"completion_tokens": usage.CandidatesTokenCount,
+		"total_tokens":      usage.TotalTokenCount,
+		"model":             "gemini",
+		"stream":            true,
+	}).Info("LLM API调用成功")
+
+	return content.String(), nil
+}
+
 // ValidateAPIConfig 验证API配置
 func (s *LLMService) ValidateAPIConfig() error {
 	cfg := config.Get()
@@ -239,3 +409,23 @@ func (s *LLMService) GetSupportedModels() []string {
 		"text-davinci-003",
 	}
 }
+
+// modelAPIURL 将 API 地址中的默认模型替换为 model；地址中不含默认模型名或 model 为空时原样返回
+func modelAPIURL(apiURL, defaultModel, model string) string {
+	if model == "" || model == defaultModel || !ModelAllowed(model) {
+		return apiURL
+	}
+	return strings.Replace(apiURL, "/models/"+defaultModel+":", "/models/"+model+":", 1)
+}
+
+// streamAPIURL 将 generateContent 接口地址转换为 SSE 流式接口地址；地址不是 generateContent 接口时返回 false
+func streamAPIURL(apiURL string) (string, bool) {
+	if !strings.Contains(apiURL, ":generateContent") {
+		return "", false
+	}
+	apiURL = strings.Replace(apiURL, ":generateContent", ":streamGenerateContent", 1)
+	if strings.Contains(apiURL, "?") {
+		return apiURL + "&alt=sse", true
+	}
+	return apiURL + "?alt=sse", true
+}
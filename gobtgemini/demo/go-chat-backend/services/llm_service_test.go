@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useLLMAPI 测试期间将 LLM API 地址指向 apiURL
+func useLLMAPI(t *testing.T, apiURL string) {
+	t.Helper()
+	if config.Get() == nil {
+		config.LoadConfig()
+	}
+	cfg := config.Get()
+	previousURL, previousModel := cfg.LLMAPIURL, cfg.LLMModel
+	cfg.LLMAPIURL, cfg.LLMModel = apiURL, "gemini-pro"
+	t.Cleanup(func() {
+		cfg.LLMAPIURL, cfg.LLMModel = previousURL, previousModel
+	})
+}
+
+// TestStreamResponse 测试按 SSE 片段回调增量文本、流式地址转换以及非 generateContent 地址的回退
+func TestStreamResponse(t *testing.T) {
+	chunks := []string{"你好", "，", "世界"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1beta/models/gemini-pro:streamGenerateContent", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "sse", r.URL.Query().Get("alt"))
+		var request GeminiChatRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Contains(t, request.Contents[0].Parts[0].Text, "问候一下")
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			data, _ := json.Marshal(GeminiChatResponse{Candidates: []GeminiResponseCandidate{{Content: GeminiContent{Parts: []GeminiPart{{Text: chunk}}}}}})
+			fmt.Fprintf(w, "data: %s\r\n\r\n", data)
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/v1beta/models/gemini-pro:generate", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GeminiChatResponse{Candidates: []GeminiResponseCandidate{{Content: GeminiContent{Parts: []GeminiPart{{Text: "完整回复"}}}}}})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	s := NewLLMService()
+	messages := []models.ChatMessage{{Role: "user", Content: "问候一下"}}
+	preference := &models.UserPreference{Temperature: 0.7, MaxTokens: 100}
+
+	useLLMAPI(t, server.URL+"/v1beta/models/gemini-pro:generateContent")
+	var deltas []string
+	content, err := s.StreamResponse(context.Background(), messages, preference, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, chunks, deltas)
+	assert.Equal(t, "你好，世界", content)
+
+	// onDelta 返回错误时中止
+	stop := errors.New("stop")
+	_, err = s.StreamResponse(context.Background(), messages, preference, func(string) error { return stop })
+	assert.ErrorIs(t, err, stop)
+
+	// 地址不是 generateContent 接口时一次性生成
+	useLLMAPI(t, server.URL+"/v1beta/models/gemini-pro:generate")
+	deltas = nil
+	content, err = s.StreamResponse(context.Background(), messages, preference, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"完整回复"}, deltas)
+	assert.Equal(t, "完整回复", content)
+
+	streamURL, ok := streamAPIURL("https://example.com/v1beta/models/gemini-pro:generateContent?key=abc")
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/v1beta/models/gemini-pro:streamGenerateContent?key=abc&alt=sse", streamURL)
+}
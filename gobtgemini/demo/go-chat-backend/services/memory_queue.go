@@ -0,0 +1,397 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 重试退避参数
+const (
+	memoryJobBaseBackoff = 5 * time.Second
+	memoryJobMaxBackoff  = 10 * time.Minute
+)
+
+// MemoryQueue 基于 Postgres 表的记忆写入队列。
+// 多个实例可同时消费，任务通过 FOR UPDATE SKIP LOCKED 领取，处理超时的任务会被重新领取。
+type MemoryQueue struct {
+	db          *gorm.DB
+	memoryStore MemoryStore
+
+	workers      int
+	batchSize    int
+	maxAttempts  int
+	pollInterval time.Duration
+	lockTimeout  time.Duration
+	retention    time.Duration
+	nodeName     string
+
+	// 入队后唤醒空闲的 worker，避免等待下一次轮询
+	wake chan struct{}
+}
+
+// MemoryQueueStats 队列状态
+type MemoryQueueStats struct {
+	Pending              int64              `json:"pending"`
+	Processing           int64              `json:"processing"`
+	Done                 int64              `json:"done"`
+	Dead                 int64              `json:"dead"`
+	OldestPendingSeconds float64            `json:"oldest_pending_seconds"`
+	Workers              int                `json:"workers"`
+	RecentFailures       []models.MemoryJob `json:"recent_failures"`
+}
+
+// NewMemoryQueue 创建记忆写入队列
+func NewMemoryQueue(db *gorm.DB, memoryStore MemoryStore) *MemoryQueue {
+	cfg := config.Get()
+	hostname, _ := os.Hostname()
+
+	q := &MemoryQueue{
+		db:           db,
+		memoryStore:  memoryStore,
+		workers:      cfg.MemoryQueueWorkers,
+		batchSize:    cfg.MemoryQueueBatchSize,
+		maxAttempts:  cfg.MemoryQueueMaxAttempts,
+		pollInterval: time.Duration(cfg.MemoryQueuePollSeconds) * time.Second,
+		lockTimeout:  time.Duration(cfg.MemoryQueueLockMinutes) * time.Minute,
+		retention:    time.Duration(cfg.MemoryQueueRetainDays) * 24 * time.Hour,
+		nodeName:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		wake:         make(chan struct{}, 1),
+	}
+
+	if q.workers <= 0 {
+		q.workers = 1
+	}
+	if q.batchSize <= 0 {
+		q.batchSize = 16
+	}
+	if q.maxAttempts <= 0 {
+		q.maxAttempts = 5
+	}
+	if q.pollInterval <= 0 {
+		q.pollInterval = 2 * time.Second
+	}
+	if q.lockTimeout <= 0 {
+		q.lockTimeout = 5 * time.Minute
+	}
+
+	return q
+}
+
+// Start 启动 worker 和过期任务清理，ctx 取消后退出
+func (q *MemoryQueue) Start(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		go q.runWorker(ctx, fmt.Sprintf("%s/%d", q.nodeName, i))
+	}
+	go q.runJanitor(ctx)
+
+	logrus.WithFields(logrus.Fields{
+		"workers":    q.workers,
+		"batch_size": q.batchSize,
+	}).Info("记忆写入队列已启动")
+}
+
+// Enqueue 将消息加入记忆写入队列
+func (q *MemoryQueue) Enqueue(messages ...*models.ChatMessage) error {
+	jobs := make([]models.MemoryJob, 0, len(messages))
+	now := time.Now()
+	for _, msg := range messages {
+		if msg == nil {
+			continue
+		}
+		jobs = append(jobs, models.MemoryJob{
+			ID:             uuid.New(),
+			MessageID:      msg.ID,
+			UserID:         msg.UserID,
+			ConversationID: msg.ConversationID,
+			Status:         models.MemoryJobPending,
+			NextRunAt:      now,
+		})
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	if err := q.db.Create(&jobs).Error; err != nil {
+		logrus.WithError(err).Error("记忆任务入队失败")
+		return errors.New("记忆任务入队失败")
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// runWorker 循环领取并处理任务，队列为空时等待轮询或唤醒
+func (q *MemoryQueue) runWorker(ctx context.Context, workerName string) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		processed, err := q.processBatch(workerName)
+		if err != nil {
+			logrus.WithError(err).WithField("worker", workerName).Warn("处理记忆任务失败")
+		}
+		if processed > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// processBatch 领取一批任务并写入向量库，返回处理的任务数
+func (q *MemoryQueue) processBatch(workerName string) (int, error) {
+	jobs, err := q.claim(workerName)
+	if err != nil || len(jobs) == 0 {
+		return 0, err
+	}
+
+	messageIDs := make([]uuid.UUID, 0, len(jobs))
+	for _, job := range jobs {
+		messageIDs = append(messageIDs, job.MessageID)
+	}
+
+	var messages []models.ChatMessage
+	if err := q.db.Where("id IN ?", messageIDs).Find(&messages).Error; err != nil {
+		q.fail(jobs, err)
+		return len(jobs), fmt.Errorf("读取消息失败: %w", err)
+	}
+	byID := make(map[uuid.UUID]models.ChatMessage, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+	}
+
+	var entries []MemoryEntry
+	var ready, skipped []models.MemoryJob
+	for _, job := range jobs {
+		msg, ok := byID[job.MessageID]
+		if !ok {
+			// 消息已被删除，无需再写入记忆
+			skipped = append(skipped, job)
+			continue
+		}
+		ready = append(ready, job)
+		entries = append(entries, MemoryEntry{
+			ID:             msg.ID.String(),
+			UserID:         msg.UserID,
+			ConversationID: msg.ConversationID,
+			Role:           msg.Role,
+			Content:        msg.Content,
+			Timestamp:      msg.CreatedAt,
+		})
+	}
+
+	if len(skipped) > 0 {
+		q.complete(skipped, "消息已删除，跳过")
+	}
+	if len(entries) == 0 {
+		return len(jobs), nil
+	}
+
+	if err := q.memoryStore.AddMemories(entries); err != nil {
+		q.fail(ready, err)
+		return len(jobs), err
+	}
+
+	q.complete(ready, "")
+	return len(jobs), nil
+}
+
+// claim 领取到期的待处理任务以及锁超时的任务
+func (q *MemoryQueue) claim(workerName string) ([]models.MemoryJob, error) {
+	var jobs []models.MemoryJob
+	now := time.Now()
+
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND next_run_at <= ?) OR (status = ? AND locked_at < ?)",
+				models.MemoryJobPending, now, models.MemoryJobProcessing, now.Add(-q.lockTimeout)).
+			Order("next_run_at ASC").
+			Limit(q.batchSize).
+			Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(jobs))
+		for i := range jobs {
+			ids = append(ids, jobs[i].ID)
+			jobs[i].Attempts++
+		}
+		return tx.Model(&models.MemoryJob{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":    models.MemoryJobProcessing,
+			"locked_at": now,
+			"locked_by": workerName,
+			"attempts":  gorm.Expr("attempts + 1"),
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("领取记忆任务失败: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// complete 标记任务完成
+func (q *MemoryQueue) complete(jobs []models.MemoryJob, note string) {
+	ids := make([]uuid.UUID, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+
+	err := q.db.Model(&models.MemoryJob{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"status":       models.MemoryJobDone,
+		"completed_at": time.Now(),
+		"locked_at":    nil,
+		"locked_by":    "",
+		"last_error":   note,
+	}).Error
+	if err != nil {
+		logrus.WithError(err).Error("更新记忆任务状态失败")
+	}
+}
+
+// fail 记录失败原因，未超过最大次数的任务按指数退避重新排队，否则进入死信
+func (q *MemoryQueue) fail(jobs []models.MemoryJob, cause error) {
+	now := time.Now()
+	for _, job := range jobs {
+		updates := map[string]interface{}{
+			"locked_at":  nil,
+			"locked_by":  "",
+			"last_error": cause.Error(),
+		}
+		if job.Attempts >= q.maxAttempts {
+			updates["status"] = models.MemoryJobDead
+			logrus.WithFields(logrus.Fields{
+				"job_id":     job.ID,
+				"message_id": job.MessageID,
+				"attempts":   job.Attempts,
+			}).WithError(cause).Error("记忆任务超过最大重试次数，进入死信")
+		} else {
+			updates["status"] = models.MemoryJobPending
+			updates["next_run_at"] = now.Add(memoryJobBackoff(job.Attempts))
+		}
+
+		if err := q.db.Model(&models.MemoryJob{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+			logrus.WithError(err).Error("更新记忆任务状态失败")
+		}
+	}
+}
+
+// memoryJobBackoff 第 attempt 次失败后的等待时间
+func memoryJobBackoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	backoff := memoryJobBaseBackoff
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= memoryJobMaxBackoff {
+			return memoryJobMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// runJanitor 定期删除已完成的历史任务
+func (q *MemoryQueue) runJanitor(ctx context.Context) {
+	if q.retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result := q.db.Where("status = ? AND completed_at < ?", models.MemoryJobDone, time.Now().Add(-q.retention)).
+				Delete(&models.MemoryJob{})
+			if result.Error != nil {
+				logrus.WithError(result.Error).Warn("清理已完成记忆任务失败")
+			} else if result.RowsAffected > 0 {
+				logrus.WithField("deleted", result.RowsAffected).Info("已清理过期记忆任务")
+			}
+		}
+	}
+}
+
+// Stats 获取队列深度和最近的失败任务
+func (q *MemoryQueue) Stats() (*MemoryQueueStats, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := q.db.Model(&models.MemoryJob{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		logrus.WithError(err).Error("获取记忆队列状态失败")
+		return nil, errors.New("获取记忆队列状态失败")
+	}
+
+	stats := &MemoryQueueStats{Workers: q.workers}
+	for _, row := range rows {
+		switch row.Status {
+		case models.MemoryJobPending:
+			stats.Pending = row.Count
+		case models.MemoryJobProcessing:
+			stats.Processing = row.Count
+		case models.MemoryJobDone:
+			stats.Done = row.Count
+		case models.MemoryJobDead:
+			stats.Dead = row.Count
+		}
+	}
+
+	var oldest struct{ CreatedAt *time.Time }
+	err := q.db.Model(&models.MemoryJob{}).Select("MIN(created_at) AS created_at").
+		Where("status = ?", models.MemoryJobPending).Scan(&oldest).Error
+	if err == nil && oldest.CreatedAt != nil {
+		stats.OldestPendingSeconds = time.Since(*oldest.CreatedAt).Seconds()
+	}
+
+	err = q.db.Where("status IN ? AND last_error <> ''", []string{models.MemoryJobPending, models.MemoryJobDead}).
+		Order("updated_at DESC").Limit(20).Find(&stats.RecentFailures).Error
+	if err != nil {
+		logrus.WithError(err).Warn("获取失败记忆任务失败")
+	}
+
+	return stats, nil
+}
+
+// RetryDead 将死信任务重新放回队列，返回重新排队的数量
+func (q *MemoryQueue) RetryDead() (int64, error) {
+	result := q.db.Model(&models.MemoryJob{}).Where("status = ?", models.MemoryJobDead).Updates(map[string]interface{}{
+		"status":      models.MemoryJobPending,
+		"attempts":    0,
+		"next_run_at": time.Now(),
+	})
+	if result.Error != nil {
+		logrus.WithError(result.Error).Error("重试死信任务失败")
+		return 0, errors.New("重试死信任务失败")
+	}
+
+	if result.RowsAffected > 0 {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return result.RowsAffected, nil
+}
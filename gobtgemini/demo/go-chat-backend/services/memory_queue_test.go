@@ -0,0 +1,173 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// queueMemoryStore 记录写入的记忆，err 不为空时写入失败
+type queueMemoryStore struct {
+	MemoryStore
+	err     error
+	entries []MemoryEntry
+}
+
+func (f *queueMemoryStore) AddMemories(entries []MemoryEntry) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.entries = append(f.entries, entries...)
+	return nil
+}
+
+// TestMemoryJobBackoff 测试重试退避时间按指数增长且有上限
+func TestMemoryJobBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, memoryJobBackoff(0))
+	assert.Equal(t, 5*time.Second, memoryJobBackoff(1))
+	assert.Equal(t, 10*time.Second, memoryJobBackoff(2))
+	assert.Equal(t, 40*time.Second, memoryJobBackoff(4))
+	assert.Equal(t, memoryJobMaxBackoff, memoryJobBackoff(20))
+}
+
+// TestMemoryQueueLifecycle 测试任务领取、失败退避、进入死信、重试死信直到写入成功，以及各阶段的队列状态
+func TestMemoryQueueLifecycle(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice")
+	session := &models.ChatSession{UserID: user.ID, Title: "t", IsActive: true}
+	require.NoError(t, db.Create(session).Error)
+	messages := make([]*models.ChatMessage, 2)
+	for i := range messages {
+		messages[i] = &models.ChatMessage{MessageID: uuid.New(), ConversationID: session.ID, UserID: user.ID, Role: "user", Content: "hello"}
+		require.NoError(t, db.Create(messages[i]).Error)
+	}
+
+	store := &queueMemoryStore{err: errors.New("向量库不可用")}
+	queue := NewMemoryQueue(db, store)
+	queue.maxAttempts = 2
+	require.NoError(t, queue.Enqueue(messages[0], nil, messages[1]))
+
+	jobs := func() []models.MemoryJob {
+		var jobs []models.MemoryJob
+		require.NoError(t, db.Order("message_id").Find(&jobs).Error)
+		return jobs
+	}
+	makeDue := func() {
+		require.NoError(t, db.Model(&models.MemoryJob{}).Where("1 = 1").Update("next_run_at", time.Now().Add(-time.Second)).Error)
+	}
+
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Pending)
+	assert.Empty(t, stats.RecentFailures)
+
+	// 第一次失败：按退避时间重新排队，未到期前不会被再次领取
+	processed, err := queue.processBatch("w1")
+	assert.Equal(t, 2, processed)
+	assert.Error(t, err)
+	for _, job := range jobs() {
+		assert.Equal(t, models.MemoryJobPending, job.Status)
+		assert.Equal(t, 1, job.Attempts)
+		assert.Equal(t, "向量库不可用", job.LastError)
+		assert.Nil(t, job.LockedAt)
+		assert.WithinDuration(t, time.Now().Add(memoryJobBackoff(1)), job.NextRunAt, 2*time.Second)
+	}
+	processed, err = queue.processBatch("w1")
+	require.NoError(t, err)
+	assert.Zero(t, processed)
+
+	stats, err = queue.Stats()
+	require.NoError(t, err)
+	assert.Len(t, stats.RecentFailures, 2)
+
+	// 达到最大次数后进入死信
+	makeDue()
+	_, err = queue.processBatch("w1")
+	assert.Error(t, err)
+	for _, job := range jobs() {
+		assert.Equal(t, models.MemoryJobDead, job.Status)
+		assert.Equal(t, 2, job.Attempts)
+	}
+	stats, err = queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, int64(2), stats.Dead)
+
+	// 重试死信后从头计数，写入成功即完成
+	store.err = nil
+	retried, err := queue.RetryDead()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), retried)
+	for _, job := range jobs() {
+		assert.Equal(t, models.MemoryJobPending, job.Status)
+		assert.Zero(t, job.Attempts)
+	}
+
+	processed, err = queue.processBatch("w1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, processed)
+	assert.Len(t, store.entries, 2)
+	for _, job := range jobs() {
+		assert.Equal(t, models.MemoryJobDone, job.Status)
+		assert.NotNil(t, job.CompletedAt)
+	}
+
+	stats, err = queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Done)
+	assert.Equal(t, int64(0), stats.Dead)
+	retried, err = queue.RetryDead()
+	require.NoError(t, err)
+	assert.Zero(t, retried)
+}
+
+// TestMemoryQueueClaim 测试锁超时的任务被重新领取，消息已删除的任务直接完成
+func TestMemoryQueueClaim(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice")
+	session := &models.ChatSession{UserID: user.ID, Title: "t", IsActive: true}
+	require.NoError(t, db.Create(session).Error)
+	kept := &models.ChatMessage{MessageID: uuid.New(), ConversationID: session.ID, UserID: user.ID, Role: "user", Content: "kept"}
+	deleted := &models.ChatMessage{MessageID: uuid.New(), ConversationID: session.ID, UserID: user.ID, Role: "user", Content: "deleted"}
+	require.NoError(t, db.Create(kept).Error)
+	require.NoError(t, db.Create(deleted).Error)
+
+	store := &queueMemoryStore{}
+	queue := NewMemoryQueue(db, store)
+	require.NoError(t, queue.Enqueue(kept, deleted))
+	require.NoError(t, db.Delete(deleted).Error)
+
+	// 其他 worker 领取后崩溃：锁未超时前不能再次领取
+	claimed, err := queue.claim("w1")
+	require.NoError(t, err)
+	assert.Len(t, claimed, 2)
+	claimed, err = queue.claim("w2")
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	stats, err := queue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Processing)
+
+	require.NoError(t, db.Model(&models.MemoryJob{}).Where("1 = 1").Update("locked_at", time.Now().Add(-queue.lockTimeout-time.Minute)).Error)
+	processed, err := queue.processBatch("w2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, processed)
+	require.Len(t, store.entries, 1)
+	assert.Equal(t, kept.ID.String(), store.entries[0].ID)
+
+	var jobs []models.MemoryJob
+	require.NoError(t, db.Find(&jobs).Error)
+	for _, job := range jobs {
+		assert.Equal(t, models.MemoryJobDone, job.Status)
+		assert.Equal(t, 2, job.Attempts)
+		if job.MessageID == deleted.ID {
+			assert.Equal(t, "消息已删除，跳过", job.LastError)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package services
+
+import "github.com/google/uuid"
+
+// 记忆库后端
+const (
+	MemoryBackendChroma   = "chroma"
+	MemoryBackendPGVector = "pgvector"
+)
+
+// MemoryStore 对话记忆的向量存储。
+// “集合”在 Chroma 中对应 collection，在 pgvector 中对应 memory_vectors.collection 列，
+// 重建索引时写入新集合、完成后切换。
+type MemoryStore interface {
+	// SearchMemory 按语义相似度检索用户的记忆，只返回该用户自己的内容
+	SearchMemory(userID uuid.UUID, query string, limit int) ([]string, error)
+	// AddMemories 批量写入当前集合，以消息ID幂等
+	AddMemories(entries []MemoryEntry) error
+	// AddMemoriesTo 批量写入指定集合（集合ID由 EnsureCollection 返回）
+	AddMemoriesTo(collectionID string, entries []MemoryEntry) error
+	// EnsureCollection 确保集合存在并返回集合ID
+	EnsureCollection(name string) (string, error)
+	// SwitchCollection 将记忆读写切换到指定集合
+	SwitchCollection(name string) error
+	// CollectionName 当前使用的集合名称
+	CollectionName() string
+	// ClearUserMemory 清空用户记忆
+	ClearUserMemory(userID uuid.UUID) error
+	// DeleteConversationMemories 删除对话在当前集合中的全部记忆（对话被彻底删除时调用）
+	DeleteConversationMemories(conversationID uuid.UUID) error
+	// DeleteMessageMemories 按消息ID删除记忆（保留策略清理旧消息时调用）
+	DeleteMessageMemories(messageIDs []uuid.UUID) error
+	// GetMemoryStats 获取用户记忆统计信息
+	GetMemoryStats(userID uuid.UUID) (map[string]interface{}, error)
+}
+
+var (
+	_ MemoryStore = (*ChromaService)(nil)
+	_ MemoryStore = (*PGVectorStore)(nil)
+)
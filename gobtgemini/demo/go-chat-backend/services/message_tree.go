@@ -0,0 +1,307 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidBranchTarget 编辑或重新生成的目标消息角色不符
+var ErrInvalidBranchTarget = errors.New("只能编辑用户消息或重新生成AI回复")
+
+// PathMessage 当前分支上的消息，附带同级分支信息，供客户端切换
+type PathMessage struct {
+	models.ChatMessage
+	SiblingIndex int         `json:"sibling_index"`         // 在同级消息中的位置，从0开始
+	SiblingCount int         `json:"sibling_count"`         // 同级消息数量（含自身），大于1即为分支切换点
+	SiblingIDs   []uuid.UUID `json:"sibling_ids,omitempty"` // 同级消息ID，按创建时间排序
+}
+
+// MessageTreeNode 消息树节点
+type MessageTreeNode struct {
+	models.ChatMessage
+	Children []*MessageTreeNode `json:"children"`
+}
+
+// messageTree 对话中全部消息组成的树。
+// 已删除的消息不出现在结果中，其子消息挂到最近的未删除祖先上。
+type messageTree struct {
+	nodes    map[uuid.UUID]*models.ChatMessage
+	children map[uuid.UUID][]uuid.UUID // uuid.Nil 为虚拟根
+}
+
+// buildMessageTree 由对话的全部消息（含已删除）构建树，同级消息按创建时间排序
+func buildMessageTree(messages []models.ChatMessage) *messageTree {
+	tree := &messageTree{
+		nodes:    make(map[uuid.UUID]*models.ChatMessage, len(messages)),
+		children: make(map[uuid.UUID][]uuid.UUID),
+	}
+	for i := range messages {
+		tree.nodes[messages[i].ID] = &messages[i]
+	}
+
+	for _, msg := range tree.nodes {
+		if msg.DeletedAt.Valid {
+			continue
+		}
+		parent := tree.visibleParent(msg)
+		tree.children[parent] = append(tree.children[parent], msg.ID)
+	}
+	for parent, ids := range tree.children {
+		sort.Slice(ids, func(i, j int) bool {
+			a, b := tree.nodes[ids[i]], tree.nodes[ids[j]]
+			if a.CreatedAt.Equal(b.CreatedAt) {
+				return a.ID.String() < b.ID.String()
+			}
+			return a.CreatedAt.Before(b.CreatedAt)
+		})
+		tree.children[parent] = ids
+	}
+	return tree
+}
+
+// visibleParent 最近的未删除祖先，没有则为虚拟根
+func (t *messageTree) visibleParent(msg *models.ChatMessage) uuid.UUID {
+	for msg.ParentID != nil {
+		parent, ok := t.nodes[*msg.ParentID]
+		if !ok {
+			return uuid.Nil
+		}
+		if !parent.DeletedAt.Valid {
+			return parent.ID
+		}
+		msg = parent
+	}
+	return uuid.Nil
+}
+
+// pathTo 从根到 leafID 的可见消息；leafID 已删除时从其最近的未删除祖先开始
+func (t *messageTree) pathTo(leafID uuid.UUID) []*models.ChatMessage {
+	msg, ok := t.nodes[leafID]
+	if !ok {
+		return nil
+	}
+	if msg.DeletedAt.Valid {
+		parent := t.visibleParent(msg)
+		if parent == uuid.Nil {
+			return nil
+		}
+		msg = t.nodes[parent]
+	}
+
+	var path []*models.ChatMessage
+	for {
+		path = append(path, msg)
+		parent := t.visibleParent(msg)
+		if parent == uuid.Nil {
+			break
+		}
+		msg = t.nodes[parent]
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// activePath 对话当前分支；leafID 为空（早期数据）时取最新的消息
+func (t *messageTree) activePath(leafID *uuid.UUID) []*models.ChatMessage {
+	if leafID != nil {
+		return t.pathTo(*leafID)
+	}
+
+	var latest *models.ChatMessage
+	for _, msg := range t.nodes {
+		if msg.DeletedAt.Valid {
+			continue
+		}
+		if latest == nil || msg.CreatedAt.After(latest.CreatedAt) {
+			latest = msg
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return t.pathTo(latest.ID)
+}
+
+// latestLeaf 沿每层最新的子消息走到底，切换分支时展示该分支最近的对话
+func (t *messageTree) latestLeaf(id uuid.UUID) uuid.UUID {
+	for {
+		children := t.children[id]
+		if len(children) == 0 {
+			return id
+		}
+		id = children[len(children)-1]
+	}
+}
+
+// annotate 为路径上的消息补充同级分支信息
+func (t *messageTree) annotate(path []*models.ChatMessage) []PathMessage {
+	result := make([]PathMessage, 0, len(path))
+	for _, msg := range path {
+		siblings := t.children[t.visibleParent(msg)]
+		item := PathMessage{ChatMessage: *msg, SiblingCount: len(siblings)}
+		for i, id := range siblings {
+			if id == msg.ID {
+				item.SiblingIndex = i
+			}
+		}
+		if len(siblings) > 1 {
+			item.SiblingIDs = siblings
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// nested 转换为嵌套结构
+func (t *messageTree) nested(parent uuid.UUID) []*MessageTreeNode {
+	ids := t.children[parent]
+	nodes := make([]*MessageTreeNode, 0, len(ids))
+	for _, id := range ids {
+		nodes = append(nodes, &MessageTreeNode{
+			ChatMessage: *t.nodes[id],
+			Children:    t.nested(id),
+		})
+	}
+	return nodes
+}
+
+// loadMessageTree 加载对话的消息树
+func (s *ChatService) loadMessageTree(conversationID uuid.UUID) (*messageTree, error) {
+	return loadMessageTree(s.db, conversationID)
+}
+
+// loadMessageTree 加载对话的全部消息（含已删除）并构建消息树
+func loadMessageTree(db *gorm.DB, conversationID uuid.UUID) (*messageTree, error) {
+	var messages []models.ChatMessage
+	err := db.Unscoped().
+		Where("conversation_id = ?", conversationID).
+		Order("created_at ASC").
+		Find(&messages).Error
+	if err != nil {
+		logrus.WithError(err).WithField("conversation_id", conversationID).Error("加载消息树失败")
+		return nil, errors.New("获取对话历史失败")
+	}
+	return buildMessageTree(messages), nil
+}
+
+// GetPathMessages 获取从根到 leafID 的最后 limit 条消息，作为生成回复的上下文
+func (s *ChatService) GetPathMessages(userID, conversationID, leafID uuid.UUID, limit int) ([]models.ChatMessage, error) {
+	if _, err := s.AuthorizeConversation(userID, conversationID, ConversationRead); err != nil {
+		return nil, err
+	}
+
+	return s.pathMessages(conversationID, leafID, limit)
+}
+
+// pathMessages 从根到 leafID 的最后 limit 条消息，limit 不大于0时不限制
+func (s *ChatService) pathMessages(conversationID, leafID uuid.UUID, limit int) ([]models.ChatMessage, error) {
+	tree, err := s.loadMessageTree(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	path := tree.pathTo(leafID)
+	if limit > 0 && len(path) > limit {
+		path = path[len(path)-limit:]
+	}
+
+	messages := make([]models.ChatMessage, 0, len(path))
+	for _, msg := range path {
+		messages = append(messages, *msg)
+	}
+	return messages, nil
+}
+
+// GetConversationTree 获取对话的完整消息树
+func (s *ChatService) GetConversationTree(userID, conversationID uuid.UUID) ([]*MessageTreeNode, error) {
+	if _, err := s.AuthorizeConversation(userID, conversationID, ConversationRead); err != nil {
+		return nil, err
+	}
+
+	tree, err := s.loadMessageTree(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return tree.nested(uuid.Nil), nil
+}
+
+// SwitchBranch 切换到包含 messageID 的分支（沿最新的子消息走到底），返回新的当前路径
+func (s *ChatService) SwitchBranch(userID, conversationID, messageID uuid.UUID) ([]PathMessage, error) {
+	if _, err := s.AuthorizeConversation(userID, conversationID, ConversationWrite); err != nil {
+		return nil, err
+	}
+
+	tree, err := s.loadMessageTree(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := tree.nodes[messageID]
+	if !ok || msg.DeletedAt.Valid {
+		return nil, ErrMessageNotFound
+	}
+
+	leaf := tree.latestLeaf(messageID)
+	if err := s.setActiveLeaf(s.db, conversationID, &leaf); err != nil {
+		return nil, err
+	}
+	return tree.annotate(tree.pathTo(leaf)), nil
+}
+
+// EditMessage 编辑用户消息：在原消息的父消息下创建新的同级消息并切换到该分支，原消息及其后续保留
+func (s *ChatService) EditMessage(userID, conversationID, messageID uuid.UUID, content string) (*models.ChatMessage, error) {
+	original, err := s.AuthorizeMessage(userID, messageID, ConversationWrite)
+	if err != nil {
+		return nil, err
+	}
+	if original.ConversationID != conversationID {
+		return nil, ErrMessageNotFound
+	}
+	if original.Role != "user" {
+		return nil, ErrInvalidBranchTarget
+	}
+
+	return s.AddMessage(userID, original.ConversationID, original.ParentID, content, "user", nil)
+}
+
+// RegenerateSource 重新生成AI回复时，返回该回复对应的用户消息；新回复作为原回复的同级消息
+func (s *ChatService) RegenerateSource(userID, conversationID, messageID uuid.UUID) (*models.ChatMessage, error) {
+	reply, err := s.AuthorizeMessage(userID, messageID, ConversationWrite)
+	if err != nil {
+		return nil, err
+	}
+	if reply.ConversationID != conversationID {
+		return nil, ErrMessageNotFound
+	}
+	if reply.Role != "assistant" || reply.ParentID == nil {
+		return nil, ErrInvalidBranchTarget
+	}
+
+	var source models.ChatMessage
+	if err := s.db.Where("id = ?", *reply.ParentID).First(&source).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		logrus.WithError(err).Error("查询消息失败")
+		return nil, errors.New("查询消息失败")
+	}
+	return &source, nil
+}
+
+// setActiveLeaf 更新对话当前分支的最后一条消息
+func (s *ChatService) setActiveLeaf(db *gorm.DB, conversationID uuid.UUID, leafID *uuid.UUID) error {
+	err := db.Model(&models.ChatSession{}).
+		Where("id = ?", conversationID).
+		Update("active_leaf_id", leafID).Error
+	if err != nil {
+		logrus.WithError(err).Error("更新当前分支失败")
+		return errors.New("更新当前分支失败")
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestEditMessageCreatesBranch 测试编辑用户消息产生同级分支，上下文沿当前分支
+func TestEditMessageCreatesBranch(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	q1, err := chatService.SendMessage(alice.ID, conversation.ID, "问题一", "user", nil)
+	require.NoError(t, err)
+	a1, err := chatService.AddMessage(alice.ID, conversation.ID, &q1.ID, "回答一", "assistant", nil)
+	require.NoError(t, err)
+	q2, err := chatService.SendMessage(alice.ID, conversation.ID, "问题二", "user", nil)
+	require.NoError(t, err)
+	require.NotNil(t, q2.ParentID)
+	assert.Equal(t, a1.ID, *q2.ParentID)
+
+	edited, err := chatService.EditMessage(alice.ID, conversation.ID, q2.ID, "问题二（修改）")
+	require.NoError(t, err)
+	assert.Equal(t, a1.ID, *edited.ParentID)
+
+	// 上下文只包含新分支
+	recent, err := chatService.GetRecentMessages(alice.ID, conversation.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, recent, 3)
+	assert.Equal(t, "问题二（修改）", recent[2].Content)
+
+	history, _, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, 1, history[0].SiblingCount)
+	assert.Equal(t, 2, history[2].SiblingCount)
+	assert.Equal(t, 1, history[2].SiblingIndex)
+	assert.Equal(t, []uuid.UUID{q2.ID, edited.ID}, history[2].SiblingIDs)
+
+	// 只能编辑用户消息
+	_, err = chatService.EditMessage(alice.ID, conversation.ID, a1.ID, "改写回答")
+	assert.ErrorIs(t, err, ErrInvalidBranchTarget)
+}
+
+// TestSwitchBranchAndRegenerate 测试切换分支到最新的后代，重新生成的回复作为同级分支
+func TestSwitchBranchAndRegenerate(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	q1, err := chatService.SendMessage(alice.ID, conversation.ID, "问题一", "user", nil)
+	require.NoError(t, err)
+	a1, err := chatService.AddMessage(alice.ID, conversation.ID, &q1.ID, "回答一", "assistant", nil)
+	require.NoError(t, err)
+	q2, err := chatService.SendMessage(alice.ID, conversation.ID, "问题二", "user", nil)
+	require.NoError(t, err)
+
+	source, err := chatService.RegenerateSource(alice.ID, conversation.ID, a1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, q1.ID, source.ID)
+	a1b, err := chatService.AddMessage(alice.ID, conversation.ID, &source.ID, "回答一（重新生成）", "assistant", nil)
+	require.NoError(t, err)
+
+	recent, err := chatService.GetRecentMessages(alice.ID, conversation.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, recent, 2)
+	assert.Equal(t, a1b.ID, recent[1].ID)
+
+	// 切回原回复时沿其最新的子消息走到底
+	path, err := chatService.SwitchBranch(alice.ID, conversation.ID, a1.ID)
+	require.NoError(t, err)
+	require.Len(t, path, 3)
+	assert.Equal(t, q2.ID, path[2].ID)
+	assert.Equal(t, 2, path[1].SiblingCount)
+
+	next, err := chatService.SendMessage(alice.ID, conversation.ID, "问题三", "user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, q2.ID, *next.ParentID)
+
+	tree, err := chatService.GetConversationTree(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, tree, 1)
+	assert.Len(t, tree[0].Children, 2)
+
+	_, err = chatService.SwitchBranch(bob.ID, conversation.ID, a1.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	_, err = chatService.RegenerateSource(alice.ID, conversation.ID, q1.ID)
+	assert.ErrorIs(t, err, ErrInvalidBranchTarget)
+}
+
+// TestDeleteActiveLeaf 测试删除当前分支末尾的消息后回退到父消息
+func TestDeleteActiveLeaf(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	q1, err := chatService.SendMessage(alice.ID, conversation.ID, "问题一", "user", nil)
+	require.NoError(t, err)
+	q2, err := chatService.SendMessage(alice.ID, conversation.ID, "问题二", "user", nil)
+	require.NoError(t, err)
+	q3, err := chatService.SendMessage(alice.ID, conversation.ID, "问题三", "user", nil)
+	require.NoError(t, err)
+
+	// 删除中间的消息，其子消息挂到最近的祖先
+	require.NoError(t, chatService.DeleteMessage(alice.ID, q2.ID))
+	history, _, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, q3.ID, history[1].ID)
+
+	require.NoError(t, chatService.DeleteMessage(alice.ID, q3.ID))
+	next, err := chatService.SendMessage(alice.ID, conversation.ID, "问题四", "user", nil)
+	require.NoError(t, err)
+	require.NotNil(t, next.ParentID)
+
+	history, _, err = chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, q1.ID, history[0].ID)
+	assert.Equal(t, next.ID, history[1].ID)
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"go-chat-backend/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 分页方向
+const (
+	PageBefore = "before" // 比游标更早的数据（默认）
+	PageAfter  = "after"  // 比游标更新的数据
+)
+
+// 列表接口统一的单页上限
+const MaxPageSize = 100
+
+// ErrInvalidPageDirection 分页方向错误
+var ErrInvalidPageDirection = errors.New("direction 只能是 before 或 after")
+
+// PageRequest 游标分页参数。Cursor 为空时从最新的数据开始
+type PageRequest struct {
+	Cursor    string
+	Direction string
+	Limit     int
+}
+
+// PageInfo 游标分页结果。Before 用于获取更早的一页，After 用于获取更新的一页
+type PageInfo struct {
+	Limit   int    `json:"limit"`
+	HasMore bool   `json:"has_more"` // 沿请求方向是否还有数据
+	Before  string `json:"before,omitempty"`
+	After   string `json:"after,omitempty"`
+}
+
+// SyncRequest 增量同步参数，Since 为空时从头同步
+type SyncRequest struct {
+	Since string
+	Limit int
+}
+
+// pageCursor 解析后的分页参数
+type pageCursor struct {
+	page  PageRequest
+	time  time.Time
+	id    uuid.UUID
+	valid bool
+}
+
+// parsePage 校验分页参数，limit 不合法时使用 defaultLimit，超过上限时取上限
+func parsePage(page PageRequest, defaultLimit int) (pageCursor, error) {
+	if page.Direction == "" {
+		page.Direction = PageBefore
+	}
+	if page.Direction != PageBefore && page.Direction != PageAfter {
+		return pageCursor{}, ErrInvalidPageDirection
+	}
+	if page.Limit <= 0 {
+		page.Limit = defaultLimit
+	}
+	if page.Limit > MaxPageSize {
+		page.Limit = MaxPageSize
+	}
+
+	pc := pageCursor{page: page}
+	if page.Cursor != "" {
+		t, id, err := utils.DecodeCursor(page.Cursor)
+		if err != nil {
+			return pageCursor{}, err
+		}
+		pc.time, pc.id, pc.valid = t, id, true
+	}
+	return pc, nil
+}
+
+// apply 按 (created_at, id) 添加游标条件和排序，多取一条用于判断是否还有数据
+func (pc pageCursor) apply(query *gorm.DB, prefix string) *gorm.DB {
+	return pc.applyOn(query, prefix+"created_at", prefix+"id")
+}
+
+// applyOn 按 (timeColumn, idColumn) 添加游标条件和排序，用于不按创建时间排序的列表
+func (pc pageCursor) applyOn(query *gorm.DB, timeColumn, idColumn string) *gorm.DB {
+	if pc.page.Direction == PageAfter {
+		if pc.valid {
+			query = query.Where("("+timeColumn+" > ? OR ("+timeColumn+" = ? AND "+idColumn+" > ?))", pc.time, pc.time, pc.id)
+		}
+		return query.Order(timeColumn + " ASC, " + idColumn + " ASC").Limit(pc.page.Limit + 1)
+	}
+	if pc.valid {
+		query = query.Where("("+timeColumn+" < ? OR ("+timeColumn+" = ? AND "+idColumn+" < ?))", pc.time, pc.time, pc.id)
+	}
+	return query.Order(timeColumn + " DESC, " + idColumn + " DESC").Limit(pc.page.Limit + 1)
+}
+
+// compare 比较 (t, id) 与游标的先后，之前返回负数，之后返回正数
+func (pc pageCursor) compare(t time.Time, id uuid.UUID) int {
+	switch {
+	case t.Before(pc.time):
+		return -1
+	case t.After(pc.time):
+		return 1
+	}
+	return bytes.Compare(id[:], pc.id[:])
+}
+
+// includes 判断 (t, id) 是否在请求方向的游标范围内
+func (pc pageCursor) includes(t time.Time, id uuid.UUID) bool {
+	if !pc.valid {
+		return true
+	}
+	if pc.page.Direction == PageAfter {
+		return pc.compare(t, id) > 0
+	}
+	return pc.compare(t, id) < 0
+}
+
+// finishPage 截取按查询方向排列的结果，并按 ascending 指定的顺序返回（true 为从旧到新）
+func finishPage[T any](items []T, pc pageCursor, ascending bool, key func(T) (time.Time, uuid.UUID)) ([]T, *PageInfo) {
+	info := &PageInfo{Limit: pc.page.Limit}
+	if len(items) > pc.page.Limit {
+		info.HasMore = true
+		items = items[:pc.page.Limit]
+	}
+
+	// 查询结果向前翻页时从新到旧，向后翻页时从旧到新
+	if (pc.page.Direction == PageAfter) != ascending {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	if len(items) == 0 {
+		// 没有更新的数据时保留原游标，客户端可继续轮询
+		if pc.page.Direction == PageAfter {
+			info.After = pc.page.Cursor
+		}
+		return items, info
+	}
+
+	oldest, newest := items[0], items[len(items)-1]
+	if !ascending {
+		oldest, newest = newest, oldest
+	}
+	info.Before = utils.EncodeCursor(key(oldest))
+	info.After = utils.EncodeCursor(key(newest))
+	return items, info
+}
+
+// defaultSyncOverlap 同步令牌回退的时间窗口。updated_at 在事务提交前写入，提交较晚的事务中的记录
+// 可能早于其他请求已经返回的令牌；令牌回退这段时间后，下次同步会重新读取窗口内的记录（客户端按 ID 去重），
+// 提交耗时不超过该窗口的变更不会被遗漏
+const defaultSyncOverlap = 10 * time.Second
+
+// parseSyncToken 解析同步令牌，空令牌表示从头开始
+func parseSyncToken(since string) (time.Time, uuid.UUID, error) {
+	if since == "" {
+		return time.Time{}, uuid.Nil, nil
+	}
+	return utils.DecodeCursor(since)
+}
+
+// applySync 查询 (updated_at, id) 在令牌之后的记录（包括软删除的），从旧到新排列
+func applySync(query *gorm.DB, since time.Time, sinceID uuid.UUID, limit int) *gorm.DB {
+	return query.Unscoped().
+		Where("(updated_at > ? OR (updated_at = ? AND id > ?))", since, since, sinceID).
+		Order("updated_at ASC, id ASC").
+		Limit(limit + 1)
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"go-chat-backend/models"
+	"go-chat-backend/utils"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConversationHistoryCursor 测试对话历史默认返回最新一页，并可沿游标双向翻页
+func TestConversationHistoryCursor(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	var contents []string
+	for _, content := range []string{"一", "二", "三", "四", "五"} {
+		_, err := chatService.SendMessage(alice.ID, conversation.ID, content, "user", nil)
+		require.NoError(t, err)
+		contents = append(contents, content)
+	}
+
+	pageContents := func(messages []PathMessage) []string {
+		result := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			result = append(result, msg.Content)
+		}
+		return result
+	}
+
+	latest, page, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"四", "五"}, pageContents(latest))
+	assert.True(t, page.HasMore)
+
+	older, page, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{Cursor: page.Before, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"二", "三"}, pageContents(older))
+	assert.True(t, page.HasMore)
+
+	oldest, page, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{Cursor: page.Before, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"一"}, pageContents(oldest))
+	assert.False(t, page.HasMore)
+
+	newer, page, err := chatService.GetOneConversationHistory(alice.ID, conversation.ID, PageRequest{Cursor: page.After, Direction: PageAfter, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"二", "三", "四"}, pageContents(newer))
+	assert.True(t, page.HasMore)
+
+	all, _, err := chatService.GetChatHistory(alice.ID, PageRequest{Limit: 500})
+	require.NoError(t, err)
+	require.Len(t, all, len(contents))
+	assert.Equal(t, "一", all[0].Content)
+
+	_, _, err = chatService.GetChatHistory(alice.ID, PageRequest{Cursor: "bad"})
+	assert.ErrorIs(t, err, utils.ErrInvalidCursor)
+	_, _, err = chatService.GetChatHistory(alice.ID, PageRequest{Direction: "sideways"})
+	assert.ErrorIs(t, err, ErrInvalidPageDirection)
+}
+
+// TestConversationListCursor 测试对话列表按创建时间从新到旧分页
+func TestConversationListCursor(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	for _, title := range []string{"A", "B", "C"} {
+		_, err := chatService.CreateChatSession(alice.ID, title)
+		require.NoError(t, err)
+	}
+
+	first, page, err := chatService.GetChatSessions(alice.ID, ConversationFilter{}, PageRequest{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	assert.Equal(t, "C", first[0].Title)
+	assert.Equal(t, "B", first[1].Title)
+	assert.True(t, page.HasMore)
+
+	rest, page, err := chatService.GetChatSessions(alice.ID, ConversationFilter{}, PageRequest{Cursor: page.Before, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.Equal(t, "A", rest[0].Title)
+	assert.False(t, page.HasMore)
+}
+
+// TestSyncChanges 测试同步令牌之后的新增和删除都能被获取
+func TestSyncChanges(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	chatService.syncOverlap = 0 // 只检查令牌之后的变更，重叠窗口见 TestSyncOverlap
+	alice := createTestUser(t, db, "alice")
+
+	kept, err := chatService.CreateChatSession(alice.ID, "保留")
+	require.NoError(t, err)
+	removed, err := chatService.CreateChatSession(alice.ID, "删除")
+	require.NoError(t, err)
+	first, err := chatService.SendMessage(alice.ID, kept.ID, "旧消息", "user", nil)
+	require.NoError(t, err)
+
+	token := chatService.SyncToken()
+
+	second, err := chatService.SendMessage(alice.ID, kept.ID, "新消息", "user", nil)
+	require.NoError(t, err)
+	require.NoError(t, chatService.DeleteMessage(alice.ID, first.ID))
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, removed.ID))
+
+	changes, err := chatService.SyncMessages(alice.ID, nil, SyncRequest{Since: token})
+	require.NoError(t, err)
+	require.Len(t, changes.Messages, 2)
+	assert.Equal(t, second.ID, changes.Messages[0].ID)
+	assert.False(t, changes.Messages[0].Deleted)
+	assert.Equal(t, first.ID, changes.Messages[1].ID)
+	assert.True(t, changes.Messages[1].Deleted)
+
+	conversationChanges, err := chatService.SyncMessages(alice.ID, &kept.ID, SyncRequest{Since: token, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, conversationChanges.Messages, 1)
+	assert.True(t, conversationChanges.HasMore)
+
+	again, err := chatService.SyncMessages(alice.ID, nil, SyncRequest{Since: changes.SyncToken})
+	require.NoError(t, err)
+	assert.Empty(t, again.Messages)
+	assert.Equal(t, changes.SyncToken, again.SyncToken)
+
+	sessionChanges, err := chatService.SyncChatSessions(alice.ID, SyncRequest{Since: token})
+	require.NoError(t, err)
+	deleted := map[string]bool{}
+	for _, session := range sessionChanges.Conversations {
+		deleted[session.Title] = !session.IsActive && session.DeletedAt.Valid
+	}
+	assert.Equal(t, map[string]bool{"保留": false, "删除": true}, deleted)
+}
+
+// TestSyncOverlap 测试已追上最新变更的令牌回退重叠窗口，提交较晚、updated_at 早于上次令牌的记录不会被遗漏
+func TestSyncOverlap(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "第一条", "user", nil)
+	require.NoError(t, err)
+	second, err := chatService.SendMessage(alice.ID, conversation.ID, "第二条", "user", nil)
+	require.NoError(t, err)
+
+	// 分页过程中从最后一条记录处继续，不重复返回
+	page, err := chatService.SyncMessages(alice.ID, nil, SyncRequest{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Messages, 1)
+	assert.True(t, page.HasMore)
+	page, err = chatService.SyncMessages(alice.ID, nil, SyncRequest{Since: page.SyncToken, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Messages, 1)
+	assert.Equal(t, second.ID, page.Messages[0].ID)
+	assert.False(t, page.HasMore)
+
+	// 另一个事务在上次同步前写入 updated_at、同步后才提交
+	late := models.ChatMessage{
+		ID:             uuid.New(),
+		MessageID:      uuid.New(),
+		UserID:         alice.ID,
+		ConversationID: conversation.ID,
+		Content:        "提交较晚",
+		Role:           "user",
+	}
+	require.NoError(t, db.Create(&late).Error)
+	require.NoError(t, db.Model(&late).UpdateColumn("updated_at", second.UpdatedAt.Add(-time.Second)).Error)
+
+	changes, err := chatService.SyncMessages(alice.ID, nil, SyncRequest{Since: page.SyncToken})
+	require.NoError(t, err)
+	ids := make([]uuid.UUID, len(changes.Messages))
+	for i, msg := range changes.Messages {
+		ids[i] = msg.ID
+	}
+	assert.Contains(t, ids, late.ID)
+	assert.Contains(t, ids, second.ID) // 窗口内已返回过的记录再次返回，由客户端按 ID 去重
+
+	// 没有新变更时令牌不再后退
+	again, err := chatService.SyncMessages(alice.ID, nil, SyncRequest{Since: changes.SyncToken})
+	require.NoError(t, err)
+	assert.Equal(t, changes.SyncToken, again.SyncToken)
+}
+
+// TestConversationListActivity 测试发送和删除消息后对话的最后消息时间、消息数和摘要，以及列表按活动时间排序
+func TestConversationListActivity(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+
+	older, err := chatService.CreateChatSession(alice.ID, "旧对话")
+	require.NoError(t, err)
+	_, err = chatService.CreateChatSession(alice.ID, "新对话")
+	require.NoError(t, err)
+
+	first, err := chatService.SendMessage(alice.ID, older.ID, "第一条", "user", nil)
+	require.NoError(t, err)
+	second, err := chatService.SendMessage(alice.ID, older.ID, "第二条\n  带换行", "assistant", nil)
+	require.NoError(t, err)
+
+	sessions, _, err := chatService.GetChatSessions(alice.ID, ConversationFilter{}, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "旧对话", sessions[0].Title)
+	assert.Equal(t, 2, sessions[0].MessageCount)
+	assert.Equal(t, "第二条 带换行", sessions[0].LastMessagePreview)
+	assert.True(t, sessions[0].LastMessageAt.Equal(second.CreatedAt))
+	assert.Equal(t, 0, sessions[1].MessageCount)
+
+	require.NoError(t, chatService.DeleteMessage(alice.ID, second.ID))
+	session, err := chatService.GetChatSession(alice.ID, older.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, session.MessageCount)
+	assert.Equal(t, "第一条", session.LastMessagePreview)
+	assert.True(t, session.LastMessageAt.Equal(first.CreatedAt))
+
+	require.NoError(t, chatService.ClearHistory(alice.ID))
+	session, err = chatService.GetChatSession(alice.ID, older.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, session.MessageCount)
+	assert.Empty(t, session.LastMessagePreview)
+	assert.True(t, session.LastMessageAt.Equal(session.CreatedAt))
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 人设相关错误
+var (
+	ErrPersonaNotFound  = errors.New("人设不存在或无权访问")
+	ErrPersonaExists    = errors.New("已存在同名人设")
+	ErrEmptyPersonaName = errors.New("人设名称不能为空")
+)
+
+// PersonaService 人设服务，人设是用户保存的一组生成参数，对话选用后参与设置合并
+type PersonaService struct {
+	db *gorm.DB
+}
+
+// NewPersonaService 创建人设服务
+func NewPersonaService(db *gorm.DB) *PersonaService {
+	return &PersonaService{db: db}
+}
+
+// CreatePersona 创建人设，同一用户下名称不能重复
+func (s *PersonaService) CreatePersona(userID uuid.UUID, name string, settings models.GenerationSettings) (*models.Persona, error) {
+	if err := ValidateSettings(settings); err != nil {
+		return nil, err
+	}
+	name, err := s.checkName(userID, uuid.Nil, name)
+	if err != nil {
+		return nil, err
+	}
+
+	persona := &models.Persona{
+		ID:       uuid.New(),
+		UserID:   userID,
+		Name:     name,
+		Settings: settings,
+	}
+	if err := s.db.Create(persona).Error; err != nil {
+		logrus.WithError(err).Error("创建人设失败")
+		return nil, errors.New("创建人设失败")
+	}
+	return persona, nil
+}
+
+// ListPersonas 获取用户的人设，按名称排序
+func (s *PersonaService) ListPersonas(userID uuid.UUID) ([]models.Persona, error) {
+	var personas []models.Persona
+	if err := s.db.Where("user_id = ?", userID).Order("name ASC").Find(&personas).Error; err != nil {
+		logrus.WithError(err).Error("获取人设列表失败")
+		return nil, errors.New("获取人设列表失败")
+	}
+	return personas, nil
+}
+
+// UpdatePersona 替换人设的名称和生成参数，为 nil 的字段恢复为沿用用户设置
+func (s *PersonaService) UpdatePersona(userID, personaID uuid.UUID, name string, settings models.GenerationSettings) (*models.Persona, error) {
+	if err := ValidateSettings(settings); err != nil {
+		return nil, err
+	}
+	persona, err := findPersona(s.db, userID, personaID)
+	if err != nil {
+		return nil, err
+	}
+	name, err = s.checkName(userID, personaID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// 用 map 更新，确保为 nil 的字段也写入 NULL
+	err = s.db.Model(persona).Updates(map[string]interface{}{
+		"name":                   name,
+		"setting_model":          settings.Model,
+		"setting_temperature":    settings.Temperature,
+		"setting_max_tokens":     settings.MaxTokens,
+		"setting_system_prompt":  settings.SystemPrompt,
+		"setting_context_window": settings.ContextWindow,
+		"setting_memory_enabled": settings.MemoryEnabled,
+	}).Error
+	if err != nil {
+		logrus.WithError(err).Error("更新人设失败")
+		return nil, errors.New("更新人设失败")
+	}
+
+	persona.Name = name
+	persona.Settings = settings
+	return persona, nil
+}
+
+// DeletePersona 删除人设，使用它的对话不再使用人设
+func (s *PersonaService) DeletePersona(userID, personaID uuid.UUID) error {
+	persona, err := findPersona(s.db, userID, personaID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.ChatSession{}).Unscoped().
+			Where("persona_id = ?", persona.ID).
+			Updates(map[string]interface{}{"persona_id": nil, "updated_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(persona).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("删除人设失败")
+		return errors.New("删除人设失败")
+	}
+	return nil
+}
+
+// checkName 校验人设名称，excludeID 为修改时的人设自身
+func (s *PersonaService) checkName(userID, excludeID uuid.UUID, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrEmptyPersonaName
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+
+	var count int64
+	err := s.db.Model(&models.Persona{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).
+		Count(&count).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询人设失败")
+		return "", errors.New("查询人设失败")
+	}
+	if count > 0 {
+		return "", ErrPersonaExists
+	}
+	return name, nil
+}
+
+// findPersona 查找用户自己的人设
+func findPersona(db *gorm.DB, userID, personaID uuid.UUID) (*models.Persona, error) {
+	var persona models.Persona
+	err := db.Where("id = ? AND user_id = ?", personaID, userID).First(&persona).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPersonaNotFound
+		}
+		logrus.WithError(err).Error("查询人设失败")
+		return nil, errors.New("查询人设失败")
+	}
+	return &persona, nil
+}
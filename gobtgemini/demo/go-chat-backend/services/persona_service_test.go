@@ -0,0 +1,63 @@
+package services
+
+import (
+	"go-chat-backend/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPersonas 测试人设的增删改、只能选用自己的人设，以及删除后对话不再使用人设
+func TestPersonas(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	personaService := NewPersonaService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	prompt := "你是一名翻译"
+	persona, err := personaService.CreatePersona(alice.ID, " 翻译 ", models.GenerationSettings{SystemPrompt: &prompt})
+	require.NoError(t, err)
+	assert.Equal(t, "翻译", persona.Name)
+	_, err = personaService.CreatePersona(alice.ID, "翻译", models.GenerationSettings{})
+	assert.ErrorIs(t, err, ErrPersonaExists)
+	_, err = personaService.CreatePersona(alice.ID, " ", models.GenerationSettings{})
+	assert.ErrorIs(t, err, ErrEmptyPersonaName)
+	window := 500
+	_, err = personaService.CreatePersona(alice.ID, "长上下文", models.GenerationSettings{ContextWindow: &window})
+	assert.ErrorIs(t, err, ErrInvalidSettings)
+	bobPersona, err := personaService.CreatePersona(bob.ID, "翻译", models.GenerationSettings{})
+	require.NoError(t, err)
+
+	// 替换后为 nil 的字段写入 NULL
+	tokens := 2000
+	updated, err := personaService.UpdatePersona(alice.ID, persona.ID, "翻译助手", models.GenerationSettings{MaxTokens: &tokens})
+	require.NoError(t, err)
+	assert.Equal(t, "翻译助手", updated.Name)
+	_, err = personaService.UpdatePersona(bob.ID, persona.ID, "翻译", models.GenerationSettings{})
+	assert.ErrorIs(t, err, ErrPersonaNotFound)
+
+	personas, err := personaService.ListPersonas(alice.ID)
+	require.NoError(t, err)
+	require.Len(t, personas, 1)
+	assert.Nil(t, personas[0].Settings.SystemPrompt)
+	require.NotNil(t, personas[0].Settings.MaxTokens)
+	assert.Equal(t, 2000, *personas[0].Settings.MaxTokens)
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{}, &bobPersona.ID)
+	assert.ErrorIs(t, err, ErrPersonaNotFound)
+	_, err = chatService.UpdateConversationSettings(alice.ID, conversation.ID, models.GenerationSettings{}, &persona.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, personaService.DeletePersona(alice.ID, persona.ID))
+	assert.ErrorIs(t, personaService.DeletePersona(alice.ID, persona.ID), ErrPersonaNotFound)
+	session, err := chatService.GetChatSession(alice.ID, conversation.ID)
+	require.NoError(t, err)
+	assert.Nil(t, session.PersonaID)
+	selected, err := chatService.ConversationPersona(session)
+	require.NoError(t, err)
+	assert.Nil(t, selected)
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"fmt"
+	"go-chat-backend/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// pgvector 距离函数对应的排序运算符
+var pgvectorOperators = map[string]string{
+	"cosine": "<=>",
+	"l2":     "<->",
+	"ip":     "<#>",
+}
+
+// PGVectorStore 基于 pgvector 的记忆库，与用户和消息共用同一个 Postgres 连接。
+// 表结构和向量索引由 database.InitDB 在 MEMORY_BACKEND=pgvector 时创建。
+type PGVectorStore struct {
+	db       *gorm.DB
+	embedder *EmbeddingService
+	operator string
+	index    string // hnsw、ivfflat 或 none，决定检索时调整哪些参数
+	lists    int    // IVFFlat 的聚类数
+
+	mu         sync.RWMutex
+	collection string
+
+	versionOnce   sync.Once
+	iterativeScan bool // pgvector >= 0.8 支持过滤后继续扫描索引
+}
+
+// pgvectorRow 写入 memory_vectors 的一行
+type pgvectorRow struct {
+	ID             string
+	Collection     string
+	UserID         uuid.UUID
+	ConversationID uuid.UUID
+	Role           string
+	Content        string
+	Embedding      string
+	CreatedAt      time.Time
+}
+
+// NewPGVectorStore 创建 pgvector 记忆库，默认集合名称与 Chroma 相同，切换后端后可直接用 reindex 重建
+func NewPGVectorStore(db *gorm.DB, embedder *EmbeddingService) (*PGVectorStore, error) {
+	cfg := config.Get()
+
+	operator, ok := pgvectorOperators[cfg.PGVectorDistance]
+	if !ok {
+		return nil, fmt.Errorf("不支持的 pgvector 距离函数: %s", cfg.PGVectorDistance)
+	}
+
+	return &PGVectorStore{
+		db:         db,
+		embedder:   embedder,
+		operator:   operator,
+		index:      cfg.PGVectorIndex,
+		lists:      cfg.PGVectorIVFFlatLists,
+		collection: cfg.ChromaCollection,
+	}, nil
+}
+
+// EnsureCollection pgvector 的集合只是一个列值，无需创建，集合ID即名称
+func (s *PGVectorStore) EnsureCollection(name string) (string, error) {
+	return name, nil
+}
+
+// SwitchCollection 将记忆读写切换到指定集合
+func (s *PGVectorStore) SwitchCollection(name string) error {
+	s.mu.Lock()
+	previous := s.collection
+	s.collection = name
+	s.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"from": previous,
+		"to":   name,
+	}).Info("记忆集合已切换")
+	return nil
+}
+
+// CollectionName 当前使用的记忆集合名称
+func (s *PGVectorStore) CollectionName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collection
+}
+
+// AddMemories 批量写入当前集合
+func (s *PGVectorStore) AddMemories(entries []MemoryEntry) error {
+	return s.AddMemoriesTo(s.CollectionName(), entries)
+}
+
+// AddMemoriesTo 批量写入指定集合，按 (collection, id) upsert，重复写入时幂等
+func (s *PGVectorStore) AddMemoriesTo(collectionID string, entries []MemoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	texts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		texts = append(texts, entry.Content)
+	}
+	embeddings, err := s.embedder.CreateEmbeddings(texts)
+	if err != nil {
+		return fmt.Errorf("批量创建向量失败: %w", err)
+	}
+
+	placeholders := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, len(entries)*8)
+	for i, entry := range entries {
+		row := pgvectorRow{
+			ID:             entry.ID,
+			Collection:     collectionID,
+			UserID:         entry.UserID,
+			ConversationID: entry.ConversationID,
+			Role:           entry.Role,
+			Content:        entry.Content,
+			Embedding:      vectorLiteral(embeddings[i]),
+			CreatedAt:      entry.Timestamp,
+		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?::vector, ?)")
+		args = append(args, row.ID, row.Collection, row.UserID, row.ConversationID, row.Role, row.Content, row.Embedding, row.CreatedAt)
+	}
+
+	sql := `INSERT INTO memory_vectors (id, collection, user_id, conversation_id, role, content, embedding, created_at)
+VALUES ` + strings.Join(placeholders, ", ") + `
+ON CONFLICT (collection, id) DO UPDATE SET
+	user_id = EXCLUDED.user_id,
+	conversation_id = EXCLUDED.conversation_id,
+	role = EXCLUDED.role,
+	content = EXCLUDED.content,
+	embedding = EXCLUDED.embedding,
+	created_at = EXCLUDED.created_at`
+
+	if err := s.db.Exec(sql, args...).Error; err != nil {
+		return fmt.Errorf("写入记忆失败: %w", err)
+	}
+	return nil
+}
+
+// SearchMemory 搜索相关记忆，与 ChromaService 一样只在当前集合中按 user_id 过滤
+func (s *PGVectorStore) SearchMemory(userID uuid.UUID, query string, limit int) ([]string, error) {
+	if limit <= 0 || limit > 20 {
+		limit = 5 // 默认返回5个结果
+	}
+	queryEmbedding, err := s.embedder.CreateEmbedding(query)
+	if err != nil {
+		return nil, fmt.Errorf("创建查询向量失败: %w", err)
+	}
+
+	documents, err := s.searchEmbedding(userID, queryEmbedding, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id": userID.String(),
+		"query":   query,
+		"results": len(documents),
+	}).Debug("成功搜索pgvector记忆")
+
+	return documents, nil
+}
+
+// searchEmbedding 在当前集合中查找用户最相近的记忆。
+// 近似索引先取固定数量的候选再按 collection 和 user_id 过滤，其他用户的记忆多时当前用户会取不到结果，
+// 所以在同一事务中用 SET LOCAL 开启迭代扫描（不支持时调大候选数），只影响本次查询。
+// 迭代扫描返回的顺序是近似的，外层再按距离排一次
+func (s *PGVectorStore) searchEmbedding(userID uuid.UUID, embedding []float64, limit int) ([]string, error) {
+	sql := fmt.Sprintf(`WITH candidates AS MATERIALIZED (
+	SELECT content, embedding %s ?::vector AS distance
+	FROM memory_vectors
+	WHERE collection = ? AND user_id = ?
+	ORDER BY distance
+	LIMIT ?
+)
+SELECT content FROM candidates ORDER BY distance`, s.operator)
+
+	var documents []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range pgvectorSearchSettings(s.index, s.lists, s.supportsIterativeScan()) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Raw(sql, vectorLiteral(embedding), s.CollectionName(), userID, limit).Scan(&documents).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("查询记忆失败: %w", err)
+	}
+	return documents, nil
+}
+
+// supportsIterativeScan 首次检索时查询 pgvector 扩展版本
+func (s *PGVectorStore) supportsIterativeScan() bool {
+	s.versionOnce.Do(func() {
+		var version string
+		err := s.db.Raw("SELECT extversion FROM pg_extension WHERE extname = 'vector'").Scan(&version).Error
+		if err != nil {
+			logrus.WithError(err).Warn("查询 pgvector 版本失败，检索时不使用迭代扫描")
+			return
+		}
+		s.iterativeScan = pgvectorVersionAtLeast(version, 0, 8)
+		if !s.iterativeScan {
+			logrus.WithField("version", version).Warn("pgvector 低于 0.8 不支持迭代扫描，检索时调大候选数量")
+		}
+	})
+	return s.iterativeScan
+}
+
+// HNSW 的 ef_search 上限，pgvector 不接受更大的值
+const pgvectorMaxEFSearch = 1000
+
+// pgvectorSearchSettings 检索事务中需要执行的 SET LOCAL 语句。
+// 支持迭代扫描时，候选过滤后不足会继续扫描索引；否则 HNSW 调到最大 ef_search，IVFFlat 扫描全部聚类
+func pgvectorSearchSettings(index string, lists int, iterative bool) []string {
+	switch index {
+	case "hnsw":
+		if iterative {
+			return []string{"SET LOCAL hnsw.iterative_scan = relaxed_order"}
+		}
+		return []string{fmt.Sprintf("SET LOCAL hnsw.ef_search = %d", pgvectorMaxEFSearch)}
+	case "ivfflat":
+		if iterative {
+			return []string{"SET LOCAL ivfflat.iterative_scan = relaxed_order"}
+		}
+		if lists <= 0 {
+			lists = 100
+		}
+		return []string{fmt.Sprintf("SET LOCAL ivfflat.probes = %d", lists)}
+	default:
+		return nil
+	}
+}
+
+// pgvectorVersionAtLeast 比较 pgvector 扩展版本号，如 0.8.0
+func pgvectorVersionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, err1 := strconv.Atoi(parts[0])
+	gotMinor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// ClearUserMemory 删除用户在所有集合中的记忆
+func (s *PGVectorStore) ClearUserMemory(userID uuid.UUID) error {
+	result := s.db.Exec("DELETE FROM memory_vectors WHERE user_id = ?", userID)
+	if result.Error != nil {
+		return fmt.Errorf("清空用户记忆失败: %w", result.Error)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id": userID.String(),
+		"deleted": result.RowsAffected,
+	}).Info("已清空用户记忆")
+	return nil
+}
+
+// DeleteConversationMemories 删除对话在所有集合中的记忆
+func (s *PGVectorStore) DeleteConversationMemories(conversationID uuid.UUID) error {
+	if err := s.db.Exec("DELETE FROM memory_vectors WHERE conversation_id = ?", conversationID).Error; err != nil {
+		return fmt.Errorf("删除对话记忆失败: %w", err)
+	}
+	return nil
+}
+
+// DeleteMessageMemories 按消息ID删除所有集合中的记忆
+func (s *PGVectorStore) DeleteMessageMemories(messageIDs []uuid.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+	if err := s.db.Exec("DELETE FROM memory_vectors WHERE id IN ?", ids).Error; err != nil {
+		return fmt.Errorf("删除消息记忆失败: %w", err)
+	}
+	return nil
+}
+
+// GetMemoryStats 获取用户在当前集合中的记忆统计信息
+func (s *PGVectorStore) GetMemoryStats(userID uuid.UUID) (map[string]interface{}, error) {
+	var stats struct {
+		Total       int64
+		LastUpdated *time.Time
+	}
+	err := s.db.Raw(`SELECT COUNT(*) AS total, MAX(created_at) AS last_updated
+FROM memory_vectors WHERE collection = ? AND user_id = ?`, s.CollectionName(), userID).Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("统计记忆失败: %w", err)
+	}
+
+	result := map[string]interface{}{
+		"user_id":         userID.String(),
+		"total_memories":  stats.Total,
+		"collection_name": s.CollectionName(),
+	}
+	if stats.LastUpdated != nil {
+		result["last_updated"] = stats.LastUpdated.Format(time.RFC3339)
+	}
+	return result, nil
+}
+
+// vectorLiteral 将向量格式化为 pgvector 文本格式，如 [0.1,0.2,0.3]
+func vectorLiteral(embedding []float64) string {
+	var b strings.Builder
+	b.Grow(len(embedding) * 10)
+	b.WriteByte('[')
+	for i, v := range embedding {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(v, 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
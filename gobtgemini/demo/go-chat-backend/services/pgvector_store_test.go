@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPGVectorSearchSettings 测试按索引类型和扩展版本选择检索参数
+func TestPGVectorSearchSettings(t *testing.T) {
+	assert.Equal(t, []string{"SET LOCAL hnsw.iterative_scan = relaxed_order"}, pgvectorSearchSettings("hnsw", 100, true))
+	assert.Equal(t, []string{"SET LOCAL hnsw.ef_search = 1000"}, pgvectorSearchSettings("hnsw", 100, false))
+	assert.Equal(t, []string{"SET LOCAL ivfflat.iterative_scan = relaxed_order"}, pgvectorSearchSettings("ivfflat", 100, true))
+	assert.Equal(t, []string{"SET LOCAL ivfflat.probes = 50"}, pgvectorSearchSettings("ivfflat", 50, false))
+	assert.Empty(t, pgvectorSearchSettings("none", 100, true))
+
+	assert.True(t, pgvectorVersionAtLeast("0.8.0", 0, 8))
+	assert.True(t, pgvectorVersionAtLeast("1.0", 0, 8))
+	assert.False(t, pgvectorVersionAtLeast("0.7.4", 0, 8))
+	assert.False(t, pgvectorVersionAtLeast("", 0, 8))
+}
+
+// TestPGVectorSearchMultiUser 测试其他用户的记忆更接近查询时，当前用户仍能取到自己的记忆。
+// 需要带 pgvector 扩展的 Postgres，见 newPostgresTestDB
+func TestPGVectorSearchMultiUser(t *testing.T) {
+	db := newPostgresTestDB(t)
+	// store 固定使用 memory_vectors 表，建在临时 schema 中
+	require.NoError(t, db.Exec("CREATE EXTENSION IF NOT EXISTS vector SCHEMA public").Error)
+	require.NoError(t, db.Exec(`CREATE TABLE memory_vectors (
+	id text NOT NULL,
+	collection varchar(100) NOT NULL,
+	user_id uuid NOT NULL,
+	conversation_id uuid,
+	role varchar(20),
+	content text NOT NULL,
+	embedding vector(3) NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (collection, id)
+)`).Error)
+
+	alice, bob := uuid.New(), uuid.New()
+	insert := func(userID uuid.UUID, content string, embedding []float64) {
+		require.NoError(t, db.Exec(`INSERT INTO memory_vectors (id, collection, user_id, content, embedding)
+VALUES (?, 'memories', ?, ?, ?::vector)`, uuid.NewString(), userID, content, vectorLiteral(embedding)).Error)
+	}
+	// bob 的大量记忆都比 alice 的更接近查询，过滤前的候选会全部被 bob 占满
+	for i := 0; i < 500; i++ {
+		insert(bob, fmt.Sprintf("bob-%d", i), []float64{1, float64(i%50) / 1000, 0})
+	}
+	insert(alice, "alice-near", []float64{0.5, 0.5, 0})
+	insert(alice, "alice-far", []float64{0, 0, 1})
+	require.NoError(t, db.Exec("CREATE INDEX ON memory_vectors USING hnsw (embedding vector_cosine_ops)").Error)
+
+	store := &PGVectorStore{db: db, operator: "<=>", index: "hnsw", collection: "memories"}
+	documents, err := store.searchEmbedding(alice, []float64{1, 0, 0}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice-near", "alice-far"}, documents)
+
+	documents, err = store.searchEmbedding(bob, []float64{1, 0, 0}, 3)
+	require.NoError(t, err)
+	assert.Len(t, documents, 3)
+	for _, document := range documents {
+		assert.True(t, strings.HasPrefix(document, "bob-"))
+	}
+}
@@ -0,0 +1,443 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// settingActiveMemoryCollection 记录当前生效的记忆集合，覆盖 CHROMA_COLLECTION_NAME
+const settingActiveMemoryCollection = "memory.active_collection"
+
+// 单批写入失败时的重试次数
+const reindexBatchAttempts = 3
+
+// 运行中的任务超过这段时间没有更新进度，视为所在实例已退出，可以重新领取
+const reindexStaleAfter = 10 * time.Minute
+
+// 重建索引相关错误
+var (
+	ErrReindexJobNotFound    = errors.New("重建任务不存在")
+	ErrReindexAlreadyRunning = errors.New("重建任务正在运行")
+	ErrReindexTargetRequired = errors.New("需要指定目标集合名称")
+	ErrReindexAnotherRunning = errors.New("已有其他重建任务正在运行")
+	ErrReindexCompleted      = errors.New("重建任务已完成")
+)
+
+// ReindexService 从 chat_messages 重建记忆向量集合
+type ReindexService struct {
+	db          *gorm.DB
+	memoryStore MemoryStore
+	// 各实例重新读取记忆集合设置的间隔，切换后等待这段时间再补齐，确保所有实例已写入新集合
+	pollInterval time.Duration
+
+	mu      sync.Mutex
+	running map[uuid.UUID]context.CancelFunc
+}
+
+// NewReindexService 创建重建索引服务
+func NewReindexService(db *gorm.DB, memoryStore MemoryStore) *ReindexService {
+	pollInterval := time.Duration(config.Get().MemoryCollectionPollSeconds) * time.Second
+	if pollInterval <= 0 {
+		pollInterval = 10 * time.Second
+	}
+
+	return &ReindexService{
+		db:           db,
+		memoryStore:  memoryStore,
+		pollInterval: pollInterval,
+		running:      make(map[uuid.UUID]context.CancelFunc),
+	}
+}
+
+// Start 定期重新读取记忆集合设置，使其他实例上的切换在本实例生效
+func (s *ReindexService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := s.LoadActiveCollection(); err != nil {
+				logrus.WithError(err).Warn("同步记忆集合设置失败")
+			}
+		}
+	}()
+}
+
+// LoadActiveCollection 应用数据库中记录的记忆集合，启动时及 Start 的每次轮询调用
+func (s *ReindexService) LoadActiveCollection() error {
+	var setting models.SystemSetting
+	err := s.db.Where("key = ?", settingActiveMemoryCollection).First(&setting).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("读取记忆集合设置失败: %w", err)
+	}
+
+	if setting.Value == "" || setting.Value == s.memoryStore.CollectionName() {
+		return nil
+	}
+	return s.memoryStore.SwitchCollection(setting.Value)
+}
+
+// ActiveCollection 当前使用的记忆集合
+func (s *ReindexService) ActiveCollection() string {
+	return s.memoryStore.CollectionName()
+}
+
+// SwitchActiveCollection 先持久化再切换本实例的记忆集合，其他实例在下一次轮询时切换。
+// 设置保存失败时本实例保持原集合，不会出现各实例使用不同集合且重启后回退的情况
+func (s *ReindexService) SwitchActiveCollection(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrReindexTargetRequired
+	}
+	if _, err := s.memoryStore.EnsureCollection(name); err != nil {
+		return fmt.Errorf("准备记忆集合失败: %w", err)
+	}
+
+	setting := models.SystemSetting{Key: settingActiveMemoryCollection, Value: name}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&setting).Error
+	if err != nil {
+		return fmt.Errorf("保存记忆集合设置失败: %w", err)
+	}
+
+	if err := s.memoryStore.SwitchCollection(name); err != nil {
+		return fmt.Errorf("切换记忆集合失败: %w", err)
+	}
+	return nil
+}
+
+// PrepareReindex 创建重建任务；目标集合已有未完成的任务时复用该任务，从游标处继续
+func (s *ReindexService) PrepareReindex(target string, switchOnComplete bool, batchSize int) (*models.ReindexJob, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil, ErrReindexTargetRequired
+	}
+	if batchSize <= 0 || batchSize > 500 {
+		batchSize = 64
+	}
+
+	var job models.ReindexJob
+	err := s.db.Where("target_collection = ? AND status <> ?", target, models.ReindexCompleted).
+		Order("created_at DESC").First(&job).Error
+	if err == nil {
+		job.SwitchOnComplete = switchOnComplete
+		job.BatchSize = batchSize
+		if err := s.db.Model(&job).Updates(map[string]interface{}{
+			"switch_on_complete": switchOnComplete,
+			"batch_size":         batchSize,
+		}).Error; err != nil {
+			return nil, fmt.Errorf("更新重建任务失败: %w", err)
+		}
+		return &job, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("查询重建任务失败: %w", err)
+	}
+
+	var total int64
+	if err := s.sourceMessages().Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("统计消息数量失败: %w", err)
+	}
+
+	job = models.ReindexJob{
+		ID:               uuid.New(),
+		SourceCollection: s.memoryStore.CollectionName(),
+		TargetCollection: target,
+		Status:           models.ReindexPaused,
+		SwitchOnComplete: switchOnComplete,
+		BatchSize:        batchSize,
+		Total:            total,
+		StartedAt:        time.Now(),
+	}
+	if err := s.db.Create(&job).Error; err != nil {
+		return nil, fmt.Errorf("创建重建任务失败: %w", err)
+	}
+
+	return &job, nil
+}
+
+// ClaimReindex 在数据库中以条件更新领取重建任务，所有实例中同一时间只有一个任务在运行。
+// 任务已在运行时返回 ErrReindexAlreadyRunning，其他任务正在运行时返回 ErrReindexAnotherRunning
+func (s *ReindexService) ClaimReindex(jobID uuid.UUID) (*models.ReindexJob, error) {
+	stale := time.Now().Add(-reindexStaleAfter)
+	others := s.db.Model(&models.ReindexJob{}).Select("1").
+		Where("id <> ? AND status = ? AND updated_at >= ?", jobID, models.ReindexRunning, stale)
+	result := s.db.Model(&models.ReindexJob{}).
+		Where("id = ? AND (status IN ? OR (status = ? AND updated_at < ?))",
+			jobID, []string{models.ReindexPaused, models.ReindexFailed}, models.ReindexRunning, stale).
+		Where("NOT EXISTS (?)", others).
+		Updates(map[string]interface{}{"status": models.ReindexRunning, "last_error": ""})
+	if result.Error != nil {
+		return nil, fmt.Errorf("领取重建任务失败: %w", result.Error)
+	}
+
+	job, err := s.GetReindexJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		switch job.Status {
+		case models.ReindexRunning:
+			return nil, ErrReindexAlreadyRunning
+		case models.ReindexCompleted:
+			return nil, ErrReindexCompleted
+		}
+		return nil, ErrReindexAnotherRunning
+	}
+	return job, nil
+}
+
+// RunReindex 领取并执行重建任务直到完成、失败或 ctx 取消；取消后任务标记为 paused，可再次运行续跑
+func (s *ReindexService) RunReindex(ctx context.Context, jobID uuid.UUID, progress func(*models.ReindexJob)) error {
+	job, err := s.ClaimReindex(jobID)
+	if errors.Is(err, ErrReindexCompleted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.RunClaimedReindex(ctx, job, progress)
+}
+
+// RunClaimedReindex 执行已由 ClaimReindex 领取的任务
+func (s *ReindexService) RunClaimedReindex(ctx context.Context, job *models.ReindexJob, progress func(*models.ReindexJob)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	s.running[job.ID] = cancel
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.ID)
+		s.mu.Unlock()
+	}()
+
+	logger := logrus.WithFields(logrus.Fields{
+		"job_id":            job.ID,
+		"target_collection": job.TargetCollection,
+	})
+
+	targetID, err := s.memoryStore.EnsureCollection(job.TargetCollection)
+	if err != nil {
+		return s.markFailed(job, fmt.Errorf("准备目标集合失败: %w", err))
+	}
+
+	logger.WithField("processed", job.Processed).Info("开始重建记忆集合")
+
+	if err := s.drain(ctx, job, targetID, progress); err != nil {
+		return s.interrupt(ctx, job, err)
+	}
+
+	if job.SwitchOnComplete && job.TargetCollection != s.memoryStore.CollectionName() {
+		if err := s.SwitchActiveCollection(job.TargetCollection); err != nil {
+			return s.markFailed(job, err)
+		}
+		// 其他实例最多一个轮询间隔后才切换，期间记忆队列仍写入旧集合。再多等一个间隔让进行中的写入完成，
+		// 然后从游标处补齐这些消息，之后的消息由记忆队列直接写入新集合
+		logger.WithField("wait", s.pollInterval.String()).Info("已切换记忆集合，等待其他实例切换后补齐")
+		select {
+		case <-ctx.Done():
+			return s.interrupt(ctx, job, ctx.Err())
+		case <-time.After(2 * s.pollInterval):
+		}
+		if err := s.drain(ctx, job, targetID, progress); err != nil {
+			return s.interrupt(ctx, job, err)
+		}
+	}
+
+	now := time.Now()
+	job.Status = models.ReindexCompleted
+	job.FinishedAt = &now
+	s.db.Model(job).Updates(map[string]interface{}{
+		"status":      models.ReindexCompleted,
+		"finished_at": now,
+	})
+	if progress != nil {
+		progress(job)
+	}
+
+	logger.WithField("processed", job.Processed).Info("记忆集合重建完成")
+	return nil
+}
+
+// drain 从游标处按批读取消息并写入目标集合，直到没有更多消息
+func (s *ReindexService) drain(ctx context.Context, job *models.ReindexJob, targetID string, progress func(*models.ReindexJob)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		query := s.sourceMessages()
+		if job.CursorCreatedAt != nil && job.CursorID != nil {
+			query = query.Where("(created_at, id) > (?, ?)", *job.CursorCreatedAt, *job.CursorID)
+		}
+
+		var messages []models.ChatMessage
+		if err := query.Order("created_at ASC, id ASC").Limit(job.BatchSize).Find(&messages).Error; err != nil {
+			return fmt.Errorf("读取消息失败: %w", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		entries := make([]MemoryEntry, 0, len(messages))
+		for _, msg := range messages {
+			entries = append(entries, MemoryEntry{
+				ID:             msg.ID.String(),
+				UserID:         msg.UserID,
+				ConversationID: msg.ConversationID,
+				Role:           msg.Role,
+				Content:        msg.Content,
+				Timestamp:      msg.CreatedAt,
+			})
+		}
+
+		if err := s.writeBatch(ctx, targetID, entries); err != nil {
+			return err
+		}
+
+		last := messages[len(messages)-1]
+		job.CursorCreatedAt = &last.CreatedAt
+		job.CursorID = &last.ID
+		job.Processed += int64(len(messages))
+		if job.Processed > job.Total {
+			job.Total = job.Processed
+		}
+
+		err := s.db.Model(job).Updates(map[string]interface{}{
+			"cursor_created_at": last.CreatedAt,
+			"cursor_id":         last.ID,
+			"processed":         job.Processed,
+			"total":             job.Total,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("保存重建进度失败: %w", err)
+		}
+
+		if progress != nil {
+			progress(job)
+		}
+	}
+}
+
+// writeBatch 写入一批记忆，失败时短暂等待后重试
+func (s *ReindexService) writeBatch(ctx context.Context, targetID string, entries []MemoryEntry) error {
+	var err error
+	for attempt := 1; attempt <= reindexBatchAttempts; attempt++ {
+		if err = s.memoryStore.AddMemoriesTo(targetID, entries); err == nil {
+			return nil
+		}
+		if attempt == reindexBatchAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(memoryJobBackoff(attempt)):
+		}
+	}
+	return err
+}
+
+// sourceMessages 需要写入记忆的消息：未删除且用户未关闭记忆功能
+func (s *ReindexService) sourceMessages() *gorm.DB {
+	return s.db.Model(&models.ChatMessage{}).
+		Where("user_id NOT IN (SELECT user_id FROM user_preferences WHERE memory_enabled = false)")
+}
+
+// interrupt 根据错误类型将任务标记为暂停或失败
+func (s *ReindexService) interrupt(ctx context.Context, job *models.ReindexJob, err error) error {
+	if ctx.Err() != nil {
+		s.db.Model(job).Update("status", models.ReindexPaused)
+		job.Status = models.ReindexPaused
+		logrus.WithField("job_id", job.ID).Info("重建任务已暂停")
+		return ctx.Err()
+	}
+	return s.markFailed(job, err)
+}
+
+// markFailed 记录失败原因
+func (s *ReindexService) markFailed(job *models.ReindexJob, err error) error {
+	job.Status = models.ReindexFailed
+	job.LastError = err.Error()
+	s.db.Model(job).Updates(map[string]interface{}{
+		"status":     models.ReindexFailed,
+		"last_error": err.Error(),
+	})
+	logrus.WithError(err).WithField("job_id", job.ID).Error("重建任务失败")
+	return err
+}
+
+// CancelReindex 停止正在本实例运行的重建任务
+func (s *ReindexService) CancelReindex(jobID uuid.UUID) error {
+	s.mu.Lock()
+	cancel, ok := s.running[jobID]
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrReindexJobNotFound
+	}
+	cancel()
+	return nil
+}
+
+// GetReindexJob 获取重建任务
+func (s *ReindexService) GetReindexJob(jobID uuid.UUID) (*models.ReindexJob, error) {
+	var job models.ReindexJob
+	if err := s.db.Where("id = ?", jobID).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReindexJobNotFound
+		}
+		return nil, fmt.Errorf("获取重建任务失败: %w", err)
+	}
+	return &job, nil
+}
+
+// ListReindexJobs 获取最近的重建任务
+func (s *ReindexService) ListReindexJobs(limit int) ([]models.ReindexJob, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var jobs []models.ReindexJob
+	if err := s.db.Order("created_at DESC").Limit(limit).Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("获取重建任务列表失败: %w", err)
+	}
+	return jobs, nil
+}
+
+// ReindexProgress 计算任务完成百分比
+func ReindexProgress(job *models.ReindexJob) float64 {
+	if job.Status == models.ReindexCompleted {
+		return 100
+	}
+	if job.Total == 0 {
+		return 0
+	}
+	return float64(job.Processed) * 100 / float64(job.Total)
+}
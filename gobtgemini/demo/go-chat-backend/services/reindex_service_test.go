@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"go-chat-backend/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectionMemoryStore 按集合记录写入的记忆ID
+type collectionMemoryStore struct {
+	MemoryStore
+	mu         sync.Mutex
+	collection string
+	written    map[string][]string
+	onSwitch   func()
+}
+
+func newCollectionMemoryStore(collection string) *collectionMemoryStore {
+	return &collectionMemoryStore{collection: collection, written: make(map[string][]string)}
+}
+
+func (f *collectionMemoryStore) AddMemories(entries []MemoryEntry) error {
+	return f.AddMemoriesTo(f.CollectionName(), entries)
+}
+
+func (f *collectionMemoryStore) AddMemoriesTo(collectionID string, entries []MemoryEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range entries {
+		f.written[collectionID] = append(f.written[collectionID], entry.ID)
+	}
+	return nil
+}
+
+func (f *collectionMemoryStore) EnsureCollection(name string) (string, error) {
+	return name, nil
+}
+
+func (f *collectionMemoryStore) SwitchCollection(name string) error {
+	f.mu.Lock()
+	f.collection = name
+	f.mu.Unlock()
+	if f.onSwitch != nil {
+		f.onSwitch()
+	}
+	return nil
+}
+
+func (f *collectionMemoryStore) CollectionName() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.collection
+}
+
+func (f *collectionMemoryStore) count(collection string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.written[collection])
+}
+
+// TestReindexSwitchAcrossNodes 测试切换集合后其他实例通过轮询跟随切换，
+// 切换到其他实例跟随之间写入旧集合的消息由补齐阶段写入新集合
+func TestReindexSwitchAcrossNodes(t *testing.T) {
+	db := newTestDB(t)
+	user := createTestUser(t, db, "alice")
+	session := &models.ChatSession{UserID: user.ID, Title: "t", IsActive: true}
+	require.NoError(t, db.Create(session).Error)
+	addMessage := func(content string, createdAt time.Time) {
+		require.NoError(t, db.Create(&models.ChatMessage{
+			MessageID: uuid.New(), ConversationID: session.ID, UserID: user.ID, Role: "user", Content: content, CreatedAt: createdAt,
+		}).Error)
+	}
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		addMessage("old", base.Add(time.Duration(i)*time.Second))
+	}
+
+	leaderStore := newCollectionMemoryStore("memories")
+	leader := NewReindexService(db, leaderStore)
+	leader.pollInterval = 50 * time.Millisecond
+	otherStore := newCollectionMemoryStore("memories")
+	other := NewReindexService(db, otherStore)
+	other.pollInterval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	other.Start(ctx)
+
+	// 切换后、其他实例跟随前保存的消息，由其他实例的记忆队列写入旧集合
+	leaderStore.onSwitch = func() {
+		assert.Equal(t, "memories", otherStore.CollectionName())
+		addMessage("during switch", time.Now())
+	}
+
+	job, err := leader.PrepareReindex("memories_v2", true, 2)
+	require.NoError(t, err)
+	require.NoError(t, leader.RunReindex(ctx, job.ID, nil))
+
+	var setting models.SystemSetting
+	require.NoError(t, db.Where("key = ?", settingActiveMemoryCollection).First(&setting).Error)
+	assert.Equal(t, "memories_v2", setting.Value)
+	assert.Equal(t, "memories_v2", otherStore.CollectionName())
+	assert.Equal(t, 4, leaderStore.count("memories_v2"))
+
+	job, err = leader.GetReindexJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReindexCompleted, job.Status)
+}
+
+// TestReindexSwitchPersistFailure 测试设置保存失败时本实例不切换集合
+func TestReindexSwitchPersistFailure(t *testing.T) {
+	db := newTestDB(t)
+	store := newCollectionMemoryStore("memories")
+	service := NewReindexService(db, store)
+	require.NoError(t, db.Migrator().DropTable(&models.SystemSetting{}))
+
+	assert.Error(t, service.SwitchActiveCollection("memories_v2"))
+	assert.Equal(t, "memories", store.CollectionName())
+	assert.ErrorIs(t, service.SwitchActiveCollection(" "), ErrReindexTargetRequired)
+}
+
+// TestReindexClaim 测试重建任务在数据库中领取，另一个实例不能重复运行同一任务或同时运行其他任务；
+// 长时间没有进度的运行中任务视为中断，可以重新领取
+func TestReindexClaim(t *testing.T) {
+	db := newTestDB(t)
+	first := NewReindexService(db, newCollectionMemoryStore("memories"))
+	second := NewReindexService(db, newCollectionMemoryStore("memories"))
+
+	jobA, err := first.PrepareReindex("memories_v2", false, 0)
+	require.NoError(t, err)
+	jobB, err := second.PrepareReindex("memories_v3", false, 0)
+	require.NoError(t, err)
+
+	claimed, err := first.ClaimReindex(jobA.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReindexRunning, claimed.Status)
+
+	_, err = second.ClaimReindex(jobA.ID)
+	assert.ErrorIs(t, err, ErrReindexAlreadyRunning)
+	_, err = second.ClaimReindex(jobB.ID)
+	assert.ErrorIs(t, err, ErrReindexAnotherRunning)
+	assert.ErrorIs(t, second.RunReindex(context.Background(), jobB.ID, nil), ErrReindexAnotherRunning)
+
+	require.NoError(t, db.Model(&models.ReindexJob{}).Where("id = ?", jobA.ID).
+		UpdateColumn("updated_at", time.Now().Add(-reindexStaleAfter-time.Minute)).Error)
+	_, err = second.ClaimReindex(jobB.ID)
+	require.NoError(t, err)
+}
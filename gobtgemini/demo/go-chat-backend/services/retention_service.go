@@ -0,0 +1,656 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat-backend/models"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// 保留规则类型
+const (
+	RetentionRuleMessages = "messages" // 删除早于 N 天的消息，最后消息早于 N 天的对话整体删除
+	RetentionRuleDeleted  = "deleted"  // 软删除超过 M 天的消息、对话和知识库文档彻底删除
+)
+
+const (
+	// maxRetentionDays 保留天数上限
+	maxRetentionDays = 36500
+	// retentionMessageBatch 每批彻底删除的消息数量
+	retentionMessageBatch = 500
+	// retentionSyncGrace 按 message_days 到期的消息和对话先软删除，超过该时间后才彻底删除，
+	// 让客户端在此期间通过增量同步获取到删除
+	retentionSyncGrace = 7 * 24 * time.Hour
+)
+
+// 保留策略相关错误
+var (
+	ErrInvalidRetention        = errors.New("保留天数需要在 0 到 36500 之间")
+	ErrRetentionPolicyNotFound = errors.New("该用户没有单独的保留策略")
+	ErrRetentionUser           = errors.New("用户不存在")
+)
+
+// 审计日志中的保留策略操作
+const (
+	AuditRetentionUpdate = "retention.update"
+	AuditRetentionDelete = "retention.delete"
+)
+
+// RetentionPolicies 全局策略和单独设置了策略的用户
+type RetentionPolicies struct {
+	Global models.RetentionPolicy   `json:"global"`
+	Users  []models.RetentionPolicy `json:"users"`
+}
+
+// RetentionRuleReport 一条保留规则涉及的数据量；同一条数据可能同时满足多条规则
+type RetentionRuleReport struct {
+	Rule           string     `json:"rule"`
+	UserID         *uuid.UUID `json:"user_id,omitempty"` // 为空表示全局规则，作用于没有单独设置该项的用户
+	Days           int        `json:"days"`
+	Cutoff         time.Time  `json:"cutoff"`
+	Conversations  int64      `json:"conversations"`
+	Messages       int64      `json:"messages"`
+	Documents      int64      `json:"documents"`
+	KnowledgeBases int64      `json:"knowledge_bases"`
+	// messages 规则中刚到期、本轮软删除的数量，同步宽限期过后才计入彻底删除
+	ExpiredConversations int64 `json:"expired_conversations"`
+	ExpiredMessages      int64 `json:"expired_messages"`
+}
+
+// RetentionReport 一轮保留策略清理的结果，试运行时为将要删除的数量
+type RetentionReport struct {
+	DryRun      bool                  `json:"dry_run"`
+	GeneratedAt time.Time             `json:"generated_at"`
+	Rules       []RetentionRuleReport `json:"rules"`
+}
+
+// retentionRule 一条生效的保留规则；userID 为空时作用于 exclude 以外的所有用户。
+// messages 规则只彻底删除在 purgeBefore 之前已软删除的数据
+type retentionRule struct {
+	kind        string
+	userID      *uuid.UUID
+	exclude     []uuid.UUID
+	days        int
+	cutoff      time.Time
+	purgeBefore time.Time
+}
+
+// owned 按数据所有者过滤，对话以创建者为准
+func (r retentionRule) owned(query *gorm.DB, column string) *gorm.DB {
+	if r.userID != nil {
+		return query.Where(column+" = ?", *r.userID)
+	}
+	if len(r.exclude) > 0 {
+		return query.Where(column+" NOT IN ?", r.exclude)
+	}
+	return query
+}
+
+// conversations 需要整体删除的对话
+func (r retentionRule) conversations(db *gorm.DB) *gorm.DB {
+	query := r.owned(db.Unscoped().Model(&models.ChatSession{}), "user_id")
+	if r.kind == RetentionRuleMessages {
+		return query.Where("last_message_at < ? AND deleted_at IS NOT NULL AND deleted_at < ?", r.cutoff, r.purgeBefore)
+	}
+	return query.Where("deleted_at IS NOT NULL AND deleted_at < ?", r.cutoff)
+}
+
+// expiredConversations messages 规则中到期但还未软删除的对话
+func (r retentionRule) expiredConversations(db *gorm.DB) *gorm.DB {
+	return r.owned(db.Model(&models.ChatSession{}), "user_id").
+		Where("last_message_at < ? AND deleted_at IS NULL", r.cutoff)
+}
+
+// messages 需要删除的消息，不包括所在对话会被整体删除的
+func (r retentionRule) messages(db *gorm.DB) *gorm.DB {
+	sessions := r.owned(db.Unscoped().Model(&models.ChatSession{}).Select("id"), "user_id")
+	query := db.Unscoped().Model(&models.ChatMessage{})
+	if r.kind == RetentionRuleMessages {
+		sessions = sessions.Where("last_message_at >= ?", r.cutoff)
+		query = query.Where("created_at < ? AND deleted_at IS NOT NULL AND deleted_at < ?", r.cutoff, r.purgeBefore)
+	} else {
+		sessions = sessions.Where("(deleted_at IS NULL OR deleted_at >= ?)", r.cutoff)
+		query = query.Where("deleted_at IS NOT NULL AND deleted_at < ?", r.cutoff)
+	}
+	return query.Where("conversation_id IN (?)", sessions)
+}
+
+// expiredMessages messages 规则中到期但还未软删除的消息，不包括所在对话会被整体删除的
+func (r retentionRule) expiredMessages(db *gorm.DB) *gorm.DB {
+	sessions := r.owned(db.Unscoped().Model(&models.ChatSession{}).Select("id"), "user_id").
+		Where("last_message_at >= ?", r.cutoff)
+	return db.Model(&models.ChatMessage{}).
+		Where("created_at < ? AND conversation_id IN (?)", r.cutoff, sessions)
+}
+
+// documents 软删除过期的知识库文档，所在知识库被整体删除的除外
+func (r retentionRule) documents(db *gorm.DB) *gorm.DB {
+	knowledgeBases := r.knowledgeBases(db).Select("id")
+	return r.owned(db.Unscoped().Model(&models.KnowledgeDocument{}), "user_id").
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", r.cutoff).
+		Where("knowledge_base_id NOT IN (?)", knowledgeBases)
+}
+
+// knowledgeBases 软删除过期的知识库
+func (r retentionRule) knowledgeBases(db *gorm.DB) *gorm.DB {
+	return r.owned(db.Unscoped().Model(&models.KnowledgeBase{}), "user_id").
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", r.cutoff)
+}
+
+func (r retentionRule) report() RetentionRuleReport {
+	return RetentionRuleReport{
+		Rule:   r.kind,
+		UserID: r.userID,
+		Days:   r.days,
+		Cutoff: r.cutoff,
+	}
+}
+
+// RetentionService 管理数据保留策略，并定期彻底删除超过保留期限的消息、对话、记忆向量和知识库文档
+type RetentionService struct {
+	db            *gorm.DB
+	memoryStore   MemoryStore
+	chromaService *ChromaService // 知识库向量，为空时只删除数据库中的记录
+	interval      time.Duration
+	syncGrace     time.Duration
+}
+
+// NewRetentionService 创建保留策略服务
+func NewRetentionService(db *gorm.DB, memoryStore MemoryStore, chromaService *ChromaService) *RetentionService {
+	return &RetentionService{
+		db:            db,
+		memoryStore:   memoryStore,
+		chromaService: chromaService,
+		interval:      time.Hour,
+		syncGrace:     retentionSyncGrace,
+	}
+}
+
+// ListPolicies 获取全局策略和所有用户策略，未设置全局策略时返回空策略
+func (s *RetentionService) ListPolicies() (*RetentionPolicies, error) {
+	var policies []models.RetentionPolicy
+	if err := s.db.Order("created_at ASC").Find(&policies).Error; err != nil {
+		logrus.WithError(err).Error("查询保留策略失败")
+		return nil, errors.New("获取保留策略失败")
+	}
+
+	result := &RetentionPolicies{Users: []models.RetentionPolicy{}}
+	for _, policy := range policies {
+		if policy.UserID == nil {
+			result.Global = policy
+		} else {
+			result.Users = append(result.Users, policy)
+		}
+	}
+	return result, nil
+}
+
+// SetPolicy 设置全局策略（userID 为空）或用户策略，替换原有的全部字段
+func (s *RetentionService) SetPolicy(actx AuditContext, userID *uuid.UUID, messageDays, deletedDays *int) (*models.RetentionPolicy, error) {
+	for _, days := range []*int{messageDays, deletedDays} {
+		if days != nil && (*days < 0 || *days > maxRetentionDays) {
+			return nil, ErrInvalidRetention
+		}
+	}
+
+	if userID != nil {
+		var count int64
+		if err := s.db.Model(&models.User{}).Where("id = ?", *userID).Count(&count).Error; err != nil {
+			logrus.WithError(err).Error("查询用户失败")
+			return nil, errors.New("设置保留策略失败")
+		}
+		if count == 0 {
+			return nil, ErrRetentionUser
+		}
+	}
+
+	policy := models.RetentionPolicy{UserID: userID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("user_id IS NULL")
+		if userID != nil {
+			query = tx.Where("user_id = ?", *userID)
+		}
+		if err := query.FirstOrCreate(&policy).Error; err != nil {
+			return err
+		}
+		// 用 map 更新，确保为 nil 的字段也写入 NULL
+		return tx.Model(&policy).Updates(map[string]interface{}{
+			"message_days": messageDays,
+			"deleted_days": deletedDays,
+			"updated_by":   actx.UserID,
+		}).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("保存保留策略失败")
+		return nil, errors.New("设置保留策略失败")
+	}
+	policy.MessageDays = messageDays
+	policy.DeletedDays = deletedDays
+	policy.UpdatedBy = actx.UserID
+
+	recordAudit(s.db, actx, AuditRetentionUpdate, "retention_policy", policy.ID, map[string]interface{}{
+		"user_id":      userID,
+		"message_days": messageDays,
+		"deleted_days": deletedDays,
+	})
+	return &policy, nil
+}
+
+// DeleteUserPolicy 删除用户的单独策略，之后沿用全局策略
+func (s *RetentionService) DeleteUserPolicy(actx AuditContext, userID uuid.UUID) error {
+	var policy models.RetentionPolicy
+	if err := s.db.Where("user_id = ?", userID).First(&policy).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRetentionPolicyNotFound
+		}
+		logrus.WithError(err).Error("查询保留策略失败")
+		return errors.New("删除保留策略失败")
+	}
+	if err := s.db.Delete(&policy).Error; err != nil {
+		logrus.WithError(err).Error("删除保留策略失败")
+		return errors.New("删除保留策略失败")
+	}
+
+	recordAudit(s.db, actx, AuditRetentionDelete, "retention_policy", policy.ID, map[string]interface{}{
+		"user_id": userID,
+	})
+	return nil
+}
+
+// rules 由全局策略和用户策略生成生效的规则：用户单独设置的项覆盖全局策略，0 表示永久保留
+func (s *RetentionService) rules(now time.Time) ([]retentionRule, error) {
+	var policies []models.RetentionPolicy
+	if err := s.db.Order("created_at ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	var global models.RetentionPolicy
+	var overrides []models.RetentionPolicy
+	for _, policy := range policies {
+		if policy.UserID == nil {
+			global = policy
+		} else {
+			overrides = append(overrides, policy)
+		}
+	}
+
+	var rules []retentionRule
+	add := func(kind string, daysOf func(models.RetentionPolicy) *int) {
+		var exclude []uuid.UUID
+		var userRules []retentionRule
+		for _, policy := range overrides {
+			days := daysOf(policy)
+			if days == nil {
+				continue
+			}
+			exclude = append(exclude, *policy.UserID)
+			if *days > 0 {
+				userRules = append(userRules, retentionRule{
+					kind:        kind,
+					userID:      policy.UserID,
+					days:        *days,
+					cutoff:      now.AddDate(0, 0, -*days),
+					purgeBefore: now.Add(-s.syncGrace),
+				})
+			}
+		}
+		if days := daysOf(global); days != nil && *days > 0 {
+			rules = append(rules, retentionRule{
+				kind:        kind,
+				exclude:     exclude,
+				days:        *days,
+				cutoff:      now.AddDate(0, 0, -*days),
+				purgeBefore: now.Add(-s.syncGrace),
+			})
+		}
+		rules = append(rules, userRules...)
+	}
+	add(RetentionRuleMessages, func(p models.RetentionPolicy) *int { return p.MessageDays })
+	add(RetentionRuleDeleted, func(p models.RetentionPolicy) *int { return p.DeletedDays })
+	return rules, nil
+}
+
+// Report 试运行：统计当前策略下将被删除的数据，不做任何修改
+func (s *RetentionService) Report() (*RetentionReport, error) {
+	now := time.Now()
+	rules, err := s.rules(now)
+	if err != nil {
+		return nil, fmt.Errorf("加载保留策略失败: %w", err)
+	}
+
+	report := &RetentionReport{DryRun: true, GeneratedAt: now, Rules: []RetentionRuleReport{}}
+	for _, rule := range rules {
+		entry := rule.report()
+		if err := rule.conversations(s.db).Count(&entry.Conversations).Error; err != nil {
+			return nil, err
+		}
+		if err := rule.messages(s.db).Count(&entry.Messages).Error; err != nil {
+			return nil, err
+		}
+		if rule.kind == RetentionRuleMessages {
+			if err := rule.expiredConversations(s.db).Count(&entry.ExpiredConversations).Error; err != nil {
+				return nil, err
+			}
+			if err := rule.expiredMessages(s.db).Count(&entry.ExpiredMessages).Error; err != nil {
+				return nil, err
+			}
+		}
+		if rule.kind == RetentionRuleDeleted {
+			if err := rule.documents(s.db).Count(&entry.Documents).Error; err != nil {
+				return nil, err
+			}
+			if err := rule.knowledgeBases(s.db).Count(&entry.KnowledgeBases).Error; err != nil {
+				return nil, err
+			}
+		}
+		report.Rules = append(report.Rules, entry)
+	}
+	return report, nil
+}
+
+// Start 启动后台清理，启动时先执行一次
+func (s *RetentionService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			if report, err := s.Run(ctx); err != nil {
+				logrus.WithError(err).Warn("执行保留策略失败")
+			} else {
+				for _, rule := range report.Rules {
+					if rule.Conversations+rule.Messages+rule.Documents+rule.KnowledgeBases+rule.ExpiredConversations+rule.ExpiredMessages == 0 {
+						continue
+					}
+					logrus.WithFields(logrus.Fields{
+						"rule":                  rule.Rule,
+						"user_id":               rule.UserID,
+						"conversations":         rule.Conversations,
+						"messages":              rule.Messages,
+						"documents":             rule.Documents,
+						"knowledge_bases":       rule.KnowledgeBases,
+						"expired_conversations": rule.ExpiredConversations,
+						"expired_messages":      rule.ExpiredMessages,
+					}).Info("已按保留策略删除数据")
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run 按当前策略彻底删除过期数据，返回实际删除的数量。
+// messages 规则先软删除刚到期的数据，同步宽限期过后的轮次再彻底删除。
+// 记忆向量或知识库向量删除失败的数据保留到下一轮重试，避免留下无法追溯的向量。
+func (s *RetentionService) Run(ctx context.Context) (*RetentionReport, error) {
+	now := time.Now()
+	rules, err := s.rules(now)
+	if err != nil {
+		return nil, fmt.Errorf("加载保留策略失败: %w", err)
+	}
+
+	report := &RetentionReport{GeneratedAt: now, Rules: []RetentionRuleReport{}}
+	for _, rule := range rules {
+		if ctx.Err() != nil {
+			break
+		}
+		entry := rule.report()
+		if entry.Conversations, err = s.purgeConversations(ctx, rule); err != nil {
+			return report, err
+		}
+		if entry.Messages, err = s.purgeMessages(ctx, rule); err != nil {
+			return report, err
+		}
+		if rule.kind == RetentionRuleMessages {
+			if entry.ExpiredConversations, entry.ExpiredMessages, err = s.expire(ctx, rule); err != nil {
+				return report, err
+			}
+		}
+		if rule.kind == RetentionRuleDeleted {
+			if entry.KnowledgeBases, err = s.purgeKnowledgeBases(rule); err != nil {
+				return report, err
+			}
+			if entry.Documents, err = s.purgeDocuments(rule); err != nil {
+				return report, err
+			}
+		}
+		report.Rules = append(report.Rules, entry)
+	}
+	return report, nil
+}
+
+// purgeConversations 整体删除对话，与回收站清理相同
+func (s *RetentionService) purgeConversations(ctx context.Context, rule retentionRule) (int64, error) {
+	var purged int64
+	for ctx.Err() == nil {
+		var ids []uuid.UUID
+		err := rule.conversations(s.db).Order("id ASC").Limit(conversationPurgeBatch).Pluck("id", &ids).Error
+		if err != nil {
+			return purged, err
+		}
+
+		progressed := false
+		for _, id := range ids {
+			if s.memoryStore != nil {
+				if err := s.memoryStore.DeleteConversationMemories(id); err != nil {
+					logrus.WithError(err).WithField("conversation_id", id).Warn("删除对话记忆失败，稍后重试")
+					continue
+				}
+			}
+			if err := purgeConversation(s.db, id); err != nil {
+				logrus.WithError(err).WithField("conversation_id", id).Warn("彻底删除对话失败")
+				continue
+			}
+			purged++
+			progressed = true
+		}
+		if len(ids) < conversationPurgeBatch || !progressed {
+			break
+		}
+	}
+	return purged, nil
+}
+
+// purgeMessages 分批彻底删除消息及其记忆向量
+func (s *RetentionService) purgeMessages(ctx context.Context, rule retentionRule) (int64, error) {
+	var purged int64
+	for ctx.Err() == nil {
+		var messages []models.ChatMessage
+		err := rule.messages(s.db).Select("id, conversation_id").
+			Order("created_at ASC, id ASC").
+			Limit(retentionMessageBatch).
+			Find(&messages).Error
+		if err != nil || len(messages) == 0 {
+			return purged, err
+		}
+
+		ids := make([]uuid.UUID, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+		if s.memoryStore != nil {
+			if err := s.memoryStore.DeleteMessageMemories(ids); err != nil {
+				logrus.WithError(err).Warn("删除消息记忆失败，稍后重试")
+				return purged, nil
+			}
+		}
+		if err := s.db.Transaction(func(tx *gorm.DB) error { return purgeMessageRows(tx, messages) }); err != nil {
+			return purged, err
+		}
+		purged += int64(len(messages))
+		if len(messages) < retentionMessageBatch {
+			break
+		}
+	}
+	return purged, nil
+}
+
+// expire 软删除 messages 规则中刚到期的对话和消息，同时更新 updated_at 让增量同步获取到删除。
+// 消息按 retentionMessageBatch 分批处理，每批一个事务，避免一次到期大量消息时超出绑定参数上限；
+// 当前分支指向被删除消息的对话清空当前分支，回退为最新的未删除消息
+func (s *RetentionService) expire(ctx context.Context, rule retentionRule) (int64, int64, error) {
+	now := time.Now()
+	result := rule.expiredConversations(s.db).Updates(map[string]interface{}{
+		"is_active":  false,
+		"deleted_at": now,
+		"updated_at": now,
+	})
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	conversations := result.RowsAffected
+
+	var messages int64
+	for ctx.Err() == nil {
+		var expired []models.ChatMessage
+		err := rule.expiredMessages(s.db).Select("id, conversation_id").
+			Order("created_at ASC, id ASC").
+			Limit(retentionMessageBatch).
+			Find(&expired).Error
+		if err != nil || len(expired) == 0 {
+			return conversations, messages, err
+		}
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			ids := make([]uuid.UUID, 0, len(expired))
+			affected := make(map[uuid.UUID]bool)
+			for _, msg := range expired {
+				ids = append(ids, msg.ID)
+				affected[msg.ConversationID] = true
+			}
+			if err := softDeleteMessages(tx, "id IN ?", ids); err != nil {
+				return err
+			}
+
+			err := tx.Model(&models.ChatSession{}).Unscoped().
+				Where("active_leaf_id IN ?", ids).
+				Updates(map[string]interface{}{"active_leaf_id": nil, "updated_at": time.Now()}).Error
+			if err != nil {
+				return err
+			}
+			conversationIDs := make([]uuid.UUID, 0, len(affected))
+			for id := range affected {
+				conversationIDs = append(conversationIDs, id)
+			}
+			return refreshSessionActivity(tx, conversationIDs...)
+		})
+		if err != nil {
+			return conversations, messages, err
+		}
+		messages += int64(len(expired))
+		if len(expired) < retentionMessageBatch {
+			break
+		}
+	}
+	return conversations, messages, nil
+}
+
+// purgeMessageRows 彻底删除消息及其关联数据。
+// 子消息改挂到被删除消息的父消息上，当前分支指向被删除消息的对话回退到其父消息，保持消息树完整。
+func purgeMessageRows(tx *gorm.DB, messages []models.ChatMessage) error {
+	ids := make([]uuid.UUID, 0, len(messages))
+	conversations := make(map[uuid.UUID]bool)
+	now := time.Now()
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+		conversations[msg.ConversationID] = true
+
+		// 改挂的子消息同样更新 updated_at，让增量同步获取到新的 parent_id
+		err := tx.Exec("UPDATE chat_messages SET parent_id = (SELECT parent_id FROM chat_messages WHERE id = ?), updated_at = ? WHERE parent_id = ?", msg.ID, now, msg.ID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Exec("UPDATE chat_sessions SET active_leaf_id = (SELECT parent_id FROM chat_messages WHERE id = ?), updated_at = ? WHERE active_leaf_id = ?", msg.ID, now, msg.ID).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("message_id IN ?", ids).Delete(&models.MemoryJob{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("message_id IN ?", ids).Delete(&models.MessageFeedback{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("id IN ?", ids).Delete(&models.ChatMessage{}).Error; err != nil {
+		return err
+	}
+
+	conversationIDs := make([]uuid.UUID, 0, len(conversations))
+	for id := range conversations {
+		conversationIDs = append(conversationIDs, id)
+	}
+	return refreshSessionActivity(tx, conversationIDs...)
+}
+
+// purgeKnowledgeBases 彻底删除已软删除的知识库及其文档
+func (s *RetentionService) purgeKnowledgeBases(rule retentionRule) (int64, error) {
+	var ids []uuid.UUID
+	if err := rule.knowledgeBases(s.db).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, id := range ids {
+		// 删除知识库时已尝试删除向量，这里再删一次，避免当时失败留下的向量
+		if s.chromaService != nil {
+			if err := s.chromaService.DeleteKnowledge("knowledge_base_id", id.String()); err != nil {
+				logrus.WithError(err).WithField("knowledge_base_id", id).Warn("删除知识库向量失败，稍后重试")
+				continue
+			}
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("knowledge_base_id = ?", id).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("knowledge_base_id = ?", id).Delete(&models.KnowledgeDocument{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id = ?", id).Delete(&models.KnowledgeBase{}).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeDocuments 彻底删除已软删除的知识库文档
+func (s *RetentionService) purgeDocuments(rule retentionRule) (int64, error) {
+	var ids []uuid.UUID
+	if err := rule.documents(s.db).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	for _, id := range ids {
+		if s.chromaService != nil {
+			if err := s.chromaService.DeleteKnowledge("document_id", id.String()); err != nil {
+				logrus.WithError(err).WithField("document_id", id).Warn("删除文档向量失败，稍后重试")
+				continue
+			}
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("document_id = ?", id).Delete(&models.KnowledgeChunk{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Where("id = ?", id).Delete(&models.KnowledgeDocument{}).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
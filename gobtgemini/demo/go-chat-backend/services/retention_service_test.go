@@ -0,0 +1,237 @@
+package services
+
+import (
+	"context"
+	"go-chat-backend/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// TestRetentionPolicies 测试全局与用户策略的合并、试运行报告，以及清理后消息树和对话活动信息保持一致
+func TestRetentionPolicies(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	chatService.syncOverlap = 0
+	store := &fakeMemoryStore{}
+	retention := NewRetentionService(db, store, nil)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	now := time.Now()
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+
+	thirty, seven, keep := 30, 7, 0
+	_, err := retention.SetPolicy(AuditContext{UserID: &alice.ID}, nil, &thirty, &seven)
+	require.NoError(t, err)
+	_, err = retention.SetPolicy(AuditContext{UserID: &alice.ID}, &bob.ID, &keep, nil)
+	require.NoError(t, err)
+
+	invalid := -1
+	_, err = retention.SetPolicy(AuditContext{}, nil, &invalid, nil)
+	assert.ErrorIs(t, err, ErrInvalidRetention)
+	unknown := uuid.New()
+	_, err = retention.SetPolicy(AuditContext{}, &unknown, &thirty, nil)
+	assert.ErrorIs(t, err, ErrRetentionUser)
+	assert.ErrorIs(t, retention.DeleteUserPolicy(AuditContext{}, alice.ID), ErrRetentionPolicyNotFound)
+
+	// 活跃的对话：较早的两条消息过期，最新的一条保留
+	active, err := chatService.CreateChatSession(alice.ID, "活跃")
+	require.NoError(t, err)
+	oldest := sendAt(t, db, chatService, alice.ID, active.ID, "很久以前", daysAgo(40))
+	older := sendAt(t, db, chatService, alice.ID, active.ID, "也很久了", daysAgo(35))
+	latest, err := chatService.SendMessage(alice.ID, active.ID, "最近", "user", nil)
+	require.NoError(t, err)
+
+	// 不活跃的对话整体删除
+	inactive, err := chatService.CreateChatSession(alice.ID, "不活跃")
+	require.NoError(t, err)
+	sendAt(t, db, chatService, alice.ID, inactive.ID, "旧对话", daysAgo(60))
+	require.NoError(t, db.Model(&models.ChatSession{}).Where("id = ?", inactive.ID).Update("last_message_at", daysAgo(60)).Error)
+
+	// 软删除超过7天的中间消息，子消息改挂到它的父消息上
+	edited, err := chatService.CreateChatSession(alice.ID, "删除过消息")
+	require.NoError(t, err)
+	first, err := chatService.SendMessage(alice.ID, edited.ID, "第一条", "user", nil)
+	require.NoError(t, err)
+	middle, err := chatService.SendMessage(alice.ID, edited.ID, "第二条", "assistant", nil)
+	require.NoError(t, err)
+	last, err := chatService.SendMessage(alice.ID, edited.ID, "第三条", "user", nil)
+	require.NoError(t, err)
+	require.NoError(t, chatService.DeleteMessage(alice.ID, middle.ID))
+	require.NoError(t, db.Unscoped().Model(&models.ChatMessage{}).Where("id = ?", middle.ID).Update("deleted_at", daysAgo(10)).Error)
+
+	// bob 单独设置了永久保留消息
+	bobConversation, err := chatService.CreateChatSession(bob.ID, "")
+	require.NoError(t, err)
+	bobMessage := sendAt(t, db, chatService, bob.ID, bobConversation.ID, "bob 的旧消息", daysAgo(40))
+
+	// 软删除超过7天的知识库文档
+	kb := models.KnowledgeBase{UserID: alice.ID, Name: "资料"}
+	require.NoError(t, db.Create(&kb).Error)
+	doc := models.KnowledgeDocument{KnowledgeBaseID: kb.ID, UserID: alice.ID, FileName: "a.md", Format: "markdown", Status: "ready"}
+	require.NoError(t, db.Create(&doc).Error)
+	require.NoError(t, db.Model(&doc).Update("deleted_at", daysAgo(8)).Error)
+
+	syncToken := chatService.SyncToken()
+	report, err := retention.Report()
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	require.Len(t, report.Rules, 2)
+	assert.Equal(t, RetentionRuleMessages, report.Rules[0].Rule)
+	assert.Nil(t, report.Rules[0].UserID)
+	assert.Equal(t, int64(1), report.Rules[0].ExpiredConversations)
+	assert.Equal(t, int64(2), report.Rules[0].ExpiredMessages)
+	assert.Zero(t, report.Rules[0].Conversations+report.Rules[0].Messages)
+	assert.Equal(t, RetentionRuleDeleted, report.Rules[1].Rule)
+	assert.Equal(t, int64(1), report.Rules[1].Messages)
+	assert.Equal(t, int64(1), report.Rules[1].Documents)
+
+	// 试运行不修改数据
+	var count int64
+	db.Unscoped().Model(&models.ChatMessage{}).Count(&count)
+	assert.Equal(t, int64(8), count)
+
+	// 第一轮：到期的消息和对话先软删除，增量同步能获取到删除
+	result, err := retention.Run(context.Background())
+	require.NoError(t, err)
+	assert.False(t, result.DryRun)
+	assert.Equal(t, report.Rules[0].ExpiredMessages, result.Rules[0].ExpiredMessages)
+	assert.Equal(t, report.Rules[0].ExpiredConversations, result.Rules[0].ExpiredConversations)
+	assert.Equal(t, report.Rules[1].Messages, result.Rules[1].Messages)
+	assert.Equal(t, report.Rules[1].Documents, result.Rules[1].Documents)
+	assert.Equal(t, []uuid.UUID{middle.ID}, store.deletedMessages)
+	assert.Empty(t, store.deleted)
+
+	changes, err := chatService.SyncMessages(alice.ID, &active.ID, SyncRequest{Since: syncToken})
+	require.NoError(t, err)
+	deleted := map[uuid.UUID]bool{}
+	for _, msg := range changes.Messages {
+		deleted[msg.ID] = msg.Deleted
+	}
+	assert.Equal(t, map[uuid.UUID]bool{oldest.ID: true, older.ID: true}, deleted)
+	sessionChanges, err := chatService.SyncChatSessions(alice.ID, SyncRequest{Since: syncToken})
+	require.NoError(t, err)
+	synced := map[uuid.UUID]bool{}
+	for _, session := range sessionChanges.Conversations {
+		synced[session.ID] = session.IsActive
+	}
+	assert.Equal(t, false, synced[inactive.ID])
+
+	history, _, err := chatService.GetOneConversationHistory(alice.ID, active.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, latest.ID, history[0].ID)
+	_, err = chatService.GetChatSession(alice.ID, inactive.ID)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+
+	// 同步宽限期内再次运行不会彻底删除
+	result, err = retention.Run(context.Background())
+	require.NoError(t, err)
+	for _, rule := range result.Rules {
+		assert.Zero(t, rule.Conversations+rule.Messages+rule.ExpiredConversations+rule.ExpiredMessages)
+	}
+
+	// 宽限期过后彻底删除，子消息改挂时也更新 updated_at
+	retention.syncGrace = 0
+	syncToken = chatService.SyncToken()
+	result, err = retention.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Rules[0].Conversations)
+	assert.Equal(t, int64(2), result.Rules[0].Messages)
+	assert.ElementsMatch(t, []uuid.UUID{oldest.ID, older.ID, middle.ID}, store.deletedMessages)
+	assert.Equal(t, []uuid.UUID{inactive.ID}, store.deleted)
+
+	history, _, err = chatService.GetOneConversationHistory(alice.ID, active.ID, PageRequest{})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, latest.ID, history[0].ID)
+	assert.Nil(t, history[0].ParentID)
+	session, err := chatService.GetChatSession(alice.ID, active.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, session.MessageCount)
+
+	changes, err = chatService.SyncMessages(alice.ID, &active.ID, SyncRequest{Since: syncToken})
+	require.NoError(t, err)
+	require.Len(t, changes.Messages, 1)
+	assert.Equal(t, latest.ID, changes.Messages[0].ID)
+	assert.Nil(t, changes.Messages[0].ParentID)
+
+	var reparented models.ChatMessage
+	require.NoError(t, db.Where("id = ?", last.ID).First(&reparented).Error)
+	require.NotNil(t, reparented.ParentID)
+	assert.Equal(t, first.ID, *reparented.ParentID)
+
+	db.Unscoped().Model(&models.ChatMessage{}).Where("id = ?", bobMessage.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+	db.Unscoped().Model(&models.KnowledgeDocument{}).Where("id = ?", doc.ID).Count(&count)
+	assert.Zero(t, count)
+
+	// 再次运行没有可删除的数据
+	result, err = retention.Run(context.Background())
+	require.NoError(t, err)
+	for _, rule := range result.Rules {
+		assert.Zero(t, rule.Conversations+rule.Messages+rule.Documents+rule.KnowledgeBases+rule.ExpiredConversations+rule.ExpiredMessages)
+	}
+}
+
+// TestRetentionExpireBatches 测试到期消息超过一批时分批软删除，全部消息都被处理，当前分支回退
+func TestRetentionExpireBatches(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	retention := NewRetentionService(db, nil, nil)
+	alice := createTestUser(t, db, "alice")
+
+	thirty := 30
+	_, err := retention.SetPolicy(AuditContext{UserID: &alice.ID}, nil, &thirty, nil)
+	require.NoError(t, err)
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "")
+	require.NoError(t, err)
+	old := make([]models.ChatMessage, retentionMessageBatch+1)
+	for i := range old {
+		old[i] = models.ChatMessage{
+			ID:             uuid.New(),
+			MessageID:      uuid.New(),
+			UserID:         alice.ID,
+			ConversationID: conversation.ID,
+			Content:        "旧消息",
+			Role:           "user",
+			CreatedAt:      time.Now().AddDate(0, 0, -40).Add(time.Duration(i) * time.Second),
+		}
+	}
+	require.NoError(t, db.CreateInBatches(old, 100).Error)
+	latest, err := chatService.SendMessage(alice.ID, conversation.ID, "最近", "user", nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&models.ChatSession{}).Where("id = ?", conversation.ID).
+		Update("active_leaf_id", old[len(old)-1].ID).Error)
+
+	result, err := retention.Run(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Rules)
+	assert.Equal(t, int64(len(old)), result.Rules[0].ExpiredMessages)
+
+	var remaining []models.ChatMessage
+	require.NoError(t, db.Where("conversation_id = ?", conversation.ID).Find(&remaining).Error)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, latest.ID, remaining[0].ID)
+
+	var session models.ChatSession
+	require.NoError(t, db.First(&session, "id = ?", conversation.ID).Error)
+	assert.Nil(t, session.ActiveLeafID)
+	assert.Equal(t, 1, session.MessageCount)
+}
+
+// sendAt 发送消息并把创建时间改为 createdAt
+func sendAt(t *testing.T, db *gorm.DB, chatService *ChatService, userID, conversationID uuid.UUID, content string, createdAt time.Time) *models.ChatMessage {
+	t.Helper()
+
+	msg, err := chatService.SendMessage(userID, conversationID, content, "user", nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(msg).Update("created_at", createdAt).Error)
+	msg.CreatedAt = createdAt
+	return msg
+}
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// scheduleLeaderLockKey 所有实例共用的选主 advisory lock 键
+	scheduleLeaderLockKey int64 = 0x676f636861740001
+	// minScheduleInterval 两次触发之间的最短间隔
+	minScheduleInterval = 5 * time.Minute
+	// maxSchedulePrompt 提示词长度上限，与发送消息一致
+	maxSchedulePrompt = 4000
+	// scheduleRunHistory 每个定时任务保留的执行记录数
+	scheduleRunHistory = 100
+	// scheduleDueBatch 每轮最多触发的任务数
+	scheduleDueBatch = 50
+	// scheduleWorkers 同时执行的任务数
+	scheduleWorkers = 4
+)
+
+// 定时提示词相关错误
+var (
+	ErrScheduleNotFound = errors.New("定时任务不存在")
+	ErrInvalidSchedule  = errors.New("定时任务参数不合法")
+	ErrScheduleLimit    = errors.New("定时任务数量已达上限")
+)
+
+// PromptRunner 执行定时提示词，由 ChatHandler 实现：与发送消息接口相同的流程保存消息、生成回复并推送
+type PromptRunner interface {
+	// RunPrompt 以用户身份把内容发送到对话并生成回复；回复失败时 userMessage 可能已保存
+	RunPrompt(user *models.User, conversationID uuid.UUID, content string, metadata map[string]interface{}) (userMessage, assistantMessage *models.ChatMessage, err error)
+	// NotifyScheduleRun 一次执行结束后通知任务的创建者
+	NotifyScheduleRun(schedule *models.ScheduledPrompt, run *models.ScheduledPromptRun)
+}
+
+// ScheduleInput 创建定时任务的参数
+type ScheduleInput struct {
+	ConversationID uuid.UUID
+	Name           string
+	Prompt         string
+	Cron           string
+	Timezone       string
+	Enabled        bool
+}
+
+// ScheduleUpdate 修改定时任务的参数，为 nil 的字段保持不变
+type ScheduleUpdate struct {
+	Name     *string
+	Prompt   *string
+	Cron     *string
+	Timezone *string
+	Enabled  *bool
+}
+
+// ScheduleService 管理定时提示词，并由选出的主节点按时触发
+type ScheduleService struct {
+	db           *gorm.DB
+	runner       PromptRunner
+	elector      leaderElector
+	pollInterval time.Duration
+	maxPerUser   int
+	nodeName     string
+}
+
+// NewScheduleService 创建定时提示词服务
+func NewScheduleService(db *gorm.DB) *ScheduleService {
+	cfg := config.Get()
+	hostname, _ := os.Hostname()
+
+	s := &ScheduleService{
+		db:           db,
+		elector:      newLeaderElector(db, scheduleLeaderLockKey),
+		pollInterval: time.Duration(cfg.SchedulePollSeconds) * time.Second,
+		maxPerUser:   cfg.ScheduleMaxPerUser,
+		nodeName:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+	if s.pollInterval <= 0 {
+		s.pollInterval = 30 * time.Second
+	}
+	return s
+}
+
+// SetPromptRunner 设置执行器（用于解决循环依赖）
+func (s *ScheduleService) SetPromptRunner(runner PromptRunner) {
+	s.runner = runner
+}
+
+// ListSchedules 获取用户的定时任务
+func (s *ScheduleService) ListSchedules(userID uuid.UUID) ([]models.ScheduledPrompt, error) {
+	var schedules []models.ScheduledPrompt
+	if err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&schedules).Error; err != nil {
+		logrus.WithError(err).Error("查询定时任务失败")
+		return nil, errors.New("获取定时任务失败")
+	}
+	return schedules, nil
+}
+
+// GetSchedule 获取用户的定时任务
+func (s *ScheduleService) GetSchedule(userID, scheduleID uuid.UUID) (*models.ScheduledPrompt, error) {
+	var schedule models.ScheduledPrompt
+	err := s.db.Where("id = ? AND user_id = ?", scheduleID, userID).First(&schedule).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrScheduleNotFound
+		}
+		logrus.WithError(err).Error("查询定时任务失败")
+		return nil, errors.New("获取定时任务失败")
+	}
+	return &schedule, nil
+}
+
+// CreateSchedule 创建定时任务，需要对目标对话有发送消息的权限
+func (s *ScheduleService) CreateSchedule(userID uuid.UUID, input ScheduleInput) (*models.ScheduledPrompt, error) {
+	schedule := &models.ScheduledPrompt{
+		UserID:         userID,
+		ConversationID: input.ConversationID,
+		Name:           strings.TrimSpace(input.Name),
+		Prompt:         strings.TrimSpace(input.Prompt),
+		CronExpr:       strings.TrimSpace(input.Cron),
+		Timezone:       strings.TrimSpace(input.Timezone),
+		Enabled:        input.Enabled,
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = "UTC"
+	}
+	if err := s.prepare(schedule, time.Now()); err != nil {
+		return nil, err
+	}
+	if _, err := authorizeConversation(s.db, userID, input.ConversationID, ConversationWrite); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.ScheduledPrompt{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		logrus.WithError(err).Error("统计定时任务失败")
+		return nil, errors.New("创建定时任务失败")
+	}
+	if s.maxPerUser > 0 && int(count) >= s.maxPerUser {
+		return nil, fmt.Errorf("%w（%d 个）", ErrScheduleLimit, s.maxPerUser)
+	}
+
+	if err := s.db.Create(schedule).Error; err != nil {
+		logrus.WithError(err).Error("创建定时任务失败")
+		return nil, errors.New("创建定时任务失败")
+	}
+	return schedule, nil
+}
+
+// UpdateSchedule 修改定时任务，修改表达式、时区或重新启用时重新计算下次触发时间
+func (s *ScheduleService) UpdateSchedule(userID, scheduleID uuid.UUID, update ScheduleUpdate) (*models.ScheduledPrompt, error) {
+	schedule, err := s.GetSchedule(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		schedule.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.Prompt != nil {
+		schedule.Prompt = strings.TrimSpace(*update.Prompt)
+	}
+	if update.Cron != nil {
+		schedule.CronExpr = strings.TrimSpace(*update.Cron)
+	}
+	if update.Timezone != nil {
+		schedule.Timezone = strings.TrimSpace(*update.Timezone)
+	}
+	if update.Enabled != nil {
+		schedule.Enabled = *update.Enabled
+	}
+	if err := s.prepare(schedule, time.Now()); err != nil {
+		return nil, err
+	}
+	if update.Enabled != nil && *update.Enabled {
+		// 重新启用时确认仍有发送消息的权限
+		if _, err := authorizeConversation(s.db, userID, schedule.ConversationID, ConversationWrite); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Model(schedule).Select("name", "prompt", "cron_expr", "timezone", "enabled", "next_run_at").Updates(schedule).Error
+	if err != nil {
+		logrus.WithError(err).Error("更新定时任务失败")
+		return nil, errors.New("更新定时任务失败")
+	}
+	return schedule, nil
+}
+
+// DeleteSchedule 删除定时任务及其执行记录
+func (s *ScheduleService) DeleteSchedule(userID, scheduleID uuid.UUID) error {
+	if _, err := s.GetSchedule(userID, scheduleID); err != nil {
+		return err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("schedule_id = ?", scheduleID).Delete(&models.ScheduledPromptRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", scheduleID).Delete(&models.ScheduledPrompt{}).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("删除定时任务失败")
+		return errors.New("删除定时任务失败")
+	}
+	return nil
+}
+
+// ListRuns 获取定时任务最近的执行记录，新的在前
+func (s *ScheduleService) ListRuns(userID, scheduleID uuid.UUID, limit int) ([]models.ScheduledPromptRun, error) {
+	if _, err := s.GetSchedule(userID, scheduleID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > scheduleRunHistory {
+		limit = 20
+	}
+
+	var runs []models.ScheduledPromptRun
+	err := s.db.Where("schedule_id = ?", scheduleID).Order("started_at DESC").Limit(limit).Find(&runs).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询执行记录失败")
+		return nil, errors.New("获取执行记录失败")
+	}
+	return runs, nil
+}
+
+// prepare 校验定时任务并计算下次触发时间，停用的任务没有下次触发时间
+func (s *ScheduleService) prepare(schedule *models.ScheduledPrompt, now time.Time) error {
+	if schedule.Prompt == "" {
+		return fmt.Errorf("%w: prompt 不能为空", ErrInvalidSchedule)
+	}
+	if len([]rune(schedule.Prompt)) > maxSchedulePrompt {
+		return fmt.Errorf("%w: prompt 不能超过 %d 字", ErrInvalidSchedule, maxSchedulePrompt)
+	}
+	if len([]rune(schedule.Name)) > 200 {
+		return fmt.Errorf("%w: name 不能超过 200 字", ErrInvalidSchedule)
+	}
+
+	cron, err := ParseCron(schedule.CronExpr, schedule.Timezone)
+	if err != nil {
+		return err
+	}
+	next := cron.Next(now)
+	if next.IsZero() {
+		return fmt.Errorf("%w: 五年内不会触发", ErrInvalidCron)
+	}
+	// 检查接下来的若干次触发，避免过于频繁地调用模型
+	for i, prev := 0, next; i < 20; i++ {
+		following := cron.Next(prev)
+		if following.IsZero() {
+			break
+		}
+		if following.Sub(prev) < minScheduleInterval {
+			return fmt.Errorf("%w: 两次触发至少间隔 %d 分钟", ErrInvalidCron, int(minScheduleInterval.Minutes()))
+		}
+		prev = following
+	}
+
+	schedule.NextRunAt = nil
+	if schedule.Enabled {
+		schedule.NextRunAt = &next
+	}
+	return nil
+}
+
+// Start 启动调度：每个轮询周期尝试成为主节点，主节点触发到期的任务；ctx 取消后释放主节点身份
+func (s *ScheduleService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+		defer s.elector.Release()
+
+		for {
+			leader, err := s.elector.Acquire(ctx)
+			if err != nil {
+				logrus.WithError(err).Warn("定时任务选主失败")
+			}
+			if leader {
+				if _, err := s.RunDue(ctx, time.Now()); err != nil {
+					logrus.WithError(err).Warn("触发定时任务失败")
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	logrus.WithField("poll_interval", s.pollInterval.String()).Info("定时任务调度已启动")
+}
+
+// RunDue 触发到期的任务并等待执行完成，返回触发的数量。
+// 停机期间错过的多次触发只补执行一次，之后从当前时间计算下次触发时间
+func (s *ScheduleService) RunDue(ctx context.Context, now time.Time) (int, error) {
+	if s.runner == nil {
+		return 0, errors.New("未设置定时任务执行器")
+	}
+
+	var due []models.ScheduledPrompt
+	err := s.db.Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at ASC").
+		Limit(scheduleDueBatch).
+		Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, scheduleWorkers)
+	triggered := 0
+	for i := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		schedule := &due[i]
+		scheduledFor := *schedule.NextRunAt
+		claimed, err := s.claim(schedule, now)
+		if err != nil {
+			logrus.WithError(err).WithField("schedule_id", schedule.ID).Warn("领取定时任务失败")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		triggered++
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.execute(schedule, scheduledFor)
+		}()
+	}
+	wg.Wait()
+	return triggered, nil
+}
+
+// claim 推进下次触发时间以领取任务；条件更新保证同一次触发只执行一次
+func (s *ScheduleService) claim(schedule *models.ScheduledPrompt, now time.Time) (bool, error) {
+	var next *time.Time
+	if cron, err := ParseCron(schedule.CronExpr, schedule.Timezone); err == nil {
+		if t := cron.Next(now); !t.IsZero() {
+			next = &t
+		}
+	}
+
+	result := s.db.Model(&models.ScheduledPrompt{}).
+		Where("id = ? AND next_run_at = ?", schedule.ID, *schedule.NextRunAt).
+		Updates(map[string]interface{}{
+			"next_run_at": next,
+			"last_run_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	schedule.NextRunAt = next
+	schedule.LastRunAt = &now
+	return result.RowsAffected == 1, nil
+}
+
+// execute 执行一次定时任务并记录结果；对话已不存在或无权访问时停用任务
+func (s *ScheduleService) execute(schedule *models.ScheduledPrompt, scheduledFor time.Time) {
+	run := &models.ScheduledPromptRun{
+		ScheduleID:     schedule.ID,
+		ConversationID: schedule.ConversationID,
+		ScheduledFor:   scheduledFor,
+		Status:         models.ScheduleRunRunning,
+		Node:           s.nodeName,
+		StartedAt:      time.Now(),
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		logrus.WithError(err).WithField("schedule_id", schedule.ID).Warn("写入执行记录失败")
+		return
+	}
+
+	err := s.runPrompt(schedule, run)
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Status = models.ScheduleRunSucceeded
+	if err != nil {
+		run.Status = models.ScheduleRunFailed
+		run.Error = err.Error()
+	}
+
+	scheduleUpdates := map[string]interface{}{
+		"last_status": run.Status,
+		"last_error":  run.Error,
+	}
+	if errors.Is(err, ErrConversationNotFound) || errors.Is(err, ErrConversationForbidden) {
+		scheduleUpdates["enabled"] = false
+		scheduleUpdates["next_run_at"] = nil
+		schedule.Enabled = false
+		schedule.NextRunAt = nil
+	}
+	schedule.LastStatus = run.Status
+	schedule.LastError = run.Error
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(run).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ScheduledPrompt{}).Where("id = ?", schedule.ID).Updates(scheduleUpdates).Error; err != nil {
+			return err
+		}
+		// 只保留最近的执行记录
+		keep := tx.Model(&models.ScheduledPromptRun{}).Select("id").
+			Where("schedule_id = ?", schedule.ID).
+			Order("started_at DESC").
+			Limit(scheduleRunHistory)
+		return tx.Where("schedule_id = ? AND id NOT IN (?)", schedule.ID, keep).Delete(&models.ScheduledPromptRun{}).Error
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("schedule_id", schedule.ID).Warn("保存执行结果失败")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"schedule_id": schedule.ID,
+		"status":      run.Status,
+		"duration":    finishedAt.Sub(run.StartedAt).String(),
+	}).Info("定时任务执行完成")
+
+	s.runner.NotifyScheduleRun(schedule, run)
+}
+
+// runPrompt 以创建者身份发送提示词，消息元数据中记录来自哪个定时任务
+func (s *ScheduleService) runPrompt(schedule *models.ScheduledPrompt, run *models.ScheduledPromptRun) error {
+	var user models.User
+	err := s.db.Where("id = ? AND is_active = ?", schedule.UserID, true).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("用户不存在或已被禁用")
+		}
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	metadata := map[string]interface{}{
+		"schedule_id":     schedule.ID,
+		"schedule_run_id": run.ID,
+	}
+	userMessage, assistantMessage, err := s.runner.RunPrompt(&user, schedule.ConversationID, schedule.Prompt, metadata)
+	if userMessage != nil {
+		run.UserMessageID = &userMessage.ID
+	}
+	if assistantMessage != nil {
+		run.AssistantMessageID = &assistantMessage.ID
+	}
+	return err
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"go-chat-backend/models"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePromptRunner 用 ChatService 保存消息，模拟发送消息接口的流程
+type fakePromptRunner struct {
+	chatService *ChatService
+	fail        error
+
+	mu       sync.Mutex
+	notified []models.ScheduledPromptRun
+}
+
+func (r *fakePromptRunner) RunPrompt(user *models.User, conversationID uuid.UUID, content string, metadata map[string]interface{}) (*models.ChatMessage, *models.ChatMessage, error) {
+	userMessage, err := r.chatService.SendMessage(user.ID, conversationID, content, "user", metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.fail != nil {
+		return userMessage, nil, r.fail
+	}
+	reply, err := r.chatService.SendMessage(user.ID, conversationID, "回复："+content, "assistant", nil)
+	return userMessage, reply, err
+}
+
+func (r *fakePromptRunner) NotifyScheduleRun(schedule *models.ScheduledPrompt, run *models.ScheduledPromptRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notified = append(r.notified, *run)
+}
+
+// TestScheduledPrompts 测试定时任务的校验、到期执行、失败记录以及对话删除后自动停用
+func TestScheduledPrompts(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	runner := &fakePromptRunner{chatService: chatService}
+	schedules := NewScheduleService(db)
+	schedules.SetPromptRunner(runner)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	ctx := context.Background()
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "日报")
+	require.NoError(t, err)
+
+	input := ScheduleInput{
+		ConversationID: conversation.ID,
+		Name:           "早间摘要",
+		Prompt:         "总结昨天的进展",
+		Cron:           "0 9 * * MON-FRI",
+		Timezone:       "Asia/Shanghai",
+		Enabled:        true,
+	}
+
+	_, err = schedules.CreateSchedule(bob.ID, input)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	tooFrequent := input
+	tooFrequent.Cron = "* * * * *"
+	_, err = schedules.CreateSchedule(alice.ID, tooFrequent)
+	assert.ErrorIs(t, err, ErrInvalidCron)
+	badTimezone := input
+	badTimezone.Timezone = "Mars/Olympus"
+	_, err = schedules.CreateSchedule(alice.ID, badTimezone)
+	assert.ErrorIs(t, err, ErrInvalidCron)
+
+	schedule, err := schedules.CreateSchedule(alice.ID, input)
+	require.NoError(t, err)
+	require.NotNil(t, schedule.NextRunAt)
+	assert.True(t, schedule.NextRunAt.After(time.Now()))
+	_, err = schedules.GetSchedule(bob.ID, schedule.ID)
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+
+	// 未到期时不触发
+	triggered, err := schedules.RunDue(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, triggered)
+
+	// 到期后执行一次，错过的多次触发只补一次
+	due := time.Now().Add(-48 * time.Hour).Truncate(time.Minute)
+	require.NoError(t, db.Model(&models.ScheduledPrompt{}).Where("id = ?", schedule.ID).Update("next_run_at", due).Error)
+	now := time.Now()
+	triggered, err = schedules.RunDue(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, triggered)
+	triggered, err = schedules.RunDue(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, triggered)
+
+	runs, err := schedules.ListRuns(alice.ID, schedule.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, models.ScheduleRunSucceeded, runs[0].Status)
+	assert.True(t, due.Equal(runs[0].ScheduledFor))
+	require.NotNil(t, runs[0].UserMessageID)
+	require.NotNil(t, runs[0].AssistantMessageID)
+	assert.NotNil(t, runs[0].FinishedAt)
+
+	var userMessage models.ChatMessage
+	require.NoError(t, db.First(&userMessage, "id = ?", *runs[0].UserMessageID).Error)
+	assert.Equal(t, input.Prompt, userMessage.Content)
+	assert.Contains(t, string(userMessage.Metadata), schedule.ID.String())
+
+	schedule, err = schedules.GetSchedule(alice.ID, schedule.ID)
+	require.NoError(t, err)
+	require.NotNil(t, schedule.NextRunAt)
+	assert.True(t, schedule.NextRunAt.After(now))
+	assert.Equal(t, models.ScheduleRunSucceeded, schedule.LastStatus)
+
+	// 生成回复失败时记录错误，任务保持启用
+	runner.fail = errors.New("模型不可用")
+	require.NoError(t, db.Model(&models.ScheduledPrompt{}).Where("id = ?", schedule.ID).Update("next_run_at", now).Error)
+	triggered, err = schedules.RunDue(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, triggered)
+
+	runs, err = schedules.ListRuns(alice.ID, schedule.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, models.ScheduleRunFailed, runs[0].Status)
+	assert.Equal(t, "模型不可用", runs[0].Error)
+	assert.NotNil(t, runs[0].UserMessageID)
+	assert.Nil(t, runs[0].AssistantMessageID)
+
+	// 停用的任务不触发，重新启用后从当前时间计算下次触发
+	disabled := false
+	schedule, err = schedules.UpdateSchedule(alice.ID, schedule.ID, ScheduleUpdate{Enabled: &disabled})
+	require.NoError(t, err)
+	assert.Nil(t, schedule.NextRunAt)
+	enabled := true
+	schedule, err = schedules.UpdateSchedule(alice.ID, schedule.ID, ScheduleUpdate{Enabled: &enabled})
+	require.NoError(t, err)
+	require.NotNil(t, schedule.NextRunAt)
+
+	// 对话删除后执行失败并自动停用
+	runner.fail = nil
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+	require.NoError(t, db.Model(&models.ScheduledPrompt{}).Where("id = ?", schedule.ID).Update("next_run_at", now).Error)
+	triggered, err = schedules.RunDue(ctx, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, triggered)
+
+	schedule, err = schedules.GetSchedule(alice.ID, schedule.ID)
+	require.NoError(t, err)
+	assert.False(t, schedule.Enabled)
+	assert.Nil(t, schedule.NextRunAt)
+	assert.Equal(t, models.ScheduleRunFailed, schedule.LastStatus)
+	assert.Len(t, runner.notified, 3)
+
+	require.NoError(t, schedules.DeleteSchedule(alice.ID, schedule.ID))
+	var remaining int64
+	db.Model(&models.ScheduledPromptRun{}).Where("schedule_id = ?", schedule.ID).Count(&remaining)
+	assert.Zero(t, remaining)
+}
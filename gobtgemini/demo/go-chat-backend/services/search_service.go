@@ -0,0 +1,331 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/utils"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ErrEmptySearchQuery 搜索关键词为空
+var ErrEmptySearchQuery = errors.New("搜索关键词不能为空")
+
+// 命中片段的高亮标记。ts_headline 先用控制字符标记，HTML 转义后再替换为 <mark>
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+	snippetRunes   = 60 // 关键词前后各保留的字符数
+)
+
+// SearchParams 消息搜索条件
+type SearchParams struct {
+	Query          string
+	ConversationID *uuid.UUID
+	Role           string
+	From           *time.Time // 包含
+	To             *time.Time // 不包含
+	Cursor         string
+	Limit          int
+	Semantic       bool // 第一页附带记忆库的语义命中
+}
+
+// SearchHit 搜索命中的消息
+type SearchHit struct {
+	MessageID         uuid.UUID `json:"message_id"`
+	ConversationID    uuid.UUID `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	Role              string    `json:"role"`
+	Snippet           string    `json:"snippet"` // 已做 HTML 转义，命中部分用 <mark> 包裹
+	Match             string    `json:"match"`   // keyword 或 semantic
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// SearchResult 搜索结果，按消息时间从新到旧排列
+type SearchResult struct {
+	Results    []SearchHit `json:"results"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Semantic   []SearchHit `json:"semantic,omitempty"` // 关键词未命中的语义相关消息
+}
+
+// searchRow 查询结果行
+type searchRow struct {
+	ID                uuid.UUID
+	ConversationID    uuid.UUID
+	ConversationTitle string
+	Role              string
+	Content           string
+	Headline          string
+	CreatedAt         time.Time
+}
+
+// SearchService 跨对话的消息搜索服务。
+// 默认使用 chat_messages.content 上的全文检索索引；查询包含中日韩文字且分词配置不支持时，
+// 退化为基于 pg_trgm 索引的子串匹配。
+type SearchService struct {
+	db               *gorm.DB
+	memoryStore      MemoryStore // 可为空，为空时不支持语义命中
+	textSearchConfig string
+}
+
+// NewSearchService 创建搜索服务
+func NewSearchService(db *gorm.DB, memoryStore MemoryStore) *SearchService {
+	return &SearchService{
+		db:               db,
+		memoryStore:      memoryStore,
+		textSearchConfig: config.Get().TextSearchConfig,
+	}
+}
+
+// Search 搜索用户自己的对话中的消息；指定的对话不存在或无权访问时返回 ErrConversationNotFound
+func (s *SearchService) Search(userID uuid.UUID, params SearchParams) (*SearchResult, error) {
+	params.Query = strings.TrimSpace(params.Query)
+	if params.Query == "" {
+		return nil, ErrEmptySearchQuery
+	}
+	if params.Limit <= 0 || params.Limit > 100 {
+		params.Limit = 20
+	}
+
+	if params.ConversationID != nil {
+		if _, err := authorizeConversation(s.db, userID, *params.ConversationID, ConversationRead); err != nil {
+			return nil, err
+		}
+	}
+
+	query := s.filteredMessages(userID, params)
+	if params.Cursor != "" {
+		cursorTime, cursorID, err := utils.DecodeCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(m.created_at < ? OR (m.created_at = ? AND m.id < ?))", cursorTime, cursorTime, cursorID)
+	}
+
+	trigram := s.useTrigram(params.Query)
+	if trigram {
+		query = query.Select("m.id, m.conversation_id, s.title AS conversation_title, m.role, m.content, m.created_at").
+			Where("m.content ILIKE ?", "%"+escapeLike(params.Query)+"%")
+	} else {
+		// 控制字符用作高亮标记，先从原文中去掉
+		options := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=35, MinWords=15, MaxFragments=2", highlightStart, highlightStop)
+		query = query.Select(fmt.Sprintf(`m.id, m.conversation_id, s.title AS conversation_title, m.role, m.created_at,
+	ts_headline('%[1]s', translate(m.content, E'\x01\x02', ''), plainto_tsquery('%[1]s', ?), ?) AS headline`,
+			s.textSearchConfig), params.Query, options).
+			Where(fmt.Sprintf("to_tsvector('%[1]s', m.content) @@ plainto_tsquery('%[1]s', ?)", s.textSearchConfig), params.Query)
+	}
+
+	var rows []searchRow
+	err := query.Order("m.created_at DESC, m.id DESC").Limit(params.Limit + 1).Scan(&rows).Error
+	if err != nil {
+		logrus.WithError(err).Error("搜索消息失败")
+		return nil, errors.New("搜索消息失败")
+	}
+
+	result := &SearchResult{Results: make([]SearchHit, 0, len(rows))}
+	if len(rows) > params.Limit {
+		rows = rows[:params.Limit]
+		last := rows[len(rows)-1]
+		result.NextCursor = utils.EncodeCursor(last.CreatedAt, last.ID)
+	}
+
+	seen := make(map[uuid.UUID]bool, len(rows))
+	for _, row := range rows {
+		snippet := highlightSnippet(row.Headline)
+		if trigram {
+			snippet = substringSnippet(row.Content, params.Query)
+		}
+		result.Results = append(result.Results, row.hit(snippet, "keyword"))
+		seen[row.ID] = true
+	}
+
+	if params.Semantic && params.Cursor == "" && s.memoryStore != nil {
+		result.Semantic = s.semanticHits(userID, params, seen)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":  userID.String(),
+		"results":  len(result.Results),
+		"semantic": len(result.Semantic),
+		"trigram":  trigram,
+	}).Debug("消息搜索完成")
+
+	return result, nil
+}
+
+// filteredMessages 用户可以查看的未删除对话中的消息，附加对话、角色和时间过滤条件
+func (s *SearchService) filteredMessages(userID uuid.UUID, params SearchParams) *gorm.DB {
+	query := s.db.Table("chat_messages AS m").
+		Joins("JOIN chat_sessions AS s ON s.id = m.conversation_id").
+		Where("s.id IN (?) AND s.is_active = ? AND s.deleted_at IS NULL AND m.deleted_at IS NULL", visibleConversations(s.db, userID), true)
+
+	if params.ConversationID != nil {
+		query = query.Where("m.conversation_id = ?", *params.ConversationID)
+	}
+	if params.Role != "" {
+		query = query.Where("m.role = ?", params.Role)
+	}
+	if params.From != nil {
+		query = query.Where("m.created_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		query = query.Where("m.created_at < ?", *params.To)
+	}
+	return query
+}
+
+// semanticHits 用记忆库做语义检索，再按内容映射回满足过滤条件的消息；失败时只记录日志
+func (s *SearchService) semanticHits(userID uuid.UUID, params SearchParams, exclude map[uuid.UUID]bool) []SearchHit {
+	contents, err := s.memoryStore.SearchMemory(userID, params.Query, min(params.Limit, 10))
+	if err != nil {
+		logrus.WithError(err).Warn("语义检索失败，仅返回关键词结果")
+		return nil
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+
+	var rows []searchRow
+	err = s.filteredMessages(userID, params).
+		Select("m.id, m.conversation_id, s.title AS conversation_title, m.role, m.content, m.created_at").
+		Where("m.content IN ?", contents).
+		Order("m.created_at DESC, m.id DESC").
+		Scan(&rows).Error
+	if err != nil {
+		logrus.WithError(err).Warn("查询语义命中的消息失败")
+		return nil
+	}
+
+	// 按语义相似度顺序排列，同一内容只取最新的一条
+	byContent := make(map[string]searchRow, len(rows))
+	for _, row := range rows {
+		if _, ok := byContent[row.Content]; !ok && !exclude[row.ID] {
+			byContent[row.Content] = row
+		}
+	}
+
+	var hits []SearchHit
+	for _, content := range contents {
+		row, ok := byContent[content]
+		if !ok {
+			continue
+		}
+		delete(byContent, content)
+		hits = append(hits, row.hit(html.EscapeString(truncateRunes(row.Content, snippetRunes*2)), "semantic"))
+	}
+	return hits
+}
+
+// useTrigram 查询包含中日韩文字且分词配置不能切分这些文字时使用子串匹配
+func (s *SearchService) useTrigram(query string) bool {
+	return needsTrigram(s.textSearchConfig, query)
+}
+
+// needsTrigram 分词配置（如 simple、english）不能切分查询中的中日韩文字时，全文检索无法命中，需要改用 pg_trgm 子串匹配
+func needsTrigram(textSearchConfig, query string) bool {
+	return textSearchConfig != "chinese" && containsCJK(query)
+}
+
+// hit 转换为搜索命中
+func (r searchRow) hit(snippet, match string) SearchHit {
+	return SearchHit{
+		MessageID:         r.ID,
+		ConversationID:    r.ConversationID,
+		ConversationTitle: r.ConversationTitle,
+		Role:              r.Role,
+		Snippet:           snippet,
+		Match:             match,
+		CreatedAt:         r.CreatedAt,
+	}
+}
+
+// containsCJK 是否包含中日韩文字
+func containsCJK(text string) bool {
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// highlightSnippet 转义 ts_headline 的结果，并把高亮标记替换为 <mark>
+func highlightSnippet(headline string) string {
+	escaped := html.EscapeString(headline)
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(escaped)
+}
+
+// substringSnippet 截取第一个（不区分大小写）命中位置前后的文本并高亮
+func substringSnippet(content, query string) string {
+	runes := []rune(content)
+	lowerContent := []rune(strings.ToLower(content))
+	lowerQuery := []rune(strings.ToLower(query))
+
+	// 大小写转换可能改变字符数，此时无法按位置对应，直接截取开头
+	start := -1
+	if len(lowerContent) == len(runes) {
+		start = indexRunes(lowerContent, lowerQuery)
+	}
+	if start < 0 {
+		return html.EscapeString(truncateRunes(content, snippetRunes*2))
+	}
+	end := start + len(lowerQuery)
+
+	from := max(start-snippetRunes, 0)
+	to := min(end+snippetRunes, len(runes))
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("...")
+	}
+	b.WriteString(html.EscapeString(string(runes[from:start])))
+	b.WriteString("<mark>")
+	b.WriteString(html.EscapeString(string(runes[start:end])))
+	b.WriteString("</mark>")
+	b.WriteString(html.EscapeString(string(runes[end:to])))
+	if to < len(runes) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// indexRunes 返回 sub 在 s 中第一次出现的位置（按字符计），不存在时返回 -1
+func indexRunes(s, sub []rune) int {
+	if len(sub) == 0 {
+		return -1
+	}
+	for i := 0; i+len(sub) <= len(s); i++ {
+		match := true
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// truncateRunes 按字符截取，超出时追加省略号
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "..."
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"fmt"
+	"go-chat-backend/models"
+	"go-chat-backend/utils"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHighlightSnippet 测试 ts_headline 结果的转义与高亮
+func TestHighlightSnippet(t *testing.T) {
+	headline := "订单 " + highlightStart + "ORD-1024" + highlightStop + " <script>"
+	assert.Equal(t, "订单 <mark>ORD-1024</mark> &lt;script&gt;", highlightSnippet(headline))
+}
+
+// TestSubstringSnippet 测试子串匹配的片段截取
+func TestSubstringSnippet(t *testing.T) {
+	assert.Equal(t, "我的<mark>订单</mark>号是 &lt;1024&gt;", substringSnippet("我的订单号是 <1024>", "订单"))
+	// 不区分大小写，高亮保留原文
+	assert.Equal(t, "Error <mark>CODE</mark>", substringSnippet("Error CODE", "code"))
+
+	long := strings.Repeat("甲", 100) + "关键词" + strings.Repeat("乙", 100)
+	snippet := substringSnippet(long, "关键词")
+	assert.Equal(t, "..."+strings.Repeat("甲", snippetRunes)+"<mark>关键词</mark>"+strings.Repeat("乙", snippetRunes)+"...", snippet)
+
+	// 未找到时截取开头
+	assert.Equal(t, "abc", substringSnippet("abc", "xyz"))
+}
+
+// TestContainsCJK 测试中日韩文字检测与 LIKE 转义
+func TestContainsCJK(t *testing.T) {
+	assert.True(t, containsCJK("订单"))
+	assert.True(t, containsCJK("エラー"))
+	assert.True(t, containsCJK("오류 code"))
+	assert.False(t, containsCJK("ORD-1024"))
+
+	assert.Equal(t, `100\% \_ok\\`, escapeLike(`100% _ok\`))
+}
+
+// TestSearchFilters 测试搜索范围只包括可以查看的未删除对话和消息，以及对话、角色、时间过滤和语义命中
+func TestSearchFilters(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	carol := createTestUser(t, db, "carol")
+
+	now := time.Now()
+	add := func(userID, conversationID uuid.UUID, content, role string, createdAt time.Time) *models.ChatMessage {
+		message, err := chatService.AddMessage(userID, conversationID, nil, content, role, nil)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(message).Update("created_at", createdAt).Error)
+		return message
+	}
+
+	first, err := chatService.CreateChatSession(alice.ID, "first")
+	require.NoError(t, err)
+	second, err := chatService.CreateChatSession(alice.ID, "second")
+	require.NoError(t, err)
+	shared, err := chatService.CreateChatSession(bob.ID, "shared")
+	require.NoError(t, err)
+	_, err = chatService.AddParticipant(bob.ID, shared.ID, "alice", models.ParticipantViewer)
+	require.NoError(t, err)
+	foreign, err := chatService.CreateChatSession(carol.ID, "foreign")
+	require.NoError(t, err)
+	trashed, err := chatService.CreateChatSession(alice.ID, "trashed")
+	require.NoError(t, err)
+
+	add(alice.ID, first.ID, "订单 1", "user", now.Add(-48*time.Hour))
+	add(alice.ID, first.ID, "订单 2", "assistant", now.Add(-24*time.Hour))
+	third := add(alice.ID, second.ID, "订单 3", "user", now)
+	add(bob.ID, shared.ID, "订单 4", "user", now)
+	add(carol.ID, foreign.ID, "订单 5", "user", now)
+	add(alice.ID, trashed.ID, "订单 6", "user", now)
+	deleted := add(alice.ID, first.ID, "订单 7", "user", now)
+	require.NoError(t, db.Delete(deleted).Error)
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, trashed.ID))
+
+	service := NewSearchService(db, nil)
+	contents := func(params SearchParams) []string {
+		var contents []string
+		require.NoError(t, service.filteredMessages(alice.ID, params).Order("m.content").Pluck("m.content", &contents).Error)
+		return contents
+	}
+	boundary := now.Add(-36 * time.Hour)
+	assert.Equal(t, []string{"订单 1", "订单 2", "订单 3", "订单 4"}, contents(SearchParams{}))
+	assert.Equal(t, []string{"订单 1", "订单 2"}, contents(SearchParams{ConversationID: &first.ID}))
+	assert.Equal(t, []string{"订单 2"}, contents(SearchParams{Role: "assistant"}))
+	assert.Equal(t, []string{"订单 2", "订单 3", "订单 4"}, contents(SearchParams{From: &boundary}))
+	assert.Equal(t, []string{"订单 1"}, contents(SearchParams{To: &boundary}))
+
+	// 语义命中按记忆库的顺序映射回满足过滤条件的消息，去掉关键词已命中的和重复的内容
+	service.memoryStore = &vectorMemoryStore{results: []string{"订单 5", "订单 3", "订单 2", "订单 1", "订单 2"}}
+	hits := service.semanticHits(alice.ID, SearchParams{Query: "订单", Limit: 20}, map[uuid.UUID]bool{third.ID: true})
+	require.Len(t, hits, 2)
+	assert.Equal(t, "订单 2", hits[0].Snippet)
+	assert.Equal(t, "semantic", hits[0].Match)
+	assert.Equal(t, "first", hits[0].ConversationTitle)
+	assert.Equal(t, "订单 1", hits[1].Snippet)
+	hits = service.semanticHits(alice.ID, SearchParams{Query: "订单", Limit: 20, Role: "user", From: &boundary}, nil)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "订单 3", hits[0].Snippet)
+
+	_, err = service.Search(alice.ID, SearchParams{Query: "  "})
+	assert.ErrorIs(t, err, ErrEmptySearchQuery)
+	_, err = service.Search(alice.ID, SearchParams{Query: "订单", ConversationID: &foreign.ID})
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	_, err = service.Search(alice.ID, SearchParams{Query: "订单", Cursor: "bad"})
+	assert.ErrorIs(t, err, utils.ErrInvalidCursor)
+}
+
+// TestSearchCursorPagination 测试全文检索和中日韩子串匹配按 (created_at, id) 游标翻页，
+// 同一时间的消息不重复也不遗漏。需要 Postgres，见 newPostgresTestDB
+func TestSearchCursorPagination(t *testing.T) {
+	db := newPostgresTestDB(t)
+	require.NoError(t, db.AutoMigrate(testModels...))
+	chatService := NewChatService(db)
+	alice := createTestUser(t, db, "alice")
+	conversation, err := chatService.CreateChatSession(alice.ID, "orders")
+	require.NoError(t, err)
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var expected []string
+	for i := 0; i < 5; i++ {
+		content := fmt.Sprintf("订单 ORD-%d shipped", i)
+		message, err := chatService.AddMessage(alice.ID, conversation.ID, nil, content, "user", nil)
+		require.NoError(t, err)
+		// 后三条使用相同的时间，只能靠 id 区分先后
+		createdAt := base.Add(time.Duration(min(i, 2)) * time.Minute)
+		require.NoError(t, db.Model(message).Update("created_at", createdAt).Error)
+		expected = append(expected, message.ID.String())
+	}
+	_, err = chatService.AddMessage(alice.ID, conversation.ID, nil, "unrelated", "user", nil)
+	require.NoError(t, err)
+
+	service := NewSearchService(db, nil)
+	service.textSearchConfig = "simple"
+	for _, query := range []string{"shipped", "订单"} {
+		var ids []string
+		var times []time.Time
+		cursor := ""
+		for page := 0; page < 10; page++ {
+			result, err := service.Search(alice.ID, SearchParams{Query: query, Cursor: cursor, Limit: 2})
+			require.NoError(t, err)
+			for _, hit := range result.Results {
+				ids = append(ids, hit.MessageID.String())
+				times = append(times, hit.CreatedAt)
+				assert.Contains(t, hit.Snippet, "<mark>")
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			cursor = result.NextCursor
+		}
+		assert.ElementsMatch(t, expected, ids, query)
+		assert.True(t, sort.SliceIsSorted(times, func(i, j int) bool { return times[i].After(times[j]) }), query)
+	}
+}
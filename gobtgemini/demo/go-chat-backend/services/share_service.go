@@ -0,0 +1,311 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-chat-backend/models"
+	"go-chat-backend/utils"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// 分享相关错误
+var (
+	ErrShareNotFound    = errors.New("分享链接不存在或已失效")
+	ErrInvalidShareMode = errors.New("分享模式只能是 snapshot 或 live")
+	ErrShareExpiry      = errors.New("过期时间必须晚于当前时间")
+)
+
+// 审计日志中的分享操作
+const (
+	AuditShareCreate = "share.create"
+	AuditShareRevoke = "share.revoke"
+	AuditShareView   = "share.view"
+	AuditShareFork   = "share.fork"
+)
+
+// SharedConversation 分享链接展示的只读视图，只包含当前分支上消息的角色、内容和时间，
+// 不包含用户、消息ID和元数据
+type SharedConversation struct {
+	Title     string          `json:"title"`
+	Mode      string          `json:"mode"`
+	CreatedAt time.Time       `json:"created_at"`
+	SharedAt  time.Time       `json:"shared_at"`
+	Messages  []SharedMessage `json:"messages"`
+}
+
+// SharedMessage 分享视图中的消息
+type SharedMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareService 对话分享服务
+type ShareService struct {
+	db *gorm.DB
+}
+
+// NewShareService 创建分享服务
+func NewShareService(db *gorm.DB) *ShareService {
+	return &ShareService{db: db}
+}
+
+// CreateShare 为对话创建分享链接，需要对话的管理权限；expiresAt 为空表示不过期
+func (s *ShareService) CreateShare(actx AuditContext, userID, conversationID uuid.UUID, mode string, expiresAt *time.Time) (*models.ConversationShare, error) {
+	if mode == "" {
+		mode = models.ShareModeSnapshot
+	}
+	if mode != models.ShareModeSnapshot && mode != models.ShareModeLive {
+		return nil, ErrInvalidShareMode
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, ErrShareExpiry
+	}
+
+	session, err := authorizeConversation(s.db, userID, conversationID, ConversationManage)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateShareToken()
+	if err != nil {
+		logrus.WithError(err).Error("生成分享令牌失败")
+		return nil, errors.New("创建分享链接失败")
+	}
+
+	share := &models.ConversationShare{
+		ID:             uuid.New(),
+		ConversationID: conversationID,
+		UserID:         userID,
+		Token:          token,
+		Mode:           mode,
+		ExpiresAt:      expiresAt,
+	}
+
+	if mode == models.ShareModeSnapshot {
+		view, err := s.buildView(session, mode, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(view)
+		if err != nil {
+			return nil, errors.New("创建分享链接失败")
+		}
+		share.Snapshot = datatypes.JSON(raw)
+	}
+
+	if err := s.db.Create(share).Error; err != nil {
+		logrus.WithError(err).Error("创建分享链接失败")
+		return nil, errors.New("创建分享链接失败")
+	}
+
+	recordAudit(s.db, actx, AuditShareCreate, "conversation_share", share.ID, map[string]interface{}{
+		"conversation_id": conversationID,
+		"mode":            mode,
+		"expires_at":      expiresAt,
+	})
+	return share, nil
+}
+
+// ListShares 获取对话的分享链接（含已撤销和已过期的）
+func (s *ShareService) ListShares(userID, conversationID uuid.UUID) ([]models.ConversationShare, error) {
+	if _, err := authorizeConversation(s.db, userID, conversationID, ConversationManage); err != nil {
+		return nil, err
+	}
+
+	var shares []models.ConversationShare
+	err := s.db.Where("conversation_id = ?", conversationID).
+		Order("created_at DESC").
+		Find(&shares).Error
+	if err != nil {
+		logrus.WithError(err).Error("获取分享链接失败")
+		return nil, errors.New("获取分享链接失败")
+	}
+	return shares, nil
+}
+
+// RevokeShare 撤销分享链接，撤销后链接立即失效
+func (s *ShareService) RevokeShare(actx AuditContext, userID, shareID uuid.UUID) error {
+	var share models.ConversationShare
+	if err := s.db.Where("id = ?", shareID).First(&share).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrShareNotFound
+		}
+		logrus.WithError(err).Error("查询分享链接失败")
+		return errors.New("撤销分享链接失败")
+	}
+
+	if _, err := authorizeConversation(s.db, userID, share.ConversationID, ConversationManage); err != nil {
+		if errors.Is(err, ErrConversationNotFound) {
+			return ErrShareNotFound
+		}
+		return err
+	}
+	if share.RevokedAt != nil {
+		return nil
+	}
+
+	if err := s.db.Model(&share).Update("revoked_at", time.Now()).Error; err != nil {
+		logrus.WithError(err).Error("撤销分享链接失败")
+		return errors.New("撤销分享链接失败")
+	}
+
+	recordAudit(s.db, actx, AuditShareRevoke, "conversation_share", share.ID, map[string]interface{}{
+		"conversation_id": share.ConversationID,
+	})
+	return nil
+}
+
+// ViewShare 通过令牌获取只读视图，无需登录；链接不存在、已撤销、已过期或对话已删除时返回 ErrShareNotFound
+func (s *ShareService) ViewShare(actx AuditContext, token string) (*SharedConversation, error) {
+	share, view, err := s.resolve(token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.db.Model(&models.ConversationShare{}).Where("id = ?", share.ID).Updates(map[string]interface{}{
+		"view_count":     gorm.Expr("view_count + 1"),
+		"last_viewed_at": now,
+	})
+	recordAudit(s.db, actx, AuditShareView, "conversation_share", share.ID, nil)
+
+	return view, nil
+}
+
+// ForkShare 将分享的内容复制为当前用户的新对话
+func (s *ShareService) ForkShare(actx AuditContext, userID uuid.UUID, token string) (*models.ChatSession, error) {
+	share, view, err := s.resolve(token)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := importedConversation{
+		Title:     view.Title + "（副本）",
+		CreatedAt: time.Now(),
+	}
+	for i, msg := range view.Messages {
+		item := importedMessage{
+			ID:        fmt.Sprint(i),
+			Role:      msg.Role,
+			Content:   msg.Content,
+			CreatedAt: msg.CreatedAt,
+		}
+		if i > 0 {
+			item.ParentID = fmt.Sprint(i - 1)
+		}
+		conv.Messages = append(conv.Messages, item)
+	}
+
+	var session *models.ChatSession
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		session, _, err = importConversation(tx, userID, &conv)
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).Error("复制分享对话失败")
+		return nil, errors.New("复制分享对话失败")
+	}
+
+	recordAudit(s.db, actx, AuditShareFork, "conversation_share", share.ID, map[string]interface{}{
+		"conversation_id": session.ID,
+	})
+	return session, nil
+}
+
+// resolve 校验令牌并返回分享及其视图
+func (s *ShareService) resolve(token string) (*models.ConversationShare, *SharedConversation, error) {
+	if token == "" {
+		return nil, nil, ErrShareNotFound
+	}
+
+	var share models.ConversationShare
+	err := s.db.Where("token = ?", token).First(&share).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrShareNotFound
+		}
+		logrus.WithError(err).Error("查询分享链接失败")
+		return nil, nil, errors.New("查询分享链接失败")
+	}
+	if share.RevokedAt != nil || (share.ExpiresAt != nil && !share.ExpiresAt.After(time.Now())) {
+		return nil, nil, ErrShareNotFound
+	}
+
+	// 对话被删除（进入回收站）后分享同时失效
+	var session models.ChatSession
+	err = s.db.Where("id = ? AND is_active = ?", share.ConversationID, true).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrShareNotFound
+		}
+		logrus.WithError(err).Error("查询分享对话失败")
+		return nil, nil, errors.New("查询分享链接失败")
+	}
+
+	if share.Mode == models.ShareModeSnapshot {
+		var view SharedConversation
+		if err := json.Unmarshal(share.Snapshot, &view); err != nil {
+			logrus.WithError(err).WithField("share_id", share.ID).Error("解析分享快照失败")
+			return nil, nil, errors.New("查询分享链接失败")
+		}
+		return &share, &view, nil
+	}
+
+	view, err := s.buildView(&session, share.Mode, share.CreatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &share, view, nil
+}
+
+// buildView 生成对话当前分支的只读视图
+func (s *ShareService) buildView(session *models.ChatSession, mode string, sharedAt time.Time) (*SharedConversation, error) {
+	tree, err := loadMessageTree(s.db, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &SharedConversation{
+		Title:     session.Title,
+		Mode:      mode,
+		CreatedAt: session.CreatedAt,
+		SharedAt:  sharedAt,
+		Messages:  []SharedMessage{},
+	}
+	for _, msg := range tree.activePath(session.ActiveLeafID) {
+		view.Messages = append(view.Messages, SharedMessage{
+			Role:      msg.Role,
+			Content:   msg.Content,
+			CreatedAt: msg.CreatedAt,
+		})
+	}
+	return view, nil
+}
+
+// RenderSharedHTML 以独立的 HTML 页面输出分享视图，内容经过转义
+func RenderSharedHTML(view *SharedConversation) ([]byte, error) {
+	export := &ConversationExport{
+		ExportedAt: view.SharedAt,
+		Conversation: ExportedConversation{
+			Title:     view.Title,
+			CreatedAt: view.CreatedAt,
+		},
+	}
+	path := make([]*models.ChatMessage, 0, len(view.Messages))
+	for _, msg := range view.Messages {
+		path = append(path, &models.ChatMessage{
+			Role:      msg.Role,
+			Content:   msg.Content,
+			CreatedAt: msg.CreatedAt,
+		})
+	}
+	return renderHTMLExport(export, path)
+}
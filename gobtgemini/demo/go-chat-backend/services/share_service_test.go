@@ -0,0 +1,149 @@
+package services
+
+import (
+	"go-chat-backend/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestShareSnapshotAndLive 测试快照分享固定创建时的内容，实时分享跟随对话更新
+func TestShareSnapshotAndLive(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	shareService := NewShareService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	actx := AuditContext{UserID: &alice.ID, IP: "127.0.0.1"}
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "旅行计划")
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "去哪里玩", "user", map[string]interface{}{"secret": "内部信息"})
+	require.NoError(t, err)
+
+	snapshot, err := shareService.CreateShare(actx, alice.ID, conversation.ID, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.ShareModeSnapshot, snapshot.Mode)
+	assert.NotEmpty(t, snapshot.Token)
+	live, err := shareService.CreateShare(actx, alice.ID, conversation.ID, models.ShareModeLive, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, snapshot.Token, live.Token)
+
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "推荐杭州", "assistant", nil)
+	require.NoError(t, err)
+
+	view, err := shareService.ViewShare(AuditContext{IP: "10.0.0.1"}, snapshot.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "旅行计划", view.Title)
+	require.Len(t, view.Messages, 1)
+	assert.Equal(t, "去哪里玩", view.Messages[0].Content)
+
+	view, err = shareService.ViewShare(AuditContext{IP: "10.0.0.1"}, live.Token)
+	require.NoError(t, err)
+	require.Len(t, view.Messages, 2)
+	assert.Equal(t, "推荐杭州", view.Messages[1].Content)
+
+	page, err := RenderSharedHTML(view)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "推荐杭州")
+	assert.NotContains(t, string(page), "内部信息")
+
+	var stored models.ConversationShare
+	require.NoError(t, db.First(&stored, "id = ?", live.ID).Error)
+	assert.Equal(t, 1, stored.ViewCount)
+	assert.NotNil(t, stored.LastViewedAt)
+
+	var audits []models.AuditLog
+	require.NoError(t, db.Order("created_at ASC").Find(&audits).Error)
+	actions := make([]string, 0, len(audits))
+	for _, entry := range audits {
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{AuditShareCreate, AuditShareCreate, AuditShareView, AuditShareView}, actions)
+	assert.Nil(t, audits[2].UserID)
+	assert.Equal(t, "10.0.0.1", audits[2].IP)
+
+	_, err = shareService.CreateShare(AuditContext{UserID: &bob.ID}, bob.ID, conversation.ID, "", nil)
+	assert.ErrorIs(t, err, ErrConversationNotFound)
+	_, err = shareService.CreateShare(actx, alice.ID, conversation.ID, "public", nil)
+	assert.ErrorIs(t, err, ErrInvalidShareMode)
+}
+
+// TestShareRevokeAndExpiry 测试撤销、过期和对话删除后分享失效
+func TestShareRevokeAndExpiry(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	shareService := NewShareService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+	actx := AuditContext{UserID: &alice.ID}
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "分享测试")
+	require.NoError(t, err)
+
+	share, err := shareService.CreateShare(actx, alice.ID, conversation.ID, models.ShareModeLive, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, shareService.RevokeShare(AuditContext{UserID: &bob.ID}, bob.ID, share.ID), ErrShareNotFound)
+	require.NoError(t, shareService.RevokeShare(actx, alice.ID, share.ID))
+	_, err = shareService.ViewShare(AuditContext{}, share.Token)
+	assert.ErrorIs(t, err, ErrShareNotFound)
+
+	past := time.Now().Add(-time.Minute)
+	_, err = shareService.CreateShare(actx, alice.ID, conversation.ID, "", &past)
+	assert.ErrorIs(t, err, ErrShareExpiry)
+
+	future := time.Now().Add(time.Hour)
+	expiring, err := shareService.CreateShare(actx, alice.ID, conversation.ID, "", &future)
+	require.NoError(t, err)
+	_, err = shareService.ViewShare(AuditContext{}, expiring.Token)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(expiring).Update("expires_at", past).Error)
+	_, err = shareService.ViewShare(AuditContext{}, expiring.Token)
+	assert.ErrorIs(t, err, ErrShareNotFound)
+
+	active, err := shareService.CreateShare(actx, alice.ID, conversation.ID, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, chatService.DeleteChatSession(alice.ID, conversation.ID))
+	_, err = shareService.ViewShare(AuditContext{}, active.Token)
+	assert.ErrorIs(t, err, ErrShareNotFound)
+
+	_, err = shareService.ViewShare(AuditContext{}, "not-a-token")
+	assert.ErrorIs(t, err, ErrShareNotFound)
+}
+
+// TestForkShare 测试登录用户将分享复制为自己的对话
+func TestForkShare(t *testing.T) {
+	db := newTestDB(t)
+	chatService := NewChatService(db)
+	shareService := NewShareService(db)
+	alice := createTestUser(t, db, "alice")
+	bob := createTestUser(t, db, "bob")
+
+	conversation, err := chatService.CreateChatSession(alice.ID, "菜谱")
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "怎么做红烧肉", "user", nil)
+	require.NoError(t, err)
+	_, err = chatService.SendMessage(alice.ID, conversation.ID, "先焯水", "assistant", nil)
+	require.NoError(t, err)
+
+	share, err := shareService.CreateShare(AuditContext{UserID: &alice.ID}, alice.ID, conversation.ID, "", nil)
+	require.NoError(t, err)
+
+	forked, err := shareService.ForkShare(AuditContext{UserID: &bob.ID}, bob.ID, share.Token)
+	require.NoError(t, err)
+	assert.Equal(t, bob.ID, forked.UserID)
+	assert.Equal(t, "菜谱（副本）", forked.Title)
+
+	require.NotNil(t, forked.ActiveLeafID)
+	path, err := chatService.GetPathMessages(bob.ID, forked.ID, *forked.ActiveLeafID, 0)
+	require.NoError(t, err)
+	require.Len(t, path, 2)
+	assert.Equal(t, "怎么做红烧肉", path[0].Content)
+	assert.Equal(t, path[0].ID, *path[1].ParentID)
+
+	var count int64
+	db.Model(&models.AuditLog{}).Where("action = ? AND user_id = ?", AuditShareFork, bob.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
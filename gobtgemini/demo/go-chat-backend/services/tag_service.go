@@ -0,0 +1,271 @@
+package services
+
+import (
+	"errors"
+	"go-chat-backend/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 标签相关错误
+var (
+	ErrTagNotFound  = errors.New("标签不存在或无权访问")
+	ErrTagExists    = errors.New("已存在同名标签")
+	ErrEmptyTagName = errors.New("标签名称不能为空")
+)
+
+// TagWithCount 标签及使用它的未删除对话数量
+type TagWithCount struct {
+	models.Tag
+	ConversationCount int64 `json:"conversation_count"`
+}
+
+// TagService 对话标签服务
+type TagService struct {
+	db *gorm.DB
+}
+
+// NewTagService 创建标签服务
+func NewTagService(db *gorm.DB) *TagService {
+	return &TagService{db: db}
+}
+
+// CreateTag 创建标签，同一用户下名称不能重复
+func (s *TagService) CreateTag(userID uuid.UUID, name, color string) (*models.Tag, error) {
+	name, err := s.checkName(userID, uuid.Nil, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &models.Tag{
+		ID:     uuid.New(),
+		UserID: userID,
+		Name:   name,
+		Color:  color,
+	}
+	if err := s.db.Create(tag).Error; err != nil {
+		logrus.WithError(err).Error("创建标签失败")
+		return nil, errors.New("创建标签失败")
+	}
+	return tag, nil
+}
+
+// ListTags 获取用户的标签，按名称排序
+func (s *TagService) ListTags(userID uuid.UUID) ([]TagWithCount, error) {
+	var tags []models.Tag
+	if err := s.db.Where("user_id = ?", userID).Order("name ASC").Find(&tags).Error; err != nil {
+		logrus.WithError(err).Error("获取标签列表失败")
+		return nil, errors.New("获取标签列表失败")
+	}
+
+	var counts []struct {
+		TagID uuid.UUID
+		Count int64
+	}
+	err := s.db.Table("conversation_tags").
+		Select("conversation_tags.tag_id, COUNT(*) AS count").
+		Joins("JOIN tags ON tags.id = conversation_tags.tag_id").
+		Joins("JOIN chat_sessions ON chat_sessions.id = conversation_tags.conversation_id").
+		Where("tags.user_id = ? AND chat_sessions.is_active = ?", userID, true).
+		Where("chat_sessions.id IN (?)", visibleConversations(s.db, userID)).
+		Group("conversation_tags.tag_id").
+		Scan(&counts).Error
+	if err != nil {
+		logrus.WithError(err).Error("统计标签对话数失败")
+		return nil, errors.New("获取标签列表失败")
+	}
+	countByTag := make(map[uuid.UUID]int64, len(counts))
+	for _, row := range counts {
+		countByTag[row.TagID] = row.Count
+	}
+
+	result := make([]TagWithCount, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, TagWithCount{Tag: tag, ConversationCount: countByTag[tag.ID]})
+	}
+	return result, nil
+}
+
+// UpdateTag 修改标签名称或颜色，为空的参数保持不变
+func (s *TagService) UpdateTag(userID, tagID uuid.UUID, name, color *string) (*models.Tag, error) {
+	tags, err := findTags(s.db, userID, []uuid.UUID{tagID})
+	if err != nil {
+		return nil, err
+	}
+	tag := &tags[0]
+
+	updates := make(map[string]interface{})
+	if name != nil {
+		checked, err := s.checkName(userID, tagID, *name)
+		if err != nil {
+			return nil, err
+		}
+		updates["name"] = checked
+	}
+	if color != nil {
+		updates["color"] = *color
+	}
+	if len(updates) == 0 {
+		return tag, nil
+	}
+
+	if err := s.db.Model(tag).Updates(updates).Error; err != nil {
+		logrus.WithError(err).Error("更新标签失败")
+		return nil, errors.New("更新标签失败")
+	}
+	return tag, nil
+}
+
+// DeleteTag 删除标签并从所有对话上移除
+func (s *TagService) DeleteTag(userID, tagID uuid.UUID) error {
+	tags, err := findTags(s.db, userID, []uuid.UUID{tagID})
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.ChatSession{}).Unscoped().
+			Where("id IN (?)", tx.Model(&models.ConversationTag{}).Select("conversation_id").Where("tag_id = ?", tagID)).
+			Update("updated_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("tag_id = ?", tagID).Delete(&models.ConversationTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tags[0]).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("删除标签失败")
+		return errors.New("删除标签失败")
+	}
+	return nil
+}
+
+// SetConversationTags 将当前用户在对话上的标签替换为 tagIDs，tagIDs 为空时清除全部标签。
+// 标签属于用户自己，不影响其他参与者的标签，能查看对话即可设置
+func (s *TagService) SetConversationTags(userID, conversationID uuid.UUID, tagIDs []uuid.UUID) ([]models.Tag, error) {
+	if _, err := authorizeConversation(s.db, userID, conversationID, ConversationRead); err != nil {
+		return nil, err
+	}
+	tags, err := findTags(s.db, userID, tagIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("conversation_id = ? AND tag_id IN (?)", conversationID, tx.Model(&models.Tag{}).Select("id").Where("user_id = ?", userID)).
+			Delete(&models.ConversationTag{}).Error
+		if err != nil {
+			return err
+		}
+		if err := addConversationTags(tx, []uuid.UUID{conversationID}, tagIDs); err != nil {
+			return err
+		}
+		// 标签变化也要让增量同步获取到
+		return tx.Model(&models.ChatSession{}).Where("id = ?", conversationID).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		logrus.WithError(err).Error("设置对话标签失败")
+		return nil, errors.New("设置对话标签失败")
+	}
+	return tags, nil
+}
+
+// checkName 校验标签名称，excludeID 为修改时的标签自身
+func (s *TagService) checkName(userID, excludeID uuid.UUID, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrEmptyTagName
+	}
+	if runes := []rune(name); len(runes) > 50 {
+		name = string(runes[:50])
+	}
+
+	var count int64
+	err := s.db.Model(&models.Tag{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).
+		Count(&count).Error
+	if err != nil {
+		logrus.WithError(err).Error("查询标签失败")
+		return "", errors.New("查询标签失败")
+	}
+	if count > 0 {
+		return "", ErrTagExists
+	}
+	return name, nil
+}
+
+// findTags 查询用户的标签，任一标签不存在或属于他人时返回 ErrTagNotFound
+func findTags(db *gorm.DB, userID uuid.UUID, tagIDs []uuid.UUID) ([]models.Tag, error) {
+	tags := []models.Tag{}
+	if len(tagIDs) == 0 {
+		return tags, nil
+	}
+
+	unique := make(map[uuid.UUID]bool, len(tagIDs))
+	for _, id := range tagIDs {
+		unique[id] = true
+	}
+	if err := db.Where("id IN ? AND user_id = ?", tagIDs, userID).Order("name ASC").Find(&tags).Error; err != nil {
+		logrus.WithError(err).Error("查询标签失败")
+		return nil, errors.New("查询标签失败")
+	}
+	if len(tags) != len(unique) {
+		return nil, ErrTagNotFound
+	}
+	return tags, nil
+}
+
+// addConversationTags 为对话添加标签，已有的关联保持不变
+func addConversationTags(tx *gorm.DB, conversationIDs, tagIDs []uuid.UUID) error {
+	links := make([]models.ConversationTag, 0, len(conversationIDs)*len(tagIDs))
+	for _, conversationID := range conversationIDs {
+		for _, tagID := range tagIDs {
+			links = append(links, models.ConversationTag{ConversationID: conversationID, TagID: tagID})
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(links, 200).Error
+}
+
+// loadConversationTags 为对话列表填充当前用户的标签，共享对话上其他参与者的标签不返回
+func loadConversationTags(db *gorm.DB, userID uuid.UUID, sessions []models.ChatSession) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+
+	var rows []struct {
+		ConversationID uuid.UUID
+		models.Tag
+	}
+	err := db.Table("conversation_tags").
+		Select("conversation_tags.conversation_id, tags.*").
+		Joins("JOIN tags ON tags.id = conversation_tags.tag_id").
+		Where("conversation_tags.conversation_id IN ? AND tags.user_id = ?", ids, userID).
+		Order("tags.name ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	byConversation := make(map[uuid.UUID][]models.Tag)
+	for _, row := range rows {
+		byConversation[row.ConversationID] = append(byConversation[row.ConversationID], row.Tag)
+	}
+	for i := range sessions {
+		sessions[i].Tags = byConversation[sessions[i].ID]
+	}
+	return nil
+}
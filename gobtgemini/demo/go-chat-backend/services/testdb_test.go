@@ -0,0 +1,156 @@
+package services
+
+import (
+	"fmt"
+	"go-chat-backend/config"
+	"go-chat-backend/models"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// testDialector 让模型能在 SQLite 上迁移：去掉 Postgres 专用的 gen_random_uuid() 默认值
+type testDialector struct {
+	*sqlite.Dialector
+}
+
+func (d testDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return testMigrator{d.Dialector.Migrator(db).(sqlite.Migrator)}
+}
+
+type testMigrator struct {
+	sqlite.Migrator
+}
+
+func (m testMigrator) FullDataTypeOf(field *schema.Field) clause.Expr {
+	expr := m.Migrator.FullDataTypeOf(field)
+	expr.SQL = strings.Replace(expr.SQL, " DEFAULT gen_random_uuid()", "", 1)
+	return expr
+}
+
+// testModels 测试数据库中迁移的模型
+var testModels = []interface{}{
+	&models.User{},
+	&models.ChatSession{},
+	&models.ChatMessage{},
+	&models.UserPreference{},
+	&models.KnowledgeBase{},
+	&models.ConversationKnowledgeBase{},
+	&models.MemoryJob{},
+	&models.SystemSetting{},
+	&models.ReindexJob{},
+	&models.ImportJob{},
+	&models.ConversationShare{},
+	&models.AuditLog{},
+	&models.Folder{},
+	&models.Tag{},
+	&models.ConversationTag{},
+	&models.MessageFeedback{},
+	&models.ConversationParticipant{},
+	&models.RetentionPolicy{},
+	&models.ScheduledPrompt{},
+	&models.ScheduledPromptRun{},
+	&models.KnowledgeDocument{},
+	&models.KnowledgeChunk{},
+	&models.Persona{},
+}
+
+// newTestDB 创建内存 SQLite 数据库，并在插入时为空的 UUID 主键生成值
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	if config.Get() == nil {
+		config.LoadConfig()
+	}
+
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(testDialector{sqlite.Open(dsn).(*sqlite.Dialector)}, &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	uuidType := reflect.TypeOf(uuid.UUID{})
+	err = db.Callback().Create().Before("gorm:create").Register("test:uuid", func(tx *gorm.DB) {
+		if tx.Statement.Schema == nil {
+			return
+		}
+		field := tx.Statement.Schema.PrioritizedPrimaryField
+		if field == nil || field.FieldType != uuidType {
+			return
+		}
+		setID := func(rv reflect.Value) {
+			if _, zero := field.ValueOf(tx.Statement.Context, rv); zero {
+				field.Set(tx.Statement.Context, rv, uuid.New())
+			}
+		}
+		rv := tx.Statement.ReflectValue
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				setID(reflect.Indirect(rv.Index(i)))
+			}
+		case reflect.Struct:
+			setID(rv)
+		}
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.AutoMigrate(testModels...))
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return db
+}
+
+// newPostgresTestDB 连接 PGVECTOR_TEST_DSN 指定的 Postgres，未设置时跳过测试。
+// 表建在临时 schema 中（可用 AutoMigrate(testModels...) 创建），测试结束后删除，不影响库中已有的数据
+func newPostgresTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("PGVECTOR_TEST_DSN")
+	if dsn == "" {
+		t.Skip("未设置 PGVECTOR_TEST_DSN")
+	}
+	if config.Get() == nil {
+		config.LoadConfig()
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1) // search_path 是连接级别的，只用一个连接
+	t.Cleanup(func() { sqlDB.Close() })
+
+	schema := "chat_test_" + uuid.NewString()[:8]
+	require.NoError(t, db.Exec("CREATE SCHEMA "+schema).Error)
+	t.Cleanup(func() { db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+	require.NoError(t, db.Exec("SET search_path TO "+schema+", public").Error)
+	return db
+}
+
+// createTestUser 创建测试用户
+func createTestUser(t *testing.T, db *gorm.DB, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{
+		Username: username,
+		Email:    username + "@example.com",
+		Password: "x",
+		IsActive: true,
+	}
+	require.NoError(t, db.Create(user).Error)
+	return user
+}
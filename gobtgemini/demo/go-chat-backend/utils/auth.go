@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"go-chat-backend/config"
 	"time"
 
@@ -75,6 +77,15 @@ func GenerateRefreshToken() string {
 	return uuid.New().String()
 }
 
+// GenerateShareToken 生成分享链接令牌（32字节随机数，URL安全的Base64）
+func GenerateShareToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
 // ValidateEmail 验证邮箱格式
 func ValidateEmail(email string) bool {
 	// 简单的邮箱验证
@@ -128,3 +128,19 @@ func IsValidUUID(id string) bool {
 	// 简单的UUID格式检查
 	return len(id) == 36 && strings.Count(id, "-") == 4
 }
+
+// IsSQLIdentifier 检查是否为可直接拼接到SQL中的简单标识符（小写字母、数字、下划线）
+func IsSQLIdentifier(name string) bool {
+	if name == "" || len(name) > 63 {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r == '_':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
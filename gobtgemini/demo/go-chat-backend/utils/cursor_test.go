@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCursorRoundTrip 测试游标编码解码
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 1, 15, 10, 30, 0, 123456789, time.FixedZone("CST", 8*3600))
+	id := uuid.New()
+
+	gotTime, gotID, err := DecodeCursor(EncodeCursor(createdAt, id))
+	require.NoError(t, err)
+	assert.True(t, createdAt.Equal(gotTime))
+	assert.Equal(t, id, gotID)
+
+	for _, cursor := range []string{"", "%%%", "bm90LWEtY3Vyc29y"} {
+		_, _, err := DecodeCursor(cursor)
+		assert.ErrorIs(t, err, ErrInvalidCursor, cursor)
+	}
+}
@@ -0,0 +1,115 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 节点间消息的类型
+const (
+	envelopeUser      = "user"      // 发送给特定用户
+	envelopeBroadcast = "broadcast" // 广播给所有连接
+	envelopePresence  = "presence"  // 节点的在线情况
+	envelopeLeave     = "leave"     // 节点停止
+)
+
+// Envelope 节点间传递的消息
+type Envelope struct {
+	Kind     string          `json:"kind"`
+	Node     string          `json:"node"`
+	UserID   uuid.UUID       `json:"user_id,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Presence *NodePresence   `json:"presence,omitempty"`
+}
+
+// NodePresence 节点定期发布的在线情况
+type NodePresence struct {
+	Users       []uuid.UUID `json:"users"`
+	Connections int         `json:"connections"`
+}
+
+// Broker 在多个后端实例之间分发 Hub 的消息。发布的消息会投递给所有订阅者，
+// 包括发布者所在的节点，Hub 按 Envelope.Node 忽略自己发布的消息
+type Broker interface {
+	// Publish 发布消息
+	Publish(ctx context.Context, envelope Envelope) error
+	// Subscribe 在后台接收消息并依次调用 handler，直到 ctx 取消
+	Subscribe(ctx context.Context, handler func(Envelope)) error
+}
+
+// MemoryBroker 进程内的 Broker，用于单实例部署和测试；多个 Hub 共用一个 MemoryBroker 时相当于多个节点
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]func(Envelope)
+}
+
+// NewMemoryBroker 创建进程内的 Broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscribers: make(map[int]func(Envelope)),
+	}
+}
+
+// Publish 同步投递给所有订阅者
+func (b *MemoryBroker) Publish(ctx context.Context, envelope Envelope) error {
+	b.mu.RLock()
+	handlers := make([]func(Envelope), 0, len(b.subscribers))
+	for _, handler := range b.subscribers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		handler(envelope)
+	}
+	return nil
+}
+
+// Subscribe 登记订阅者，ctx 取消后移除
+func (b *MemoryBroker) Subscribe(ctx context.Context, handler func(Envelope)) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handler
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+	}()
+	return nil
+}
+
+// remoteNode 其他节点最近一次发布的在线情况
+type remoteNode struct {
+	users       map[uuid.UUID]bool
+	connections int
+	seenAt      time.Time
+}
+
+// NodeStats 一个节点的连接数
+type NodeStats struct {
+	Node        string    `json:"node"`
+	Users       int       `json:"users"`
+	Connections int       `json:"connections"`
+	Local       bool      `json:"local"`
+	SeenAt      time.Time `json:"seen_at"`
+}
+
+// PresenceStats 所有节点的在线情况，同一用户连接到多个节点时只计一次
+type PresenceStats struct {
+	Node             string      `json:"node"`
+	ConnectedUsers   int         `json:"connected_users"`
+	TotalConnections int         `json:"total_connections"`
+	Nodes            []NodeStats `json:"nodes"`
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNode 启动使用 broker 的 Hub，模拟一个后端实例
+func startNode(t *testing.T, broker Broker, name string) *Hub {
+	t.Helper()
+	hub := NewHub()
+	hub.node = name
+	hub.SetBroker(broker, 20*time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		hub.Run()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		hub.Stop()
+		<-stopped
+	})
+	return hub
+}
+
+// TestBrokerFanOut 测试发送和广播到达其他节点上的连接，且每个连接只收到一次
+func TestBrokerFanOut(t *testing.T) {
+	broker := NewMemoryBroker()
+	nodeA := startNode(t, broker, "node-a")
+	nodeB := startNode(t, broker, "node-b")
+	alice, bob := uuid.New(), uuid.New()
+	aliceOnA := connect(t, nodeA, alice)
+	aliceOnB := connect(t, nodeB, alice)
+	bobOnB := connect(t, nodeB, bob)
+
+	// 节点 A 上生成的回复送达连接在节点 B 上的用户
+	require.NoError(t, nodeA.SendToUser(bob, Message{Type: "chat_response", Content: "来自 A"}))
+	assert.Equal(t, "来自 A", receive(t, bobOnB).Content)
+
+	require.NoError(t, nodeB.SendToUser(alice, Message{Type: "chat_response", Content: "来自 B"}))
+	assert.Equal(t, "来自 B", receive(t, aliceOnA).Content)
+	assert.Equal(t, "来自 B", receive(t, aliceOnB).Content)
+
+	require.NoError(t, nodeA.BroadcastMessage(Message{Type: "system", Content: "公告"}))
+	for _, client := range []*Client{aliceOnA, aliceOnB, bobOnB} {
+		assert.Equal(t, "公告", receive(t, client).Content)
+	}
+
+	// 自己发布的消息不会重复投递
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, aliceOnA.send)
+	assert.Empty(t, aliceOnB.send)
+	assert.Empty(t, bobOnB.send)
+}
+
+// TestBrokerPresence 测试在线人数按所有节点统计，节点停止或不再发布后移除
+func TestBrokerPresence(t *testing.T) {
+	broker := NewMemoryBroker()
+	nodeA := startNode(t, broker, "node-a")
+	nodeB := NewHub()
+	nodeB.node = "node-b"
+	nodeB.SetBroker(broker, 20*time.Millisecond)
+	go nodeB.Run()
+
+	alice, bob := uuid.New(), uuid.New()
+	connect(t, nodeA, alice)
+	connect(t, nodeB, alice)
+	connect(t, nodeB, bob)
+
+	// 同一用户连接到两个节点只计一次
+	require.Eventually(t, func() bool {
+		return nodeA.GetTotalConnections() == 3 && nodeB.GetTotalConnections() == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, nodeA.GetConnectedUsers())
+	assert.Equal(t, 2, nodeB.GetConnectedUsers())
+
+	presence := nodeA.Presence()
+	assert.Equal(t, "node-a", presence.Node)
+	require.Len(t, presence.Nodes, 2)
+	assert.True(t, presence.Nodes[0].Local)
+	assert.Equal(t, "node-b", presence.Nodes[1].Node)
+	assert.Equal(t, 2, presence.Nodes[1].Connections)
+	assert.Equal(t, 2, presence.Nodes[1].Users)
+
+	// 节点停止时立即通知其他节点
+	nodeB.Stop()
+	require.Eventually(t, func() bool {
+		return nodeA.GetTotalConnections() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, nodeA.GetConnectedUsers())
+
+	// 异常退出、没有发出停止消息的节点在三个间隔后过期
+	ghost := Envelope{Kind: envelopePresence, Node: "ghost", Presence: &NodePresence{Users: []uuid.UUID{bob}, Connections: 4}}
+	require.NoError(t, broker.Publish(context.Background(), ghost))
+	assert.Equal(t, 5, nodeA.GetTotalConnections())
+	require.Eventually(t, func() bool {
+		return nodeA.GetTotalConnections() == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestNotifyFragments 测试超过 NOTIFY 上限的消息按字符边界分片，并能在乱序、交错时重组
+func TestNotifyFragments(t *testing.T) {
+	envelope := Envelope{
+		Kind:   envelopeUser,
+		Node:   "node-a",
+		UserID: uuid.New(),
+		Data:   json.RawMessage(`{"content":"` + strings.Repeat("长回复", 3000) + `"}`),
+	}
+	payload, err := json.Marshal(envelope)
+	require.NoError(t, err)
+
+	large := splitNotifyPayload(uuid.New(), payload, maxNotifyChunk)
+	require.Greater(t, len(large), 2)
+	for _, fragment := range large {
+		assert.LessOrEqual(t, len(fragment), 8000)
+		assert.True(t, utf8.ValidString(fragment))
+	}
+	small := splitNotifyPayload(uuid.New(), []byte(`{"kind":"broadcast"}`), maxNotifyChunk)
+	require.Len(t, small, 1)
+
+	assembler := newFragmentAssembler(time.Minute)
+	now := time.Now()
+
+	// 倒序到达，中间插入另一条完整消息
+	for i := len(large) - 1; i > 0; i-- {
+		_, ok, err := assembler.add(large[i], now)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+	got, ok, err := assembler.add(small[0], now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.JSONEq(t, `{"kind":"broadcast"}`, string(got))
+
+	got, ok, err = assembler.add(large[0], now)
+	require.NoError(t, err)
+	require.True(t, ok)
+	var decoded Envelope
+	require.NoError(t, json.Unmarshal(got, &decoded))
+	assert.Equal(t, envelope.UserID, decoded.UserID)
+	assert.JSONEq(t, string(envelope.Data), string(decoded.Data))
+
+	// 超时未到齐的分片被丢弃
+	_, ok, err = assembler.add(large[0], now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assembler.add(small[0], now.Add(2*time.Minute))
+	assert.Empty(t, assembler.pending)
+
+	_, _, err = assembler.add("not-a-fragment", now)
+	assert.Error(t, err)
+}
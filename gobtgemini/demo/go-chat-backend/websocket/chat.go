@@ -0,0 +1,238 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// 聊天请求回传给发起连接的帧类型
+const (
+	FrameChatAck   = "chat_ack"   // 用户消息已保存
+	FrameChatDelta = "chat_delta" // 回复的一段增量文本
+	FrameChatDone  = "chat_done"  // 回复完成，带有完整内容和消息
+	FrameChatError = "chat_error" // 请求失败，之后不会再有该请求的帧
+)
+
+const (
+	// maxChatContent 消息内容的最大字符数，与 HTTP 发送消息接口一致
+	maxChatContent = 4000
+	// maxRequestIDLength request_id 的最大长度
+	maxRequestIDLength = 64
+	// maxInflightChats 每个连接同时处理的聊天请求数
+	maxInflightChats = 3
+)
+
+// ChatRequest 客户端通过WebSocket发送的聊天请求
+type ChatRequest struct {
+	RequestID      string
+	ConversationID uuid.UUID
+	Content        string
+	UserID         uuid.UUID
+	Username       string
+}
+
+// ChatStream 向发起请求的连接回传帧，帧中带有请求的 request_id；
+// Done 和 Error 只有第一次调用生效
+type ChatStream interface {
+	// Ack 确认用户消息已保存
+	Ack(data map[string]interface{})
+	// Delta 推送一段回复文本
+	Delta(content string)
+	// Done 回复完成
+	Done(content string, data map[string]interface{})
+	// Error 请求失败
+	Error(code, message string)
+}
+
+// ChatProcessor 处理WebSocket聊天请求，由 handlers.ChatHandler 实现；连接断开时 ctx 被取消
+type ChatProcessor interface {
+	ProcessChat(ctx context.Context, request ChatRequest, stream ChatStream)
+}
+
+// chatMessage 客户端发送的聊天帧
+type chatMessage struct {
+	Type           string `json:"type"`
+	Content        string `json:"content"`
+	RequestID      string `json:"request_id"`
+	ConversationID string `json:"conversation_id"`
+}
+
+// handleChat 校验聊天帧并在单独的协程中处理，不阻塞读取
+func (c *Client) handleChat(msg chatMessage) {
+	requestID := strings.TrimSpace(msg.RequestID)
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		c.sendChatError(requestID, "INVALID_REQUEST", "需要 request_id，且不超过64个字符")
+		return
+	}
+	conversationID, err := uuid.Parse(msg.ConversationID)
+	if err != nil {
+		c.sendChatError(requestID, "INVALID_REQUEST", "无效的对话ID")
+		return
+	}
+	content := strings.TrimSpace(msg.Content)
+	if content == "" {
+		c.sendChatError(requestID, "EMPTY_MESSAGE", "消息内容不能为空")
+		return
+	}
+	if utf8.RuneCountInString(content) > maxChatContent {
+		c.sendChatError(requestID, "INVALID_REQUEST", "消息内容不能超过4000个字符")
+		return
+	}
+
+	processor := c.hub.chatProcessor
+	if processor == nil {
+		c.sendChatError(requestID, "CHAT_UNAVAILABLE", "WebSocket聊天暂不可用，请通过HTTP API发送")
+		return
+	}
+	if code, message := c.beginChat(requestID); code != "" {
+		c.sendChatError(requestID, code, message)
+		return
+	}
+
+	request := ChatRequest{
+		RequestID:      requestID,
+		ConversationID: conversationID,
+		Content:        content,
+		UserID:         c.userID,
+		Username:       c.username,
+	}
+	stream := &chatStream{client: c, requestID: requestID, conversationID: conversationID}
+
+	go func() {
+		defer c.endChat(requestID)
+		defer func() {
+			if r := recover(); r != nil {
+				logrus.WithField("panic", r).Error("处理WebSocket聊天请求时发生panic")
+			}
+			// 处理器没有回传结束帧时补发错误，保证每个请求都有结束帧
+			if c.ctx.Err() == nil {
+				stream.Error("INTERNAL_ERROR", "服务器内部错误")
+			}
+		}()
+		processor.ProcessChat(c.ctx, request, stream)
+	}()
+}
+
+// beginChat 登记进行中的请求；同一 request_id 正在处理或超过并发数时返回错误码
+func (c *Client) beginChat(requestID string) (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.inflight[requestID] {
+		return "DUPLICATE_REQUEST", "相同 request_id 的请求正在处理"
+	}
+	if len(c.inflight) >= maxInflightChats {
+		return "TOO_MANY_REQUESTS", "同时处理的请求过多，请等待之前的回复完成"
+	}
+	c.inflight[requestID] = true
+	return "", ""
+}
+
+// endChat 请求处理结束
+func (c *Client) endChat(requestID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, requestID)
+}
+
+// sendChatError 请求未被受理时直接回传错误帧
+func (c *Client) sendChatError(requestID, code, message string) {
+	c.sendFrame(Message{
+		Type:      FrameChatError,
+		Content:   message,
+		RequestID: requestID,
+		Data:      map[string]interface{}{"code": code},
+		Timestamp: time.Now(),
+	})
+}
+
+// sendFrame 序列化后发送给当前连接。发送缓冲区已满时与 Hub.deliver 一样断开该连接，
+// 不静默丢弃帧，否则流式回复缺少片段而客户端无从得知；断开后进行中的请求随 ctx 取消
+func (c *Client) sendFrame(message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		logrus.WithError(err).Warn("WebSocket消息序列化失败")
+		return
+	}
+	if c.trySend(data) {
+		return
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"client_id":  c.id,
+		"type":       message.Type,
+		"request_id": message.RequestID,
+	}).Warn("WebSocket客户端发送缓冲区已满，断开连接")
+	c.hub.unregisterClient(c)
+}
+
+// chatStream 绑定到发起请求的连接的 ChatStream 实现
+type chatStream struct {
+	client         *Client
+	requestID      string
+	conversationID uuid.UUID
+
+	mu       sync.Mutex
+	seq      int
+	finished bool
+}
+
+func (s *chatStream) Ack(data map[string]interface{}) {
+	s.send(FrameChatAck, "", data, false)
+}
+
+func (s *chatStream) Delta(content string) {
+	s.mu.Lock()
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+	s.send(FrameChatDelta, content, map[string]interface{}{"seq": seq}, false)
+}
+
+func (s *chatStream) Done(content string, data map[string]interface{}) {
+	s.send(FrameChatDone, content, data, true)
+}
+
+func (s *chatStream) Error(code, message string) {
+	s.send(FrameChatError, message, map[string]interface{}{"code": code}, true)
+}
+
+// send 在帧的 data 中补充 conversation_id；请求结束后不再发送
+func (s *chatStream) send(frameType, content string, data map[string]interface{}, final bool) {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+	if final {
+		s.finished = true
+	}
+	s.mu.Unlock()
+
+	fields := make(map[string]interface{}, len(data)+1)
+	for key, value := range data {
+		fields[key] = value
+	}
+	fields["conversation_id"] = s.conversationID
+
+	s.client.sendFrame(Message{
+		Type:      frameType,
+		Content:   content,
+		RequestID: s.requestID,
+		Data:      fields,
+		Timestamp: time.Now(),
+	})
+}
@@ -1,8 +1,14 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,100 +17,236 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// Hub WebSocket连接管理中心
+// ErrHubStopped Hub 已停止，不再接收消息
+var ErrHubStopped = errors.New("WebSocket Hub 已停止")
+
+const (
+	// defaultPresenceInterval 默认的在线情况发布间隔
+	defaultPresenceInterval = 10 * time.Second
+	// publishTimeout 向其他节点发布一条消息的超时
+	publishTimeout = 5 * time.Second
+)
+
+// Hub WebSocket连接管理中心。连接表只在 Run 的协程中读写，
+// 注册、注销、发送和查询都通过通道提交，由 Run 依次处理。
+// 设置 Broker 后，发送和广播同时发布给其他节点，在线人数按所有节点统计
 type Hub struct {
 	// 已注册的客户端连接
 	clients map[*Client]bool
 
 	// 用户ID到客户端的映射
-	userClients map[uuid.UUID][]*Client
+	userClients map[uuid.UUID]map[*Client]bool
 
-	// 从客户端接收的消息
+	// 广播给所有客户端的消息
 	broadcast chan []byte
 
+	// 发送给特定用户的消息
+	direct chan userMessage
+
 	// 注册请求
 	register chan *Client
 
 	// 注销请求
 	unregister chan *Client
+
+	// 在线情况查询
+	stats chan chan PresenceStats
+
+	// 关闭后 Run 断开所有连接并退出
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// 处理客户端发送的聊天消息，为空时拒绝WebSocket聊天
+	chatProcessor ChatProcessor
+
+	// 跨节点分发，为空时只投递本节点的连接
+	broker Broker
+
+	// 本节点名称
+	node string
+
+	// 其他节点发来的在线情况
+	remote chan Envelope
+
+	// 其他节点最近的在线情况
+	nodes map[string]*remoteNode
+
+	// 待发布的本节点在线情况，只保留最新的一份
+	presenceOut chan NodePresence
+
+	// 发布在线情况的间隔，超过三个间隔没有更新的节点视为离线
+	presenceInterval time.Duration
+}
+
+// userMessage 发送给特定用户所有连接的消息
+type userMessage struct {
+	userID uuid.UUID
+	data   []byte
 }
 
 // NewHub 创建新的Hub
 func NewHub() *Hub {
+	hostname, _ := os.Hostname()
 	return &Hub{
 		clients:     make(map[*Client]bool),
-		userClients: make(map[uuid.UUID][]*Client),
+		userClients: make(map[uuid.UUID]map[*Client]bool),
 		broadcast:   make(chan []byte),
+		direct:      make(chan userMessage),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
+		stats:       make(chan chan PresenceStats),
+		done:        make(chan struct{}),
+		remote:      make(chan Envelope),
+		nodes:       make(map[string]*remoteNode),
+		presenceOut: make(chan NodePresence, 1),
+		node:        fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+
+		presenceInterval: defaultPresenceInterval,
 	}
 }
 
-// Run 运行Hub
+// SetBroker 设置跨节点分发，需在 Run 之前调用；interval 为发布在线情况的间隔，不大于0时使用默认值
+func (h *Hub) SetBroker(broker Broker, interval time.Duration) {
+	h.broker = broker
+	if interval > 0 {
+		h.presenceInterval = interval
+	}
+}
+
+// Node 本节点名称
+func (h *Hub) Node() string {
+	return h.node
+}
+
+// SetChatProcessor 设置WebSocket聊天的处理器，需在 Run 之前调用
+func (h *Hub) SetChatProcessor(processor ChatProcessor) {
+	h.chatProcessor = processor
+}
+
+// Run 运行Hub，直到 Stop 被调用
 func (h *Hub) Run() {
+	var presenceTicks <-chan time.Time
+	if h.broker != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := h.broker.Subscribe(ctx, h.receive); err != nil {
+			logrus.WithError(err).Error("订阅跨节点消息失败，只投递本节点的连接")
+		}
+		go h.publishPresence(ctx)
+
+		ticker := time.NewTicker(h.presenceInterval)
+		defer ticker.Stop()
+		presenceTicks = ticker.C
+		h.queuePresence()
+	}
+
 	for {
 		select {
 		case client := <-h.register:
-			// 注册客户端
-			h.clients[client] = true
-			h.userClients[client.userID] = append(h.userClients[client.userID], client)
-			logrus.WithFields(logrus.Fields{
-				"user_id":   client.userID,
-				"client_id": client.id,
-				"total":     len(h.clients),
-			}).Info("WebSocket客户端已连接")
-
-			// 发送欢迎消息
-			welcomeMsg := Message{
-				Type:      "system",
-				Content:   "欢迎使用智能聊天助手！",
-				Timestamp: time.Now(),
-			}
-			if data, err := json.Marshal(welcomeMsg); err == nil {
-				client.send <- data
-			}
+			h.addClient(client)
 
 		case client := <-h.unregister:
-			// 注销客户端
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-
-				// 从用户客户端列表中删除
-				userClients := h.userClients[client.userID]
-				for i, c := range userClients {
-					if c == client {
-						h.userClients[client.userID] = append(userClients[:i], userClients[i+1:]...)
-						break
-					}
-				}
-
-				// 如果用户没有其他连接，删除用户条目
-				if len(h.userClients[client.userID]) == 0 {
-					delete(h.userClients, client.userID)
-				}
-
-				logrus.WithFields(logrus.Fields{
-					"user_id":   client.userID,
-					"client_id": client.id,
-					"total":     len(h.clients),
-				}).Info("WebSocket客户端已断开")
+			h.removeClient(client, "WebSocket客户端已断开")
+
+		case message := <-h.direct:
+			// 发送给用户在本节点的所有连接
+			for client := range h.userClients[message.userID] {
+				h.deliver(client, message.data)
 			}
 
 		case message := <-h.broadcast:
 			// 广播消息给所有客户端
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+				h.deliver(client, message)
+			}
+
+		case reply := <-h.stats:
+			reply <- h.presence(time.Now())
+
+		case envelope := <-h.remote:
+			h.updateNode(envelope)
+
+		case <-presenceTicks:
+			h.queuePresence()
+
+		case <-h.done:
+			for client := range h.clients {
+				h.removeClient(client, "WebSocket Hub 已停止，断开客户端")
+			}
+			// 通知其他节点立即移除本节点的在线情况
+			if err := h.publish(Envelope{Kind: envelopeLeave}); err != nil {
+				logrus.WithError(err).Warn("发布节点停止消息失败")
 			}
+			return
 		}
 	}
 }
 
+// Stop 停止 Hub，断开所有连接；之后的发送返回 ErrHubStopped
+func (h *Hub) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// addClient 注册客户端并发送欢迎消息
+func (h *Hub) addClient(client *Client) {
+	h.clients[client] = true
+	if h.userClients[client.userID] == nil {
+		h.userClients[client.userID] = make(map[*Client]bool)
+	}
+	h.userClients[client.userID][client] = true
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":   client.userID,
+		"client_id": client.id,
+		"total":     len(h.clients),
+	}).Info("WebSocket客户端已连接")
+
+	// 发送欢迎消息
+	welcomeMsg := Message{
+		Type:      "system",
+		Content:   "欢迎使用智能聊天助手！",
+		Timestamp: time.Now(),
+	}
+	if data, err := json.Marshal(welcomeMsg); err == nil {
+		h.deliver(client, data)
+	}
+}
+
+// removeClient 注销客户端并关闭其发送通道，写协程随后关闭连接；
+// 客户端断开和慢速客户端被踢出都经过这里，重复调用无副作用
+func (h *Hub) removeClient(client *Client, reason string) {
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
+	delete(h.clients, client)
+
+	// 从用户客户端列表中删除，用户没有其他连接时删除用户条目
+	if userClients := h.userClients[client.userID]; userClients != nil {
+		delete(userClients, client)
+		if len(userClients) == 0 {
+			delete(h.userClients, client.userID)
+		}
+	}
+
+	client.cancel()
+	client.closeSend()
+
+	logrus.WithFields(logrus.Fields{
+		"user_id":   client.userID,
+		"client_id": client.id,
+		"total":     len(h.clients),
+	}).Info(reason)
+}
+
+// deliver 非阻塞地发送给客户端，发送缓冲区已满时踢出该客户端
+func (h *Hub) deliver(client *Client, data []byte) {
+	if !client.trySend(data) {
+		h.removeClient(client, "WebSocket客户端发送缓冲区已满，已断开")
+	}
+}
+
 // SendToUser 发送消息给特定用户
 func (h *Hub) SendToUser(userID uuid.UUID, message interface{}) error {
 	data, err := json.Marshal(message)
@@ -112,24 +254,12 @@ func (h *Hub) SendToUser(userID uuid.UUID, message interface{}) error {
 		return err
 	}
 
-	userClients, exists := h.userClients[userID]
-	if !exists || len(userClients) == 0 {
-		logrus.WithField("user_id", userID).Warn("用户无WebSocket连接")
-		return nil
+	select {
+	case h.direct <- userMessage{userID: userID, data: data}:
+	case <-h.done:
+		return ErrHubStopped
 	}
-
-	// 发送给用户的所有连接
-	for _, client := range userClients {
-		select {
-		case client.send <- data:
-		default:
-			// 如果发送失败，关闭连接
-			close(client.send)
-			delete(h.clients, client)
-		}
-	}
-
-	return nil
+	return h.publish(Envelope{Kind: envelopeUser, UserID: userID, Data: data})
 }
 
 // BroadcastMessage 广播消息
@@ -139,18 +269,180 @@ func (h *Hub) BroadcastMessage(message interface{}) error {
 		return err
 	}
 
-	h.broadcast <- data
-	return nil
+	select {
+	case h.broadcast <- data:
+	case <-h.done:
+		return ErrHubStopped
+	}
+	return h.publish(Envelope{Kind: envelopeBroadcast, Data: data})
 }
 
-// GetConnectedUsers 获取在线用户数量
+// GetConnectedUsers 获取所有节点的在线用户数量
 func (h *Hub) GetConnectedUsers() int {
-	return len(h.userClients)
+	return h.Presence().ConnectedUsers
 }
 
-// GetTotalConnections 获取总连接数
+// GetTotalConnections 获取所有节点的总连接数
 func (h *Hub) GetTotalConnections() int {
-	return len(h.clients)
+	return h.Presence().TotalConnections
+}
+
+// Presence 由 Run 返回各节点的在线情况，Hub 已停止时为空
+func (h *Hub) Presence() PresenceStats {
+	reply := make(chan PresenceStats, 1)
+	select {
+	case h.stats <- reply:
+		return <-reply
+	case <-h.done:
+		return PresenceStats{Node: h.node}
+	}
+}
+
+// presence 汇总本节点和未过期的其他节点，同一用户只计一次
+func (h *Hub) presence(now time.Time) PresenceStats {
+	stats := PresenceStats{
+		Node:             h.node,
+		TotalConnections: len(h.clients),
+		Nodes: []NodeStats{{
+			Node:        h.node,
+			Users:       len(h.userClients),
+			Connections: len(h.clients),
+			Local:       true,
+			SeenAt:      now,
+		}},
+	}
+	users := make(map[uuid.UUID]bool, len(h.userClients))
+	for userID := range h.userClients {
+		users[userID] = true
+	}
+
+	var remote []NodeStats
+	for name, node := range h.nodes {
+		if now.Sub(node.seenAt) > 3*h.presenceInterval {
+			delete(h.nodes, name)
+			continue
+		}
+		for userID := range node.users {
+			users[userID] = true
+		}
+		stats.TotalConnections += node.connections
+		remote = append(remote, NodeStats{
+			Node:        name,
+			Users:       len(node.users),
+			Connections: node.connections,
+			SeenAt:      node.seenAt,
+		})
+	}
+	sort.Slice(remote, func(i, j int) bool { return remote[i].Node < remote[j].Node })
+
+	stats.ConnectedUsers = len(users)
+	stats.Nodes = append(stats.Nodes, remote...)
+	return stats
+}
+
+// updateNode 记录其他节点的在线情况，以收到的时间判断是否过期
+func (h *Hub) updateNode(envelope Envelope) {
+	if envelope.Kind == envelopeLeave || envelope.Presence == nil {
+		delete(h.nodes, envelope.Node)
+		return
+	}
+	users := make(map[uuid.UUID]bool, len(envelope.Presence.Users))
+	for _, userID := range envelope.Presence.Users {
+		users[userID] = true
+	}
+	h.nodes[envelope.Node] = &remoteNode{
+		users:       users,
+		connections: envelope.Presence.Connections,
+		seenAt:      time.Now(),
+	}
+}
+
+// queuePresence 将本节点的在线情况交给发布协程，未发布的旧数据直接替换
+func (h *Hub) queuePresence() {
+	presence := NodePresence{
+		Users:       make([]uuid.UUID, 0, len(h.userClients)),
+		Connections: len(h.clients),
+	}
+	for userID := range h.userClients {
+		presence.Users = append(presence.Users, userID)
+	}
+
+	select {
+	case <-h.presenceOut:
+	default:
+	}
+	h.presenceOut <- presence
+}
+
+// publishPresence 在单独的协程中发布在线情况，避免 Run 等待网络
+func (h *Hub) publishPresence(ctx context.Context) {
+	for {
+		select {
+		case presence := <-h.presenceOut:
+			if err := h.publish(Envelope{Kind: envelopePresence, Presence: &presence}); err != nil {
+				logrus.WithError(err).Warn("发布节点在线情况失败")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish 发布给其他节点，未设置 Broker 时什么都不做
+func (h *Hub) publish(envelope Envelope) error {
+	if h.broker == nil {
+		return nil
+	}
+	envelope.Node = h.node
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return h.broker.Publish(ctx, envelope)
+}
+
+// receive 处理其他节点发布的消息，只投递给本节点的连接
+func (h *Hub) receive(envelope Envelope) {
+	if envelope.Node == h.node {
+		return
+	}
+
+	switch envelope.Kind {
+	case envelopeUser:
+		select {
+		case h.direct <- userMessage{userID: envelope.UserID, data: envelope.Data}:
+		case <-h.done:
+		}
+	case envelopeBroadcast:
+		select {
+		case h.broadcast <- []byte(envelope.Data):
+		case <-h.done:
+		}
+	case envelopePresence, envelopeLeave:
+		select {
+		case h.remote <- envelope:
+		case <-h.done:
+		}
+	default:
+		logrus.WithField("kind", envelope.Kind).Warn("未知的跨节点消息类型")
+	}
+}
+
+// registerClient 提交注册请求，Hub 已停止时返回 false
+func (h *Hub) registerClient(client *Client) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// unregisterClient 提交注销请求
+func (h *Hub) unregisterClient(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
 }
 
 // Client WebSocket客户端
@@ -161,6 +453,57 @@ type Client struct {
 	hub      *Hub
 	conn     *websocket.Conn
 	send     chan []byte
+
+	// ctx 在连接断开时取消，用于中止进行中的聊天请求
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	closed   bool            // send 已关闭
+	inflight map[string]bool // 进行中的聊天请求
+}
+
+// newClient 创建客户端；测试中 conn 可以为空
+func newClient(hub *Hub, conn *websocket.Conn, userID uuid.UUID, username string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Client{
+		id:       uuid.New(),
+		userID:   userID,
+		username: username,
+		hub:      hub,
+		conn:     conn,
+		send:     make(chan []byte, sendBufferSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		inflight: make(map[string]bool),
+	}
+}
+
+// trySend 非阻塞地发送给当前连接，连接已关闭或缓冲区已满时返回 false
+func (c *Client) trySend(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeSend 关闭发送通道，可重复调用
+func (c *Client) closeSend() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
 }
 
 // Message WebSocket消息结构
@@ -169,10 +512,18 @@ type Message struct {
 	Content   string      `json:"content"`
 	UserID    uuid.UUID   `json:"user_id,omitempty"`
 	Username  string      `json:"username,omitempty"`
+	RequestID string      `json:"request_id,omitempty"` // 聊天请求的帧中回传客户端的 request_id
 	Data      interface{} `json:"data,omitempty"`
 	Timestamp time.Time   `json:"timestamp"`
 }
 
+const (
+	// maxMessageSize 客户端单条消息的最大字节数，需容纳4000字的聊天内容
+	maxMessageSize = 32 * 1024
+	// sendBufferSize 每个连接的发送缓冲区，写满时客户端被视为过慢而断开
+	sendBufferSize = 256
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -211,17 +562,13 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 	}
 
 	// 创建客户端
-	client := &Client{
-		id:       uuid.New(),
-		userID:   userID.(uuid.UUID),
-		username: username.(string),
-		hub:      h.hub,
-		conn:     conn,
-		send:     make(chan []byte, 256),
-	}
+	client := newClient(h.hub, conn, userID.(uuid.UUID), username.(string))
 
 	// 注册客户端
-	client.hub.register <- client
+	if !h.hub.registerClient(client) {
+		conn.Close()
+		return
+	}
 
 	// 启动客户端的读写协程
 	go client.writePump()
@@ -231,12 +578,13 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 // readPump 读取消息
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.cancel()
+		c.hub.unregisterClient(c)
 		c.conn.Close()
 	}()
 
 	// 设置读取限制
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -244,7 +592,7 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		var msg Message
+		var msg chatMessage
 		err := c.conn.ReadJSON(&msg)
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -253,11 +601,6 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// 设置消息信息
-		msg.UserID = c.userID
-		msg.Username = c.username
-		msg.Timestamp = time.Now()
-
 		// 处理不同类型的消息
 		switch msg.Type {
 		case "ping":
@@ -267,16 +610,11 @@ func (c *Client) readPump() {
 				Content:   "pong",
 				Timestamp: time.Now(),
 			}
-			if data, err := json.Marshal(pongMsg); err == nil {
-				c.send <- data
-			}
+			c.sendFrame(pongMsg)
 
 		case "chat":
-			// 这里可以处理聊天消息，但建议通过HTTP API发送
-			logrus.WithFields(logrus.Fields{
-				"user_id": c.userID,
-				"content": msg.Content,
-			}).Info("收到聊天消息")
+			// 与HTTP发送消息接口相同的流程，回复以流式帧回传给当前连接
+			c.handleChat(msg)
 
 		default:
 			logrus.WithField("type", msg.Type).Warn("未知消息类型")
@@ -0,0 +1,294 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startHub 启动 Hub，测试结束时停止
+func startHub(t *testing.T) *Hub {
+	t.Helper()
+	hub := NewHub()
+	stopped := make(chan struct{})
+	go func() {
+		hub.Run()
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		hub.Stop()
+		<-stopped
+	})
+	return hub
+}
+
+// connect 注册一个没有网络连接的客户端，并读掉欢迎消息
+func connect(t *testing.T, hub *Hub, userID uuid.UUID) *Client {
+	t.Helper()
+	client := newClient(hub, nil, userID, "tester")
+	require.True(t, hub.registerClient(client))
+	assert.Equal(t, "system", receive(t, client).Type)
+	return client
+}
+
+// receive 读取客户端收到的下一条消息
+func receive(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case data, ok := <-client.send:
+		require.True(t, ok, "发送通道已关闭")
+		var message Message
+		require.NoError(t, json.Unmarshal(data, &message))
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("等待消息超时")
+		return Message{}
+	}
+}
+
+// TestHubDelivery 测试按用户发送、广播和连接数统计
+func TestHubDelivery(t *testing.T) {
+	hub := startHub(t)
+	alice, bob := uuid.New(), uuid.New()
+	aliceWeb := connect(t, hub, alice)
+	aliceMobile := connect(t, hub, alice)
+	bobWeb := connect(t, hub, bob)
+
+	assert.Equal(t, 2, hub.GetConnectedUsers())
+	assert.Equal(t, 3, hub.GetTotalConnections())
+
+	require.NoError(t, hub.SendToUser(alice, Message{Type: "chat_response", Content: "给 alice"}))
+	assert.Equal(t, "给 alice", receive(t, aliceWeb).Content)
+	assert.Equal(t, "给 alice", receive(t, aliceMobile).Content)
+
+	require.NoError(t, hub.BroadcastMessage(Message{Type: "system", Content: "公告"}))
+	for _, client := range []*Client{aliceWeb, aliceMobile, bobWeb} {
+		assert.Equal(t, "公告", receive(t, client).Content)
+	}
+	assert.Empty(t, bobWeb.send)
+
+	// 重复注销没有副作用
+	hub.unregisterClient(aliceWeb)
+	hub.unregisterClient(aliceWeb)
+	assert.Equal(t, 2, hub.GetConnectedUsers())
+	assert.Equal(t, 2, hub.GetTotalConnections())
+	_, ok := <-aliceWeb.send
+	assert.False(t, ok)
+	assert.Error(t, aliceWeb.ctx.Err())
+
+	hub.unregisterClient(aliceMobile)
+	assert.Equal(t, 1, hub.GetConnectedUsers())
+
+	hub.Stop()
+	assert.ErrorIs(t, hub.SendToUser(bob, Message{Type: "system"}), ErrHubStopped)
+	assert.ErrorIs(t, hub.BroadcastMessage(Message{Type: "system"}), ErrHubStopped)
+	assert.Zero(t, hub.GetTotalConnections())
+	assert.False(t, hub.registerClient(newClient(hub, nil, bob, "bob")))
+}
+
+// TestHubEvictsSlowClient 测试发送缓冲区写满的客户端被注销，之后的注销和发送都是安全的
+func TestHubEvictsSlowClient(t *testing.T) {
+	hub := startHub(t)
+	userID := uuid.New()
+	slow := newClient(hub, nil, userID, "slow")
+	slow.send = make(chan []byte, 1)
+	require.True(t, hub.registerClient(slow)) // 欢迎消息占满缓冲区
+	fast := connect(t, hub, userID)
+
+	require.NoError(t, hub.SendToUser(userID, Message{Type: "chat_response"}))
+	assert.Equal(t, "chat_response", receive(t, fast).Type)
+	assert.Equal(t, 1, hub.GetConnectedUsers())
+	assert.Equal(t, 1, hub.GetTotalConnections())
+
+	// 缓冲区中的消息读完后通道已关闭，写协程据此关闭连接
+	<-slow.send
+	_, ok := <-slow.send
+	assert.False(t, ok)
+	assert.Error(t, slow.ctx.Err())
+	assert.False(t, slow.trySend([]byte("{}")))
+	hub.unregisterClient(slow)
+
+	require.NoError(t, hub.BroadcastMessage(Message{Type: "system"}))
+	assert.Equal(t, "system", receive(t, fast).Type)
+	assert.Equal(t, 1, hub.GetTotalConnections())
+}
+
+// TestChatStreamEvictsSlowClient 测试流式回复遇到发送缓冲区已满时断开连接并取消请求，而不是丢弃片段
+func TestChatStreamEvictsSlowClient(t *testing.T) {
+	hub := startHub(t)
+	slow := newClient(hub, nil, uuid.New(), "slow")
+	slow.send = make(chan []byte, 1)
+	require.True(t, hub.registerClient(slow)) // 欢迎消息占满缓冲区
+
+	stream := &chatStream{client: slow, requestID: "r", conversationID: uuid.New()}
+	stream.Delta("片段")
+	// 在线统计同样由 Run 处理，返回时注销已完成
+	assert.Equal(t, 0, hub.GetTotalConnections())
+	assert.Error(t, slow.ctx.Err())
+
+	// 断开后的帧直接忽略
+	stream.Done("完成", nil)
+	<-slow.send
+	_, ok := <-slow.send
+	assert.False(t, ok)
+}
+
+// TestHubConcurrentAccess 并发连接、断开、发送和查询，配合 go test -race 检查数据竞争和重复关闭
+func TestHubConcurrentAccess(t *testing.T) {
+	hub := startHub(t)
+	users := make([]uuid.UUID, 5)
+	for i := range users {
+		users[i] = uuid.New()
+	}
+
+	const workers = 20
+	const rounds = 30
+	var clientsWG, sendersWG sync.WaitGroup
+	stopSenders := make(chan struct{})
+
+	// 持续发送和查询
+	for i := 0; i < 4; i++ {
+		sendersWG.Add(1)
+		go func(i int) {
+			defer sendersWG.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stopSenders:
+					return
+				default:
+				}
+				switch n % 4 {
+				case 0:
+					hub.SendToUser(users[(i+n)%len(users)], Message{Type: "chat_response", Content: "hi"})
+				case 1:
+					hub.BroadcastMessage(Message{Type: "system", Content: "all"})
+				case 2:
+					hub.GetConnectedUsers()
+				default:
+					hub.GetTotalConnections()
+				}
+			}
+		}(i)
+	}
+
+	// 不断连接和断开，部分客户端不读取消息以触发踢出，同时从连接本身回传帧
+	for w := 0; w < workers; w++ {
+		clientsWG.Add(1)
+		go func(w int) {
+			defer clientsWG.Done()
+			for r := 0; r < rounds; r++ {
+				client := newClient(hub, nil, users[(w+r)%len(users)], "tester")
+				slow := r%3 == 0
+				if slow {
+					client.send = make(chan []byte, 1)
+				}
+				if !hub.registerClient(client) {
+					return
+				}
+
+				drained := make(chan struct{})
+				go func() {
+					defer close(drained)
+					if slow {
+						<-client.ctx.Done()
+					}
+					for range client.send {
+					}
+				}()
+
+				client.sendFrame(Message{Type: "pong"})
+				stream := &chatStream{client: client, requestID: "r", conversationID: uuid.New()}
+				stream.Delta("片段")
+				stream.Done("完成", nil)
+
+				if r%2 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				hub.unregisterClient(client)
+				<-drained
+			}
+		}(w)
+	}
+
+	clientsWG.Wait()
+	close(stopSenders)
+	sendersWG.Wait()
+
+	assert.Zero(t, hub.GetConnectedUsers())
+	assert.Zero(t, hub.GetTotalConnections())
+}
+
+// fakeProcessor 回传固定的帧
+type fakeProcessor struct {
+	release chan struct{}
+}
+
+func (p *fakeProcessor) ProcessChat(ctx context.Context, request ChatRequest, stream ChatStream) {
+	stream.Ack(map[string]interface{}{"content": request.Content})
+	<-p.release
+	stream.Delta("你")
+	stream.Delta("好")
+	stream.Done("你好", nil)
+	stream.Error("IGNORED", "结束后不再发送")
+}
+
+// TestClientChat 测试聊天帧的校验、并发限制以及按 request_id 回传的帧顺序
+func TestClientChat(t *testing.T) {
+	hub := NewHub()
+	processor := &fakeProcessor{release: make(chan struct{})}
+	hub.SetChatProcessor(processor)
+	go hub.Run()
+	defer hub.Stop()
+	client := connect(t, hub, uuid.New())
+	conversationID := uuid.New()
+
+	client.handleChat(chatMessage{Type: "chat", Content: "hi", ConversationID: conversationID.String()})
+	frame := receive(t, client)
+	assert.Equal(t, FrameChatError, frame.Type)
+	assert.Equal(t, "INVALID_REQUEST", frame.Data.(map[string]interface{})["code"])
+
+	client.handleChat(chatMessage{Type: "chat", RequestID: "a", Content: "  ", ConversationID: conversationID.String()})
+	frame = receive(t, client)
+	assert.Equal(t, "a", frame.RequestID)
+	assert.Equal(t, "EMPTY_MESSAGE", frame.Data.(map[string]interface{})["code"])
+
+	client.handleChat(chatMessage{Type: "chat", RequestID: "a", Content: "你好", ConversationID: conversationID.String()})
+	frame = receive(t, client)
+	assert.Equal(t, FrameChatAck, frame.Type)
+	assert.Equal(t, "a", frame.RequestID)
+	assert.Equal(t, conversationID.String(), frame.Data.(map[string]interface{})["conversation_id"])
+
+	// 同一 request_id 正在处理
+	client.handleChat(chatMessage{Type: "chat", RequestID: "a", Content: "你好", ConversationID: conversationID.String()})
+	frame = receive(t, client)
+	assert.Equal(t, "DUPLICATE_REQUEST", frame.Data.(map[string]interface{})["code"])
+
+	close(processor.release)
+	var types []string
+	var seqs []float64
+	for len(types) < 3 {
+		frame = receive(t, client)
+		assert.Equal(t, "a", frame.RequestID)
+		types = append(types, frame.Type)
+		if frame.Type == FrameChatDelta {
+			seqs = append(seqs, frame.Data.(map[string]interface{})["seq"].(float64))
+		}
+	}
+	assert.Equal(t, []string{FrameChatDelta, FrameChatDelta, FrameChatDone}, types)
+	assert.Equal(t, []float64{1, 2}, seqs)
+	assert.Equal(t, "你好", frame.Content)
+
+	// Error 在 Done 之后不再发送
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.inflight) == 0
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, client.send)
+}
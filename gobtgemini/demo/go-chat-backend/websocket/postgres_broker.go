@@ -0,0 +1,229 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxNotifyChunk 每条 NOTIFY 携带的最大字节数，Postgres 限制 payload 小于 8000 字节，需留出分片头
+	maxNotifyChunk = 7800
+	// fragmentTimeout 分片未到齐的消息保留的时间
+	fragmentTimeout = 30 * time.Second
+	// maxListenBackoff 监听连接断开后重连的最长等待时间
+	maxListenBackoff = 30 * time.Second
+)
+
+// PostgresBroker 通过 Postgres LISTEN/NOTIFY 在多个后端实例之间分发消息，复用现有数据库。
+// 超过 payload 限制的消息拆成多条 NOTIFY 在同一事务中发送，接收方按消息ID重组。
+// 监听连接断开到重连成功期间发布的消息会丢失
+type PostgresBroker struct {
+	db      *sql.DB
+	channel string
+}
+
+// NewPostgresBroker 创建 Postgres Broker，db 需使用 pgx 驱动（gorm 的 postgres 驱动即是）
+func NewPostgresBroker(db *sql.DB, channel string) *PostgresBroker {
+	return &PostgresBroker{
+		db:      db,
+		channel: channel,
+	}
+}
+
+// Publish 序列化后分片发送
+func (b *PostgresBroker) Publish(ctx context.Context, envelope Envelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	fragments := splitNotifyPayload(uuid.New(), payload, maxNotifyChunk)
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, fragment := range fragments {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", b.channel, fragment); err != nil {
+			return fmt.Errorf("发送 NOTIFY 失败: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Subscribe 在后台占用一个连接执行 LISTEN，断开后按指数退避重连
+func (b *PostgresBroker) Subscribe(ctx context.Context, handler func(Envelope)) error {
+	go func() {
+		backoff := time.Second
+		for ctx.Err() == nil {
+			err := b.listen(ctx, handler, func() { backoff = time.Second })
+			if ctx.Err() != nil {
+				return
+			}
+			logrus.WithError(err).WithField("retry_in", backoff.String()).Warn("监听跨节点消息中断，稍后重连")
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			if backoff *= 2; backoff > maxListenBackoff {
+				backoff = maxListenBackoff
+			}
+		}
+	}()
+	return nil
+}
+
+// listen 在一个专用连接上 LISTEN 并转发收到的消息；返回时连接被丢弃，不会带着 LISTEN 回到连接池
+func (b *PostgresBroker) listen(ctx context.Context, handler func(Envelope), onListening func()) error {
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(driverConn interface{}) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			listenErr = errors.New("LISTEN 需要 pgx 驱动")
+			return listenErr
+		}
+		pgConn := stdConn.Conn()
+
+		if _, err := pgConn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+			listenErr = err
+			return fmt.Errorf("%w: %v", driver.ErrBadConn, err)
+		}
+		logrus.WithField("channel", b.channel).Info("开始监听跨节点消息")
+		onListening()
+
+		assembler := newFragmentAssembler(fragmentTimeout)
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				listenErr = err
+				return fmt.Errorf("%w: %v", driver.ErrBadConn, err)
+			}
+			payload, ok, err := assembler.add(notification.Payload, time.Now())
+			if err != nil {
+				logrus.WithError(err).Warn("跨节点消息格式错误")
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			var envelope Envelope
+			if err := json.Unmarshal(payload, &envelope); err != nil {
+				logrus.WithError(err).Warn("跨节点消息解析失败")
+				continue
+			}
+			handler(envelope)
+		}
+	})
+	return listenErr
+}
+
+// splitNotifyPayload 按字节上限拆分 payload，不拆开多字节字符。每个分片格式为 "消息ID:序号:总数:内容"
+func splitNotifyPayload(id uuid.UUID, payload []byte, chunkSize int) []string {
+	var chunks [][]byte
+	for len(payload) > chunkSize {
+		end := chunkSize
+		for end > 0 && !utf8.RuneStart(payload[end]) {
+			end--
+		}
+		chunks = append(chunks, payload[:end])
+		payload = payload[end:]
+	}
+	chunks = append(chunks, payload)
+
+	fragments := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		fragments[i] = fmt.Sprintf("%s:%d:%d:%s", id, i, len(chunks), chunk)
+	}
+	return fragments
+}
+
+// fragmentAssembler 重组分片，丢弃超时未到齐的消息
+type fragmentAssembler struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	pending map[string]*pendingPayload
+}
+
+type pendingPayload struct {
+	parts    []string
+	received int
+	started  time.Time
+}
+
+func newFragmentAssembler(timeout time.Duration) *fragmentAssembler {
+	return &fragmentAssembler{
+		timeout: timeout,
+		pending: make(map[string]*pendingPayload),
+	}
+}
+
+// add 加入一个分片，消息的所有分片到齐时返回完整内容
+func (a *fragmentAssembler) add(fragment string, now time.Time) ([]byte, bool, error) {
+	parts := strings.SplitN(fragment, ":", 4)
+	if len(parts) != 4 {
+		return nil, false, fmt.Errorf("无效的分片 %.40q", fragment)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, false, fmt.Errorf("无效的分片序号 %q", parts[1])
+	}
+	total, err := strconv.Atoi(parts[2])
+	if err != nil || total <= 0 || index < 0 || index >= total {
+		return nil, false, fmt.Errorf("无效的分片总数 %q", parts[2])
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, pending := range a.pending {
+		if now.Sub(pending.started) > a.timeout {
+			delete(a.pending, id)
+		}
+	}
+	if total == 1 {
+		return []byte(parts[3]), true, nil
+	}
+
+	pending := a.pending[parts[0]]
+	if pending == nil {
+		pending = &pendingPayload{parts: make([]string, total), started: now}
+		a.pending[parts[0]] = pending
+	}
+	if len(pending.parts) != total {
+		return nil, false, fmt.Errorf("分片总数不一致 %q", parts[0])
+	}
+	if pending.parts[index] == "" {
+		pending.received++
+	}
+	pending.parts[index] = parts[3]
+	if pending.received < total {
+		return nil, false, nil
+	}
+
+	delete(a.pending, parts[0])
+	return []byte(strings.Join(pending.parts, "")), true, nil
+}